@@ -0,0 +1,75 @@
+// Package breach checks passwords against a local corpus of breached password
+// hashes, such as the Pwned Passwords list, so that the check works offline and
+// never sends anything about a password anywhere.
+//
+// The corpus is an index file: an 8 byte header followed by the raw 20 byte SHA-1
+// digests of the breached passwords, sorted and without duplicates. Open maps the
+// file into memory and looks digests up with a binary search, so even a corpus of
+// hundreds of millions of hashes costs a few page reads per check and no start up
+// time. Indexes are built from a hash dump with a Builder, or with cmd/breachindex.
+package breach
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// magic starts every index file
+const magic = "SDGBRC01"
+
+// ErrInvalidIndex is returned by Open for a file that is not an index.
+var ErrInvalidIndex = errors.New("not a breached password index")
+
+// Corpus is an open index of breached password hashes. It is safe for concurrent use.
+type Corpus struct {
+	records []byte
+	unmap   func() error
+}
+
+// Open maps the index file at path into memory.
+func Open(path string) (*Corpus, error) {
+	b, unmap, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) < len(magic) || string(b[:len(magic)]) != magic || (len(b)-len(magic))%sha1.Size != 0 {
+		_ = unmap()
+		return nil, fmt.Errorf("%s: %w", path, ErrInvalidIndex)
+	}
+
+	return &Corpus{records: b[len(magic):], unmap: unmap}, nil
+}
+
+// Len returns the number of hashes in the corpus.
+func (c *Corpus) Len() int {
+	return len(c.records) / sha1.Size
+}
+
+// Contains reports whether the corpus holds the SHA-1 digest sum.
+func (c *Corpus) Contains(sum [sha1.Size]byte) bool {
+	n := c.Len()
+
+	i := sort.Search(n, func(i int) bool {
+		return bytes.Compare(c.record(i), sum[:]) >= 0
+	})
+
+	return i < n && bytes.Equal(c.record(i), sum[:])
+}
+
+// Breached reports whether password is in the corpus.
+func (c *Corpus) Breached(password string) (bool, error) {
+	return c.Contains(sha1.Sum([]byte(password))), nil
+}
+
+// Close unmaps the index. The corpus must not be used afterwards.
+func (c *Corpus) Close() error {
+	return c.unmap()
+}
+
+func (c *Corpus) record(i int) []byte {
+	return c.records[i*sha1.Size : (i+1)*sha1.Size]
+}
@@ -0,0 +1,125 @@
+package breach
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeIndex builds an index of passwords in a temporary file and opens it
+func writeIndex(t *testing.T, passwords ...string) *Corpus {
+	t.Helper()
+
+	var lines []string
+	for i, p := range passwords {
+		lines = append(lines, fmt.Sprintf("%X:%d", sha1.Sum([]byte(p)), i+1))
+	}
+	sort.Strings(lines)
+
+	var buf bytes.Buffer
+	b, err := NewBuilder(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ReadDump(strings.NewReader(strings.Join(lines, "\r\n")), 1, b.Add); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "breached.idx")
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	return c
+}
+
+func TestCorpus(t *testing.T) {
+	breached := []string{"password", "123456", "qwerty", "letmein", "dragon"}
+	c := writeIndex(t, breached...)
+
+	if c.Len() != len(breached) {
+		t.Fatalf("expected %d hashes, got %d", len(breached), c.Len())
+	}
+
+	for _, p := range breached {
+		if ok, _ := c.Breached(p); !ok {
+			t.Errorf("expected %q to be breached", p)
+		}
+	}
+
+	for _, p := range []string{"correct horse battery staple", "", "Password"} {
+		if ok, _ := c.Breached(p); ok {
+			t.Errorf("expected %q not to be breached", p)
+		}
+	}
+}
+
+func TestReadRange(t *testing.T) {
+	sum := fmt.Sprintf("%X", sha1.Sum([]byte("password")))
+
+	var got [][sha1.Size]byte
+	add := func(s [sha1.Size]byte) error { got = append(got, s); return nil }
+
+	body := sum[5:] + ":3861493\n" + strings.Repeat("F", 35) + ":1\n"
+	if err := ReadRange(sum[:5], strings.NewReader(body), 2, add); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 1 || got[0] != sha1.Sum([]byte("password")) {
+		t.Fatalf("expected only the common hash, got %X", got)
+	}
+
+	if err := ReadRange(sum[:5], strings.NewReader(sum+":1\n"), 1, add); err == nil {
+		t.Fatal("expected a full hash to be refused in a range")
+	}
+}
+
+func TestBuilderNeedsSortedInput(t *testing.T) {
+	lo, hi := sha1.Sum([]byte("a")), sha1.Sum([]byte("b"))
+	if bytes.Compare(lo[:], hi[:]) > 0 {
+		lo, hi = hi, lo
+	}
+
+	b, err := NewBuilder(&bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Add(hi); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Add(hi); err != nil || b.Count() != 1 {
+		t.Fatalf("expected repeats to be dropped, got %v, %d", err, b.Count())
+	}
+
+	if err := b.Add(lo); !errors.Is(err, ErrUnsorted) {
+		t.Fatalf("expected ErrUnsorted, got %v", err)
+	}
+}
+
+func TestOpenRejectsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.txt")
+	if err := os.WriteFile(path, []byte("5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(path); !errors.Is(err, ErrInvalidIndex) {
+		t.Fatalf("expected ErrInvalidIndex, got %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package breach
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsorted is returned by Builder.Add for a hash that sorts before the one added
+// last. Dumps downloaded from Pwned Passwords are already sorted by hash.
+var ErrUnsorted = errors.New("hashes are not sorted")
+
+// Builder writes an index file. Hashes must be added in ascending order; repeats are
+// dropped.
+type Builder struct {
+	w    *bufio.Writer
+	last [sha1.Size]byte
+	n    int
+}
+
+// NewBuilder starts an index on w.
+func NewBuilder(w io.Writer) (*Builder, error) {
+	b := &Builder{w: bufio.NewWriter(w)}
+
+	if _, err := b.w.WriteString(magic); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Add appends a digest to the index.
+func (b *Builder) Add(sum [sha1.Size]byte) error {
+	if b.n > 0 {
+		switch bytes.Compare(sum[:], b.last[:]) {
+		case 0:
+			return nil
+		case -1:
+			return fmt.Errorf("%w: %X after %X", ErrUnsorted, sum, b.last)
+		}
+	}
+
+	if _, err := b.w.Write(sum[:]); err != nil {
+		return err
+	}
+
+	b.last = sum
+	b.n++
+
+	return nil
+}
+
+// Count returns the number of hashes added so far.
+func (b *Builder) Count() int {
+	return b.n
+}
+
+// Close flushes the index. It does not close the underlying writer.
+func (b *Builder) Close() error {
+	return b.w.Flush()
+}
+
+// ReadDump reads a dump with one hash per line, as HASH or HASH:COUNT with the full
+// hex SHA-1, and calls add for every hash seen at least minCount times. Hashes
+// without a count are taken to have been seen once.
+func ReadDump(r io.Reader, minCount int, add func([sha1.Size]byte) error) error {
+	return readLines(r, "", minCount, add)
+}
+
+// ReadRange reads the body of a Pwned Passwords range response, or a file saved from
+// one, for the five character hex prefix: one SUFFIX:COUNT line per hash. It calls
+// add for every hash seen at least minCount times.
+func ReadRange(prefix string, r io.Reader, minCount int, add func([sha1.Size]byte) error) error {
+	if len(prefix) != 5 {
+		return fmt.Errorf("invalid range prefix %q", prefix)
+	}
+
+	return readLines(r, prefix, minCount, add)
+}
+
+func readLines(r io.Reader, prefix string, minCount int, add func([sha1.Size]byte) error) error {
+	scanner := bufio.NewScanner(r)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		hash, count, found := strings.Cut(text, ":")
+
+		seen := 1
+		if found {
+			n, err := strconv.Atoi(strings.TrimSpace(count))
+			if err != nil {
+				return fmt.Errorf("line %d: invalid count %q", line, count)
+			}
+			seen = n
+		}
+
+		if seen < minCount {
+			continue
+		}
+
+		full := prefix + strings.TrimSpace(hash)
+
+		var sum [sha1.Size]byte
+		if len(full) != hex.EncodedLen(sha1.Size) {
+			return fmt.Errorf("line %d: invalid hash %q", line, hash)
+		}
+		if _, err := hex.Decode(sum[:], []byte(full)); err != nil {
+			return fmt.Errorf("line %d: invalid hash %q", line, hash)
+		}
+
+		if err := add(sum); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+
+	return scanner.Err()
+}
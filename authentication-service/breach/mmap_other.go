@@ -0,0 +1,15 @@
+//go:build !unix
+
+package breach
+
+import "os"
+
+// mapFile reads the file at path into memory, where there is no mmap
+func mapFile(path string) ([]byte, func() error, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return b, func() error { return nil }, nil
+}
@@ -0,0 +1,34 @@
+//go:build unix
+
+package breach
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile maps the file at path into memory, read only
+func mapFile(path string) ([]byte, func() error, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// an empty mapping is an error, and can't be an index anyway
+	if info.Size() == 0 {
+		return nil, func() error { return nil }, nil
+	}
+
+	b, err := syscall.Mmap(int(f.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return b, func() error { return syscall.Munmap(b) }, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// exchangeKey runs the client credentials grant for an API key
+func exchangeKey(env *testEnv, key, scope string) *httptest.ResponseRecorder {
+	prefix, secret, _ := strings.Cut(key, ".")
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if scope != "" {
+		form.Set("scope", scope)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(prefix, secret)
+
+	rr := httptest.NewRecorder()
+	env.app.routes().ServeHTTP(rr, req)
+
+	return rr
+}
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	env := newTestEnv(t)
+	admin := login(t, env, "laptop")
+
+	rr := call(env, http.MethodPost, "/apikeys", admin.AccessToken, `{"name":"nightly export","scopes":["logs:write","mail:send"]}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create: expected %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+	}
+
+	var created struct {
+		Data struct {
+			ID     int    `json:"id"`
+			Prefix string `json:"prefix"`
+			Key    string `json:"key"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(created.Data.Key, created.Data.Prefix+".") {
+		t.Fatalf("expected the key to start with its prefix, got %+v", created.Data)
+	}
+
+	rr = exchangeKey(env, created.Data.Key, "logs:write")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("exchange: expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		Scope       string `json:"scope"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&token); err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := env.app.Verifier.Verify(token.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Subject != "apikey:"+created.Data.Prefix || !claims.Can("logs:write") || claims.Can("mail:send") {
+		t.Fatalf("expected a token for the key narrowed to logs:write, got %+v", claims)
+	}
+
+	if rr := exchangeKey(env, created.Data.Key, "users:manage"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("scope the key lacks: expected %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	if rr := exchangeKey(env, created.Data.Prefix+".wrong", ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong secret: expected %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+
+	key, err := env.app.APIKeys.GetByPrefix(context.Background(), created.Data.Prefix)
+	if err != nil || key.LastUsedAt == nil {
+		t.Fatalf("expected the key's last use to be recorded, got %+v (%v)", key, err)
+	}
+
+	rr = call(env, http.MethodDelete, "/apikeys/1", admin.AccessToken, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("revoke: expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+
+	if rr := exchangeKey(env, created.Data.Key, ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("revoked key: expected %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+}
+
+func TestCreateAPIKeyNeedsPermission(t *testing.T) {
+	env := newTestEnv(t)
+	admin := login(t, env, "laptop")
+
+	if rr := call(env, http.MethodPost, "/apikeys", "", `{"name":"x","scopes":["logs:write"]}`); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous: expected %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+
+	if rr := call(env, http.MethodPost, "/apikeys", admin.AccessToken, `{"name":"x","scopes":[]}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("no scopes: expected %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestCreateAPIKeyRejectsUnknownScopes(t *testing.T) {
+	env := newTestEnv(t)
+	admin := login(t, env, "laptop")
+
+	// the admin can grant anything, so only scopes that exist stop these
+	for _, tc := range []struct {
+		scope string
+		want  int
+	}{
+		{"logs:write", http.StatusCreated},
+		{"logs:*", http.StatusCreated},
+		{"impersonate", http.StatusCreated},
+		{"*", http.StatusCreated},
+		{"log:write", http.StatusBadRequest},
+		{"logs:delete", http.StatusBadRequest},
+		{"log:*", http.StatusBadRequest},
+		{"impersonate:*", http.StatusBadRequest},
+		{"logs", http.StatusBadRequest},
+		{":*", http.StatusBadRequest},
+		{"", http.StatusBadRequest},
+		{"logs:read logs:write", http.StatusBadRequest},
+	} {
+		body, _ := json.Marshal(map[string]any{"name": "x", "scopes": []string{tc.scope}})
+
+		if rr := call(env, http.MethodPost, "/apikeys", admin.AccessToken, string(body)); rr.Code != tc.want {
+			t.Errorf("scope %q: expected %d, got %d: %s", tc.scope, tc.want, rr.Code, rr.Body)
+		}
+	}
+}
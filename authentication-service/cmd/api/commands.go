@@ -0,0 +1,258 @@
+package main
+
+import (
+	"authentication/data"
+	"authentication/event"
+	"authentication/migrations"
+	"authz"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	migrateTimeout   = 5 * time.Minute
+	defaultAdminMail = "admin@example.com"
+)
+
+const usage = `usage:
+  authApp                     start the service
+  authApp migrate up          apply every pending migration
+  authApp migrate down [n]    roll back the last n migrations (default 1)
+  authApp migrate status      list migrations and when they were applied
+  authApp seed                create the default admin user if it is missing
+  authApp import [-dry-run] [-org id] <file.csv|file.json>
+                              create users from a file and email them invitations`
+
+// runCommand runs one of the administrative subcommands instead of starting the service
+func runCommand(conn *sql.DB, args []string) error {
+	switch args[0] {
+	case "migrate":
+		if len(args) < 2 {
+			return errors.New(usage)
+		}
+
+		return migrate(conn, args[1], args[2:])
+	case "seed":
+		return seed(conn)
+	case "import":
+		return importCommand(conn, args[1:])
+	default:
+		return errors.New(usage)
+	}
+}
+
+func migrate(conn *sql.DB, direction string, args []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+
+	switch direction {
+	case "up":
+		applied, err := migrations.Up(ctx, conn)
+		for _, m := range applied {
+			log.Printf("Applied migration %d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(applied) == 0 {
+			log.Println("Schema is up to date")
+		}
+	case "down":
+		steps := 1
+		if len(args) > 0 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid number of steps: %q", args[0])
+			}
+			steps = n
+		}
+
+		reverted, err := migrations.Down(ctx, conn, steps)
+		for _, m := range reverted {
+			log.Printf("Reverted migration %d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+	case "status":
+		all, err := migrations.Status(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range all {
+			applied := "pending"
+			if m.AppliedAt != nil {
+				applied = m.AppliedAt.Format(time.RFC3339)
+			}
+
+			fmt.Printf("%06d  %-40s  %s\n", m.Version, m.Name, applied)
+		}
+	default:
+		return errors.New(usage)
+	}
+
+	return nil
+}
+
+// seed creates the default admin user from ADMIN_EMAIL and ADMIN_PASSWORD
+func seed(conn *sql.DB) error {
+	email := os.Getenv("ADMIN_EMAIL")
+	if email == "" {
+		email = defaultAdminMail
+	}
+
+	password := os.Getenv("ADMIN_PASSWORD")
+	if password == "" {
+		return errors.New("ADMIN_PASSWORD is not set")
+	}
+
+	policy, err := passwordPolicy()
+	if err != nil {
+		return err
+	}
+
+	hash, err := policy.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	users := data.NewPostgresUserRepository(conn, policy)
+
+	created, err := users.SeedAdmin(context.Background(), email, hash)
+	if err != nil {
+		return err
+	}
+
+	if created {
+		log.Println("Created admin user", email)
+	} else {
+		log.Println("Admin user", email, "already exists")
+	}
+
+	return nil
+}
+
+// importCommand imports users from a CSV or JSON file, told apart by its extension,
+// in the same way as POST /users/import. Invitations go out through the mail service,
+// so JWT_SECRET must be set unless this is a dry run.
+func importCommand(conn *sql.DB, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "check every row without importing anything")
+	organizationID := flags.Int("org", 0, "id of the organization the users join")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return errors.New(usage)
+	}
+
+	path := flags.Arg(0)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rows, err := parseImport(f, strings.ToLower(strings.TrimPrefix(filepath.Ext(path), ".")))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	policy, err := passwordPolicy()
+	if err != nil {
+		return err
+	}
+
+	app := &Config{
+		Users:          data.NewPostgresUserRepository(conn, policy),
+		Organizations:  data.NewPostgresOrganizationRepository(conn),
+		Invitations:    data.NewPostgresInvitationRepository(conn),
+		Issuer:         oidcIssuer(),
+		MailServiceURL: mailServiceURL,
+	}
+
+	ctx := context.Background()
+
+	if *organizationID != 0 {
+		if _, err := app.Organizations.Get(ctx, *organizationID); err != nil {
+			return fmt.Errorf("organization %d: %w", *organizationID, err)
+		}
+	}
+
+	if !*dryRun {
+		secret := os.Getenv("JWT_SECRET")
+		if secret == "" {
+			return errors.New("JWT_SECRET is not set, so invitations cannot be sent")
+		}
+		app.Tokens = authz.NewHMAC(secret)
+
+		// imported users are announced like any others
+		rabbitConn, err := connectToRabbit()
+		if err != nil {
+			return err
+		}
+		defer rabbitConn.Close()
+
+		emitter, err := event.NewEmitter(rabbitConn, event.UsersExchange)
+		if err != nil {
+			return err
+		}
+
+		app.Users = withUserEvents(app.Users, emitter)
+	}
+
+	report := app.importUsers(ctx, rows, *organizationID, *dryRun)
+
+	for _, row := range report.Rows {
+		if row.Error != "" {
+			log.Printf("Row %d (%s): %s\n", row.Row, row.Email, row.Error)
+		}
+	}
+
+	if *dryRun {
+		log.Printf("Dry run: %d of %d users can be imported\n", report.Imported, report.Total)
+	} else {
+		log.Printf("Imported %d of %d users\n", report.Imported, report.Total)
+	}
+
+	if report.Failed > 0 {
+		return fmt.Errorf("%d rows failed", report.Failed)
+	}
+
+	return nil
+}
+
+// migrateOnStart brings the schema up to date and seeds the admin user when the
+// service starts, if AUTO_MIGRATE is set. Replicas starting together queue up on the
+// migration lock, so only the first one does any work.
+func migrateOnStart(conn *sql.DB) error {
+	if os.Getenv("AUTO_MIGRATE") != "true" {
+		return nil
+	}
+
+	err := migrate(conn, "up", nil)
+	if err != nil {
+		return err
+	}
+
+	if os.Getenv("ADMIN_PASSWORD") == "" {
+		log.Println("ADMIN_PASSWORD is not set; skipping admin seed")
+		return nil
+	}
+
+	return seed(conn)
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"authentication/data"
+	"authentication/lockout"
+	"authentication/password"
 	"bytes"
 	"encoding/json"
 	"errors"
@@ -20,35 +23,162 @@ func (app *Config) Authenticate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ip := app.clientIP(r)
+
+	// don't spend any time on a password check while the account or IP is throttled.
+	// The attempt is counted now, and only given back once the user is fully signed in.
+	wait, err := app.Limiter.Reserve(r.Context(), requestPayload.Email, ip)
+	if errors.Is(err, lockout.ErrBlocked) {
+		app.recordLogin(r, loginByPassword, data.LoginBlocked, 0, requestPayload.Email)
+		app.tooManyAttempts(w, wait)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
 	// validate the user against the database
-	user, err := app.Models.User.GetByEmail(requestPayload.Email)
+	user, err := app.Users.GetByEmailContext(r.Context(), requestPayload.Email)
 	if err != nil {
-		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
+		if errors.Is(app.checkNoUser(requestPayload.Password), password.ErrBusy) {
+			app.releaseAttempt(r.Context(), requestPayload.Email, ip)
+			app.serverBusy(w)
+			return
+		}
+
+		app.recordLogin(r, loginByPassword, data.LoginFailed, 0, requestPayload.Email)
+		app.loginFailed(w, r, requestPayload.Email, ip)
 		return
 	}
 
-	valid, err := user.PasswordMatches(requestPayload.Password)
-	if err != nil || !valid {
-		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
+	valid, err := app.checkPassword(r.Context(), user, requestPayload.Password)
+	if errors.Is(err, password.ErrBusy) {
+		app.releaseAttempt(r.Context(), requestPayload.Email, ip)
+		app.serverBusy(w)
+		return
+	} else if err != nil || !valid {
+		app.recordLogin(r, loginByPassword, data.LoginFailed, user.ID, user.Email)
+		app.loginFailed(w, r, requestPayload.Email, ip)
 		return
 	}
 
+	// a deactivated account answers like a wrong password, so that it can't be told
+	// apart from one
+	if user.Active != 1 {
+		app.recordLogin(r, loginByPassword, data.LoginFailed, user.ID, user.Email)
+		app.loginFailed(w, r, requestPayload.Email, ip)
+		return
+	}
+
+	// users who have enrolled a second factor get a challenge rather than a token, and
+	// the password alone does not clear their failed attempts
+	if user.MFAEnabled {
+		app.recordLogin(r, loginByPassword, data.LoginMFARequired, user.ID, user.Email)
+		app.startMFAChallenge(w, r, user)
+		return
+	}
+
+	app.clearFailures(r.Context(), requestPayload.Email, ip)
+
+	app.completeLogin(w, r, user, loginByPassword)
+}
+
+// completeLogin logs a successful login made by method, starts a session for it and
+// sends the user their access and refresh tokens
+func (app *Config) completeLogin(w http.ResponseWriter, r *http.Request, user *data.User, method string) {
 	// log authentication
-	err = app.logRequest("authentication", fmt.Sprintf("%s logged in", user.Email))
+	err := app.logRequest("authentication", fmt.Sprintf("%s logged in", user.Email))
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
+	session, refresh, err := app.startSession(r.Context(), r, user)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// issue an access token carrying the user's roles and permissions
+	token, err := app.issueToken(r.Context(), user, session.ID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	token.RefreshToken = refresh
+
+	app.loginSucceeded(r, user, method)
+
 	payload := jsonResponse{
 		Error:   false,
 		Message: fmt.Sprintf("Logged in user %s", user.Email),
-		Data:    user,
+		Data:    token,
 	}
 
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
 
+// minPasswordLength is the shortest password we let users choose
+const minPasswordLength = 8
+
+// ChangePassword sets a new password for the signed in user, who must know their
+// current one. Impersonation tokens are turned away before they get here.
+func (app *Config) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if len(requestPayload.NewPassword) < minPasswordLength {
+		app.errorJSON(w, fmt.Errorf("new password must be at least %d characters", minPasswordLength))
+		return
+	}
+
+	id, err := app.currentUserID(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.Users.GetOneContext(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	valid, err := app.checkPassword(r.Context(), user, requestPayload.CurrentPassword)
+	if errors.Is(err, password.ErrBusy) {
+		app.serverBusy(w)
+		return
+	} else if err != nil || !valid {
+		app.errorJSON(w, errors.New("current password is wrong"), http.StatusForbidden)
+		return
+	}
+
+	err = app.Users.ResetPasswordContext(r.Context(), id, requestPayload.NewPassword)
+	if errors.Is(err, password.ErrBusy) {
+		app.serverBusy(w)
+		return
+	} else if errors.Is(err, password.ErrBreached) {
+		app.errorJSON(w, err)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: "password changed",
+	})
+}
+
 func (app *Config) logRequest(name, data string) error {
 	var entry struct {
 		Name string `json:"name"`
@@ -59,13 +189,19 @@ func (app *Config) logRequest(name, data string) error {
 	entry.Data = data
 
 	jsonData, _ := json.MarshalIndent(entry, "", "\t")
-	logServiceURL := "http://logger-service/log"
+	request, err := http.NewRequest("POST", app.LogServiceURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
 
-	request, err := http.NewRequest("POST", logServiceURL, bytes.NewBuffer(jsonData))
+	token, err := app.serviceToken("logs:write")
 	if err != nil {
 		return err
 	}
 
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "Bearer "+token)
+
 	client := &http.Client{}
 	_, err = client.Do(request)
 	if err != nil {
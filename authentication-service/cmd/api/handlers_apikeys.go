@@ -0,0 +1,274 @@
+package main
+
+import (
+	"authentication/data"
+	"authz"
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiKeyPrefix starts every API key, so that leaked keys are easy to search for
+const apiKeyPrefix = "sk_"
+
+var errInvalidAPIKey = errors.New("invalid API key")
+
+// AllAPIKeys lists every API key, without their secrets
+func (app *Config) AllAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := app.APIKeys.All(r.Context())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: "api keys",
+		Data:    keys,
+	})
+}
+
+// CreateAPIKey creates an API key. The key is only ever shown in this response; we
+// keep a hash of its secret. Nobody can hand out scopes they don't hold themselves.
+func (app *Config) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if strings.TrimSpace(requestPayload.Name) == "" {
+		app.errorJSON(w, errors.New("name is required"))
+		return
+	}
+
+	if len(requestPayload.Scopes) == 0 {
+		app.errorJSON(w, errors.New("at least one scope is required"))
+		return
+	}
+
+	now := time.Now()
+
+	if requestPayload.ExpiresAt != nil && !requestPayload.ExpiresAt.After(now) {
+		app.errorJSON(w, errors.New("expires_at must be in the future"))
+		return
+	}
+
+	permissions, err := app.Permissions.Names(r.Context())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	claims, _ := authz.FromContext(r.Context())
+	for _, scope := range requestPayload.Scopes {
+		if !knownScope(scope, permissions) {
+			app.errorJSON(w, fmt.Errorf("invalid scope %q", scope))
+			return
+		}
+
+		if !claims.Can(scope) {
+			app.errorJSON(w, fmt.Errorf("you cannot grant %q", scope), http.StatusForbidden)
+			return
+		}
+	}
+
+	userID, err := app.currentUserID(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	id, err := randomToken(9)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// the key acts for the creator's organization
+	organizationID, _ := strconv.Atoi(claims.TenantID)
+
+	key := data.APIKey{
+		Prefix:         apiKeyPrefix + id,
+		SecretHash:     hashToken(secret),
+		Name:           requestPayload.Name,
+		Scopes:         requestPayload.Scopes,
+		OrganizationID: organizationID,
+		CreatedBy:      userID,
+		CreatedAt:      now,
+		ExpiresAt:      requestPayload.ExpiresAt,
+	}
+
+	key.ID, err = app.APIKeys.Insert(r.Context(), key)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	go func() {
+		msg := fmt.Sprintf("API key %s (%s) created by user %d", key.Prefix, key.Name, userID)
+		if err := app.logRequest("apikeys", msg); err != nil {
+			log.Println("Error logging API key creation:", err)
+		}
+	}()
+
+	app.writeJSON(w, http.StatusCreated, jsonResponse{
+		Error:   false,
+		Message: "API key created; store the key now, it will not be shown again",
+		Data: struct {
+			*data.APIKey
+			Key string `json:"key"`
+		}{&key, key.Prefix + "." + secret},
+	})
+}
+
+// knownScope reports whether scope names a permission that exists, or is "resource:*"
+// for a resource that has at least one
+func knownScope(scope string, permissions []string) bool {
+	resource := strings.TrimSuffix(scope, ":*")
+
+	for _, p := range permissions {
+		if p == scope {
+			return true
+		}
+		if resource != scope && strings.HasPrefix(p, resource+":") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RevokeAPIKey revokes an API key. Access tokens already issued for it run out
+// within tokenTTL.
+func (app *Config) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIntParam(r, "id")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	ok, err := app.APIKeys.Revoke(r.Context(), id, time.Now())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if !ok {
+		app.errorJSON(w, errors.New("no such API key"), http.StatusNotFound)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("revoked API key %d", id),
+	})
+}
+
+// clientCredentialsGrant trades an API key for a short lived access token. The key's
+// prefix is the client_id and its secret the client_secret. The token carries the
+// key's scopes as permissions, or those asked for with scope if it is narrower.
+func (app *Config) clientCredentialsGrant(w http.ResponseWriter, r *http.Request) {
+	prefix, secret, basic := clientCredentials(r)
+
+	key, err := app.checkAPIKey(r.Context(), prefix, secret)
+	if errors.Is(err, errInvalidAPIKey) {
+		clientAuthFailed(w, basic)
+		return
+	} else if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	scopes := key.Scopes
+	if requested := strings.Fields(r.PostForm.Get("scope")); len(requested) > 0 {
+		granted := authz.Claims{Permissions: key.Scopes}
+		for _, s := range requested {
+			if !granted.Can(s) {
+				oauthError(w, http.StatusBadRequest, "invalid_scope", fmt.Sprintf("the key does not grant %q", s))
+				return
+			}
+		}
+		scopes = requested
+	}
+
+	now := time.Now()
+
+	expiry := now.Add(tokenTTL)
+	if key.ExpiresAt != nil && key.ExpiresAt.Before(expiry) {
+		expiry = *key.ExpiresAt
+	}
+
+	signingKey := app.Keys.Signing()
+
+	token, err := authz.SignAccessToken(signingKey.ID, signingKey.Private, authz.Claims{
+		Subject:     "apikey:" + key.Prefix,
+		Issuer:      tokenIssuer,
+		TenantID:    apiKeyTenant(key),
+		Permissions: scopes,
+		IssuedAt:    now.Unix(),
+		ExpiresAt:   expiry.Unix(),
+	})
+	if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	headers := http.Header{}
+	headers.Set("Cache-Control", "no-store")
+	headers.Set("Pragma", "no-cache")
+
+	app.writeJSON(w, http.StatusOK, struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int    `json:"expires_in"`
+		Scope       string `json:"scope"`
+	}{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(expiry.Sub(now).Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	}, headers)
+}
+
+// checkAPIKey returns the active key with prefix if secret is its secret, and records
+// that it was used. Any other key gets errInvalidAPIKey.
+func (app *Config) checkAPIKey(ctx context.Context, prefix, secret string) (*data.APIKey, error) {
+	key, err := app.APIKeys.GetByPrefix(ctx, prefix)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errInvalidAPIKey
+	} else if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	if subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(key.SecretHash)) != 1 || !key.Active(now) {
+		return nil, errInvalidAPIKey
+	}
+
+	if err := app.APIKeys.Touch(ctx, key.ID, now); err != nil {
+		log.Println("Error recording API key use:", err)
+	}
+
+	return key, nil
+}
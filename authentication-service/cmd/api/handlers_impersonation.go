@@ -0,0 +1,126 @@
+package main
+
+import (
+	"authz"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// impersonationTTL is how long support staff can act as a user before asking again.
+// There is no refresh token for an impersonation.
+const impersonationTTL = 15 * time.Minute
+
+// Impersonate gives the caller a short lived token acting as another user. The token
+// carries the target's roles and permissions, with the caller in its act claim, and
+// belongs to the caller's session, so signing out ends the impersonation too. Nobody
+// can impersonate a user who holds permissions they don't hold themselves.
+func (app *Config) Impersonate(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Reason string `json:"reason"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	requestPayload.Reason = strings.TrimSpace(requestPayload.Reason)
+	if requestPayload.Reason == "" {
+		app.errorJSON(w, errors.New("a reason is required"))
+		return
+	}
+
+	actorID, err := app.currentUserID(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	id, err := app.readIntParam(r, "id")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if id == actorID {
+		app.errorJSON(w, errors.New("you cannot impersonate yourself"))
+		return
+	}
+
+	user, err := app.Users.GetOneContext(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("no such user"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if user.Active == 0 {
+		app.errorJSON(w, errors.New("user is not active"))
+		return
+	}
+
+	access, err := app.Users.GetAccessContext(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	claims, _ := authz.FromContext(r.Context())
+	for _, p := range access.Permissions {
+		if !claims.Can(p) {
+			app.errorJSON(w, fmt.Errorf("user %d holds permissions you do not", id), http.StatusForbidden)
+			return
+		}
+	}
+
+	// no audit record, no impersonation
+	msg := fmt.Sprintf("user %s (%s) started impersonating user %d (%s): %s", claims.Subject, claims.Email, user.ID, user.Email, requestPayload.Reason)
+	if err := app.logRequest("impersonation", msg); err != nil {
+		app.errorJSON(w, err, http.StatusServiceUnavailable)
+		return
+	}
+
+	token, err := app.signUserToken(r.Context(), user, claims.SessionID, &authz.Actor{
+		Subject: claims.Subject,
+		Email:   claims.Email,
+	}, impersonationTTL)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Impersonating user %s", user.Email),
+		Data:    token,
+	})
+}
+
+// auditImpersonation logs every request made with an impersonation token, under
+// both user ids, before it is handled. Requests we cannot log are refused.
+func (app *Config) auditImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := authz.FromContext(r.Context())
+		if !ok || !claims.Impersonated() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		msg := fmt.Sprintf("user %s acting as user %s: %s %s", claims.Actor.Subject, claims.Subject, r.Method, r.URL.Path)
+		if err := app.logRequest("impersonation", msg); err != nil {
+			log.Println("Error logging impersonated request:", err)
+			app.errorJSON(w, errors.New("could not record the request"), http.StatusServiceUnavailable)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
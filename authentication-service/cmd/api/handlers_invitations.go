@@ -0,0 +1,171 @@
+package main
+
+import (
+	"authentication/data"
+	"authentication/password"
+	"authz"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	invitationTTL  = 7 * 24 * time.Hour
+	invitationPath = "/invitations/accept"
+
+	// maxImportBytes is the largest file ImportUsers reads; tens of thousands of users
+	maxImportBytes = 10 << 20
+)
+
+var errInvalidInvitation = errors.New("this invitation is invalid or has expired")
+
+// ImportUsers creates users from a CSV or JSON file in the request body, chosen by
+// its Content-Type, and invites each of them by email to choose a password. They join
+// the importing admin's organization. With ?dry_run=true every row is checked and
+// nothing is changed. The response reports on every row; rows that fail do not stop
+// the others.
+func (app *Config) ImportUsers(w http.ResponseWriter, r *http.Request) {
+	var format string
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		format = importCSV
+	case "application/json":
+		format = importJSON
+	default:
+		app.errorJSON(w, errors.New("send users as text/csv or application/json"), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	dryRun := false
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(v)
+		if err != nil {
+			app.errorJSON(w, fmt.Errorf("invalid dry_run %q", v))
+			return
+		}
+	}
+
+	rows, err := parseImport(http.MaxBytesReader(w, r.Body, maxImportBytes), format)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if len(rows) == 0 {
+		app.errorJSON(w, errors.New("there are no users to import"))
+		return
+	}
+
+	claims, _ := authz.FromContext(r.Context())
+	organizationID, _ := strconv.Atoi(claims.TenantID)
+
+	report := app.importUsers(r.Context(), rows, organizationID, dryRun)
+
+	message := fmt.Sprintf("imported %d of %d users", report.Imported, report.Total)
+	if dryRun {
+		message = fmt.Sprintf("dry run: %d of %d users can be imported", report.Imported, report.Total)
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: message,
+		Data:    report,
+	})
+}
+
+// invite stores a new invitation for user and mails them the link to accept it
+func (app *Config) invite(ctx context.Context, user *data.User) error {
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	err = app.Invitations.Insert(ctx, data.Invitation{
+		TokenHash: hashToken(token),
+		UserID:    user.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(invitationTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := app.Issuer + invitationPath + "?" + url.Values{"token": {token}}.Encode()
+
+	message := fmt.Sprintf("An account has been created for you. Follow this link to choose "+
+		"your password: %s\n\nThe link works once, for the next %d days.", link, int(invitationTTL.Hours()/24))
+
+	return app.sendMail(user.Email, "You have been invited", message)
+}
+
+// AcceptInvitation sets the password of an invited user, given the token from their
+// invitation. Each invitation can be accepted once.
+func (app *Config) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if len(requestPayload.Password) < minPasswordLength {
+		app.errorJSON(w, fmt.Errorf("password must be at least %d characters", minPasswordLength))
+		return
+	}
+
+	invitation, err := app.Invitations.Consume(r.Context(), hashToken(requestPayload.Token))
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errInvalidInvitation)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if time.Now().After(invitation.ExpiresAt) {
+		app.errorJSON(w, errInvalidInvitation)
+		return
+	}
+
+	err = app.Users.ResetPasswordContext(r.Context(), invitation.UserID, requestPayload.Password)
+	if errors.Is(err, password.ErrBusy) || errors.Is(err, password.ErrBreached) {
+		// the password was never set, so the invitation still stands
+		if restoreErr := app.Invitations.Insert(r.Context(), *invitation); restoreErr != nil {
+			log.Println("Error restoring invitation:", restoreErr)
+		}
+
+		if errors.Is(err, password.ErrBusy) {
+			app.serverBusy(w)
+		} else {
+			app.errorJSON(w, err)
+		}
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errInvalidInvitation)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: "password set; you can now sign in",
+	})
+}
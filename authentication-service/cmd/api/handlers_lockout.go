@@ -0,0 +1,134 @@
+package main
+
+import (
+	"authentication/lockout"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// loginFailed records a failed login and tells the client their credentials were wrong.
+func (app *Config) loginFailed(w http.ResponseWriter, r *http.Request, email, ip string) {
+	app.recordFailure(r.Context(), email, ip)
+
+	app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
+}
+
+// recordFailure reports any lockout caused by a failed login, which Reserve has already
+// counted against the account and client IP, to the logger service.
+func (app *Config) recordFailure(ctx context.Context, email, ip string) {
+	res, err := app.Limiter.Failure(ctx, email, ip)
+	if err != nil {
+		log.Println("Error recording failed login:", err)
+	}
+
+	for _, key := range res.Locked {
+		msg := fmt.Sprintf("%s locked out for %s after repeated failed logins (last attempt for %s from %s)",
+			key, res.RetryAfter, email, ip)
+
+		// a slow or broken logger must not hold up the response
+		go func() {
+			if err := app.logRequest("lockout", msg); err != nil {
+				log.Println("Error logging lockout:", err)
+			}
+		}()
+	}
+}
+
+// clearFailures forgets the failed logins for an account once someone has signed in to
+// it, giving back the attempt reserved for the client IP.
+func (app *Config) clearFailures(ctx context.Context, email, ip string) {
+	err := app.Limiter.Success(ctx, email, ip)
+	if err != nil {
+		log.Println("Error clearing failed logins:", err)
+	}
+}
+
+// releaseAttempt gives back the attempt reserved for a login that was never checked
+func (app *Config) releaseAttempt(ctx context.Context, email, ip string) {
+	err := app.Limiter.Release(ctx, email, ip)
+	if err != nil {
+		log.Println("Error releasing login attempt:", err)
+	}
+}
+
+// tooManyAttempts tells the client to back off, and for how long
+func (app *Config) tooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+
+	headers := http.Header{}
+	headers.Set("Retry-After", strconv.Itoa(seconds))
+
+	app.writeJSON(w, http.StatusTooManyRequests, jsonResponse{
+		Error:   true,
+		Message: lockout.ErrBlocked.Error(),
+	}, headers)
+}
+
+// UnlockUser lifts any delay or lockout on a user's account. If the body names an IP,
+// that is unlocked as well.
+func (app *Config) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIntParam(r, "id")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var requestPayload struct {
+		IP string `json:"ip"`
+	}
+
+	if r.ContentLength != 0 {
+		err = app.readJSON(w, r, &requestPayload)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+	}
+
+	user, err := app.Users.GetOneContext(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("no such user"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.Limiter.UnlockAccount(r.Context(), user.Email)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	unlocked := []string{lockout.AccountKey(user.Email)}
+
+	if ip := strings.TrimSpace(requestPayload.IP); ip != "" {
+		err = app.Limiter.UnlockIP(r.Context(), ip)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		unlocked = append(unlocked, lockout.IPKey(ip))
+	}
+
+	go func() {
+		msg := fmt.Sprintf("%s unlocked by an administrator", strings.Join(unlocked, ", "))
+		if err := app.logRequest("lockout", msg); err != nil {
+			log.Println("Error logging unlock:", err)
+		}
+	}()
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("unlocked %s", strings.Join(unlocked, ", ")),
+	})
+}
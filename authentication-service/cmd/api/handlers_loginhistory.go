@@ -0,0 +1,126 @@
+package main
+
+import (
+	"authentication/data"
+	"authentication/event"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// The ways a user can sign in, as recorded in their login history
+const (
+	loginByPassword  = "password"
+	loginByMFA       = "mfa"
+	loginByMagicLink = "magic_link"
+	loginByOAuth     = "oauth"
+)
+
+// recordLogin adds an attempt to the login history. userID is zero when the attempt
+// named no account we know of. A failure to record is logged; it never fails the
+// login itself.
+func (app *Config) recordLogin(r *http.Request, method, outcome string, userID int, email string) {
+	err := app.LoginHistory.Insert(r.Context(), data.LoginEvent{
+		UserID:    userID,
+		Email:     email,
+		IP:        app.clientIP(r),
+		UserAgent: truncatedUserAgent(r),
+		Method:    method,
+		Outcome:   outcome,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Println("Error recording login attempt:", err)
+	}
+}
+
+// loginSucceeded records a successful login. If the user has never signed in from
+// this IP and user agent before, they are emailed about it and a security.new_device
+// event is published.
+func (app *Config) loginSucceeded(r *http.Request, user *data.User, method string) {
+	ip, userAgent := app.clientIP(r), truncatedUserAgent(r)
+
+	seen, err := app.LoginHistory.Seen(r.Context(), user.ID, ip, userAgent)
+	if err != nil {
+		log.Println("Error checking login history:", err)
+		seen = true
+	}
+
+	app.recordLogin(r, method, data.LoginSucceeded, user.ID, user.Email)
+
+	if seen {
+		return
+	}
+
+	message := fmt.Sprintf("Someone just signed in to your account from a new device.\n\n"+
+		"IP address: %s\nBrowser: %s\nTime: %s\n\n"+
+		"If this was you, there is nothing to do. If not, change your password and sign out "+
+		"of your other sessions straight away.", ip, userAgent, time.Now().UTC().Format(time.RFC1123))
+
+	err = app.sendMail(user.Email, "New sign in to your account", message)
+	if err != nil {
+		log.Println("Error sending new device notice:", err)
+	}
+
+	e := event.NewSecurityEvent(event.NewDevice, event.UserData{
+		ID:        user.ID,
+		Email:     user.Email,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Active:    user.Active == 1,
+	}, ip, userAgent)
+
+	err = app.Events.Publish(event.SecurityExchange, event.NewDevice, e)
+	if err != nil {
+		log.Printf("Error publishing %s for user %d: %v", event.NewDevice, user.ID, err)
+	}
+}
+
+// ListLoginHistory lists the signed in user's login attempts, newest first
+func (app *Config) ListLoginHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := app.currentUserID(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	app.writeLoginHistory(w, r, id)
+}
+
+// UserLoginHistory lists a user's login attempts, for admins
+func (app *Config) UserLoginHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIntParam(r, "id")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeLoginHistory(w, r, id)
+}
+
+// writeLoginHistory sends the page of userID's login history asked for
+func (app *Config) writeLoginHistory(w http.ResponseWriter, r *http.Request, userID int) {
+	page, perPage, err := app.readPage(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	events, total, err := app.LoginHistory.ForUser(r.Context(), userID, (page-1)*perPage, perPage)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("login history for user %d", userID),
+		Data: struct {
+			Entries []*data.LoginEvent `json:"entries"`
+			Page    int                `json:"page"`
+			PerPage int                `json:"per_page"`
+			Total   int                `json:"total"`
+		}{events, page, perPage, total},
+	})
+}
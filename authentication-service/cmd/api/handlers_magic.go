@@ -0,0 +1,234 @@
+package main
+
+import (
+	"authentication/data"
+	"authentication/lockout"
+	"bytes"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	magicLinkTTL    = 10 * time.Minute
+	magicCookieName = "magic_nonce"
+	magicCookiePath = "/login/magic"
+)
+
+var errInvalidMagicLink = errors.New("this sign in link is invalid or has expired")
+
+// RequestMagicLink emails a single use sign in link to a registered, active user.
+// The browser asking for it is given a nonce cookie, and the link only works in a
+// browser holding that cookie. The response is the same whether or not the address
+// is registered, and requests are throttled per address and per IP.
+func (app *Config) RequestMagicLink(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if requestPayload.Email == "" {
+		app.errorJSON(w, errors.New("email is required"))
+		return
+	}
+
+	ip := app.clientIP(r)
+
+	// every request counts, and is never given back, so that nobody can flood an inbox
+	wait, err := app.MagicLinkLimiter.Reserve(r.Context(), requestPayload.Email, ip)
+	if errors.Is(err, lockout.ErrBlocked) {
+		app.tooManyAttempts(w, wait)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	nonce, err := randomToken(32)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	user, err := app.Users.GetByEmailContext(r.Context(), requestPayload.Email)
+	if err == nil && user.Active == 1 {
+		err = app.sendMagicLink(r, user, nonce)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     magicCookieName,
+		Value:    nonce,
+		Path:     magicCookiePath,
+		MaxAge:   int(magicLinkTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	app.writeJSON(w, http.StatusAccepted, jsonResponse{
+		Error:   false,
+		Message: "if that address is registered, a sign in link is on its way",
+	})
+}
+
+// sendMagicLink stores a new link for user, bound to nonce, and mails it to them
+func (app *Config) sendMagicLink(r *http.Request, user *data.User, nonce string) error {
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	err = app.MagicLinks.Insert(r.Context(), data.MagicLink{
+		TokenHash: hashToken(token),
+		UserID:    user.ID,
+		NonceHash: hashToken(nonce),
+		CreatedAt: now,
+		ExpiresAt: now.Add(magicLinkTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := app.Issuer + magicCookiePath + "/verify?" + url.Values{"token": {token}}.Encode()
+
+	message := fmt.Sprintf("Follow this link to sign in: %s\n\n"+
+		"It works once, in the browser you asked for it from, for the next %d minutes. "+
+		"If you didn't ask to sign in, you can ignore this email.", link, int(magicLinkTTL.Minutes()))
+
+	return app.sendMail(user.Email, "Your sign in link", message)
+}
+
+// VerifyMagicLink exchanges a magic link for a session and tokens, in the same way
+// as a password login. Users with a second factor are asked for it next. Failed
+// attempts are throttled by IP.
+func (app *Config) VerifyMagicLink(w http.ResponseWriter, r *http.Request) {
+	ip := app.clientIP(r)
+
+	wait, err := app.MagicLinkLimiter.Reserve(r.Context(), "", ip)
+	if errors.Is(err, lockout.ErrBlocked) {
+		app.tooManyAttempts(w, wait)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		app.magicLinkFailed(w, r, ip, 0)
+		return
+	}
+
+	link, err := app.MagicLinks.Consume(r.Context(), hashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		app.magicLinkFailed(w, r, ip, 0)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// the link has been used up either way, so a leaked link is no use once its
+	// owner has tried it
+	cookie, err := r.Cookie(magicCookieName)
+	if err != nil || subtle.ConstantTimeCompare([]byte(hashToken(cookie.Value)), []byte(link.NonceHash)) != 1 {
+		app.magicLinkFailed(w, r, ip, link.UserID)
+		return
+	}
+
+	if time.Now().After(link.ExpiresAt) {
+		app.magicLinkFailed(w, r, ip, link.UserID)
+		return
+	}
+
+	user, err := app.Users.GetOneContext(r.Context(), link.UserID)
+	if err != nil || user.Active != 1 {
+		app.magicLinkFailed(w, r, ip, link.UserID)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     magicCookieName,
+		Path:     magicCookiePath,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	// a link that worked is not held against the IP it was opened from
+	err = app.MagicLinkLimiter.Release(r.Context(), "", ip)
+	if err != nil {
+		log.Println("Error releasing magic link attempt:", err)
+	}
+
+	if user.MFAEnabled {
+		app.recordLogin(r, loginByMagicLink, data.LoginMFARequired, user.ID, user.Email)
+		app.startMFAChallenge(w, r, user)
+		return
+	}
+
+	app.completeLogin(w, r, user, loginByMagicLink)
+}
+
+// magicLinkFailed records a failed attempt to use a link, made for userID if the link
+// was found. The attempt stays counted against the client IP.
+func (app *Config) magicLinkFailed(w http.ResponseWriter, r *http.Request, ip string, userID int) {
+	app.recordLogin(r, loginByMagicLink, data.LoginFailed, userID, "")
+
+	app.errorJSON(w, errInvalidMagicLink)
+}
+
+// sendMail sends a message through the mail service
+func (app *Config) sendMail(to, subject, message string) error {
+	jsonData, _ := json.Marshal(struct {
+		To      string `json:"to"`
+		Subject string `json:"subject"`
+		Message string `json:"message"`
+	}{to, subject, message})
+
+	request, err := http.NewRequest("POST", app.MailServiceURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+
+	token, err := app.serviceToken("mail:send")
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("mail service returned %s", response.Status)
+	}
+
+	return nil
+}
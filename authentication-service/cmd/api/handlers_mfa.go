@@ -0,0 +1,379 @@
+package main
+
+import (
+	"authentication/data"
+	"authentication/lockout"
+	"authentication/totp"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	totpIssuer        = "go-micro"
+	mfaChallengeTTL   = 5 * time.Minute
+	mfaMaxAttempts    = 5
+	recoveryCodeCount = 10
+)
+
+var errInvalidCode = errors.New("invalid code")
+
+// secondFactorPayload is the body accepted by every endpoint that needs a code.
+// Either a TOTP code or a recovery code may be supplied.
+type secondFactorPayload struct {
+	ChallengeToken string `json:"challenge_token,omitempty"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code,omitempty"`
+}
+
+// startMFAChallenge is called once a user with a second factor has got their password
+// right. Rather than a token, they get a challenge to complete at /authenticate/mfa.
+func (app *Config) startMFAChallenge(w http.ResponseWriter, r *http.Request, user *data.User) {
+	token, err := randomToken(32)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	expiry := time.Now().Add(mfaChallengeTTL)
+
+	_, err = app.MFAChallenges.Insert(r.Context(), data.MFAChallenge{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: expiry,
+	})
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "second factor required",
+		Data: struct {
+			MFARequired    bool      `json:"mfa_required"`
+			ChallengeToken string    `json:"challenge_token"`
+			ExpiresAt      time.Time `json:"expires_at"`
+		}{
+			MFARequired:    true,
+			ChallengeToken: token,
+			ExpiresAt:      expiry,
+		},
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// CompleteMFA trades a challenge token and a code for an access token
+func (app *Config) CompleteMFA(w http.ResponseWriter, r *http.Request) {
+	var requestPayload secondFactorPayload
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	challenge, err := app.MFAChallenges.GetByHash(r.Context(), hashToken(requestPayload.ChallengeToken))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid or expired challenge"), http.StatusUnauthorized)
+		return
+	}
+
+	if time.Now().After(challenge.ExpiresAt) || challenge.Attempts >= mfaMaxAttempts {
+		_ = app.MFAChallenges.DeleteByID(r.Context(), challenge.ID)
+		app.errorJSON(w, errors.New("invalid or expired challenge"), http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.Users.GetOneContext(r.Context(), challenge.UserID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// the account may have been deactivated since the password step
+	if user.Active != 1 {
+		_ = app.MFAChallenges.DeleteByID(r.Context(), challenge.ID)
+		app.errorJSON(w, errors.New("invalid or expired challenge"), http.StatusUnauthorized)
+		return
+	}
+
+	// codes are guessed under the same limits as passwords, so that starting a new
+	// challenge does not buy more guesses
+	ip := app.clientIP(r)
+
+	wait, err := app.Limiter.Reserve(r.Context(), user.Email, ip)
+	if errors.Is(err, lockout.ErrBlocked) {
+		app.recordLogin(r, loginByMFA, data.LoginBlocked, user.ID, user.Email)
+		app.tooManyAttempts(w, wait)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	mfa, err := app.TOTP.GetForUser(r.Context(), challenge.UserID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	ok, err := app.verifySecondFactor(r.Context(), mfa, requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if !ok {
+		_ = app.MFAChallenges.RecordAttempt(r.Context(), challenge.ID)
+		app.recordLogin(r, loginByMFA, data.LoginFailed, user.ID, user.Email)
+		app.recordFailure(r.Context(), user.Email, ip)
+		app.errorJSON(w, errInvalidCode, http.StatusUnauthorized)
+		return
+	}
+
+	_ = app.MFAChallenges.DeleteByID(r.Context(), challenge.ID)
+
+	app.clearFailures(r.Context(), user.Email, ip)
+
+	app.completeLogin(w, r, user, loginByMFA)
+}
+
+// EnrollTOTP generates a new TOTP secret for the current user. The secret does not
+// take effect until it has been confirmed with a code.
+func (app *Config) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.currentUserID(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.Users.GetOneContext(r.Context(), userID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	existing, err := app.TOTP.GetForUser(r.Context(), userID)
+	if err == nil && existing.Confirmed() {
+		app.errorJSON(w, errors.New("two-factor authentication is already enabled"), http.StatusConflict)
+		return
+	} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.TOTP.Enroll(r.Context(), userID, secret)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "scan the URI with an authenticator app, then confirm with a code",
+		Data: struct {
+			Secret string `json:"secret"`
+			URI    string `json:"uri"`
+		}{
+			Secret: secret,
+			URI:    totp.URI(totpIssuer, user.Email, secret),
+		},
+	}
+
+	app.writeJSON(w, http.StatusCreated, payload)
+}
+
+// ConfirmTOTP turns on two-factor authentication once the user has shown they can
+// generate a valid code. The response carries the user's recovery codes, which are
+// never shown again.
+func (app *Config) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.currentUserID(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	var requestPayload secondFactorPayload
+
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	mfa, err := app.TOTP.GetForUser(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("no pending enrollment"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if mfa.Confirmed() {
+		app.errorJSON(w, errors.New("two-factor authentication is already enabled"), http.StatusConflict)
+		return
+	}
+
+	step, ok := totp.Validate(mfa.Secret, requestPayload.Code, time.Now())
+	if !ok {
+		app.errorJSON(w, errInvalidCode, http.StatusUnauthorized)
+		return
+	}
+
+	codes, err := app.newRecoveryCodes(r.Context(), userID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.TOTP.Confirm(r.Context(), userID, step)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "two-factor authentication enabled; store these recovery codes somewhere safe",
+		Data: struct {
+			RecoveryCodes []string `json:"recovery_codes"`
+		}{
+			RecoveryCodes: codes,
+		},
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// DisableTOTP turns off two-factor authentication for the current user. It needs a
+// current code or a recovery code, so a stolen access token alone is not enough.
+func (app *Config) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.currentUserID(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	var requestPayload secondFactorPayload
+
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	mfa, err := app.TOTP.GetForUser(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("two-factor authentication is not enabled"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if mfa.Confirmed() {
+		user, err := app.Users.GetOneContext(r.Context(), userID)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		// a stolen session must not be able to guess its way past the second factor
+		ip := app.clientIP(r)
+
+		wait, err := app.Limiter.Reserve(r.Context(), user.Email, ip)
+		if errors.Is(err, lockout.ErrBlocked) {
+			app.tooManyAttempts(w, wait)
+			return
+		} else if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		ok, err := app.verifySecondFactor(r.Context(), mfa, requestPayload)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		if !ok {
+			app.recordFailure(r.Context(), user.Email, ip)
+			app.errorJSON(w, errInvalidCode, http.StatusUnauthorized)
+			return
+		}
+
+		app.clearFailures(r.Context(), user.Email, ip)
+	}
+
+	err = app.TOTP.Delete(r.Context(), userID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: "two-factor authentication disabled",
+	})
+}
+
+// verifySecondFactor checks either the TOTP code or the recovery code in payload.
+// Each code is only ever accepted once.
+func (app *Config) verifySecondFactor(ctx context.Context, mfa *data.TOTP, payload secondFactorPayload) (bool, error) {
+	if payload.RecoveryCode != "" {
+		return app.RecoveryCodes.Use(ctx, mfa.UserID, hashToken(normalizeRecoveryCode(payload.RecoveryCode)))
+	}
+
+	step, ok := totp.Validate(mfa.Secret, payload.Code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	return app.TOTP.UseStep(ctx, mfa.UserID, step)
+}
+
+// newRecoveryCodes replaces a user's recovery codes, returning the new codes in the
+// clear. Only their hashes are stored.
+func (app *Config) newRecoveryCodes(ctx context.Context, userID int) ([]string, error) {
+	var codes, hashes []string
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+
+		raw := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		code := raw[:5] + "-" + raw[5:10]
+		codes = append(codes, code)
+		hashes = append(hashes, hashToken(normalizeRecoveryCode(code)))
+	}
+
+	err := app.RecoveryCodes.Replace(ctx, userID, hashes)
+	if err != nil {
+		return nil, fmt.Errorf("storing recovery codes: %w", err)
+	}
+
+	return codes, nil
+}
+
+// normalizeRecoveryCode lets users type recovery codes with or without the dash, in any case
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -0,0 +1,785 @@
+package main
+
+import (
+	"authentication/data"
+	"authentication/lockout"
+	"authentication/password"
+	"authz"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	authCodeTTL    = time.Minute
+	csrfCookieName = "oauth_csrf"
+)
+
+// authorizeRequest is an OAuth 2.0 authorization request, as sent to /oauth/authorize.
+// GET carries it in the query string; the login form posts it back as hidden fields.
+type authorizeRequest struct {
+	ClientID            string
+	RedirectURI         string
+	ResponseType        string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+
+	// target is where the browser is sent back to: RedirectURI, or the client's
+	// only registered URI if the request did not name one
+	target string
+}
+
+func readAuthorizeRequest(r *http.Request) authorizeRequest {
+	return authorizeRequest{
+		ClientID:            r.FormValue("client_id"),
+		RedirectURI:         r.FormValue("redirect_uri"),
+		ResponseType:        r.FormValue("response_type"),
+		Scope:               r.FormValue("scope"),
+		State:               r.FormValue("state"),
+		Nonce:               r.FormValue("nonce"),
+		CodeChallenge:       r.FormValue("code_challenge"),
+		CodeChallengeMethod: r.FormValue("code_challenge_method"),
+	}
+}
+
+// hasScope reports whether the space separated scope list includes want
+func hasScope(scope, want string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == want {
+			return true
+		}
+	}
+
+	return false
+}
+
+// idTokenClaims is the payload of an OpenID Connect ID token
+type idTokenClaims struct {
+	Issuer          string `json:"iss"`
+	Subject         string `json:"sub"`
+	Audience        string `json:"aud"`
+	ExpiresAt       int64  `json:"exp"`
+	IssuedAt        int64  `json:"iat"`
+	AuthTime        int64  `json:"auth_time"`
+	Nonce           string `json:"nonce,omitempty"`
+	AccessTokenHash string `json:"at_hash,omitempty"`
+	Email           string `json:"email,omitempty"`
+	GivenName       string `json:"given_name,omitempty"`
+	FamilyName      string `json:"family_name,omitempty"`
+}
+
+// OpenIDConfiguration serves the OpenID Connect discovery document
+func (app *Config) OpenIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	app.writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                app.Issuer,
+		"authorization_endpoint":                app.Issuer + "/oauth/authorize",
+		"token_endpoint":                        app.Issuer + "/oauth/token",
+		"userinfo_endpoint":                     app.Issuer + "/oauth/userinfo",
+		"jwks_uri":                              app.Issuer + "/.well-known/jwks.json",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code", "client_credentials"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{"openid", "email", "profile"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported":                      []string{"sub", "email", "given_name", "family_name", "name"},
+	})
+}
+
+// JWKS publishes the public keys that access and ID tokens are signed with
+func (app *Config) JWKS(w http.ResponseWriter, r *http.Request) {
+	headers := http.Header{}
+	headers.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(authz.JWKSMaxAge.Seconds())))
+
+	app.writeJSON(w, http.StatusOK, app.Keys.JWKS(), headers)
+}
+
+// Authorize is the authorization endpoint. GET shows the login form; POST checks the
+// credentials from it and sends the browser back to the client with a code.
+func (app *Config) Authorize(w http.ResponseWriter, r *http.Request) {
+	req := readAuthorizeRequest(r)
+
+	client, err := app.OAuth.GetClient(r.Context(), req.ClientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.loginPage(w, http.StatusBadRequest, loginView{Error: "Unknown client."})
+		return
+	} else if err != nil {
+		app.loginPage(w, http.StatusInternalServerError, loginView{Error: "Something went wrong. Please try again."})
+		log.Println("Error loading OAuth client:", err)
+		return
+	}
+
+	// a client with a single redirect URI may leave it out, in which case the token
+	// request may too; RedirectURI stays as it was sent, for the code to record
+	req.target = req.RedirectURI
+	if req.target == "" && len(client.RedirectURIs) == 1 {
+		req.target = client.RedirectURIs[0]
+	}
+
+	// never redirect anywhere the client has not registered
+	if !client.AllowsRedirect(req.target) {
+		app.loginPage(w, http.StatusBadRequest, loginView{Error: "The redirect URI is not registered for this client."})
+		return
+	}
+
+	switch {
+	case req.ResponseType != "code":
+		authorizeRedirect(w, r, req, url.Values{
+			"error":             {"unsupported_response_type"},
+			"error_description": {"only the authorization code flow is supported"},
+		})
+		return
+	case !hasScope(req.Scope, "openid"):
+		authorizeRedirect(w, r, req, url.Values{
+			"error":             {"invalid_scope"},
+			"error_description": {"the openid scope is required"},
+		})
+		return
+	case req.CodeChallenge == "" || req.CodeChallengeMethod != "S256":
+		authorizeRedirect(w, r, req, url.Values{
+			"error":             {"invalid_request"},
+			"error_description": {"PKCE with code_challenge_method S256 is required"},
+		})
+		return
+	}
+
+	view := loginView{Client: client, Request: req}
+
+	if r.Method == http.MethodGet {
+		view.CSRFToken, err = randomToken(32)
+		if err != nil {
+			app.loginPage(w, http.StatusInternalServerError, loginView{Error: "Something went wrong. Please try again."})
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     csrfCookieName,
+			Value:    view.CSRFToken,
+			Path:     "/oauth/authorize",
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		app.loginPage(w, http.StatusOK, view)
+		return
+	}
+
+	cookie, err := r.Cookie(csrfCookieName)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.PostFormValue("csrf_token"))) != 1 {
+		app.loginPage(w, http.StatusForbidden, loginView{Error: "Your session has expired. Please go back and try again."})
+		return
+	}
+	view.CSRFToken = cookie.Value
+	view.Email = r.PostFormValue("email")
+
+	user, status, msg := app.signIn(w, r, view.Email, r.PostFormValue("password"), r.PostFormValue("code"))
+	if user == nil {
+		view.Error = msg
+		app.loginPage(w, status, view)
+		return
+	}
+
+	code, err := randomToken(32)
+	if err != nil {
+		app.loginPage(w, http.StatusInternalServerError, loginView{Error: "Something went wrong. Please try again."})
+		return
+	}
+
+	// the client gets its tokens from the code, not a refresh token of ours
+	session, _, err := app.startSession(r.Context(), r, user)
+	if err != nil {
+		log.Println("Error starting session:", err)
+		app.loginPage(w, http.StatusInternalServerError, loginView{Error: "Something went wrong. Please try again."})
+		return
+	}
+
+	now := time.Now()
+
+	err = app.OAuth.InsertCode(r.Context(), data.AuthCode{
+		CodeHash:      hashToken(code),
+		ClientID:      client.ClientID,
+		UserID:        user.ID,
+		RedirectURI:   req.RedirectURI,
+		Scope:         req.Scope,
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+		SessionID:     session.ID,
+		AuthTime:      now,
+		ExpiresAt:     now.Add(authCodeTTL),
+	})
+	if err != nil {
+		log.Println("Error storing authorization code:", err)
+		app.loginPage(w, http.StatusInternalServerError, loginView{Error: "Something went wrong. Please try again."})
+		return
+	}
+
+	err = app.logRequest("authentication", fmt.Sprintf("%s signed in to %s", user.Email, client.Name))
+	if err != nil {
+		log.Println("Error logging sign in:", err)
+	}
+
+	app.loginSucceeded(r, user, loginByOAuth)
+
+	authorizeRedirect(w, r, req, url.Values{"code": {code}})
+}
+
+// signIn checks the credentials posted from the login form, under the same lockout
+// rules as /authenticate. It returns the user, or a status and a message to show.
+func (app *Config) signIn(w http.ResponseWriter, r *http.Request, email, plainText, code string) (*data.User, int, string) {
+	ip := app.clientIP(r)
+
+	wait, err := app.Limiter.Reserve(r.Context(), email, ip)
+	if errors.Is(err, lockout.ErrBlocked) {
+		app.recordLogin(r, loginByOAuth, data.LoginBlocked, 0, email)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return nil, http.StatusTooManyRequests, "Too many failed attempts. Please try again later."
+	} else if err != nil {
+		log.Println("Error checking failed logins:", err)
+		return nil, http.StatusInternalServerError, "Something went wrong. Please try again."
+	}
+
+	user, err := app.Users.GetByEmailContext(r.Context(), email)
+	if err != nil {
+		if errors.Is(app.checkNoUser(plainText), password.ErrBusy) {
+			app.releaseAttempt(r.Context(), email, ip)
+			w.Header().Set("Retry-After", busyRetryAfter)
+			return nil, http.StatusServiceUnavailable, "We're very busy right now. Please try again in a moment."
+		}
+
+		app.recordLogin(r, loginByOAuth, data.LoginFailed, 0, email)
+		app.recordFailure(r.Context(), email, ip)
+		return nil, http.StatusBadRequest, "Invalid email or password."
+	}
+
+	valid, err := app.checkPassword(r.Context(), user, plainText)
+	if errors.Is(err, password.ErrBusy) {
+		app.releaseAttempt(r.Context(), email, ip)
+		w.Header().Set("Retry-After", busyRetryAfter)
+		return nil, http.StatusServiceUnavailable, "We're very busy right now. Please try again in a moment."
+	} else if err != nil || !valid || user.Active != 1 {
+		app.recordLogin(r, loginByOAuth, data.LoginFailed, user.ID, user.Email)
+		app.recordFailure(r.Context(), email, ip)
+		return nil, http.StatusBadRequest, "Invalid email or password."
+	}
+
+	if user.MFAEnabled {
+		if code == "" {
+			app.recordLogin(r, loginByOAuth, data.LoginMFARequired, user.ID, user.Email)
+			return nil, http.StatusBadRequest, "Enter the code from your authenticator app, or a recovery code."
+		}
+
+		mfa, err := app.TOTP.GetForUser(r.Context(), user.ID)
+		if err != nil {
+			log.Println("Error loading second factor:", err)
+			return nil, http.StatusInternalServerError, "Something went wrong. Please try again."
+		}
+
+		payload := secondFactorPayload{Code: code}
+		if strings.Contains(code, "-") || len(code) > 6 {
+			payload = secondFactorPayload{RecoveryCode: code}
+		}
+
+		ok, err := app.verifySecondFactor(r.Context(), mfa, payload)
+		if err != nil || !ok {
+			app.recordLogin(r, loginByOAuth, data.LoginFailed, user.ID, user.Email)
+			app.recordFailure(r.Context(), email, ip)
+			return nil, http.StatusBadRequest, "Invalid code."
+		}
+	}
+
+	app.clearFailures(r.Context(), email, ip)
+
+	return user, 0, ""
+}
+
+// authorizeRedirect sends the browser back to the client's redirect URI with params,
+// and the state from the request
+func authorizeRedirect(w http.ResponseWriter, r *http.Request, req authorizeRequest, params url.Values) {
+	u, err := url.Parse(req.target)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+
+	if req.State != "" {
+		q.Set("state", req.State)
+	}
+
+	u.RawQuery = q.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// Token is the token endpoint. It trades an authorization code for an access token
+// and an ID token, or an API key for an access token with the client credentials grant.
+func (app *Config) Token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_request", "could not parse the request body")
+		return
+	}
+
+	switch grant := r.PostForm.Get("grant_type"); grant {
+	case "authorization_code":
+	case "client_credentials":
+		app.clientCredentialsGrant(w, r)
+		return
+	default:
+		oauthError(w, http.StatusBadRequest, "unsupported_grant_type", fmt.Sprintf("grant type %q is not supported", grant))
+		return
+	}
+
+	client, ok := app.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	code, err := app.OAuth.ConsumeCode(r.Context(), hashToken(r.PostForm.Get("code")))
+	if errors.Is(err, sql.ErrNoRows) {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "invalid authorization code")
+		return
+	} else if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	// the redirect URI must be sent again, exactly as it was to /oauth/authorize, if
+	// it was sent there at all (RFC 6749 section 4.1.3)
+	redirectURI := r.PostForm.Get("redirect_uri")
+
+	switch {
+	case code.ClientID != client.ClientID,
+		time.Now().After(code.ExpiresAt),
+		code.RedirectURI != "" && redirectURI != code.RedirectURI:
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "invalid authorization code")
+		return
+	case !verifyPKCE(code.CodeChallenge, r.PostForm.Get("code_verifier")):
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "code_verifier does not match the code_challenge")
+		return
+	}
+
+	user, err := app.Users.GetOneContext(r.Context(), code.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "the user no longer exists")
+		return
+	} else if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	if user.Active != 1 {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "the user has been deactivated")
+		return
+	}
+
+	// the user may have signed the session out between sign in and redemption
+	session, err := app.Sessions.Get(r.Context(), code.SessionID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !session.Active(time.Now())) {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "the session has ended")
+		return
+	} else if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	// the client gets a token for what the user granted it, not one of ours
+	token, err := app.clientToken(user, session.ID, client.ClientID, code.Scope)
+	if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	idToken, err := app.idToken(client, user, code, token)
+	if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	headers := http.Header{}
+	headers.Set("Cache-Control", "no-store")
+	headers.Set("Pragma", "no-cache")
+
+	app.writeJSON(w, http.StatusOK, struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int    `json:"expires_in"`
+		IDToken     string `json:"id_token"`
+		Scope       string `json:"scope"`
+	}{
+		AccessToken: token.AccessToken,
+		TokenType:   token.TokenType,
+		ExpiresIn:   int(time.Until(token.ExpiresAt).Seconds()),
+		IDToken:     idToken,
+		Scope:       code.Scope,
+	}, headers)
+}
+
+// authenticateClient identifies the client calling the token endpoint, from HTTP
+// Basic auth or from client_id and client_secret in the body. Public clients only
+// send their client_id; PKCE is what protects their codes.
+func (app *Config) authenticateClient(w http.ResponseWriter, r *http.Request) (*data.OAuthClient, bool) {
+	clientID, secret, basic := clientCredentials(r)
+
+	fail := func() (*data.OAuthClient, bool) {
+		clientAuthFailed(w, basic)
+		return nil, false
+	}
+
+	client, err := app.OAuth.GetClient(r.Context(), clientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fail()
+	} else if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", "")
+		return nil, false
+	}
+
+	if !client.Public() && subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return fail()
+	}
+
+	return client, true
+}
+
+// clientCredentials returns the client_id and client_secret sent to the token
+// endpoint, from HTTP Basic auth or the body, and whether Basic auth was used.
+func clientCredentials(r *http.Request) (string, string, bool) {
+	clientID, secret, basic := r.BasicAuth()
+	if !basic {
+		return r.PostForm.Get("client_id"), r.PostForm.Get("client_secret"), false
+	}
+
+	// RFC 6749 has clients form encode their credentials before sending them
+	clientID, _ = url.QueryUnescape(clientID)
+	secret, _ = url.QueryUnescape(secret)
+
+	return clientID, secret, true
+}
+
+// clientAuthFailed rejects a client whose credentials are wrong
+func clientAuthFailed(w http.ResponseWriter, basic bool) {
+	if basic {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	oauthError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+}
+
+// verifyPKCE checks a code_verifier against the S256 code_challenge it was issued for
+func verifyPKCE(challenge, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// idToken signs an ID token for user, audienced to client, with the current signing key
+func (app *Config) idToken(client *data.OAuthClient, user *data.User, code *data.AuthCode, token *tokenResponse) (string, error) {
+	// at_hash is the left half of the SHA-256 of the access token
+	sum := sha256.Sum256([]byte(token.AccessToken))
+
+	claims := idTokenClaims{
+		Issuer:          app.Issuer,
+		Subject:         strconv.Itoa(user.ID),
+		Audience:        client.ClientID,
+		ExpiresAt:       token.ExpiresAt.Unix(),
+		IssuedAt:        time.Now().Unix(),
+		AuthTime:        code.AuthTime.Unix(),
+		Nonce:           code.Nonce,
+		AccessTokenHash: base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2]),
+	}
+
+	if hasScope(code.Scope, "email") {
+		claims.Email = user.Email
+	}
+
+	if hasScope(code.Scope, "profile") {
+		claims.GivenName = user.FirstName
+		claims.FamilyName = user.LastName
+	}
+
+	key := app.Keys.Signing()
+
+	return authz.SignRS256(key.ID, key.Private, claims)
+}
+
+// oauthError sends an error in the form RFC 6749 section 5.2 asks for
+func oauthError(w http.ResponseWriter, status int, code, description string) {
+	out, _ := json.Marshal(struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description,omitempty"`
+	}{code, description})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_, _ = w.Write(out)
+}
+
+// authenticateUserInfo is Authenticate for the userinfo endpoint, which also takes
+// the tokens issued to OAuth clients, as long as they were granted the openid scope.
+func (app *Config) authenticateUserInfo(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := authz.BearerToken(r)
+		if token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		claims, err := app.Verifier.Verify(token)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			oauthError(w, http.StatusUnauthorized, "invalid_token", err.Error())
+			return
+		}
+
+		if claims.Audience != "" && !hasScope(claims.Scope, "openid") {
+			w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+			oauthError(w, http.StatusForbidden, "insufficient_scope", "the token was not granted the openid scope")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(authz.NewContext(r.Context(), claims)))
+	})
+}
+
+// UserInfo returns the claims about the user that the access token was issued to.
+// A token issued to an OAuth client only gets the claims its scopes cover.
+func (app *Config) UserInfo(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.currentUserID(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		oauthError(w, http.StatusUnauthorized, "invalid_token", err.Error())
+		return
+	}
+
+	user, err := app.Users.GetOneContext(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		oauthError(w, http.StatusUnauthorized, "invalid_token", "the user no longer exists")
+		return
+	} else if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	claims, _ := authz.FromContext(r.Context())
+	granted := func(scope string) bool {
+		return claims.Audience == "" || hasScope(claims.Scope, scope)
+	}
+
+	info := map[string]any{"sub": strconv.Itoa(user.ID)}
+
+	if granted("email") {
+		info["email"] = user.Email
+	}
+
+	if granted("profile") {
+		info["given_name"] = user.FirstName
+		info["family_name"] = user.LastName
+		info["name"] = strings.TrimSpace(user.FirstName + " " + user.LastName)
+	}
+
+	app.writeJSON(w, http.StatusOK, info)
+}
+
+// AllClients lists the registered OAuth clients
+func (app *Config) AllClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := app.OAuth.AllClients(r.Context())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: "clients",
+		Data:    clients,
+	})
+}
+
+// RegisterClient registers an OAuth client. The secret is only ever shown in this
+// response; we keep a hash of it.
+func (app *Config) RegisterClient(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Public       bool     `json:"public"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if strings.TrimSpace(requestPayload.Name) == "" {
+		app.errorJSON(w, errors.New("name is required"))
+		return
+	}
+
+	if len(requestPayload.RedirectURIs) == 0 {
+		app.errorJSON(w, errors.New("at least one redirect URI is required"))
+		return
+	}
+
+	for _, uri := range requestPayload.RedirectURIs {
+		if err := validateRedirectURI(uri); err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+	}
+
+	clientID, err := randomToken(16)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	client := data.OAuthClient{
+		ClientID:     clientID,
+		Name:         requestPayload.Name,
+		RedirectURIs: requestPayload.RedirectURIs,
+	}
+
+	var secret string
+	if !requestPayload.Public {
+		secret, err = randomToken(32)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+		client.SecretHash = hashToken(secret)
+	}
+
+	client.ID, err = app.OAuth.InsertClient(r.Context(), client)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Registered client %s", client.Name),
+		Data: struct {
+			*data.OAuthClient
+			ClientSecret string `json:"client_secret,omitempty"`
+		}{&client, secret},
+	})
+}
+
+// validateRedirectURI makes sure uri is an absolute http(s) URL without a fragment
+func validateRedirectURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		return fmt.Errorf("redirect URI %q must be an absolute http or https URL", uri)
+	}
+
+	if u.Fragment != "" || strings.Contains(uri, "#") {
+		return fmt.Errorf("redirect URI %q must not have a fragment", uri)
+	}
+
+	return nil
+}
+
+// DeleteClient removes an OAuth client. Tokens already issued to it stay valid until they expire.
+func (app *Config) DeleteClient(w http.ResponseWriter, r *http.Request) {
+	clientID := chi.URLParam(r, "clientID")
+
+	_, err := app.OAuth.GetClient(r.Context(), clientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("no such client"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.OAuth.DeleteClient(r.Context(), clientID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusAccepted, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Deleted client %s", clientID),
+	})
+}
+
+// loginView is what the login form is rendered from
+type loginView struct {
+	Client    *data.OAuthClient
+	Request   authorizeRequest
+	CSRFToken string
+	Email     string
+	Error     string
+}
+
+var loginTemplate = template.Must(template.New("login").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Sign in</title>
+</head>
+<body>
+{{if .Client}}<h1>Sign in to {{.Client.Name}}</h1>{{else}}<h1>Sign in</h1>{{end}}
+{{with .Error}}<p role="alert">{{.}}</p>{{end}}
+{{if .CSRFToken}}
+<form method="post" action="/oauth/authorize">
+<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<input type="hidden" name="client_id" value="{{.Request.ClientID}}">
+<input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+<input type="hidden" name="response_type" value="{{.Request.ResponseType}}">
+<input type="hidden" name="scope" value="{{.Request.Scope}}">
+<input type="hidden" name="state" value="{{.Request.State}}">
+<input type="hidden" name="nonce" value="{{.Request.Nonce}}">
+<input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.Request.CodeChallengeMethod}}">
+<p><label>Email <input type="email" name="email" value="{{.Email}}" autocomplete="username" required></label></p>
+<p><label>Password <input type="password" name="password" autocomplete="current-password" required></label></p>
+<p><label>Authentication code (if enabled) <input type="text" name="code" autocomplete="one-time-code"></label></p>
+<p><button type="submit">Sign in</button></p>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+// loginPage renders the login form, or just an error if there is no form to show
+func (app *Config) loginPage(w http.ResponseWriter, status int, view loginView) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+
+	if err := loginTemplate.Execute(w, view); err != nil {
+		log.Println("Error rendering login page:", err)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"authentication/data"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// AllOrganizations lists every organization
+func (app *Config) AllOrganizations(w http.ResponseWriter, r *http.Request) {
+	orgs, err := app.Organizations.All(r.Context())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: "organizations",
+		Data:    orgs,
+	})
+}
+
+// CreateOrganization adds a new organization with no members
+func (app *Config) CreateOrganization(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Name string `json:"name"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	requestPayload.Name = strings.TrimSpace(requestPayload.Name)
+	if requestPayload.Name == "" {
+		app.errorJSON(w, errors.New("organization name is required"))
+		return
+	}
+
+	id, err := app.Organizations.Insert(r.Context(), data.Organization{Name: requestPayload.Name})
+	if errors.Is(err, data.ErrDuplicateOrganization) {
+		app.errorJSON(w, err, http.StatusConflict)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	org, err := app.Organizations.Get(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("created organization %s", org.Name),
+		Data:    org,
+	})
+}
+
+// DeleteOrganization removes an organization, its memberships and its API keys.
+// Records other services hold for the tenant are left where they are.
+func (app *Config) DeleteOrganization(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIntParam(r, "id")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.Organizations.Delete(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("no such organization"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("deleted organization %d", id),
+	})
+}
+
+// OrganizationMembers lists the ids of an organization's users
+func (app *Config) OrganizationMembers(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.loadOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	members, err := app.Organizations.Members(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("members of organization %d", id),
+		Data:    members,
+	})
+}
+
+// AddOrganizationMember puts a user in an organization. If it is the first one they
+// join, their next token is issued for it.
+func (app *Config) AddOrganizationMember(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.loadOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	userID, err := app.readIntParam(r, "userID")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	_, err = app.Users.GetOneContext(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("no such user"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.Organizations.AddMember(r.Context(), id, userID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("added user %d to organization %d", userID, id),
+	})
+}
+
+// RemoveOrganizationMember takes a user out of an organization. Tokens already
+// issued for it keep their tenant until they expire.
+func (app *Config) RemoveOrganizationMember(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIntParam(r, "id")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	userID, err := app.readIntParam(r, "userID")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	ok, err := app.Organizations.RemoveMember(r.Context(), id, userID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if !ok {
+		app.errorJSON(w, errors.New("the user is not a member"), http.StatusNotFound)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("removed user %d from organization %d", userID, id),
+	})
+}
+
+// loadOrganization reads the organization id from the route, and sends a 404 if
+// there is no such organization
+func (app *Config) loadOrganization(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := app.readIntParam(r, "id")
+	if err != nil {
+		app.errorJSON(w, err)
+		return 0, false
+	}
+
+	_, err = app.Organizations.Get(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("no such organization"), http.StatusNotFound)
+		return 0, false
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return 0, false
+	}
+
+	return id, true
+}
+
+// createUser stores a new user and puts them in organizationID, or in the default
+// organization if that is 0, so that their tokens carry a tenant from the start. A
+// user who was stored but could not be added is returned with the error.
+func (app *Config) createUser(ctx context.Context, user data.User, organizationID int) (int, error) {
+	if organizationID == 0 {
+		org, err := app.Organizations.Default(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("finding the default organization: %w", err)
+		}
+		organizationID = org.ID
+	}
+
+	id, err := app.Users.InsertContext(ctx, user)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := app.Organizations.AddMember(ctx, organizationID, id); err != nil {
+		return id, fmt.Errorf("user created, but not added to organization %d: %w", organizationID, err)
+	}
+
+	return id, nil
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"archive/zip"
+	"authentication/data"
+	"authentication/event"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// subjectEntry is a log entry, as the logger service returns it for a data subject
+// request. Sent mail is recorded as entries named "mail".
+type subjectEntry struct {
+	ID         string         `json:"id"`
+	Name       string         `json:"name"`
+	Data       string         `json:"data"`
+	Tenant     string         `json:"tenant,omitempty"`
+	Severity   string         `json:"severity,omitempty"`
+	Source     string         `json:"source,omitempty"`
+	UserID     string         `json:"user_id,omitempty"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+}
+
+// erasure is what erasing a user removed or pseudonymised
+type erasure struct {
+	UserID       int    `json:"user_id"`
+	Pseudonym    string `json:"pseudonym"`
+	Sessions     int    `json:"sessions"`
+	LoginHistory int    `json:"login_history"`
+	LogEntries   int    `json:"log_entries"`
+}
+
+// ExportUser answers a data subject access request with a ZIP file holding the
+// user's account, roles, sessions and login history, and every log entry and sent
+// mail record that mentions them
+func (app *Config) ExportUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.loadSubject(w, r)
+	if !ok {
+		return
+	}
+
+	access, err := app.Users.GetAccessContext(r.Context(), user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	sessions, err := app.Sessions.ForUser(r.Context(), user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	history, err := app.allLoginHistory(r.Context(), user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	var entries []subjectEntry
+	err = app.callLogSubjects("/export", subjectTerms(user), "", &entries)
+	if err != nil {
+		app.errorJSON(w, fmt.Errorf("fetching log entries: %w", err), http.StatusBadGateway)
+		return
+	}
+
+	logs, mails := []subjectEntry{}, []subjectEntry{}
+	for _, e := range entries {
+		if e.Name == "mail" {
+			mails = append(mails, e)
+		} else {
+			logs = append(logs, e)
+		}
+	}
+
+	files := []struct {
+		name     string
+		contents any
+	}{
+		{"user.json", struct {
+			*data.User
+			*data.Access
+		}{user, access}},
+		{"sessions.json", sessions},
+		{"login_history.json", history},
+		{"logs.json", logs},
+		{"mail.json", mails},
+	}
+
+	// built in memory first, so that a failure can still be reported as JSON
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "\t")
+		if err := enc.Encode(f.contents); err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.logPrivacy(r, fmt.Sprintf("data of user %d exported", user.ID))
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%d.zip"`, user.ID))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// EraseUser answers a data subject erasure request. The user's sessions and login
+// history are deleted, their account is deactivated and stripped of their email
+// address and names, and every mention of their address in the log and mail records
+// is replaced with a pseudonym. A user.erased event is published once it is done.
+// Erasing the same user again is harmless, so a failed erasure can be retried.
+func (app *Config) EraseUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.loadSubject(w, r)
+	if !ok {
+		return
+	}
+
+	if id, _ := app.currentUserID(r); id == user.ID {
+		app.errorJSON(w, errors.New("you cannot erase your own account"))
+		return
+	}
+
+	result := erasure{UserID: user.ID, Pseudonym: fmt.Sprintf("erased-%d@erased.invalid", user.ID)}
+
+	// the logs go first: they can only be found by the address we are about to
+	// overwrite, so if this fails nothing else has changed and the erasure can be
+	// run again
+	var changed struct {
+		Changed int `json:"changed"`
+	}
+	err := app.callLogSubjects("/erase", []string{user.Email}, result.Pseudonym, &changed)
+	if err != nil {
+		app.errorJSON(w, fmt.Errorf("pseudonymising log entries: %w", err), http.StatusBadGateway)
+		return
+	}
+	result.LogEntries = changed.Changed
+
+	now := time.Now()
+
+	ids, err := app.Sessions.RevokeAllForUser(r.Context(), user.ID, now)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	app.revoked(user.ID, ids, now)
+
+	result.Sessions, err = app.Sessions.DeleteForUser(r.Context(), user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	result.LoginHistory, err = app.LoginHistory.DeleteForUser(r.Context(), user.ID, user.Email)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.EmailChanges.DeleteForUser(r.Context(), user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// the account is pseudonymised before its password is reset, so that the user
+	// events this publishes, which end up in the logs, never carry the address
+	erased := data.User{ID: user.ID, Email: result.Pseudonym, Active: 0}
+
+	err = app.Users.UpdateContext(r.Context(), erased)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// nobody can sign in as the user again
+	random, err := randomToken(32)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.Users.ResetPasswordContext(r.Context(), user.ID, random)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	e := event.NewUserEvent(event.UserErased, event.UserData{ID: erased.ID, Email: erased.Email})
+
+	err = app.Events.Publish(event.UsersExchange, event.UserErased, e)
+	if err != nil {
+		log.Printf("Error publishing %s for user %d: %v", event.UserErased, user.ID, err)
+	}
+
+	app.logPrivacy(r, fmt.Sprintf("user %d erased: %d sessions and %d login attempts deleted, %d log entries pseudonymised",
+		user.ID, result.Sessions, result.LoginHistory, result.LogEntries))
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("erased user %d", user.ID),
+		Data:    result,
+	})
+}
+
+// loadSubject returns the user named by the id URL parameter, or sends the error
+func (app *Config) loadSubject(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := app.readIntParam(r, "id")
+	if err != nil {
+		app.errorJSON(w, err)
+		return nil, false
+	}
+
+	user, err := app.Users.GetOneContext(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("no such user"), http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// allLoginHistory returns every one of a user's login attempts, newest first
+func (app *Config) allLoginHistory(ctx context.Context, userID int) ([]*data.LoginEvent, error) {
+	const pageSize = 500
+
+	all := []*data.LoginEvent{}
+	for {
+		events, total, err := app.LoginHistory.ForUser(ctx, userID, len(all), pageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, events...)
+		if len(events) == 0 || len(all) >= total {
+			return all, nil
+		}
+	}
+}
+
+// subjectTerms are the ways log entries refer to user: by address, or by id
+func subjectTerms(user *data.User) []string {
+	return []string{user.Email, fmt.Sprintf("user %d", user.ID)}
+}
+
+// callLogSubjects makes a data subject request of the logger service, and decodes
+// the data in its response into out
+func (app *Config) callLogSubjects(path string, terms []string, pseudonym string, out any) error {
+	jsonData, _ := json.Marshal(struct {
+		Terms     []string `json:"terms"`
+		Pseudonym string   `json:"pseudonym,omitempty"`
+	}{terms, pseudonym})
+
+	request, err := http.NewRequest("POST", app.LogSubjectsURL+path, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+
+	token, err := app.serviceToken("logs:subjects")
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("logger service returned %s", response.Status)
+	}
+
+	var payload struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&payload); err != nil {
+		return err
+	}
+
+	if len(payload.Data) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(payload.Data, out)
+}
+
+// logPrivacy records a data subject request and the administrator who made it
+func (app *Config) logPrivacy(r *http.Request, msg string) {
+	if id, err := app.currentUserID(r); err == nil {
+		msg = fmt.Sprintf("%s by user %d", msg, id)
+	}
+
+	if err := app.logRequest("privacy", msg); err != nil {
+		log.Println("Error logging data subject request:", err)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"authentication/data"
+	"authentication/event"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	emailChangeTTL   = 24 * time.Hour
+	emailConfirmPath = "/me/email/confirm"
+)
+
+var errInvalidEmailChange = errors.New("this confirmation link is invalid or has expired")
+
+// Me returns the signed in user's profile
+func (app *Config) Me(w http.ResponseWriter, r *http.Request) {
+	id, err := app.currentUserID(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.Users.GetOneContext(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: "profile for " + user.Email,
+		Data:    user,
+	})
+}
+
+// UpdateMe changes the signed in user's names, and starts changing their email
+// address. Names change straight away. A new address only replaces the old one once
+// it is confirmed from a link sent to it; the old address is told about the change.
+// Fields left out of the body are left alone.
+func (app *Config) UpdateMe(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email     *string `json:"email"`
+		FirstName *string `json:"first_name"`
+		LastName  *string `json:"last_name"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	id, err := app.currentUserID(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.Users.GetOneContext(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	updated := *user
+	if requestPayload.FirstName != nil {
+		updated.FirstName = strings.TrimSpace(*requestPayload.FirstName)
+	}
+	if requestPayload.LastName != nil {
+		updated.LastName = strings.TrimSpace(*requestPayload.LastName)
+	}
+
+	if err := validNames(updated.FirstName, updated.LastName); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	newEmail := ""
+	if requestPayload.Email != nil && strings.TrimSpace(*requestPayload.Email) != user.Email {
+		newEmail = strings.TrimSpace(*requestPayload.Email)
+
+		if err := validEmail(newEmail); err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+
+		_, err := app.Users.GetByEmailContext(r.Context(), newEmail)
+		if err == nil {
+			app.errorJSON(w, data.ErrDuplicateEmail, http.StatusConflict)
+			return
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if updated.FirstName != user.FirstName || updated.LastName != user.LastName {
+		err = app.Users.UpdateContext(r.Context(), updated)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		app.logUserUpdated(fmt.Sprintf("user %d changed their name", user.ID))
+	}
+
+	if newEmail == "" {
+		app.writeJSON(w, http.StatusOK, jsonResponse{
+			Error:   false,
+			Message: "profile updated",
+			Data:    updated,
+		})
+		return
+	}
+
+	err = app.startEmailChange(r.Context(), &updated, newEmail)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusAccepted, jsonResponse{
+		Error:   false,
+		Message: "follow the link sent to " + newEmail + " to confirm your new email address",
+		Data:    updated,
+	})
+}
+
+// startEmailChange stores a pending change of user's address to newEmail, mails the
+// confirmation link to the new address, and tells the old one
+func (app *Config) startEmailChange(ctx context.Context, user *data.User, newEmail string) error {
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	err = app.EmailChanges.Insert(ctx, data.EmailChange{
+		TokenHash: hashToken(token),
+		UserID:    user.ID,
+		NewEmail:  newEmail,
+		CreatedAt: now,
+		ExpiresAt: now.Add(emailChangeTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := app.Issuer + emailConfirmPath + "?" + url.Values{"token": {token}}.Encode()
+
+	message := fmt.Sprintf("Follow this link to make this your account's email address: %s\n\n"+
+		"It works once, for the next %d hours. If you didn't ask for this, you can ignore "+
+		"this email.", link, int(emailChangeTTL.Hours()))
+
+	err = app.sendMail(newEmail, "Confirm your new email address", message)
+	if err != nil {
+		return err
+	}
+
+	notice := fmt.Sprintf("Someone asked to change your account's email address to %s. It will "+
+		"change once the link sent there is followed.\n\nIf this wasn't you, change your password "+
+		"and sign out of your other sessions straight away.", newEmail)
+
+	err = app.sendMail(user.Email, "Your email address is being changed", notice)
+	if err != nil {
+		log.Println("Error sending email change notice:", err)
+	}
+
+	return nil
+}
+
+// ConfirmEmailChange makes a pending change of email address, given the token from
+// the link sent to the new address. Each link works once.
+func (app *Config) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		app.errorJSON(w, errInvalidEmailChange)
+		return
+	}
+
+	change, err := app.EmailChanges.Consume(r.Context(), hashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errInvalidEmailChange)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if time.Now().After(change.ExpiresAt) {
+		app.errorJSON(w, errInvalidEmailChange)
+		return
+	}
+
+	user, err := app.Users.GetOneContext(r.Context(), change.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errInvalidEmailChange)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	oldEmail := user.Email
+	user.Email = change.NewEmail
+
+	// someone may have registered the address since the change was asked for
+	err = app.Users.UpdateContext(r.Context(), *user)
+	if errors.Is(err, data.ErrDuplicateEmail) {
+		app.errorJSON(w, err, http.StatusConflict)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.logUserUpdated(fmt.Sprintf("user %d changed their email address from %s to %s", user.ID, oldEmail, user.Email))
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: "email address changed to " + user.Email,
+	})
+}
+
+// logUserUpdated records a change a user made to their own profile
+func (app *Config) logUserUpdated(msg string) {
+	if err := app.logRequest(event.UserUpdated, msg); err != nil {
+		log.Println("Error logging profile change:", err)
+	}
+}
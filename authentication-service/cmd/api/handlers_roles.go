@@ -0,0 +1,251 @@
+package main
+
+import (
+	"authentication/data"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// AllRoles lists every role along with the permissions it grants
+func (app *Config) AllRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.Roles.GetAll(r.Context())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	type roleWithPermissions struct {
+		*data.Role
+		Permissions []*data.Permission `json:"permissions"`
+	}
+
+	var out []roleWithPermissions
+	for _, role := range roles {
+		permissions, err := app.Permissions.GetForRole(r.Context(), role.ID)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		out = append(out, roleWithPermissions{Role: role, Permissions: permissions})
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: "roles",
+		Data:    out,
+	})
+}
+
+// CreateRole adds a new, empty role
+func (app *Config) CreateRole(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	requestPayload.Name = strings.TrimSpace(requestPayload.Name)
+	if requestPayload.Name == "" {
+		app.errorJSON(w, errors.New("role name is required"))
+		return
+	}
+
+	id, err := app.Roles.Insert(r.Context(), data.Role{
+		Name:        requestPayload.Name,
+		Description: requestPayload.Description,
+	})
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	role, err := app.Roles.GetOne(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("created role %s", role.Name),
+		Data:    role,
+	})
+}
+
+// DeleteRole removes a role, along with its grants and assignments
+func (app *Config) DeleteRole(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIntParam(r, "id")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.Roles.DeleteByID(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("deleted role %d", id),
+	})
+}
+
+// AllPermissions lists every permission that can be granted to a role
+func (app *Config) AllPermissions(w http.ResponseWriter, r *http.Request) {
+	permissions, err := app.Permissions.GetAll(r.Context())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: "permissions",
+		Data:    permissions,
+	})
+}
+
+// GrantPermission attaches a permission to a role
+func (app *Config) GrantPermission(w http.ResponseWriter, r *http.Request) {
+	roleID, err := app.readIntParam(r, "id")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	permissionID, err := app.readIntParam(r, "permissionID")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.Roles.GrantPermission(r.Context(), roleID, permissionID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("granted permission %d to role %d", permissionID, roleID),
+	})
+}
+
+// RevokePermission detaches a permission from a role
+func (app *Config) RevokePermission(w http.ResponseWriter, r *http.Request) {
+	roleID, err := app.readIntParam(r, "id")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	permissionID, err := app.readIntParam(r, "permissionID")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.Roles.RevokePermission(r.Context(), roleID, permissionID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("revoked permission %d from role %d", permissionID, roleID),
+	})
+}
+
+// UserRoles lists the roles assigned to a user
+func (app *Config) UserRoles(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIntParam(r, "id")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	roles, err := app.Roles.GetForUser(r.Context(), userID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("roles for user %d", userID),
+		Data:    roles,
+	})
+}
+
+// AssignRole gives a role to a user. The change shows up in the next token the user is issued.
+func (app *Config) AssignRole(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIntParam(r, "id")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	roleID, err := app.readIntParam(r, "roleID")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	_, err = app.Users.GetOneContext(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("no such user"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.Roles.AssignToUser(r.Context(), userID, roleID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("assigned role %d to user %d", roleID, userID),
+	})
+}
+
+// RemoveRole takes a role away from a user
+func (app *Config) RemoveRole(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIntParam(r, "id")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	roleID, err := app.readIntParam(r, "roleID")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.Roles.RemoveFromUser(r.Context(), userID, roleID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("removed role %d from user %d", roleID, userID),
+	})
+}
@@ -0,0 +1,678 @@
+package main
+
+import (
+	"authentication/data"
+	"authentication/password"
+	"authentication/scim"
+	"authz"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// scimPermission is what a token or API key needs to provision through SCIM
+const scimPermission = "scim:provision"
+
+// writeSCIM sends a SCIM resource or message
+func (app *Config) writeSCIM(w http.ResponseWriter, status int, v any) {
+	out, err := json.Marshal(v)
+	if err != nil {
+		status = http.StatusInternalServerError
+		out, _ = json.Marshal(scim.NewError(status, "", err.Error()))
+	}
+
+	w.Header().Set("Content-Type", scim.ContentType)
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+// scimError sends a SCIM error response
+func (app *Config) scimError(w http.ResponseWriter, status int, scimType, detail string) {
+	app.writeSCIM(w, status, scim.NewError(status, scimType, detail))
+}
+
+// authenticateSCIM lets in callers with the scim:provision permission. HR systems
+// are set up with a long lived bearer token, so besides our access tokens we take an
+// API key itself as the bearer token, and give the request the key's scopes and
+// organization. Callers only ever provision within their own organization.
+func (app *Config) authenticateSCIM(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := authz.BearerToken(r)
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="scim"`)
+			app.scimError(w, http.StatusUnauthorized, "", authz.ErrUnauthenticated.Error())
+			return
+		}
+
+		var claims *authz.Claims
+
+		if prefix, secret, ok := strings.Cut(token, "."); ok && strings.HasPrefix(prefix, apiKeyPrefix) {
+			key, err := app.checkAPIKey(r.Context(), prefix, secret)
+			if errors.Is(err, errInvalidAPIKey) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="scim", error="invalid_token"`)
+				app.scimError(w, http.StatusUnauthorized, "", err.Error())
+				return
+			} else if err != nil {
+				app.scimError(w, http.StatusInternalServerError, "", err.Error())
+				return
+			}
+
+			claims = &authz.Claims{Subject: "apikey:" + key.Prefix, TenantID: apiKeyTenant(key), Permissions: key.Scopes}
+		} else {
+			var err error
+			claims, err = app.Verifier.Verify(token)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="scim", error="invalid_token"`)
+				app.scimError(w, http.StatusUnauthorized, "", err.Error())
+				return
+			}
+		}
+
+		if !claims.Can(scimPermission) || claims.Impersonated() {
+			app.scimError(w, http.StatusForbidden, "", authz.ErrForbidden.Error())
+			return
+		}
+
+		if claims.TenantID == "" {
+			app.scimError(w, http.StatusForbidden, "", authz.ErrNoTenant.Error())
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(authz.NewContext(r.Context(), claims)))
+	})
+}
+
+// scimTenant returns the organization a request provisions for. authenticateSCIM
+// has made sure there is one.
+func scimTenant(r *http.Request) int {
+	claims, _ := authz.FromContext(r.Context())
+	id, _ := strconv.Atoi(claims.TenantID)
+
+	return id
+}
+
+// scimOwns reports whether a user belongs to the organization a request provisions
+// for, which is the one their own tokens are issued for. Someone who has joined
+// other organizations as well is only managed by the first.
+func (app *Config) scimOwns(r *http.Request, userID int) (bool, error) {
+	tenant, err := app.tenantFor(r.Context(), userID)
+	if err != nil {
+		return false, err
+	}
+
+	return tenant == strconv.Itoa(scimTenant(r)), nil
+}
+
+// scimID reads the resource id from the route. Ids we could never have issued are
+// simply not found.
+func (app *Config) scimID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := app.readIntParam(r, "id")
+	if err != nil {
+		app.scimError(w, http.StatusNotFound, "", "resource not found")
+		return 0, false
+	}
+
+	return id, true
+}
+
+// scimUser is the SCIM view of a user
+func (app *Config) scimUser(u *data.User) scim.User {
+	id := strconv.Itoa(u.ID)
+	active := u.Active == 1
+
+	user := scim.User{
+		Schemas:  []string{scim.UserSchema},
+		ID:       id,
+		UserName: u.Email,
+		Emails:   []scim.Email{{Value: u.Email, Type: "work", Primary: true}},
+		Active:   &active,
+		Meta: &scim.Meta{
+			ResourceType: "User",
+			Created:      u.CreatedAt,
+			LastModified: u.UpdatedAt,
+			Location:     app.Issuer + "/scim/v2/Users/" + id,
+		},
+	}
+
+	if u.FirstName != "" || u.LastName != "" {
+		user.Name = &scim.Name{GivenName: u.FirstName, FamilyName: u.LastName}
+	}
+
+	return user
+}
+
+// fromSCIMUser copies what we store of a SCIM user onto u. The user's email address
+// is their userName; active is left alone when it isn't given.
+func fromSCIMUser(s scim.User, u *data.User) error {
+	u.Email = strings.TrimSpace(s.UserName)
+	if u.Email == "" {
+		return errors.New("userName is required")
+	}
+
+	u.FirstName, u.LastName = "", ""
+	if s.Name != nil {
+		u.FirstName, u.LastName = s.Name.GivenName, s.Name.FamilyName
+	}
+
+	if s.Active != nil {
+		u.Active = 0
+		if *s.Active {
+			u.Active = 1
+		}
+	}
+
+	return nil
+}
+
+// SCIMUsers lists users a page at a time, optionally filtered with userName eq
+func (app *Config) SCIMUsers(w http.ResponseWriter, r *http.Request) {
+	offset, limit, err := scim.Page(r.URL.Query().Get("startIndex"), r.URL.Query().Get("count"))
+	if err != nil {
+		app.scimError(w, http.StatusBadRequest, scim.InvalidValue, err.Error())
+		return
+	}
+
+	var users []*data.User
+
+	if filter := r.URL.Query().Get("filter"); filter != "" {
+		attribute, value, err := scim.ParseFilter(filter)
+		if err != nil || (attribute != "username" && attribute != "emails.value") {
+			app.scimError(w, http.StatusBadRequest, scim.InvalidFilter, scim.ErrInvalidFilter.Error()+", on userName")
+			return
+		}
+
+		user, err := app.Users.GetByEmailContext(r.Context(), value)
+		if err == nil {
+			var owned bool
+			owned, err = app.scimOwns(r, user.ID)
+			if owned {
+				users = append(users, user)
+			}
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			app.scimError(w, http.StatusInternalServerError, "", err.Error())
+			return
+		}
+	} else {
+		users, err = app.scimUsers(r)
+		if err != nil {
+			app.scimError(w, http.StatusInternalServerError, "", err.Error())
+			return
+		}
+	}
+
+	total := len(users)
+	page := []scim.User{}
+	for i := offset; i < total && len(page) < limit; i++ {
+		page = append(page, app.scimUser(users[i]))
+	}
+
+	app.writeSCIM(w, http.StatusOK, scim.NewListResponse(page, len(page), total, offset+1))
+}
+
+// scimUsers returns the users a request's organization owns, ordered by id
+func (app *Config) scimUsers(r *http.Request) ([]*data.User, error) {
+	ids, err := app.Organizations.Members(r.Context(), scimTenant(r))
+	if err != nil {
+		return nil, err
+	}
+
+	var users []*data.User
+
+	for _, id := range ids {
+		owned, err := app.scimOwns(r, id)
+		if err != nil {
+			return nil, err
+		} else if !owned {
+			continue
+		}
+
+		user, err := app.Users.GetOneContext(r.Context(), id)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		users = append(users, user)
+	}
+
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+
+	return users, nil
+}
+
+// SCIMUser returns one user
+func (app *Config) SCIMUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.loadSCIMUser(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeSCIM(w, http.StatusOK, app.scimUser(user))
+}
+
+// loadSCIMUser reads the user named in the route, and sends a 404 if there is none
+// in the request's organization
+func (app *Config) loadSCIMUser(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, ok := app.scimID(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	owned, err := app.scimOwns(r, id)
+	if err != nil {
+		app.scimError(w, http.StatusInternalServerError, "", err.Error())
+		return nil, false
+	}
+
+	user, err := app.Users.GetOneContext(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !owned) {
+		app.scimError(w, http.StatusNotFound, "", fmt.Sprintf("user %d not found", id))
+		return nil, false
+	} else if err != nil {
+		app.scimError(w, http.StatusInternalServerError, "", err.Error())
+		return nil, false
+	}
+
+	return user, true
+}
+
+// CreateSCIMUser provisions a user into the request's organization. Users are active
+// unless told otherwise. A user provisioned without a password gets a random one,
+// and signs in once it is reset.
+func (app *Config) CreateSCIMUser(w http.ResponseWriter, r *http.Request) {
+	var s scim.User
+	if err := app.readJSON(w, r, &s); err != nil {
+		app.scimError(w, http.StatusBadRequest, scim.InvalidSyntax, err.Error())
+		return
+	}
+
+	user := data.User{Active: 1, Password: s.Password}
+	if err := fromSCIMUser(s, &user); err != nil {
+		app.scimError(w, http.StatusBadRequest, scim.InvalidValue, err.Error())
+		return
+	}
+
+	if user.Password == "" {
+		random, err := randomToken(32)
+		if err != nil {
+			app.scimError(w, http.StatusInternalServerError, "", err.Error())
+			return
+		}
+		user.Password = random
+	}
+
+	id, err := app.createUser(r.Context(), user, scimTenant(r))
+	if !app.scimStored(w, err) {
+		return
+	}
+
+	created, err := app.Users.GetOneContext(r.Context(), id)
+	if err != nil {
+		app.scimError(w, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+
+	resource := app.scimUser(created)
+	w.Header().Set("Location", resource.Meta.Location)
+	app.writeSCIM(w, http.StatusCreated, resource)
+}
+
+// ReplaceSCIMUser replaces a user with the one in the body
+func (app *Config) ReplaceSCIMUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.loadSCIMUser(w, r)
+	if !ok {
+		return
+	}
+
+	var s scim.User
+	if err := app.readJSON(w, r, &s); err != nil {
+		app.scimError(w, http.StatusBadRequest, scim.InvalidSyntax, err.Error())
+		return
+	}
+
+	if err := fromSCIMUser(s, user); err != nil {
+		app.scimError(w, http.StatusBadRequest, scim.InvalidValue, err.Error())
+		return
+	}
+
+	app.saveSCIMUser(w, r, user, s.Password)
+}
+
+// PatchSCIMUser applies a PatchOp to a user
+func (app *Config) PatchSCIMUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.loadSCIMUser(w, r)
+	if !ok {
+		return
+	}
+
+	var patch scim.PatchOp
+	if err := app.readJSON(w, r, &patch); err != nil {
+		app.scimError(w, http.StatusBadRequest, scim.InvalidSyntax, err.Error())
+		return
+	}
+
+	s := app.scimUser(user)
+	if !app.scimPatched(w, patch.ApplyToUser(&s)) {
+		return
+	}
+
+	if err := fromSCIMUser(s, user); err != nil {
+		app.scimError(w, http.StatusBadRequest, scim.InvalidValue, err.Error())
+		return
+	}
+
+	app.saveSCIMUser(w, r, user, s.Password)
+}
+
+// saveSCIMUser stores a replaced or patched user, and its new password if it has one.
+// A user who is not active is signed out everywhere.
+func (app *Config) saveSCIMUser(w http.ResponseWriter, r *http.Request, user *data.User, newPassword string) {
+	// the password goes first, so that a rejected one leaves the user as it was
+	if newPassword != "" {
+		if !app.scimStored(w, app.Users.ResetPasswordContext(r.Context(), user.ID, newPassword)) {
+			return
+		}
+	}
+
+	if !app.scimStored(w, app.Users.UpdateContext(r.Context(), *user)) {
+		return
+	}
+
+	updated, err := app.Users.GetOneContext(r.Context(), user.ID)
+	if err != nil {
+		app.scimError(w, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+
+	if updated.Active != 1 {
+		if err := app.revokeAllSessions(r.Context(), updated.ID); err != nil {
+			app.scimError(w, http.StatusInternalServerError, "", err.Error())
+			return
+		}
+	}
+
+	app.writeSCIM(w, http.StatusOK, app.scimUser(updated))
+}
+
+// DeleteSCIMUser deletes a user, signing them out everywhere first
+func (app *Config) DeleteSCIMUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.loadSCIMUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := app.revokeAllSessions(r.Context(), user.ID); err != nil {
+		app.scimError(w, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+
+	if err := app.Users.DeleteByIDContext(r.Context(), user.ID); err != nil {
+		app.scimError(w, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// scimStored sends the error response for a failed insert or update, if it failed
+func (app *Config) scimStored(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, data.ErrDuplicateEmail), errors.Is(err, data.ErrDuplicateGroup):
+		app.scimError(w, http.StatusConflict, scim.Uniqueness, err.Error())
+	case errors.Is(err, password.ErrBreached):
+		app.scimError(w, http.StatusBadRequest, scim.InvalidValue, err.Error())
+	case errors.Is(err, sql.ErrNoRows):
+		app.scimError(w, http.StatusNotFound, "", "resource not found")
+	default:
+		app.scimError(w, http.StatusInternalServerError, "", err.Error())
+	}
+
+	return false
+}
+
+// scimPatched sends the error response for a PatchOp that could not be applied
+func (app *Config) scimPatched(w http.ResponseWriter, err error) bool {
+	var perr *scim.PatchError
+	if errors.As(err, &perr) {
+		app.scimError(w, http.StatusBadRequest, perr.ScimType, perr.Detail)
+		return false
+	} else if err != nil {
+		app.scimError(w, http.StatusBadRequest, scim.InvalidSyntax, err.Error())
+		return false
+	}
+
+	return true
+}
+
+// scimGroup is the SCIM view of a group, with each member's email address as its display
+func (app *Config) scimGroup(r *http.Request, g *data.Group) scim.Group {
+	id := strconv.Itoa(g.ID)
+
+	group := scim.Group{
+		Schemas:     []string{scim.GroupSchema},
+		ID:          id,
+		DisplayName: g.DisplayName,
+		Members:     []scim.Member{},
+		Meta: &scim.Meta{
+			ResourceType: "Group",
+			Created:      g.CreatedAt,
+			LastModified: g.UpdatedAt,
+			Location:     app.Issuer + "/scim/v2/Groups/" + id,
+		},
+	}
+
+	for _, userID := range g.Members {
+		member := scim.Member{
+			Value: strconv.Itoa(userID),
+			Ref:   app.Issuer + "/scim/v2/Users/" + strconv.Itoa(userID),
+		}
+
+		if u, err := app.Users.GetOneContext(r.Context(), userID); err == nil {
+			member.Display = u.Email
+		}
+
+		group.Members = append(group.Members, member)
+	}
+
+	return group
+}
+
+// fromSCIMGroup copies a SCIM group onto g, checking that every member is one of the
+// request organization's users
+func (app *Config) fromSCIMGroup(r *http.Request, s scim.Group, g *data.Group) error {
+	g.DisplayName = strings.TrimSpace(s.DisplayName)
+	if g.DisplayName == "" {
+		return errors.New("displayName is required")
+	}
+
+	g.Members = []int{}
+	for _, m := range s.Members {
+		id, err := strconv.Atoi(m.Value)
+		owned := false
+		if err == nil {
+			owned, err = app.scimOwns(r, id)
+		}
+		if err == nil && owned {
+			_, err = app.Users.GetOneContext(r.Context(), id)
+		}
+		if err != nil || !owned {
+			return fmt.Errorf("member %q is not a user", m.Value)
+		}
+
+		g.Members = append(g.Members, id)
+	}
+
+	return nil
+}
+
+// SCIMGroups lists groups a page at a time, optionally filtered with displayName eq
+func (app *Config) SCIMGroups(w http.ResponseWriter, r *http.Request) {
+	offset, limit, err := scim.Page(r.URL.Query().Get("startIndex"), r.URL.Query().Get("count"))
+	if err != nil {
+		app.scimError(w, http.StatusBadRequest, scim.InvalidValue, err.Error())
+		return
+	}
+
+	var displayName string
+
+	if filter := r.URL.Query().Get("filter"); filter != "" {
+		var attribute string
+		attribute, displayName, err = scim.ParseFilter(filter)
+		if err != nil || attribute != "displayname" {
+			app.scimError(w, http.StatusBadRequest, scim.InvalidFilter, scim.ErrInvalidFilter.Error()+", on displayName")
+			return
+		}
+	}
+
+	groups, total, err := app.Groups.Find(r.Context(), scimTenant(r), displayName, offset, limit)
+	if err != nil {
+		app.scimError(w, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+
+	page := []scim.Group{}
+	for _, g := range groups {
+		page = append(page, app.scimGroup(r, g))
+	}
+
+	app.writeSCIM(w, http.StatusOK, scim.NewListResponse(page, len(page), total, offset+1))
+}
+
+// loadSCIMGroup reads the group named in the route, and sends a 404 if there is none
+func (app *Config) loadSCIMGroup(w http.ResponseWriter, r *http.Request) (*data.Group, bool) {
+	id, ok := app.scimID(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	group, err := app.Groups.Get(r.Context(), scimTenant(r), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.scimError(w, http.StatusNotFound, "", fmt.Sprintf("group %d not found", id))
+		return nil, false
+	} else if err != nil {
+		app.scimError(w, http.StatusInternalServerError, "", err.Error())
+		return nil, false
+	}
+
+	return group, true
+}
+
+// SCIMGroup returns one group
+func (app *Config) SCIMGroup(w http.ResponseWriter, r *http.Request) {
+	group, ok := app.loadSCIMGroup(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeSCIM(w, http.StatusOK, app.scimGroup(r, group))
+}
+
+// CreateSCIMGroup provisions a group
+func (app *Config) CreateSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	var s scim.Group
+	if err := app.readJSON(w, r, &s); err != nil {
+		app.scimError(w, http.StatusBadRequest, scim.InvalidSyntax, err.Error())
+		return
+	}
+
+	group := data.Group{OrganizationID: scimTenant(r)}
+	if err := app.fromSCIMGroup(r, s, &group); err != nil {
+		app.scimError(w, http.StatusBadRequest, scim.InvalidValue, err.Error())
+		return
+	}
+
+	id, err := app.Groups.Insert(r.Context(), group)
+	if !app.scimStored(w, err) {
+		return
+	}
+
+	created, err := app.Groups.Get(r.Context(), scimTenant(r), id)
+	if err != nil {
+		app.scimError(w, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+
+	resource := app.scimGroup(r, created)
+	w.Header().Set("Location", resource.Meta.Location)
+	app.writeSCIM(w, http.StatusCreated, resource)
+}
+
+// ReplaceSCIMGroup replaces a group's name and members with those in the body
+func (app *Config) ReplaceSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	group, ok := app.loadSCIMGroup(w, r)
+	if !ok {
+		return
+	}
+
+	var s scim.Group
+	if err := app.readJSON(w, r, &s); err != nil {
+		app.scimError(w, http.StatusBadRequest, scim.InvalidSyntax, err.Error())
+		return
+	}
+
+	app.saveSCIMGroup(w, r, group, s)
+}
+
+// PatchSCIMGroup applies a PatchOp to a group; this is how most clients change members
+func (app *Config) PatchSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	group, ok := app.loadSCIMGroup(w, r)
+	if !ok {
+		return
+	}
+
+	var patch scim.PatchOp
+	if err := app.readJSON(w, r, &patch); err != nil {
+		app.scimError(w, http.StatusBadRequest, scim.InvalidSyntax, err.Error())
+		return
+	}
+
+	s := app.scimGroup(r, group)
+	if !app.scimPatched(w, patch.ApplyToGroup(&s)) {
+		return
+	}
+
+	app.saveSCIMGroup(w, r, group, s)
+}
+
+// saveSCIMGroup stores a replaced or patched group
+func (app *Config) saveSCIMGroup(w http.ResponseWriter, r *http.Request, group *data.Group, s scim.Group) {
+	if err := app.fromSCIMGroup(r, s, group); err != nil {
+		app.scimError(w, http.StatusBadRequest, scim.InvalidValue, err.Error())
+		return
+	}
+
+	if !app.scimStored(w, app.Groups.Update(r.Context(), *group)) {
+		return
+	}
+
+	updated, err := app.Groups.Get(r.Context(), scimTenant(r), group.ID)
+	if err != nil {
+		app.scimError(w, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+
+	app.writeSCIM(w, http.StatusOK, app.scimGroup(r, updated))
+}
+
+// DeleteSCIMGroup deletes a group. Its members are left alone.
+func (app *Config) DeleteSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.scimID(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.scimStored(w, app.Groups.Delete(r.Context(), scimTenant(r), id)) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"authentication/data"
+	"authz"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// sessionTTL is how long a session lasts without being used. Every refresh pushes
+	// the expiry back.
+	sessionTTL = 30 * 24 * time.Hour
+
+	maxUserAgent = 512
+)
+
+// startSession records a new session for user, signed in from the device making r,
+// and returns it along with its refresh token.
+func (app *Config) startSession(ctx context.Context, r *http.Request, user *data.User) (*data.Session, string, error) {
+	id, err := randomToken(16)
+	if err != nil {
+		return nil, "", err
+	}
+
+	refresh, err := randomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	session := data.Session{
+		ID:          id,
+		UserID:      user.ID,
+		UserAgent:   truncatedUserAgent(r),
+		IP:          app.clientIP(r),
+		RefreshHash: hashToken(refresh),
+		CreatedAt:   now,
+		LastSeenAt:  now,
+		ExpiresAt:   now.Add(sessionTTL),
+	}
+
+	err = app.Sessions.Insert(ctx, session)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &session, refresh, nil
+}
+
+// revoked tells every service to refuse tokens for the given sessions. We apply it
+// to our own list straight away, then publish it for everybody else.
+func (app *Config) revoked(userID int, ids []string, at time.Time) {
+	if len(ids) == 0 {
+		return
+	}
+
+	rev := authz.Revocation{
+		UserID:     userID,
+		SessionIDs: ids,
+		RevokedAt:  at,
+		Until:      at.Add(tokenTTL),
+	}
+
+	app.Revocations.Add(rev)
+
+	err := app.Events.Publish(authz.RevocationExchange, authz.RevocationKey, rev)
+	if err != nil {
+		log.Println("Error publishing session revocation:", err)
+	}
+}
+
+// revokeAllSessions signs a user out everywhere, telling the other services too
+func (app *Config) revokeAllSessions(ctx context.Context, userID int) error {
+	now := time.Now()
+
+	ids, err := app.Sessions.RevokeAllForUser(ctx, userID, now)
+	if err != nil {
+		return err
+	}
+	app.revoked(userID, ids, now)
+
+	return nil
+}
+
+// RefreshToken trades a refresh token for a new access token. The refresh token is
+// rotated each time, so a stolen one stops working as soon as the owner uses theirs.
+func (app *Config) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	now := time.Now()
+
+	session, err := app.Sessions.GetByRefreshHash(r.Context(), hashToken(requestPayload.RefreshToken))
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !session.Active(now)) {
+		app.errorJSON(w, errors.New("invalid refresh token"), http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	user, err := app.Users.GetOneContext(r.Context(), session.UserID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && user.Active != 1) {
+		app.errorJSON(w, errors.New("invalid refresh token"), http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	refresh, err := randomToken(32)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	rotated, err := app.Sessions.Touch(r.Context(), session.ID, session.RefreshHash, hashToken(refresh), now, now.Add(sessionTTL))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// someone else used this refresh token first, so it has been used twice, and one
+	// of the two must have stolen it; neither gets to keep the session
+	if !rotated {
+		revoked, err := app.Sessions.Revoke(r.Context(), session.ID, now)
+		if err != nil {
+			log.Println("Error revoking reused session:", err)
+		} else if revoked {
+			app.revoked(session.UserID, []string{session.ID}, now)
+		}
+
+		app.errorJSON(w, errors.New("invalid refresh token"), http.StatusUnauthorized)
+		return
+	}
+
+	token, err := app.issueToken(r.Context(), user, session.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	token.RefreshToken = refresh
+
+	app.writeJSON(w, http.StatusAccepted, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Refreshed token for %s", user.Email),
+		Data:    token,
+	})
+}
+
+// sessionView is a session as shown to its owner
+type sessionView struct {
+	*data.Session
+	Current bool `json:"current"`
+}
+
+// ListSessions lists the caller's active sessions, marking the one they are using
+func (app *Config) ListSessions(w http.ResponseWriter, r *http.Request) {
+	claims, _ := authz.FromContext(r.Context())
+
+	userID, err := app.currentUserID(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	sessions, err := app.Sessions.ActiveForUser(r.Context(), userID, time.Now())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	views := make([]sessionView, 0, len(sessions))
+	for _, s := range sessions {
+		views = append(views, sessionView{Session: s, Current: s.ID == claims.SessionID})
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d active sessions", len(views)),
+		Data:    views,
+	})
+}
+
+// RevokeSession signs the caller out of one of their sessions
+func (app *Config) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.currentUserID(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+
+	// somebody else's session looks just like one that doesn't exist
+	session, err := app.Sessions.Get(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && session.UserID != userID) {
+		app.errorJSON(w, errors.New("no such session"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+
+	ok, err := app.Sessions.Revoke(r.Context(), id, now)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if ok {
+		app.revoked(userID, []string{id}, now)
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: "session revoked",
+	})
+}
+
+// RevokeUserSessions signs a user out everywhere
+func (app *Config) RevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIntParam(r, "id")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	user, err := app.Users.GetOneContext(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("no such user"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+
+	ids, err := app.Sessions.RevokeAllForUser(r.Context(), user.ID, now)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.revoked(user.ID, ids, now)
+
+	go func() {
+		msg := fmt.Sprintf("%d sessions for %s revoked by an administrator", len(ids), user.Email)
+		if err := app.logRequest("sessions", msg); err != nil {
+			log.Println("Error logging session revocation:", err)
+		}
+	}()
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("revoked %d sessions for user %d", len(ids), user.ID),
+		Data:    ids,
+	})
+}
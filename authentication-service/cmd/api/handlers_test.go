@@ -0,0 +1,375 @@
+package main
+
+import (
+	"authentication/data"
+	"authentication/event"
+	"authentication/keys"
+	"authentication/lockout"
+	"authentication/password"
+	"authz"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testSecret = "test-secret"
+
+// testLockoutThreshold is how many failed attempts lock an account in the test env
+const testLockoutThreshold = 8
+
+type testEnv struct {
+	app   *Config
+	users *data.MemoryUserRepository
+
+	mu         sync.Mutex
+	entries    []string
+	mails      []mailMessage
+	events     []authz.Revocation
+	userEvents []event.UserEvent
+	security   []event.SecurityEvent
+}
+
+// Publish records revocations, user events and security events in place of RabbitMQ
+func (env *testEnv) Publish(exchange, routingKey string, payload any) error {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+
+	switch e := payload.(type) {
+	case authz.Revocation:
+		env.events = append(env.events, e)
+	case event.UserEvent:
+		if exchange == event.UsersExchange && routingKey == e.Type {
+			env.userEvents = append(env.userEvents, e)
+		}
+	case event.SecurityEvent:
+		if exchange == event.SecurityExchange && routingKey == e.Type {
+			env.security = append(env.security, e)
+		}
+	}
+
+	return nil
+}
+
+// newTestEnv returns an app backed by in-memory stores, with one active admin user in
+// the default organization as the migrations leave it, a fake logger service that
+// records the name of every entry posted to it, and a fake mail service that records
+// every message
+func newTestEnv(t testing.TB) *testEnv {
+	t.Helper()
+
+	env := &testEnv{users: data.NewMemoryUserRepository()}
+
+	id, err := env.users.Insert(data.User{
+		Email:     "admin@example.com",
+		FirstName: "Admin",
+		LastName:  "User",
+		Password:  "verysecret",
+		Active:    1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.users.SetAccess(id, []string{"admin"}, []string{"*"})
+
+	organizations := data.NewMemoryOrganizationRepository()
+	defaultID, err := organizations.Insert(context.Background(), data.Organization{Name: data.DefaultOrganization})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := organizations.AddMember(context.Background(), defaultID, id); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// data subject requests find one entry and one mail record for anyone
+		switch r.URL.Path {
+		case "/subjects/export":
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"data":[{"name":"authentication","data":"someone logged in"},{"name":"mail","data":"mail to someone"}]}`))
+			return
+		case "/subjects/erase":
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"data":{"changed":2}}`))
+			return
+		}
+
+		var entry struct {
+			Name string `json:"name"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&entry)
+
+		env.mu.Lock()
+		env.entries = append(env.entries, entry.Name)
+		env.mu.Unlock()
+
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(logger.Close)
+
+	mailer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg mailMessage
+		_ = json.NewDecoder(r.Body).Decode(&msg)
+
+		env.mu.Lock()
+		env.mails = append(env.mails, msg)
+		env.mu.Unlock()
+
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(mailer.Close)
+
+	ring, err := keys.NewRing(context.Background(), keys.NewMemoryStore(), time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revocations := authz.NewRevocations()
+	recoveryCodes := data.NewMemoryRecoveryCodeRepository()
+
+	passwords := password.Policy{Algorithm: password.Bcrypt, BcryptCost: bcrypt.MinCost}
+	dummy, err := dummyHash(passwords)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env.app = &Config{
+		Users:         withUserEvents(env.users, env),
+		Passwords:     passwords,
+		DummyHash:     dummy,
+		TOTP:          data.NewMemoryTOTPRepository(recoveryCodes),
+		RecoveryCodes: recoveryCodes,
+		MFAChallenges: data.NewMemoryMFAChallengeRepository(),
+		// the permissions the migrations create
+		Permissions: data.NewMemoryPermissionRepository("*", "logs:read", "logs:write", "logs:manage",
+			"mail:send", "roles:manage", "users:manage", "oauth:manage", "apikeys:manage", "impersonate",
+			"scim:provision", "organizations:manage", "privacy:manage"),
+		Tokens:        authz.NewHMAC(testSecret),
+		Keys:          ring,
+		Verifier:      authz.WithRevocations(ring, revocations),
+		Sessions:      data.NewMemorySessionRepository(),
+		APIKeys:       data.NewMemoryAPIKeyRepository(),
+		Groups:        data.NewMemoryGroupRepository(),
+		Organizations: organizations,
+		Revocations:   revocations,
+		Events:        env,
+		Limiter: lockout.New(lockout.NewMemoryStore(),
+			lockout.Policy{FreeAttempts: 100, Threshold: testLockoutThreshold, Duration: time.Hour, Window: time.Hour},
+			lockout.Policy{FreeAttempts: 100, Threshold: 1000, Duration: time.Hour, Window: time.Hour},
+		),
+		MagicLinks: data.NewMemoryMagicLinkRepository(),
+		MagicLinkLimiter: lockout.New(lockout.NewMemoryStore(),
+			lockout.Policy{FreeAttempts: 100, Threshold: 3, Duration: time.Hour, Window: time.Hour},
+			lockout.Policy{FreeAttempts: 100, Threshold: 10, Duration: time.Hour, Window: time.Hour},
+		),
+		LoginHistory:   data.NewMemoryLoginHistoryRepository(),
+		Invitations:    data.NewMemoryInvitationRepository(),
+		EmailChanges:   data.NewMemoryEmailChangeRepository(),
+		LogServiceURL:  logger.URL,
+		LogSubjectsURL: logger.URL + "/subjects",
+		MailServiceURL: mailer.URL,
+	}
+
+	return env
+}
+
+// mailMessage is a message posted to the fake mail service
+type mailMessage struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Message string `json:"message"`
+}
+
+func (env *testEnv) sent() []mailMessage {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+
+	return append([]mailMessage(nil), env.mails...)
+}
+
+func (env *testEnv) logged() []string {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+
+	return append([]string(nil), env.entries...)
+}
+
+func (env *testEnv) authenticate(body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/authenticate", strings.NewReader(body))
+	req.RemoteAddr = "10.0.0.1:1234"
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(env.app.Authenticate).ServeHTTP(rr, req)
+
+	return rr
+}
+
+func credentials(email, password string) string {
+	body, _ := json.Marshal(map[string]string{"email": email, "password": password})
+	return string(body)
+}
+
+func TestAuthenticate(t *testing.T) {
+	env := newTestEnv(t)
+
+	rr := env.authenticate(credentials("admin@example.com", "verysecret"))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body)
+	}
+
+	var resp struct {
+		Error bool          `json:"error"`
+		Data  tokenResponse `json:"data"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Error || resp.Data.TokenType != "Bearer" || resp.Data.User.Email != "admin@example.com" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+
+	claims, err := authz.VerifyRS256(resp.Data.AccessToken, env.app.Keys.PublicKey)
+	if err != nil {
+		t.Fatalf("access token does not verify: %v", err)
+	}
+
+	if claims.Subject != "1" || !claims.HasRole("admin") || !claims.Can("users:manage") {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+
+	if got := env.logged(); len(got) != 1 || got[0] != "authentication" {
+		t.Fatalf("expected one authentication log entry, got %v", got)
+	}
+}
+
+func TestAuthenticateRejectsBadCredentials(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"wrong password", credentials("admin@example.com", "wrong"), http.StatusBadRequest},
+		{"unknown user", credentials("nobody@example.com", "verysecret"), http.StatusBadRequest},
+		{"malformed body", `{"email":`, http.StatusBadRequest},
+		{"two values", credentials("admin@example.com", "verysecret") + `{}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+
+			rr := env.authenticate(tt.body)
+			if rr.Code != tt.want {
+				t.Fatalf("expected %d, got %d: %s", tt.want, rr.Code, rr.Body)
+			}
+
+			var resp jsonResponse
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if !resp.Error || resp.Data != nil {
+				t.Fatalf("expected an error and no token, got %+v", resp)
+			}
+
+			if got := env.logged(); len(got) != 0 {
+				t.Fatalf("expected nothing to be logged, got %v", got)
+			}
+		})
+	}
+}
+
+func TestAuthenticateUnknownUserLooksLikeWrongPassword(t *testing.T) {
+	env := newTestEnv(t)
+
+	wrong := env.authenticate(credentials("admin@example.com", "wrong"))
+	unknown := env.authenticate(credentials("nobody@example.com", "wrong"))
+
+	if wrong.Code != unknown.Code || !bytes.Equal(wrong.Body.Bytes(), unknown.Body.Bytes()) {
+		t.Fatalf("responses differ: %d %s vs %d %s", wrong.Code, wrong.Body, unknown.Code, unknown.Body)
+	}
+}
+
+// countingHasher counts the passwords checked by the Hasher it wraps
+type countingHasher struct {
+	password.Hasher
+	verified int
+}
+
+func (h *countingHasher) Verify(plainText, encoded string) (bool, error) {
+	h.verified++
+	return h.Hasher.Verify(plainText, encoded)
+}
+
+func TestAuthenticateUnknownUserChecksAPassword(t *testing.T) {
+	env := newTestEnv(t)
+
+	hasher := &countingHasher{Hasher: env.app.Passwords}
+	env.app.Passwords = hasher
+
+	// an unknown account costs a password check, like a wrong password does, so the
+	// time taken doesn't give away which accounts exist
+	env.authenticate(credentials("nobody@example.com", "wrong"))
+	if hasher.verified != 1 {
+		t.Fatalf("expected 1 password check, got %d", hasher.verified)
+	}
+}
+
+func TestAuthenticateLocksOutAccount(t *testing.T) {
+	env := newTestEnv(t)
+
+	for i := 0; i < testLockoutThreshold; i++ {
+		env.authenticate(credentials("admin@example.com", "wrong"))
+	}
+
+	// the right password no longer helps once the account is locked
+	rr := env.authenticate(credentials("admin@example.com", "verysecret"))
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected %d, got %d: %s", http.StatusTooManyRequests, rr.Code, rr.Body)
+	}
+
+	if rr.Header().Get("Retry-After") == "" {
+		t.Fatal("expected a Retry-After header")
+	}
+}
+
+func TestAuthenticateUpgradesPasswordHash(t *testing.T) {
+	env := newTestEnv(t)
+
+	env.app.Passwords = password.Policy{
+		Algorithm: password.Argon2id,
+		Argon2:    password.Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+	}
+
+	// a wrong password leaves the old hash alone
+	env.authenticate(credentials("admin@example.com", "wrong"))
+
+	user, _ := env.users.GetOne(1)
+	if !strings.HasPrefix(user.Password, "$2a$") {
+		t.Fatalf("expected the bcrypt hash to be kept, got %q", user.Password)
+	}
+
+	rr := env.authenticate(credentials("admin@example.com", "verysecret"))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body)
+	}
+
+	user, _ = env.users.GetOne(1)
+	if !strings.HasPrefix(user.Password, "$argon2id$") {
+		t.Fatalf("expected the hash to be upgraded to argon2id, got %q", user.Password)
+	}
+
+	// and the new hash works
+	rr = env.authenticate(credentials("admin@example.com", "verysecret"))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("after upgrade: expected %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body)
+	}
+}
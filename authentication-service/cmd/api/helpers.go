@@ -1,10 +1,18 @@
 package main
 
 import (
+	"authz"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
 )
 
 type jsonResponse struct {
@@ -71,3 +79,132 @@ func (app *Config) errorJSON(w http.ResponseWriter, err error, status ...int) er
 
 	return app.writeJSON(w, statusCode, payload)
 }
+
+// readIntParam reads a positive integer URL parameter from the route
+func (app *Config) readIntParam(r *http.Request, name string) (int, error) {
+	value, err := strconv.Atoi(chi.URLParam(r, name))
+	if err != nil || value < 1 {
+		return 0, fmt.Errorf("invalid %s parameter", name)
+	}
+
+	return value, nil
+}
+
+// defaultPerPage and maxPerPage bound the page size of paginated lists
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// readPage reads the page and per_page query parameters. Pages count from 1.
+func (app *Config) readPage(r *http.Request) (int, int, error) {
+	page, perPage := 1, defaultPerPage
+
+	if v := r.URL.Query().Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, 0, errors.New("invalid page parameter")
+		}
+		page = n
+	}
+
+	if v := r.URL.Query().Get("per_page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPerPage {
+			return 0, 0, fmt.Errorf("per_page must be between 1 and %d", maxPerPage)
+		}
+		perPage = n
+	}
+
+	return page, perPage, nil
+}
+
+// maxNameLength is as long as an email address or a first or last name can be in
+// the users table
+const maxNameLength = 255
+
+// validEmail checks that email is a bare email address we can store
+func validEmail(email string) error {
+	if email == "" {
+		return errors.New("email is required")
+	}
+
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return fmt.Errorf("%q is not an email address", email)
+	}
+
+	if len(email) > maxNameLength {
+		return fmt.Errorf("email addresses can be at most %d characters", maxNameLength)
+	}
+
+	return nil
+}
+
+// validNames checks that a user's names fit in the users table
+func validNames(names ...string) error {
+	for _, name := range names {
+		if len(name) > maxNameLength {
+			return fmt.Errorf("names can be at most %d characters", maxNameLength)
+		}
+	}
+
+	return nil
+}
+
+// currentUserID returns the id of the user the request's access token was issued to
+func (app *Config) currentUserID(r *http.Request) (int, error) {
+	claims, ok := authz.FromContext(r.Context())
+	if !ok {
+		return 0, authz.ErrUnauthenticated
+	}
+
+	id, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return 0, errors.New("token does not belong to a user")
+	}
+
+	return id, nil
+}
+
+// clientIP returns the address of the client that made the request. X-Forwarded-For
+// is only believed when the request comes straight from one of our trusted proxies
+// (the broker, in practice); otherwise anyone could pick their own address.
+func (app *Config) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	remote := net.ParseIP(host)
+	if remote == nil {
+		return host
+	}
+
+	forwarded := r.Header.Get("X-Forwarded-For")
+	if forwarded == "" {
+		return remote.String()
+	}
+
+	for _, n := range app.TrustedProxies {
+		if n.Contains(remote) {
+			// the last entry is the one our proxy added
+			hops := strings.Split(forwarded, ",")
+			if ip := net.ParseIP(strings.TrimSpace(hops[len(hops)-1])); ip != nil {
+				return ip.String()
+			}
+		}
+	}
+
+	return remote.String()
+}
+
+// truncatedUserAgent returns the request's user agent, cut down to what we store
+func truncatedUserAgent(r *http.Request) string {
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgent {
+		userAgent = userAgent[:maxUserAgent]
+	}
+
+	return userAgent
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"authentication/data"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestImpersonation(t *testing.T) {
+	env := newTestEnv(t)
+	admin := login(t, env, "laptop")
+
+	id, err := env.users.Insert(data.User{Email: "user@example.com", Password: "password1", Active: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.users.SetAccess(id, []string{"user"}, []string{"logs:read"})
+
+	if rr := call(env, http.MethodPost, "/users/2/impersonate", admin.AccessToken, `{}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("no reason: expected %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	rr := call(env, http.MethodPost, "/users/2/impersonate", admin.AccessToken, `{"reason":"ticket 42"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("impersonate: expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+
+	var resp struct {
+		Data tokenResponse `json:"data"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	token := resp.Data.AccessToken
+
+	claims, err := env.app.Verifier.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Subject != "2" || claims.Actor == nil || claims.Actor.Subject != "1" || claims.Can("users:manage") {
+		t.Fatalf("expected a token for user 2 acting as user 1, got %+v", claims)
+	}
+
+	if rr := call(env, http.MethodGet, "/sessions", token, ""); rr.Code != http.StatusOK {
+		t.Fatalf("list sessions: expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+
+	for _, path := range []string{"/password", "/mfa/totp/enroll", "/users/1/impersonate"} {
+		method := http.MethodPost
+		if path == "/password" {
+			method = http.MethodPut
+		}
+
+		if rr := call(env, method, path, token, `{}`); rr.Code != http.StatusForbidden {
+			t.Fatalf("%s while impersonating: expected %d, got %d", path, http.StatusForbidden, rr.Code)
+		}
+	}
+
+	audited := 0
+	for _, name := range env.logged() {
+		if name == "impersonation" {
+			audited++
+		}
+	}
+
+	// starting the impersonation, then each of the four requests made with it
+	if audited != 5 {
+		t.Fatalf("expected 5 impersonation entries, got %v", env.logged())
+	}
+
+	// signing the admin out ends the impersonation
+	if rr := call(env, http.MethodDelete, "/users/1/sessions", admin.AccessToken, ""); rr.Code != http.StatusOK {
+		t.Fatalf("sign out: expected %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	if rr := call(env, http.MethodGet, "/sessions", token, ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("after sign out: expected %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+}
+
+func TestImpersonationNeedsPermissions(t *testing.T) {
+	env := newTestEnv(t)
+
+	id, _ := env.users.Insert(data.User{Email: "support@example.com", Password: "password1", Active: 1})
+	env.users.SetAccess(id, []string{"support"}, []string{"impersonate"})
+
+	rr := env.authenticate(credentials("support@example.com", "password1"))
+	var resp struct {
+		Data tokenResponse `json:"data"`
+	}
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+
+	// the admin holds permissions support staff do not
+	if rr := call(env, http.MethodPost, "/users/1/impersonate", resp.Data.AccessToken, `{"reason":"curious"}`); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected %d, got %d: %s", http.StatusForbidden, rr.Code, rr.Body)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	env := newTestEnv(t)
+	admin := login(t, env, "laptop")
+
+	if rr := call(env, http.MethodPut, "/password", admin.AccessToken, `{"current_password":"wrong","new_password":"brandnewsecret"}`); rr.Code != http.StatusForbidden {
+		t.Fatalf("wrong password: expected %d, got %d", http.StatusForbidden, rr.Code)
+	}
+
+	if rr := call(env, http.MethodPut, "/password", admin.AccessToken, `{"current_password":"verysecret","new_password":"brandnewsecret"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+
+	if rr := env.authenticate(credentials("admin@example.com", "brandnewsecret")); rr.Code != http.StatusAccepted {
+		t.Fatalf("login with new password: expected %d, got %d", http.StatusAccepted, rr.Code)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"authentication/data"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The formats users can be imported from
+const (
+	importCSV  = "csv"
+	importJSON = "json"
+)
+
+// importRow is one user to import. A CSV file needs a header row naming its columns,
+// which are these fields' JSON names in any order; a JSON file is an array of them.
+type importRow struct {
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+// importResult is what became of one row. Rows are numbered from 1, not counting
+// a CSV header. ID is set once the user exists, even if inviting them then failed.
+type importResult struct {
+	Row   int    `json:"row"`
+	Email string `json:"email"`
+	ID    int    `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// importReport says what an import did, or would have done for a dry run
+type importReport struct {
+	DryRun   bool           `json:"dry_run"`
+	Total    int            `json:"total"`
+	Imported int            `json:"imported"`
+	Failed   int            `json:"failed"`
+	Rows     []importResult `json:"rows"`
+}
+
+// parseImport reads the users to import from r, in format
+func parseImport(r io.Reader, format string) ([]importRow, error) {
+	switch format {
+	case importJSON:
+		var rows []importRow
+
+		dec := json.NewDecoder(r)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&rows); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+
+		return rows, nil
+	case importCSV:
+		return parseImportCSV(r)
+	default:
+		return nil, fmt.Errorf("cannot import users from %q; use %s or %s", format, importCSV, importJSON)
+	}
+}
+
+func parseImportCSV(r io.Reader) ([]importRow, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "email", "first_name", "last_name":
+			columns[name] = i
+		default:
+			return nil, fmt.Errorf("unknown column %q; expected email, first_name and last_name", name)
+		}
+	}
+
+	if _, ok := columns["email"]; !ok {
+		return nil, errors.New("the CSV header has no email column")
+	}
+
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok {
+			return record[i]
+		}
+		return ""
+	}
+
+	var rows []importRow
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+
+		rows = append(rows, importRow{
+			Email:     field(record, "email"),
+			FirstName: field(record, "first_name"),
+			LastName:  field(record, "last_name"),
+		})
+	}
+}
+
+// validate tidies up a row and checks it could be stored
+func (row *importRow) validate() error {
+	row.Email = strings.TrimSpace(row.Email)
+	row.FirstName = strings.TrimSpace(row.FirstName)
+	row.LastName = strings.TrimSpace(row.LastName)
+
+	if err := validEmail(row.Email); err != nil {
+		return err
+	}
+
+	return validNames(row.FirstName, row.LastName)
+}
+
+// importUsers creates a user for each valid row in organizationID, or in the default
+// organization if that is 0, and emails them an invitation to choose a password.
+// Until they do, their password is random. Rows that fail are reported and skipped;
+// the rest are still imported. A dry run validates every row and changes nothing.
+func (app *Config) importUsers(ctx context.Context, rows []importRow, organizationID int, dryRun bool) *importReport {
+	report := &importReport{DryRun: dryRun, Total: len(rows), Rows: make([]importResult, 0, len(rows))}
+
+	// the same address twice in one file is an error on the second row
+	seen := make(map[string]bool)
+
+	for i, row := range rows {
+		result := importResult{Row: i + 1, Email: row.Email}
+
+		id, err := app.importUser(ctx, &row, organizationID, dryRun, seen)
+		result.Email, result.ID = row.Email, id
+		if err != nil {
+			result.Error = err.Error()
+			report.Failed++
+		} else {
+			report.Imported++
+		}
+
+		report.Rows = append(report.Rows, result)
+	}
+
+	return report
+}
+
+func (app *Config) importUser(ctx context.Context, row *importRow, organizationID int, dryRun bool, seen map[string]bool) (int, error) {
+	if err := row.validate(); err != nil {
+		return 0, err
+	}
+
+	key := strings.ToLower(row.Email)
+	if seen[key] {
+		return 0, errors.New("email address appears more than once")
+	}
+	seen[key] = true
+
+	_, err := app.Users.GetByEmailContext(ctx, row.Email)
+	if err == nil {
+		return 0, data.ErrDuplicateEmail
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
+	if dryRun {
+		return 0, nil
+	}
+
+	random, err := randomToken(32)
+	if err != nil {
+		return 0, err
+	}
+
+	user := data.User{
+		Email:     row.Email,
+		FirstName: row.FirstName,
+		LastName:  row.LastName,
+		Password:  random,
+		Active:    1,
+	}
+
+	id, err := app.createUser(ctx, user, organizationID)
+	if err != nil {
+		return id, err
+	}
+	user.ID = id
+
+	if err := app.invite(ctx, &user); err != nil {
+		return id, fmt.Errorf("user created, but the invitation was not sent: %w", err)
+	}
+
+	return id, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// importFile posts a file of users to import, and returns the report
+func importFile(t *testing.T, env *testEnv, token, contentType, query, body string) importReport {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/users/import"+query, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", contentType)
+
+	rr := httptest.NewRecorder()
+	env.app.routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("import: expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+
+	var resp struct {
+		Data importReport `json:"data"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.Data
+}
+
+const importCSVFile = `email,first_name,last_name
+ada@example.com,Ada,Lovelace
+not an address,Bad,Row
+admin@example.com,Already,Here
+grace@example.com,Grace,Hopper
+ada@example.com,Ada,Again
+`
+
+func TestImportUsersDryRun(t *testing.T) {
+	env := newTestEnv(t)
+	admin := login(t, env, "laptop")
+	before := len(env.sent())
+
+	report := importFile(t, env, admin.AccessToken, "text/csv", "?dry_run=true", importCSVFile)
+
+	if !report.DryRun || report.Total != 5 || report.Imported != 2 || report.Failed != 3 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	for _, row := range []int{2, 3, 5} {
+		if report.Rows[row-1].Error == "" {
+			t.Fatalf("expected row %d to fail, got %+v", row, report.Rows[row-1])
+		}
+	}
+
+	if _, err := env.users.GetByEmail("ada@example.com"); err == nil {
+		t.Fatal("expected a dry run to create nobody")
+	}
+
+	if len(env.sent()) != before {
+		t.Fatal("expected a dry run to send no invitations")
+	}
+}
+
+func TestImportUsersAndAcceptInvitation(t *testing.T) {
+	env := newTestEnv(t)
+	admin := login(t, env, "laptop")
+	before := len(env.sent())
+
+	report := importFile(t, env, admin.AccessToken, "application/json", "",
+		`[{"email":"ada@example.com","first_name":"Ada"},{"email":""}]`)
+
+	if report.Imported != 1 || report.Failed != 1 || report.Rows[0].ID == 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	mails := env.sent()
+	if len(mails) != before+1 || mails[len(mails)-1].To != "ada@example.com" {
+		t.Fatalf("expected one invitation to ada@example.com, got %v", mails[before:])
+	}
+
+	_, rest, ok := strings.Cut(mails[len(mails)-1].Message, invitationPath+"?")
+	if !ok {
+		t.Fatalf("no link in %q", mails[len(mails)-1].Message)
+	}
+
+	q, err := url.ParseQuery(strings.Fields(rest)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accept := `{"token":"` + q.Get("token") + `","password":"lovelace1815"}`
+
+	if rr := call(env, http.MethodPost, invitationPath, "", accept); rr.Code != http.StatusOK {
+		t.Fatalf("accept: expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+
+	if rr := env.authenticate(credentials("ada@example.com", "lovelace1815")); rr.Code != http.StatusAccepted {
+		t.Fatalf("login: expected %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body)
+	}
+
+	// invitations work once
+	if rr := call(env, http.MethodPost, invitationPath, "", accept); rr.Code != http.StatusBadRequest {
+		t.Fatalf("reuse: expected %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestImportUsersRejectsBadFiles(t *testing.T) {
+	env := newTestEnv(t)
+	admin := login(t, env, "laptop")
+
+	for _, tc := range []struct {
+		contentType, body string
+		status            int
+	}{
+		{"text/plain", "ada@example.com", http.StatusUnsupportedMediaType},
+		{"text/csv", "email,phone\nada@example.com,123\n", http.StatusBadRequest},
+		{"text/csv", "first_name\nAda\n", http.StatusBadRequest},
+		{"application/json", `{"email":"ada@example.com"}`, http.StatusBadRequest},
+		{"application/json", `[]`, http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/users/import", strings.NewReader(tc.body))
+		req.Header.Set("Authorization", "Bearer "+admin.AccessToken)
+		req.Header.Set("Content-Type", tc.contentType)
+
+		rr := httptest.NewRecorder()
+		env.app.routes().ServeHTTP(rr, req)
+
+		if rr.Code != tc.status {
+			t.Errorf("%s %q: expected %d, got %d: %s", tc.contentType, tc.body, tc.status, rr.Code, rr.Body)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"authentication/password"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingHasher holds every Verify until it is released
+type blockingHasher struct {
+	password.Policy
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *blockingHasher) Verify(plainText, encoded string) (bool, error) {
+	h.started <- struct{}{}
+	<-h.release
+	return h.Policy.Verify(plainText, encoded)
+}
+
+func TestAuthenticateBusy(t *testing.T) {
+	env := newTestEnv(t)
+
+	h := &blockingHasher{
+		Policy:  env.app.Passwords.(password.Policy),
+		started: make(chan struct{}, 1),
+		release: make(chan struct{}),
+	}
+	pool := password.NewPool(h, 1, 0)
+	defer pool.Close()
+	env.app.Passwords = pool
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- env.authenticate(credentials("admin@example.com", "verysecret")) }()
+	<-h.started
+
+	rr := env.authenticate(credentials("admin@example.com", "verysecret"))
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected %d with Retry-After, got %d %v", http.StatusServiceUnavailable, rr.Code, rr.Header())
+	}
+
+	close(h.release)
+
+	if rr := <-done; rr.Code != http.StatusAccepted {
+		t.Fatalf("the login that got a worker: expected %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body)
+	}
+
+	// turning a client away is not a failed login
+	rr = env.authenticate(credentials("admin@example.com", "wrong"))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+// BenchmarkConcurrentLogins logs in from many clients at once, with password checks
+// run inline and through the pool, and reports login latency and how responsive a
+// cheap endpoint stays meanwhile. Run it with
+//
+//	go test ./cmd/api -run '^$' -bench ConcurrentLogins -cpu 4
+func BenchmarkConcurrentLogins(b *testing.B) {
+	policy := password.Policy{Algorithm: password.Bcrypt, BcryptCost: 10}
+
+	b.Run("inline", func(b *testing.B) {
+		benchmarkLogins(b, policy)
+	})
+
+	b.Run("pool", func(b *testing.B) {
+		pool := password.NewPool(policy, 2, 8)
+		defer pool.Close()
+
+		benchmarkLogins(b, pool)
+	})
+}
+
+func benchmarkLogins(b *testing.B, hasher password.Hasher) {
+	env := newTestEnv(b)
+	env.app.Passwords = hasher
+
+	user, _ := env.users.GetOne(1)
+	hash, err := password.Policy{Algorithm: password.Bcrypt, BcryptCost: 10}.Hash("verysecret")
+	if err != nil {
+		b.Fatal(err)
+	}
+	_ = env.users.SetPasswordHash(user.ID, hash)
+
+	handler := env.app.routes()
+	body := credentials("admin@example.com", "verysecret")
+
+	var (
+		mu       sync.Mutex
+		logins   []time.Duration
+		pings    []time.Duration
+		rejected atomic.Int64
+		stop     = make(chan struct{})
+		pinged   = make(chan struct{})
+	)
+
+	// something cheap, to see whether the rest of the service is starved
+	go func() {
+		defer close(pinged)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+
+			start := time.Now()
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/ping", nil))
+
+			mu.Lock()
+			pings = append(pings, time.Since(start))
+			mu.Unlock()
+		}
+	}()
+
+	b.SetParallelism(8)
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			req := httptest.NewRequest(http.MethodPost, "/authenticate", strings.NewReader(body))
+			req.RemoteAddr = "10.0.0.1:1234"
+
+			start := time.Now()
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			elapsed := time.Since(start)
+
+			if rr.Code == http.StatusServiceUnavailable {
+				rejected.Add(1)
+				continue
+			}
+
+			mu.Lock()
+			logins = append(logins, elapsed)
+			mu.Unlock()
+		}
+	})
+
+	b.StopTimer()
+	close(stop)
+	<-pinged
+
+	b.ReportMetric(percentile(logins, 0.50), "login-p50-ms")
+	b.ReportMetric(percentile(logins, 0.99), "login-p99-ms")
+	b.ReportMetric(percentile(pings, 0.99), "ping-p99-ms")
+	b.ReportMetric(float64(rejected.Load())/float64(b.N), "rejected/op")
+}
+
+// percentile returns the p'th percentile of ds in milliseconds
+func percentile(ds []time.Duration, p float64) float64 {
+	if len(ds) == 0 {
+		return 0
+	}
+
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+
+	return float64(ds[int(float64(len(ds)-1)*p)]) / float64(time.Millisecond)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"authentication/data"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// securityEvents returns the security events published so far
+func (env *testEnv) securityEvents() []string {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+
+	var types []string
+	for _, e := range env.security {
+		types = append(types, e.Type+" "+e.UserAgent)
+	}
+
+	return types
+}
+
+func TestLoginHistoryAndNewDevices(t *testing.T) {
+	env := newTestEnv(t)
+
+	login(t, env, "laptop")
+	login(t, env, "laptop")
+
+	if rr := env.authenticate(credentials("admin@example.com", "wrong")); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected a failed login, got %d", rr.Code)
+	}
+
+	token := login(t, env, "phone").AccessToken
+
+	if got := env.securityEvents(); len(got) != 2 || got[0] != "security.new_device laptop" || got[1] != "security.new_device phone" {
+		t.Fatalf("expected a new device event for the laptop and the phone, got %v", got)
+	}
+
+	mails := env.sent()
+	if len(mails) != 2 || mails[0].To != "admin@example.com" {
+		t.Fatalf("expected a notice per new device, got %+v", mails)
+	}
+
+	rr := call(env, http.MethodGet, "/login-history?per_page=3", token, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("history: expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+
+	var resp struct {
+		Data struct {
+			Entries []data.LoginEvent `json:"entries"`
+			Total   int               `json:"total"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Data.Total != 4 || len(resp.Data.Entries) != 3 {
+		t.Fatalf("expected 3 of 4 attempts, got %d of %d", len(resp.Data.Entries), resp.Data.Total)
+	}
+
+	newest := resp.Data.Entries[0]
+	if newest.Outcome != data.LoginSucceeded || newest.UserAgent != "phone" || newest.IP != "10.0.0.1" || newest.Method != loginByPassword {
+		t.Fatalf("unexpected newest entry %+v", newest)
+	}
+
+	if resp.Data.Entries[1].Outcome != data.LoginFailed {
+		t.Fatalf("expected the failed attempt next, got %+v", resp.Data.Entries[1])
+	}
+
+	rr = call(env, http.MethodGet, "/users/1/login-history?page=2&per_page=3", token, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("admin history: expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+
+	if rr := call(env, http.MethodGet, "/login-history?per_page=1000", token, ""); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected an oversized page to be refused, got %d", rr.Code)
+	}
+}
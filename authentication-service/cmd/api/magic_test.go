@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// requestMagicLink asks for a link for email, and returns the nonce cookie and the
+// link's token from the message sent, if any
+func requestMagicLink(t *testing.T, env *testEnv, email string) (*http.Cookie, string) {
+	t.Helper()
+
+	before := len(env.sent())
+
+	rr := call(env, http.MethodPost, "/login/magic", "", `{"email":"`+email+`"}`)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("request: expected %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body)
+	}
+
+	var cookie *http.Cookie
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == magicCookieName {
+			cookie = c
+		}
+	}
+	if cookie == nil || !cookie.HttpOnly {
+		t.Fatalf("expected an http only nonce cookie, got %v", rr.Result().Cookies())
+	}
+
+	mails := env.sent()
+	if len(mails) == before {
+		return cookie, ""
+	}
+
+	msg := mails[len(mails)-1]
+	if msg.To != email {
+		t.Fatalf("expected the link to go to %s, got %s", email, msg.To)
+	}
+
+	_, rest, ok := strings.Cut(msg.Message, magicCookiePath+"/verify?")
+	if !ok {
+		t.Fatalf("no link in %q", msg.Message)
+	}
+
+	q, err := url.ParseQuery(strings.Fields(rest)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cookie, q.Get("token")
+}
+
+// followMagicLink follows a link, sending cookie if it is set
+func followMagicLink(env *testEnv, token string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, magicCookiePath+"/verify?"+url.Values{"token": {token}}.Encode(), nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+
+	rr := httptest.NewRecorder()
+	env.app.routes().ServeHTTP(rr, req)
+
+	return rr
+}
+
+func TestMagicLinkLogin(t *testing.T) {
+	env := newTestEnv(t)
+
+	cookie, token := requestMagicLink(t, env, "admin@example.com")
+	if token == "" {
+		t.Fatal("expected a link to be mailed")
+	}
+
+	rr := followMagicLink(env, token, cookie)
+	if rr.Code != http.StatusAccepted || !strings.Contains(rr.Body.String(), "access_token") {
+		t.Fatalf("follow: expected tokens, got %d: %s", rr.Code, rr.Body)
+	}
+
+	// links work once
+	if rr := followMagicLink(env, token, cookie); rr.Code != http.StatusBadRequest {
+		t.Fatalf("reuse: expected %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	// unknown addresses get the same answer, and no mail
+	if _, token := requestMagicLink(t, env, "nobody@example.com"); token != "" {
+		t.Fatal("expected no mail for an unknown address")
+	}
+}
+
+func TestMagicLinkBoundToBrowser(t *testing.T) {
+	env := newTestEnv(t)
+
+	_, token := requestMagicLink(t, env, "admin@example.com")
+	other, _ := requestMagicLink(t, env, "nobody@example.com")
+
+	if rr := followMagicLink(env, token, nil); rr.Code != http.StatusBadRequest {
+		t.Fatalf("no cookie: expected %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	cookie, token := requestMagicLink(t, env, "admin@example.com")
+
+	if rr := followMagicLink(env, token, other); rr.Code != http.StatusBadRequest {
+		t.Fatalf("wrong cookie: expected %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	// a failed attempt uses the link up
+	if rr := followMagicLink(env, token, cookie); rr.Code != http.StatusBadRequest {
+		t.Fatalf("after a failed attempt: expected %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestMagicLinkRateLimited(t *testing.T) {
+	env := newTestEnv(t)
+
+	for i := 0; i < 3; i++ {
+		requestMagicLink(t, env, "admin@example.com")
+	}
+
+	rr := call(env, http.MethodPost, "/login/magic", "", `{"email":"admin@example.com"}`)
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected %d with Retry-After, got %d", http.StatusTooManyRequests, rr.Code)
+	}
+
+	// following bad links is throttled by IP, which the requests above count against too
+	for i := 0; i < 7; i++ {
+		followMagicLink(env, "nonsense", nil)
+	}
+
+	if rr := followMagicLink(env, "nonsense", nil); rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected %d after repeated bad links, got %d", http.StatusTooManyRequests, rr.Code)
+	}
+}
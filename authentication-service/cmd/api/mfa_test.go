@@ -0,0 +1,321 @@
+package main
+
+import (
+	"authentication/totp"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// enrollTOTP turns on two-factor authentication for the admin, returning their secret
+// and recovery codes
+func enrollTOTP(t *testing.T, env *testEnv) (string, []string) {
+	t.Helper()
+
+	token := login(t, env, "laptop").AccessToken
+
+	rr := call(env, http.MethodPost, "/mfa/totp/enroll", token, "")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("enroll: expected %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+	}
+
+	var enrolled struct {
+		Data struct {
+			Secret string `json:"secret"`
+			URI    string `json:"uri"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&enrolled); err != nil {
+		t.Fatal(err)
+	}
+
+	secret := enrolled.Data.Secret
+	if secret == "" || !strings.HasPrefix(enrolled.Data.URI, "otpauth://totp/") {
+		t.Fatalf("unexpected enrollment: %+v", enrolled.Data)
+	}
+
+	rr = call(env, http.MethodPost, "/mfa/totp/confirm", token, `{"code":"000000"}`)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("confirm with a wrong code: expected %d, got %d: %s", http.StatusUnauthorized, rr.Code, rr.Body)
+	}
+
+	rr = call(env, http.MethodPost, "/mfa/totp/confirm", token, codeBody("", code(t, secret, 0)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("confirm: expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+
+	var confirmed struct {
+		Data struct {
+			RecoveryCodes []string `json:"recovery_codes"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&confirmed); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(confirmed.Data.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("expected %d recovery codes, got %v", recoveryCodeCount, confirmed.Data.RecoveryCodes)
+	}
+
+	// Postgres reads this from user_totp alongside the user
+	env.users.SetMFAEnabled(1, true)
+
+	return secret, confirmed.Data.RecoveryCodes
+}
+
+// code returns the code for secret offset steps from now
+func code(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+
+	c, err := totp.Code(secret, totp.Step(time.Now())+offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func codeBody(challenge, code string) string {
+	body, _ := json.Marshal(secondFactorPayload{ChallengeToken: challenge, Code: code})
+	return string(body)
+}
+
+func recoveryBody(challenge, code string) string {
+	body, _ := json.Marshal(secondFactorPayload{ChallengeToken: challenge, RecoveryCode: code})
+	return string(body)
+}
+
+// challenge logs the admin in with their password, which should get a challenge
+// rather than a token
+func challenge(t *testing.T, env *testEnv) string {
+	t.Helper()
+
+	rr := env.authenticate(credentials("admin@example.com", "verysecret"))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("authenticate: expected %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body)
+	}
+
+	var resp struct {
+		Data struct {
+			MFARequired    bool   `json:"mfa_required"`
+			ChallengeToken string `json:"challenge_token"`
+			AccessToken    string `json:"access_token"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if !resp.Data.MFARequired || resp.Data.ChallengeToken == "" || resp.Data.AccessToken != "" {
+		t.Fatalf("expected a challenge and no token, got %+v", resp.Data)
+	}
+
+	return resp.Data.ChallengeToken
+}
+
+// completeMFA posts body to /authenticate/mfa, returning the status and any access token
+func completeMFA(t *testing.T, env *testEnv, body string) (int, string) {
+	t.Helper()
+
+	rr := call(env, http.MethodPost, "/authenticate/mfa", "", body)
+
+	var resp struct {
+		Data tokenResponse `json:"data"`
+	}
+	if rr.Code == http.StatusAccepted {
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return rr.Code, resp.Data.AccessToken
+}
+
+func TestMFALogin(t *testing.T) {
+	env := newTestEnv(t)
+	secret, _ := enrollTOTP(t, env)
+
+	mfa, err := env.app.TOTP.GetForUser(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	confirming, err := totp.Code(secret, mfa.LastUsedStep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := totp.Code(secret, mfa.LastUsedStep+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := challenge(t, env)
+
+	// the code used to confirm enrollment has been used, so cannot be replayed
+	if status, _ := completeMFA(t, env, codeBody(token, confirming)); status != http.StatusUnauthorized {
+		t.Fatalf("replayed code: expected %d, got %d", http.StatusUnauthorized, status)
+	}
+
+	status, access := completeMFA(t, env, codeBody(token, next))
+	if status != http.StatusAccepted || access == "" {
+		t.Fatalf("expected a token, got %d %q", status, access)
+	}
+
+	// a challenge is only good for one login
+	if status, _ := completeMFA(t, env, codeBody(token, next)); status != http.StatusUnauthorized {
+		t.Fatalf("reused challenge: expected %d, got %d", http.StatusUnauthorized, status)
+	}
+
+	// and neither is a code, even on a fresh challenge
+	if status, _ := completeMFA(t, env, codeBody(challenge(t, env), next)); status != http.StatusUnauthorized {
+		t.Fatalf("reused code: expected %d, got %d", http.StatusUnauthorized, status)
+	}
+}
+
+func TestMFARecoveryCodesAreSingleUse(t *testing.T) {
+	env := newTestEnv(t)
+	_, codes := enrollTOTP(t, env)
+
+	if status, access := completeMFA(t, env, recoveryBody(challenge(t, env), codes[0])); status != http.StatusAccepted || access == "" {
+		t.Fatalf("expected a token, got %d %q", status, access)
+	}
+
+	if status, _ := completeMFA(t, env, recoveryBody(challenge(t, env), codes[0])); status != http.StatusUnauthorized {
+		t.Fatalf("reused recovery code: expected %d, got %d", http.StatusUnauthorized, status)
+	}
+
+	// codes may be typed without the dash, in any case
+	typed := strings.ToUpper(strings.ReplaceAll(codes[1], "-", ""))
+	if status, _ := completeMFA(t, env, recoveryBody(challenge(t, env), typed)); status != http.StatusAccepted {
+		t.Fatalf("retyped recovery code: expected %d, got %d", http.StatusAccepted, status)
+	}
+
+	left, err := env.app.RecoveryCodes.CountUnused(context.Background(), 1)
+	if err != nil || left != recoveryCodeCount-2 {
+		t.Fatalf("expected %d unused codes, got %d, %v", recoveryCodeCount-2, left, err)
+	}
+}
+
+func TestMFAChallengeLocksAfterMaxAttempts(t *testing.T) {
+	env := newTestEnv(t)
+	secret, codes := enrollTOTP(t, env)
+
+	token := challenge(t, env)
+
+	for i := 0; i < mfaMaxAttempts; i++ {
+		if status, _ := completeMFA(t, env, codeBody(token, "000000")); status != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected %d, got %d", i+1, http.StatusUnauthorized, status)
+		}
+	}
+
+	// once the attempts are used up, not even a right code gets through
+	if status, _ := completeMFA(t, env, codeBody(token, code(t, secret, 1))); status != http.StatusUnauthorized {
+		t.Fatalf("locked challenge: expected %d, got %d", http.StatusUnauthorized, status)
+	}
+	if status, _ := completeMFA(t, env, recoveryBody(token, codes[0])); status != http.StatusUnauthorized {
+		t.Fatalf("locked challenge: expected %d, got %d", http.StatusUnauthorized, status)
+	}
+
+	// the locked challenge did not use up the recovery code
+	if status, _ := completeMFA(t, env, recoveryBody(challenge(t, env), codes[0])); status != http.StatusAccepted {
+		t.Fatalf("fresh challenge: expected %d, got %d", http.StatusAccepted, status)
+	}
+}
+
+func TestMFAFailuresCountAgainstAccount(t *testing.T) {
+	env := newTestEnv(t)
+	enrollTOTP(t, env)
+
+	// each fresh challenge has its own attempts, but the account does not
+	for i := 0; i < testLockoutThreshold/2; i++ {
+		if status, _ := completeMFA(t, env, codeBody(challenge(t, env), "000000")); status != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected %d, got %d", i+1, http.StatusUnauthorized, status)
+		}
+	}
+
+	rr := env.authenticate(credentials("admin@example.com", "verysecret"))
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected %d, got %d: %s", http.StatusTooManyRequests, rr.Code, rr.Body)
+	}
+
+	// and the recovery codes are kept for when the lockout is over
+	if left, err := env.app.RecoveryCodes.CountUnused(context.Background(), 1); err != nil || left != recoveryCodeCount {
+		t.Fatalf("expected %d unused codes, got %d, %v", recoveryCodeCount, left, err)
+	}
+}
+
+func TestMFAPasswordAloneKeepsFailures(t *testing.T) {
+	env := newTestEnv(t)
+	enrollTOTP(t, env)
+
+	// a right password, with no second factor after it, must not wipe the count
+	for i := 0; i < testLockoutThreshold; i++ {
+		if i%2 == 0 {
+			env.authenticate(credentials("admin@example.com", "wrong"))
+		} else {
+			challenge(t, env)
+		}
+	}
+
+	rr := env.authenticate(credentials("admin@example.com", "verysecret"))
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected %d, got %d: %s", http.StatusTooManyRequests, rr.Code, rr.Body)
+	}
+}
+
+func TestMFADeactivatedBeforeSecondFactor(t *testing.T) {
+	env := newTestEnv(t)
+	secret, _ := enrollTOTP(t, env)
+
+	token := challenge(t, env)
+
+	admin, err := env.users.GetOne(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin.Active = 0
+	if err := env.users.Update(*admin); err != nil {
+		t.Fatal(err)
+	}
+
+	if status, _ := completeMFA(t, env, codeBody(token, code(t, secret, 1))); status != http.StatusUnauthorized {
+		t.Fatalf("expected %d, got %d", http.StatusUnauthorized, status)
+	}
+}
+
+func TestDisableTOTPNeedsCode(t *testing.T) {
+	env := newTestEnv(t)
+	_, codes := enrollTOTP(t, env)
+
+	status, access := completeMFA(t, env, recoveryBody(challenge(t, env), codes[0]))
+	if status != http.StatusAccepted {
+		t.Fatalf("expected a token, got %d", status)
+	}
+
+	if rr := call(env, http.MethodDelete, "/mfa/totp", access, `{"code":"000000"}`); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("disable with a wrong code: expected %d, got %d: %s", http.StatusUnauthorized, rr.Code, rr.Body)
+	}
+
+	// wrong codes count against the account like any other
+	for i := 1; i < testLockoutThreshold; i++ {
+		call(env, http.MethodDelete, "/mfa/totp", access, `{"code":"000000"}`)
+	}
+	if rr := call(env, http.MethodDelete, "/mfa/totp", access, recoveryBody("", codes[1])); rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("disable after repeated wrong codes: expected %d, got %d: %s", http.StatusTooManyRequests, rr.Code, rr.Body)
+	}
+
+	if err := env.app.Limiter.UnlockAccount(context.Background(), "admin@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if rr := call(env, http.MethodDelete, "/mfa/totp", access, recoveryBody("", codes[1])); rr.Code != http.StatusOK {
+		t.Fatalf("disable: expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+
+	left, err := env.app.RecoveryCodes.CountUnused(context.Background(), 1)
+	if err != nil || left != 0 {
+		t.Fatalf("expected the recovery codes to go with the secret, got %d, %v", left, err)
+	}
+}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"authentication/data"
+	"authz"
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRedirectURI = "https://app.example.com/callback"
+
+// newOIDCServer serves env's app over HTTP, with an in-memory OAuth store
+func newOIDCServer(t *testing.T, env *testEnv) *httptest.Server {
+	t.Helper()
+
+	env.app.OAuth = data.NewMemoryOAuthRepository()
+
+	srv := httptest.NewServer(env.app.routes())
+	t.Cleanup(srv.Close)
+
+	env.app.Issuer = srv.URL
+
+	return srv
+}
+
+// oidcClient is a minimal relying party, doing what an internal app would do to sign
+// its users in through the authentication service
+type oidcClient struct {
+	t      *testing.T
+	http   *http.Client
+	issuer string
+
+	clientID     string
+	clientSecret string
+
+	discovery struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		UserinfoEndpoint      string `json:"userinfo_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+}
+
+func newOIDCClient(t *testing.T, issuer, clientID, clientSecret string) *oidcClient {
+	t.Helper()
+
+	jar, _ := cookiejar.New(nil)
+
+	c := &oidcClient{
+		t:      t,
+		issuer: issuer,
+		http: &http.Client{
+			Jar: jar,
+			// the redirect back to the app is the result we are after, so don't follow it
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		clientID:     clientID,
+		clientSecret: clientSecret,
+	}
+
+	c.getJSON(issuer+"/.well-known/openid-configuration", "", &c.discovery)
+
+	if c.discovery.Issuer != issuer {
+		t.Fatalf("discovery issuer = %q, want %q", c.discovery.Issuer, issuer)
+	}
+
+	return c
+}
+
+func (c *oidcClient) getJSON(url, bearer string, v any) {
+	c.t.Helper()
+
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		c.t.Fatalf("GET %s: %d %s", url, resp.StatusCode, body)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+var csrfField = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+// signIn runs the browser side of the flow: it opens the authorization endpoint,
+// fills in the login form, and returns where the service redirected to
+func (c *oidcClient) signIn(params url.Values, email, password string) *url.URL {
+	c.t.Helper()
+
+	resp, err := c.http.Get(c.discovery.AuthorizationEndpoint + "?" + params.Encode())
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		c.t.Fatalf("authorize: %d %s", resp.StatusCode, page)
+	}
+
+	match := csrfField.FindSubmatch(page)
+	if match == nil {
+		c.t.Fatalf("no login form in %s", page)
+	}
+
+	form := url.Values{}
+	for k, v := range params {
+		form[k] = v
+	}
+	form.Set("csrf_token", string(match[1]))
+	form.Set("email", email)
+	form.Set("password", password)
+
+	resp, err = c.http.PostForm(c.discovery.AuthorizationEndpoint, form)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	page, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound {
+		c.t.Fatalf("login: %d %s", resp.StatusCode, page)
+	}
+
+	location, err := resp.Location()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	return location
+}
+
+type tokenSet struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int    `json:"expires_in"`
+	IDToken          string `json:"id_token"`
+	Scope            string `json:"scope"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// exchange trades a code at the token endpoint, authenticating with HTTP Basic if the
+// client has a secret
+func (c *oidcClient) exchange(code, verifier string) (int, tokenSet) {
+	c.t.Helper()
+
+	return c.exchangeFor(code, verifier, testRedirectURI)
+}
+
+// exchangeFor trades a code naming redirectURI, which is left out when empty
+func (c *oidcClient) exchangeFor(code, verifier, redirectURI string) (int, tokenSet) {
+	c.t.Helper()
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"code_verifier": {verifier},
+	}
+	if redirectURI != "" {
+		form.Set("redirect_uri", redirectURI)
+	}
+	if c.clientSecret == "" {
+		form.Set("client_id", c.clientID)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, c.discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if c.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.clientID), url.QueryEscape(c.clientSecret))
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var tokens tokenSet
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		c.t.Fatal(err)
+	}
+
+	return resp.StatusCode, tokens
+}
+
+// verifyIDToken checks an ID token the way a relying party must: signature against
+// the published keys, then issuer, audience, expiry and nonce
+func (c *oidcClient) verifyIDToken(token, nonce string) idTokenClaims {
+	c.t.Helper()
+
+	var jwks authz.JWKS
+	c.getJSON(c.discovery.JWKSURI, "", &jwks)
+
+	var claims idTokenClaims
+	err := authz.ParseRS256(token, func(kid string) (*rsa.PublicKey, error) { return jwks.Key(kid) }, &claims)
+	if err != nil {
+		c.t.Fatalf("ID token does not verify: %v", err)
+	}
+
+	switch {
+	case claims.Issuer != c.issuer:
+		c.t.Fatalf("iss = %q, want %q", claims.Issuer, c.issuer)
+	case claims.Audience != c.clientID:
+		c.t.Fatalf("aud = %q, want %q", claims.Audience, c.clientID)
+	case time.Now().Unix() >= claims.ExpiresAt:
+		c.t.Fatal("ID token has expired")
+	case claims.Nonce != nonce:
+		c.t.Fatalf("nonce = %q, want %q", claims.Nonce, nonce)
+	}
+
+	return claims
+}
+
+func pkce() (verifier, challenge string) {
+	verifier = base64.RawURLEncoding.EncodeToString([]byte("a code verifier long enough for RFC 7636"))
+	sum := sha256.Sum256([]byte(verifier))
+
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func authorizeParams(clientID, challenge string) url.Values {
+	return url.Values{
+		"client_id":             {clientID},
+		"redirect_uri":          {testRedirectURI},
+		"response_type":         {"code"},
+		"scope":                 {"openid email profile"},
+		"state":                 {"xyz"},
+		"nonce":                 {"n-0S6_WzA2Mj"},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+}
+
+// registerClient registers a client through the admin endpoint, as the admin user
+func registerClient(t *testing.T, env *testEnv, srv *httptest.Server, public bool) (string, string) {
+	t.Helper()
+
+	admin, err := env.users.GetByEmail("admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := env.app.issueToken(context.Background(), admin, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(map[string]any{
+		"name":          "Test App",
+		"redirect_uris": []string{testRedirectURI},
+		"public":        public,
+	})
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/oauth/clients", strings.NewReader(string(body)))
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		out, _ := io.ReadAll(resp.Body)
+		t.Fatalf("register client: %d %s", resp.StatusCode, out)
+	}
+
+	var payload struct {
+		Data struct {
+			ClientID     string `json:"client_id"`
+			ClientSecret string `json:"client_secret"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatal(err)
+	}
+
+	if public != (payload.Data.ClientSecret == "") {
+		t.Fatalf("public = %v but client_secret = %q", public, payload.Data.ClientSecret)
+	}
+
+	return payload.Data.ClientID, payload.Data.ClientSecret
+}
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+	env := newTestEnv(t)
+	srv := newOIDCServer(t, env)
+
+	clientID, secret := registerClient(t, env, srv, false)
+	client := newOIDCClient(t, srv.URL, clientID, secret)
+
+	verifier, challenge := pkce()
+
+	location := client.signIn(authorizeParams(clientID, challenge), "admin@example.com", "verysecret")
+
+	if got := location.Scheme + "://" + location.Host + location.Path; got != testRedirectURI {
+		t.Fatalf("redirected to %s, want %s", got, testRedirectURI)
+	}
+
+	if location.Query().Get("state") != "xyz" {
+		t.Fatalf("state = %q, want xyz", location.Query().Get("state"))
+	}
+
+	code := location.Query().Get("code")
+	if code == "" {
+		t.Fatalf("no code in %s", location)
+	}
+
+	status, tokens := client.exchange(code, verifier)
+	if status != http.StatusOK {
+		t.Fatalf("token: %d %s: %s", status, tokens.Error, tokens.ErrorDescription)
+	}
+
+	if tokens.TokenType != "Bearer" || tokens.ExpiresIn <= 0 || tokens.Scope != "openid email profile" {
+		t.Fatalf("unexpected token response: %+v", tokens)
+	}
+
+	claims := client.verifyIDToken(tokens.IDToken, "n-0S6_WzA2Mj")
+	if claims.Subject != "1" || claims.Email != "admin@example.com" || claims.GivenName != "Admin" {
+		t.Fatalf("unexpected ID token claims: %+v", claims)
+	}
+
+	sum := sha256.Sum256([]byte(tokens.AccessToken))
+	if claims.AccessTokenHash != base64.RawURLEncoding.EncodeToString(sum[:16]) {
+		t.Fatal("at_hash does not match the access token")
+	}
+
+	var info map[string]string
+	client.getJSON(client.discovery.UserinfoEndpoint, tokens.AccessToken, &info)
+
+	if info["sub"] != "1" || info["email"] != "admin@example.com" || info["name"] != "Admin User" {
+		t.Fatalf("unexpected userinfo: %v", info)
+	}
+
+	// the access token is the client's, for userinfo, and is no good as one of ours
+	access, err := env.app.Verifier.Verify(tokens.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if access.Audience != clientID || access.Scope != "openid email profile" || len(access.Permissions) != 0 || len(access.Roles) != 0 {
+		t.Fatalf("unexpected access token claims: %+v", access)
+	}
+
+	if rr := call(env, http.MethodGet, "/me", tokens.AccessToken, ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("client token at /me: expected %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+
+	// and the ID token is no access token at all
+	if _, err := env.app.Verifier.Verify(tokens.IDToken); !errors.Is(err, authz.ErrNotAccessToken) {
+		t.Fatalf("expected the ID token to be refused as an access token, got %v", err)
+	}
+	if rr := call(env, http.MethodGet, "/oauth/userinfo", tokens.IDToken, ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("ID token at userinfo: expected %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+
+	// a code can only be used once
+	status, tokens = client.exchange(code, verifier)
+	if status != http.StatusBadRequest || tokens.Error != "invalid_grant" {
+		t.Fatalf("replayed code: %d %s, want 400 invalid_grant", status, tokens.Error)
+	}
+}
+
+func TestOIDCPublicClientNeedsMatchingVerifier(t *testing.T) {
+	env := newTestEnv(t)
+	srv := newOIDCServer(t, env)
+
+	clientID, _ := registerClient(t, env, srv, true)
+	client := newOIDCClient(t, srv.URL, clientID, "")
+
+	_, challenge := pkce()
+
+	location := client.signIn(authorizeParams(clientID, challenge), "admin@example.com", "verysecret")
+
+	wrong := base64.RawURLEncoding.EncodeToString([]byte("somebody else's code verifier, also long"))
+
+	status, tokens := client.exchange(location.Query().Get("code"), wrong)
+	if status != http.StatusBadRequest || tokens.Error != "invalid_grant" {
+		t.Fatalf("wrong verifier: %d %s, want 400 invalid_grant", status, tokens.Error)
+	}
+}
+
+func TestOIDCTokenNeedsMatchingRedirect(t *testing.T) {
+	env := newTestEnv(t)
+	srv := newOIDCServer(t, env)
+
+	clientID, _ := registerClient(t, env, srv, true)
+	client := newOIDCClient(t, srv.URL, clientID, "")
+
+	for _, tc := range []struct {
+		name        string
+		redirectURI string
+	}{
+		{"omitted", ""},
+		{"different", testRedirectURI + "/other"},
+	} {
+		verifier, challenge := pkce()
+		location := client.signIn(authorizeParams(clientID, challenge), "admin@example.com", "verysecret")
+
+		status, tokens := client.exchangeFor(location.Query().Get("code"), verifier, tc.redirectURI)
+		if status != http.StatusBadRequest || tokens.Error != "invalid_grant" {
+			t.Fatalf("%s redirect_uri: %d %s, want 400 invalid_grant", tc.name, status, tokens.Error)
+		}
+	}
+}
+
+func TestOIDCUserInfoFollowsScope(t *testing.T) {
+	env := newTestEnv(t)
+	srv := newOIDCServer(t, env)
+
+	clientID, _ := registerClient(t, env, srv, true)
+	client := newOIDCClient(t, srv.URL, clientID, "")
+
+	verifier, challenge := pkce()
+	params := authorizeParams(clientID, challenge)
+	params.Set("scope", "openid")
+
+	location := client.signIn(params, "admin@example.com", "verysecret")
+
+	status, tokens := client.exchange(location.Query().Get("code"), verifier)
+	if status != http.StatusOK {
+		t.Fatalf("token: %d %s: %s", status, tokens.Error, tokens.ErrorDescription)
+	}
+
+	var info map[string]string
+	client.getJSON(client.discovery.UserinfoEndpoint, tokens.AccessToken, &info)
+
+	if info["sub"] != "1" || info["email"] != "" || info["name"] != "" {
+		t.Fatalf("expected only the subject, got %v", info)
+	}
+}
+
+func TestOIDCRedirectLeftOutOfBoth(t *testing.T) {
+	env := newTestEnv(t)
+	srv := newOIDCServer(t, env)
+
+	clientID, _ := registerClient(t, env, srv, true)
+	client := newOIDCClient(t, srv.URL, clientID, "")
+
+	// a client with one redirect URI need not name it, at either end
+	verifier, challenge := pkce()
+	params := authorizeParams(clientID, challenge)
+	params.Del("redirect_uri")
+
+	location := client.signIn(params, "admin@example.com", "verysecret")
+	if got := location.Scheme + "://" + location.Host + location.Path; got != testRedirectURI {
+		t.Fatalf("redirected to %s, want %s", got, testRedirectURI)
+	}
+
+	status, tokens := client.exchangeFor(location.Query().Get("code"), verifier, "")
+	if status != http.StatusOK {
+		t.Fatalf("token without redirect_uri: %d %s: %s", status, tokens.Error, tokens.ErrorDescription)
+	}
+}
+
+func TestOIDCConfidentialClientNeedsSecret(t *testing.T) {
+	env := newTestEnv(t)
+	srv := newOIDCServer(t, env)
+
+	clientID, _ := registerClient(t, env, srv, false)
+	client := newOIDCClient(t, srv.URL, clientID, "not the secret")
+
+	verifier, challenge := pkce()
+	location := client.signIn(authorizeParams(clientID, challenge), "admin@example.com", "verysecret")
+
+	status, tokens := client.exchange(location.Query().Get("code"), verifier)
+	if status != http.StatusUnauthorized || tokens.Error != "invalid_client" {
+		t.Fatalf("wrong secret: %d %s, want 401 invalid_client", status, tokens.Error)
+	}
+}
+
+func TestOIDCAuthorizeRejectsUnregisteredRedirect(t *testing.T) {
+	env := newTestEnv(t)
+	srv := newOIDCServer(t, env)
+
+	clientID, secret := registerClient(t, env, srv, false)
+	client := newOIDCClient(t, srv.URL, clientID, secret)
+
+	_, challenge := pkce()
+	params := authorizeParams(clientID, challenge)
+	params.Set("redirect_uri", "https://evil.example.com/callback")
+
+	resp, err := client.http.Get(client.discovery.AuthorizationEndpoint + "?" + params.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+
+	if _, err := resp.Location(); !errors.Is(err, http.ErrNoLocation) {
+		t.Fatal("must not redirect to an unregistered URI")
+	}
+}
+
+func TestOIDCAuthorizeRequiresPKCE(t *testing.T) {
+	env := newTestEnv(t)
+	srv := newOIDCServer(t, env)
+
+	clientID, secret := registerClient(t, env, srv, false)
+	client := newOIDCClient(t, srv.URL, clientID, secret)
+
+	params := authorizeParams(clientID, "")
+	params.Del("code_challenge_method")
+
+	resp, err := client.http.Get(client.discovery.AuthorizationEndpoint + "?" + params.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	location, err := resp.Location()
+	if err != nil {
+		t.Fatalf("expected a redirect back to the client, got %d", resp.StatusCode)
+	}
+
+	if location.Query().Get("error") != "invalid_request" || location.Query().Get("state") != "xyz" {
+		t.Fatalf("unexpected error redirect: %s", location)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"authz"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestOrganizationTenant(t *testing.T) {
+	env := newTestEnv(t)
+	admin := login(t, env, "laptop")
+
+	claims, err := env.app.Verifier.Verify(admin.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.TenantID != "1" {
+		t.Fatalf("expected the default organization's tenant, got %q", claims.TenantID)
+	}
+
+	rr := call(env, http.MethodPost, "/organizations", admin.AccessToken, `{"name":"Acme"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create: expected %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+	}
+
+	var created struct {
+		Data struct {
+			ID int `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+
+	if rr := call(env, http.MethodPost, "/organizations", admin.AccessToken, `{"name":"Acme"}`); rr.Code != http.StatusConflict {
+		t.Fatalf("duplicate: expected %d, got %d", http.StatusConflict, rr.Code)
+	}
+
+	if rr := call(env, http.MethodPut, "/organizations/2/members/99", admin.AccessToken, ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("unknown user: expected %d, got %d", http.StatusNotFound, rr.Code)
+	}
+
+	// tokens are issued for the first organization joined, so the admin leaves the
+	// default one to move over
+	if rr := call(env, http.MethodDelete, "/organizations/1/members/1", admin.AccessToken, ""); rr.Code != http.StatusOK {
+		t.Fatalf("leave default: expected %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	if rr := call(env, http.MethodPut, "/organizations/2/members/1", admin.AccessToken, ""); rr.Code != http.StatusOK {
+		t.Fatalf("add member: expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+
+	claims, err = env.app.Verifier.Verify(login(t, env, "laptop").AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.TenantID != "2" {
+		t.Fatalf("expected tenant 2 after joining, got %q", claims.TenantID)
+	}
+
+	// API keys act for the organization of whoever created them
+	admin = login(t, env, "laptop")
+	rr = call(env, http.MethodPost, "/apikeys", admin.AccessToken, `{"name":"export","scopes":["logs:write"]}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create key: expected %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+	}
+
+	var key struct {
+		Data struct {
+			OrganizationID int    `json:"organization_id"`
+			Key            string `json:"key"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&key); err != nil {
+		t.Fatal(err)
+	}
+	if key.Data.OrganizationID != created.Data.ID {
+		t.Fatalf("expected the key to belong to organization %d, got %d", created.Data.ID, key.Data.OrganizationID)
+	}
+
+	rr = exchangeKey(env, key.Data.Key, "")
+	var exchanged tokenResponse
+	if err := json.NewDecoder(rr.Body).Decode(&exchanged); err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err = env.app.Verifier.Verify(exchanged.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.TenantID != "2" {
+		t.Fatalf("expected the key's token to carry tenant 2, got %q", claims.TenantID)
+	}
+
+	if rr := call(env, http.MethodDelete, "/organizations/2/members/1", admin.AccessToken, ""); rr.Code != http.StatusOK {
+		t.Fatalf("remove member: expected %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	rr = call(env, http.MethodGet, "/organizations/2/members", admin.AccessToken, "")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"data":[]`) {
+		t.Fatalf("members: expected none, got %d: %s", rr.Code, rr.Body)
+	}
+}
+
+func TestNewUserCanLogThroughBroker(t *testing.T) {
+	env := newTestEnv(t)
+	admin := login(t, env, "laptop")
+
+	// the admin leaves every organization, so whoever they import gets the default
+	if rr := call(env, http.MethodDelete, "/organizations/1/members/1", admin.AccessToken, ""); rr.Code != http.StatusOK {
+		t.Fatalf("leave default: expected %d, got %d", http.StatusOK, rr.Code)
+	}
+	admin = login(t, env, "laptop")
+
+	report := importFile(t, env, admin.AccessToken, "application/json", "", `[{"email":"ada@example.com","first_name":"Ada"}]`)
+	if report.Imported != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	id := report.Rows[0].ID
+
+	// as an admin would, give them the user role
+	env.users.SetAccess(id, []string{"user"}, []string{"logs:write", "mail:send"})
+
+	mails := env.sent()
+	_, rest, _ := strings.Cut(mails[len(mails)-1].Message, invitationPath+"?")
+	q, err := url.ParseQuery(strings.Fields(rest)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accept := `{"token":"` + q.Get("token") + `","password":"lovelace1815"}`
+	if rr := call(env, http.MethodPost, invitationPath, "", accept); rr.Code != http.StatusOK {
+		t.Fatalf("accept: expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+
+	rr := env.authenticate(credentials("ada@example.com", "lovelace1815"))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("login: expected %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body)
+	}
+
+	var resp struct {
+		Data tokenResponse `json:"data"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	// the broker's log action: logs:write, and a tenant to file the entry under
+	broker := authz.Authenticate(env.app.Verifier)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := authz.Authorize(r.Context(), "logs:write")
+		if err == nil {
+			var tenant string
+			tenant, err = authz.ResolveTenant(r.Context(), "")
+			if err == nil && tenant == "" {
+				err = authz.ErrNoTenant
+			}
+		}
+
+		if err != nil {
+			http.Error(w, err.Error(), authz.StatusCode(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/handle", strings.NewReader(`{"action":"log","log":{"name":"event","data":"hello"}}`))
+	req.Header.Set("Authorization", "Bearer "+resp.Data.AccessToken)
+
+	rec := httptest.NewRecorder()
+	broker.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("log action: expected %d, got %d: %s", http.StatusAccepted, rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"authentication/breach"
+	"authentication/data"
+	"authentication/password"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+)
+
+// busyRetryAfter is what we tell clients turned away by a full hashing queue. A
+// password check takes a fraction of a second, so the queue drains quickly.
+const busyRetryAfter = "1"
+
+// passwordPolicy returns the policy new password hashes are made under. It defaults
+// to argon2id; PASSWORD_HASH selects "argon2id" or "bcrypt", and each algorithm's
+// cost can be raised or lowered with the variables below.
+func passwordPolicy() (password.Policy, error) {
+	policy := password.DefaultPolicy
+
+	if alg := os.Getenv("PASSWORD_HASH"); alg != "" {
+		policy.Algorithm = alg
+	}
+
+	if n, err := strconv.ParseUint(os.Getenv("ARGON2_MEMORY_KIB"), 10, 32); err == nil {
+		policy.Argon2.Memory = uint32(n)
+	}
+
+	if n, err := strconv.ParseUint(os.Getenv("ARGON2_ITERATIONS"), 10, 32); err == nil {
+		policy.Argon2.Iterations = uint32(n)
+	}
+
+	if n, err := strconv.ParseUint(os.Getenv("ARGON2_PARALLELISM"), 10, 8); err == nil {
+		policy.Argon2.Parallelism = uint8(n)
+	}
+
+	if n, err := strconv.Atoi(os.Getenv("BCRYPT_COST")); err == nil {
+		policy.BcryptCost = n
+	}
+
+	return policy, policy.Validate()
+}
+
+// passwordPool starts the workers every password hash and check runs on. There is
+// one per CPU unless PASSWORD_WORKERS says otherwise, and PASSWORD_QUEUE callers may
+// wait for a worker before we start turning them away.
+func passwordPool(policy password.Policy) *password.Pool {
+	workers := runtime.NumCPU()
+	if n, err := strconv.Atoi(os.Getenv("PASSWORD_WORKERS")); err == nil && n > 0 {
+		workers = n
+	}
+
+	queue := 4 * workers
+	if n, err := strconv.Atoi(os.Getenv("PASSWORD_QUEUE")); err == nil && n >= 0 {
+		queue = n
+	}
+
+	return password.NewPool(policy, workers, queue)
+}
+
+// rejectBreached wraps hasher so that it refuses passwords found in the breached
+// password index BREACHED_PASSWORDS names, built with cmd/breachindex. Without an
+// index, new passwords are not checked.
+func rejectBreached(hasher password.Hasher) (password.Hasher, error) {
+	path := os.Getenv("BREACHED_PASSWORDS")
+	if path == "" {
+		log.Println("BREACHED_PASSWORDS is not set, so new passwords are not checked against breaches")
+		return hasher, nil
+	}
+
+	// the index stays mapped for the life of the process
+	corpus, err := breach.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Checking new passwords against %d breached password hashes\n", corpus.Len())
+
+	return password.RejectBreached(hasher, corpus), nil
+}
+
+// serverBusy tells the client that we're too busy checking passwords to check theirs
+func (app *Config) serverBusy(w http.ResponseWriter) {
+	headers := http.Header{}
+	headers.Set("Retry-After", busyRetryAfter)
+
+	app.writeJSON(w, http.StatusServiceUnavailable, jsonResponse{
+		Error:   true,
+		Message: password.ErrBusy.Error(),
+	}, headers)
+}
+
+// dummyHash hashes a password nobody has under policy, for Config.DummyHash
+func dummyHash(policy password.Policy) (string, error) {
+	plainText, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	return policy.Hash(plainText)
+}
+
+// checkNoUser checks plainText against DummyHash, for a login that names no account,
+// so that it takes as long as a wrong password for one that exists. It only fails
+// with ErrBusy, which the caller should answer as it would for a real account.
+func (app *Config) checkNoUser(plainText string) error {
+	_, err := app.Passwords.Verify(plainText, app.DummyHash)
+	if errors.Is(err, password.ErrBusy) {
+		return err
+	}
+
+	return nil
+}
+
+// checkPassword reports whether plainText is the user's password. When it is, and the
+// stored hash is weaker than our policy, the hash is upgraded while we have the
+// password in hand.
+func (app *Config) checkPassword(ctx context.Context, user *data.User, plainText string) (bool, error) {
+	valid, err := app.Passwords.Verify(plainText, user.Password)
+	if err != nil || !valid {
+		return false, err
+	}
+
+	if app.Passwords.NeedsRehash(user.Password) {
+		hash, err := app.Passwords.Hash(plainText)
+		if err == nil {
+			err = app.Users.SetPasswordHashContext(ctx, user.ID, hash)
+		}
+
+		// the login still goes ahead; we'll try again next time
+		if err != nil {
+			log.Println("Error upgrading password hash:", err)
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"archive/zip"
+	"authentication/data"
+	"authentication/event"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// subject adds a user who has signed in once, and returns their id
+func subject(t *testing.T, env *testEnv) int {
+	t.Helper()
+
+	id, err := env.users.Insert(data.User{Email: "ada@example.com", FirstName: "Ada", Password: "password1", Active: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr := env.authenticate(credentials("ada@example.com", "password1")); rr.Code != http.StatusAccepted {
+		t.Fatalf("login: expected %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body)
+	}
+
+	return id
+}
+
+func TestExportUser(t *testing.T) {
+	env := newTestEnv(t)
+	admin := login(t, env, "laptop")
+	id := subject(t, env)
+
+	rr := call(env, http.MethodGet, "/users/"+strconv.Itoa(id)+"/export", admin.AccessToken, "")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("expected a zip, got %d %s: %s", rr.Code, rr.Header().Get("Content-Type"), rr.Body)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make(map[string]int)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var contents any
+		if err := json.NewDecoder(rc).Decode(&contents); err != nil {
+			t.Fatalf("%s: %v", f.Name, err)
+		}
+		rc.Close()
+
+		if list, ok := contents.([]any); ok {
+			counts[f.Name] = len(list)
+		} else {
+			counts[f.Name] = 1
+		}
+	}
+
+	want := map[string]int{"user.json": 1, "sessions.json": 1, "login_history.json": 1, "logs.json": 1, "mail.json": 1}
+	for name, n := range want {
+		if counts[name] != n {
+			t.Errorf("%s: expected %d records, got %d", name, n, counts[name])
+		}
+	}
+
+	if rr := call(env, http.MethodGet, "/users/99/export", admin.AccessToken, ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("unknown user: expected %d, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestEraseUser(t *testing.T) {
+	env := newTestEnv(t)
+	admin := login(t, env, "laptop")
+	id := subject(t, env)
+
+	rr := call(env, http.MethodPost, "/users/"+strconv.Itoa(id)+"/erase", admin.AccessToken, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("erase: expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+
+	var resp struct {
+		Data erasure `json:"data"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Data.Sessions != 1 || resp.Data.LoginHistory != 1 || resp.Data.LogEntries != 2 {
+		t.Fatalf("unexpected erasure %+v", resp.Data)
+	}
+
+	user, err := env.users.GetOne(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != resp.Data.Pseudonym || user.FirstName != "" || user.Active != 0 {
+		t.Fatalf("expected the account to be pseudonymised, got %+v", user)
+	}
+
+	if rr := env.authenticate(credentials("ada@example.com", "password1")); rr.Code == http.StatusAccepted {
+		t.Fatal("expected the erased user to be unable to sign in")
+	}
+
+	env.mu.Lock()
+	defer env.mu.Unlock()
+
+	for _, e := range env.userEvents {
+		if e.User.ID == id && e.User.Email == "ada@example.com" && e.Type != event.UserCreated {
+			t.Errorf("%s was published with the erased address", e.Type)
+		}
+	}
+
+	last := env.userEvents[len(env.userEvents)-1]
+	if last.Type != event.UserErased || last.User.Email != resp.Data.Pseudonym {
+		t.Fatalf("expected %s last, got %s for %s", event.UserErased, last.Type, last.User.Email)
+	}
+}
+
+func TestEraseSelfRefused(t *testing.T) {
+	env := newTestEnv(t)
+	admin := login(t, env, "laptop")
+
+	if rr := call(env, http.MethodPost, "/users/1/erase", admin.AccessToken, ""); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
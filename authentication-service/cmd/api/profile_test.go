@@ -0,0 +1,115 @@
+package main
+
+import (
+	"authentication/data"
+	"authentication/event"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// confirmationToken returns the token in the confirmation link mailed to email
+func confirmationToken(t *testing.T, env *testEnv, email string) string {
+	t.Helper()
+
+	for _, msg := range env.sent() {
+		if msg.To != email {
+			continue
+		}
+
+		_, rest, ok := strings.Cut(msg.Message, emailConfirmPath+"?")
+		if !ok {
+			continue
+		}
+
+		q, err := url.ParseQuery(strings.Fields(rest)[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return q.Get("token")
+	}
+
+	t.Fatalf("no confirmation link was sent to %s", email)
+	return ""
+}
+
+func TestProfileUpdate(t *testing.T) {
+	env := newTestEnv(t)
+	admin := login(t, env, "laptop")
+
+	rr := call(env, http.MethodPatch, "/me", admin.AccessToken, `{"first_name":"Ada","last_name":"Lovelace"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("patch: expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+
+	rr = call(env, http.MethodGet, "/me", admin.AccessToken, "")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"first_name":"Ada"`) {
+		t.Fatalf("get: expected the new name, got %d: %s", rr.Code, rr.Body)
+	}
+
+	found := false
+	for _, name := range env.logged() {
+		found = found || name == event.UserUpdated
+	}
+	if !found {
+		t.Fatalf("expected a %s log entry, got %v", event.UserUpdated, env.logged())
+	}
+}
+
+func TestProfileEmailChange(t *testing.T) {
+	env := newTestEnv(t)
+	admin := login(t, env, "laptop")
+
+	rr := call(env, http.MethodPatch, "/me", admin.AccessToken, `{"email":"ada@example.com"}`)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("patch: expected %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body)
+	}
+
+	// nothing changes until the new address confirms
+	if user, _ := env.users.GetOne(1); user.Email != "admin@example.com" {
+		t.Fatalf("expected the email to be unchanged, got %s", user.Email)
+	}
+
+	notified := false
+	for _, msg := range env.sent() {
+		notified = notified || msg.To == "admin@example.com"
+	}
+	if !notified {
+		t.Fatal("expected a notice to the old address")
+	}
+
+	token := confirmationToken(t, env, "ada@example.com")
+
+	if rr := call(env, http.MethodGet, emailConfirmPath+"?token="+token, "", ""); rr.Code != http.StatusOK {
+		t.Fatalf("confirm: expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+
+	if user, _ := env.users.GetOne(1); user.Email != "ada@example.com" {
+		t.Fatalf("expected the new email, got %s", user.Email)
+	}
+
+	// links work once
+	if rr := call(env, http.MethodGet, emailConfirmPath+"?token="+token, "", ""); rr.Code != http.StatusBadRequest {
+		t.Fatalf("reuse: expected %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestProfileEmailChangeRejected(t *testing.T) {
+	env := newTestEnv(t)
+	admin := login(t, env, "laptop")
+
+	if _, err := env.users.Insert(data.User{Email: "taken@example.com", Password: "password1", Active: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	for body, status := range map[string]int{
+		`{"email":"not an address"}`:    http.StatusBadRequest,
+		`{"email":"taken@example.com"}`: http.StatusConflict,
+	} {
+		if rr := call(env, http.MethodPatch, "/me", admin.AccessToken, body); rr.Code != status {
+			t.Errorf("%s: expected %d, got %d: %s", body, status, rr.Code, rr.Body)
+		}
+	}
+}
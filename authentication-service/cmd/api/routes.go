@@ -1,6 +1,8 @@
 package main
 
 import (
+	"authz"
+	"expvar"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -14,7 +16,7 @@ func (app *Config) routes() http.Handler {
 	// specify who is allowed to connect
 	mux.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: true,
@@ -24,5 +26,132 @@ func (app *Config) routes() http.Handler {
 	mux.Use(middleware.Heartbeat("/ping"))
 
 	mux.Post("/authenticate", app.Authenticate)
+	mux.Post("/authenticate/mfa", app.CompleteMFA)
+	mux.Post("/authenticate/refresh", app.RefreshToken)
+	mux.Post("/login/magic", app.RequestMagicLink)
+	mux.Get("/login/magic/verify", app.VerifyMagicLink)
+	mux.Post("/invitations/accept", app.AcceptInvitation)
+	mux.Get("/me/email/confirm", app.ConfirmEmailChange)
+
+	// OpenID Connect provider
+	mux.Get("/.well-known/openid-configuration", app.OpenIDConfiguration)
+	mux.Get("/.well-known/jwks.json", app.JWKS)
+	mux.Get("/oauth/authorize", app.Authorize)
+	mux.Post("/oauth/authorize", app.Authorize)
+	mux.Post("/oauth/token", app.Token)
+
+	// SCIM provisioning, for HR systems; it has its own authentication and errors
+	mux.Route("/scim/v2", func(mux chi.Router) {
+		mux.Use(app.authenticateSCIM)
+
+		mux.Get("/Users", app.SCIMUsers)
+		mux.Post("/Users", app.CreateSCIMUser)
+		mux.Get("/Users/{id}", app.SCIMUser)
+		mux.Put("/Users/{id}", app.ReplaceSCIMUser)
+		mux.Patch("/Users/{id}", app.PatchSCIMUser)
+		mux.Delete("/Users/{id}", app.DeleteSCIMUser)
+
+		mux.Get("/Groups", app.SCIMGroups)
+		mux.Post("/Groups", app.CreateSCIMGroup)
+		mux.Get("/Groups/{id}", app.SCIMGroup)
+		mux.Put("/Groups/{id}", app.ReplaceSCIMGroup)
+		mux.Patch("/Groups/{id}", app.PatchSCIMGroup)
+		mux.Delete("/Groups/{id}", app.DeleteSCIMGroup)
+	})
+
+	// userinfo also takes the tokens issued to OAuth clients, which the rest of the
+	// API turns away
+	mux.Group(func(mux chi.Router) {
+		mux.Use(app.authenticateUserInfo)
+
+		mux.Get("/oauth/userinfo", app.UserInfo)
+		mux.Post("/oauth/userinfo", app.UserInfo)
+	})
+
+	// everything below needs a valid access token
+	mux.Group(func(mux chi.Router) {
+		mux.Use(authz.Authenticate(app.Verifier))
+		mux.Use(app.auditImpersonation)
+
+		// only the user themselves may change how they sign in
+		mux.Group(func(mux chi.Router) {
+			mux.Use(authz.RequireDirect)
+
+			mux.Put("/password", app.ChangePassword)
+			mux.Patch("/me", app.UpdateMe)
+
+			mux.Post("/mfa/totp/enroll", app.EnrollTOTP)
+			mux.Post("/mfa/totp/confirm", app.ConfirmTOTP)
+			mux.Delete("/mfa/totp", app.DisableTOTP)
+
+			mux.Delete("/sessions/{id}", app.RevokeSession)
+
+			mux.With(authz.RequirePermission("impersonate")).Post("/users/{id}/impersonate", app.Impersonate)
+		})
+
+		mux.Get("/me", app.Me)
+		mux.Get("/sessions", app.ListSessions)
+		mux.Get("/login-history", app.ListLoginHistory)
+
+		mux.Route("/oauth/clients", func(mux chi.Router) {
+			mux.Use(authz.RequirePermission("oauth:manage"))
+
+			mux.Get("/", app.AllClients)
+			mux.Post("/", app.RegisterClient)
+			mux.Delete("/{clientID}", app.DeleteClient)
+		})
+
+		mux.Route("/apikeys", func(mux chi.Router) {
+			mux.Use(authz.RequirePermission("apikeys:manage"))
+
+			mux.Get("/", app.AllAPIKeys)
+			mux.Post("/", app.CreateAPIKey)
+			mux.Delete("/{id}", app.RevokeAPIKey)
+		})
+
+		mux.Route("/organizations", func(mux chi.Router) {
+			mux.Use(authz.RequirePermission("organizations:manage"))
+
+			mux.Get("/", app.AllOrganizations)
+			mux.Post("/", app.CreateOrganization)
+			mux.Delete("/{id}", app.DeleteOrganization)
+			mux.Get("/{id}/members", app.OrganizationMembers)
+			mux.Put("/{id}/members/{userID}", app.AddOrganizationMember)
+			mux.Delete("/{id}/members/{userID}", app.RemoveOrganizationMember)
+		})
+
+		mux.Route("/roles", func(mux chi.Router) {
+			mux.Use(authz.RequirePermission("roles:manage"))
+
+			mux.Get("/", app.AllRoles)
+			mux.Post("/", app.CreateRole)
+			mux.Delete("/{id}", app.DeleteRole)
+			mux.Put("/{id}/permissions/{permissionID}", app.GrantPermission)
+			mux.Delete("/{id}/permissions/{permissionID}", app.RevokePermission)
+		})
+
+		mux.With(authz.RequirePermission("roles:manage")).Get("/permissions", app.AllPermissions)
+
+		// runtime and password pool counters, as JSON
+		mux.With(authz.RequirePermission("metrics:read")).Get("/debug/vars", expvar.Handler().ServeHTTP)
+
+		mux.With(authz.RequirePermission("users:manage")).Post("/users/import", app.ImportUsers)
+		mux.With(authz.RequirePermission("users:manage")).Post("/users/{id}/unlock", app.UnlockUser)
+		mux.With(authz.RequirePermission("users:manage")).Delete("/users/{id}/sessions", app.RevokeUserSessions)
+		mux.With(authz.RequirePermission("users:manage")).Get("/users/{id}/login-history", app.UserLoginHistory)
+
+		// data subject requests
+		mux.With(authz.RequirePermission("privacy:manage")).Get("/users/{id}/export", app.ExportUser)
+		mux.With(authz.RequirePermission("privacy:manage")).Post("/users/{id}/erase", app.EraseUser)
+
+		mux.Route("/users/{id}/roles", func(mux chi.Router) {
+			mux.Use(authz.RequirePermission("roles:manage"))
+
+			mux.Get("/", app.UserRoles)
+			mux.Put("/{roleID}", app.AssignRole)
+			mux.Delete("/{roleID}", app.RemoveRole)
+		})
+	})
+
 	return mux
 }
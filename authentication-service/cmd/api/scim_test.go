@@ -0,0 +1,259 @@
+package main
+
+import (
+	"authentication/data"
+	"authentication/scim"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// provisioningKey creates an API key with scopes for the admin's organization, and
+// returns it
+func provisioningKey(t *testing.T, env *testEnv, scopes ...string) string {
+	t.Helper()
+
+	admin := login(t, env, "laptop")
+	body, _ := json.Marshal(map[string]any{"name": "hr", "scopes": scopes})
+
+	rr := call(env, http.MethodPost, "/apikeys", admin.AccessToken, string(body))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create key: expected %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+	}
+
+	var resp struct {
+		Data struct {
+			Key string `json:"key"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.Data.Key
+}
+
+// joinOrganization creates an organization called name and adds userID to it
+func joinOrganization(t *testing.T, env *testEnv, userID int, name string) int {
+	t.Helper()
+
+	id, err := env.app.Organizations.Insert(context.Background(), data.Organization{Name: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.app.Organizations.AddMember(context.Background(), id, userID); err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+// scimCall makes a SCIM request and decodes the response into v, if given
+func scimCall(t *testing.T, env *testEnv, method, path, token, body string, want int, v any) {
+	t.Helper()
+
+	rr := call(env, method, path, token, body)
+	if rr.Code != want {
+		t.Fatalf("%s %s: expected %d, got %d: %s", method, path, want, rr.Code, rr.Body)
+	}
+
+	if v != nil {
+		if ct := rr.Header().Get("Content-Type"); ct != scim.ContentType {
+			t.Fatalf("%s %s: expected %s, got %s", method, path, scim.ContentType, ct)
+		}
+
+		if err := json.NewDecoder(rr.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSCIMUsers(t *testing.T) {
+	env := newTestEnv(t)
+	key := provisioningKey(t, env, "scim:provision")
+
+	var created scim.User
+	scimCall(t, env, http.MethodPost, "/scim/v2/Users", key,
+		`{"schemas":["urn:ietf:params:scim:schemas:core:2.0:User"],"userName":"bjensen@example.com","name":{"givenName":"Barbara","familyName":"Jensen"}}`,
+		http.StatusCreated, &created)
+
+	if created.ID != "2" || created.Active == nil || !*created.Active {
+		t.Fatalf("expected an active user 2, got %+v", created)
+	}
+
+	scimCall(t, env, http.MethodPost, "/scim/v2/Users", key, `{"userName":"bjensen@example.com"}`, http.StatusConflict, nil)
+
+	var list scim.ListResponse
+	filter := url.QueryEscape(`userName eq "bjensen@example.com"`)
+	scimCall(t, env, http.MethodGet, "/scim/v2/Users?filter="+filter, key, "", http.StatusOK, &list)
+
+	if list.TotalResults != 1 {
+		t.Fatalf("expected one user, got %+v", list)
+	}
+
+	scimCall(t, env, http.MethodGet, "/scim/v2/Users?startIndex=2&count=1", key, "", http.StatusOK, &list)
+	if list.TotalResults != 2 || list.ItemsPerPage != 1 || list.StartIndex != 2 {
+		t.Fatalf("expected the second of two users, got %+v", list)
+	}
+
+	var patched scim.User
+	scimCall(t, env, http.MethodPatch, "/scim/v2/Users/2", key,
+		`{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[{"op":"replace","path":"active","value":false}]}`,
+		http.StatusOK, &patched)
+
+	if *patched.Active || patched.Name.FamilyName != "Jensen" {
+		t.Fatalf("expected only active to change, got %+v", patched)
+	}
+
+	var replaced scim.User
+	scimCall(t, env, http.MethodPut, "/scim/v2/Users/2", key, `{"userName":"barbara@example.com","password":"provisioned"}`, http.StatusOK, &replaced)
+
+	if replaced.UserName != "barbara@example.com" || replaced.Name != nil {
+		t.Fatalf("expected the user to be replaced, got %+v", replaced)
+	}
+
+	scimCall(t, env, http.MethodDelete, "/scim/v2/Users/2", key, "", http.StatusNoContent, nil)
+	scimCall(t, env, http.MethodGet, "/scim/v2/Users/2", key, "", http.StatusNotFound, nil)
+}
+
+func TestSCIMGroups(t *testing.T) {
+	env := newTestEnv(t)
+	key := provisioningKey(t, env, "scim:provision")
+
+	scimCall(t, env, http.MethodPost, "/scim/v2/Users", key, `{"userName":"bjensen@example.com"}`, http.StatusCreated, nil)
+
+	var group scim.Group
+	scimCall(t, env, http.MethodPost, "/scim/v2/Groups", key,
+		`{"displayName":"Engineering","members":[{"value":"1"},{"value":"2"}]}`, http.StatusCreated, &group)
+
+	if len(group.Members) != 2 || group.Members[1].Display != "bjensen@example.com" {
+		t.Fatalf("expected two members, got %+v", group)
+	}
+
+	scimCall(t, env, http.MethodPost, "/scim/v2/Groups", key, `{"displayName":"Nobody","members":[{"value":"99"}]}`, http.StatusBadRequest, nil)
+	scimCall(t, env, http.MethodPost, "/scim/v2/Groups", key, `{"displayName":"Engineering"}`, http.StatusConflict, nil)
+
+	scimCall(t, env, http.MethodPatch, "/scim/v2/Groups/1", key,
+		`{"Operations":[{"op":"remove","path":"members[value eq \"1\"]"}]}`, http.StatusOK, &group)
+
+	if len(group.Members) != 1 || group.Members[0].Value != "2" {
+		t.Fatalf("expected user 1 to be removed, got %+v", group.Members)
+	}
+
+	var list scim.ListResponse
+	scimCall(t, env, http.MethodGet, "/scim/v2/Groups?filter="+url.QueryEscape(`displayName eq "Engineering"`), key, "", http.StatusOK, &list)
+	if list.TotalResults != 1 {
+		t.Fatalf("expected one group, got %+v", list)
+	}
+
+	scimCall(t, env, http.MethodGet, "/scim/v2/Groups?filter="+url.QueryEscape(`displayName co "Eng"`), key, "", http.StatusBadRequest, nil)
+
+	scimCall(t, env, http.MethodDelete, "/scim/v2/Groups/1", key, "", http.StatusNoContent, nil)
+	scimCall(t, env, http.MethodDelete, "/scim/v2/Groups/1", key, "", http.StatusNotFound, nil)
+}
+
+func TestSCIMAuthentication(t *testing.T) {
+	env := newTestEnv(t)
+	key := provisioningKey(t, env, "logs:read")
+
+	rr := httptest.NewRecorder()
+	env.app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/scim/v2/Users", nil))
+	if rr.Code != http.StatusUnauthorized || !strings.HasPrefix(rr.Header().Get("WWW-Authenticate"), "Bearer") {
+		t.Fatalf("no token: expected %d with a challenge, got %d", http.StatusUnauthorized, rr.Code)
+	}
+
+	scimCall(t, env, http.MethodGet, "/scim/v2/Users", key, "", http.StatusForbidden, nil)
+	scimCall(t, env, http.MethodGet, "/scim/v2/Users", "sk_nope.nope", "", http.StatusUnauthorized, nil)
+
+	// an ordinary access token with the permission works too
+	admin := login(t, env, "laptop")
+	scimCall(t, env, http.MethodGet, "/scim/v2/Users", admin.AccessToken, "", http.StatusOK, nil)
+}
+
+func TestSCIMStaysInItsOrganization(t *testing.T) {
+	env := newTestEnv(t)
+	ours := provisioningKey(t, env, "scim:provision")
+
+	scimCall(t, env, http.MethodPost, "/scim/v2/Users", ours, `{"userName":"bjensen@example.com"}`, http.StatusCreated, nil)
+	scimCall(t, env, http.MethodPost, "/scim/v2/Groups", ours, `{"displayName":"Engineering","members":[{"value":"2"}]}`, http.StatusCreated, nil)
+
+	// the admin moves to another organization and sets up a key for it
+	if _, err := env.app.Organizations.RemoveMember(context.Background(), 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	joinOrganization(t, env, 1, "Globex")
+	theirs := provisioningKey(t, env, "scim:provision")
+
+	var list scim.ListResponse
+	scimCall(t, env, http.MethodGet, "/scim/v2/Users", theirs, "", http.StatusOK, &list)
+	if list.TotalResults != 1 {
+		t.Fatalf("expected only the admin, got %+v", list)
+	}
+
+	filter := url.QueryEscape(`userName eq "bjensen@example.com"`)
+	scimCall(t, env, http.MethodGet, "/scim/v2/Users?filter="+filter, theirs, "", http.StatusOK, &list)
+	if list.TotalResults != 0 {
+		t.Fatalf("expected another organization's user to be left out, got %+v", list)
+	}
+
+	scimCall(t, env, http.MethodGet, "/scim/v2/Users/2", theirs, "", http.StatusNotFound, nil)
+	scimCall(t, env, http.MethodPatch, "/scim/v2/Users/2", theirs,
+		`{"Operations":[{"op":"replace","path":"active","value":false}]}`, http.StatusNotFound, nil)
+	scimCall(t, env, http.MethodDelete, "/scim/v2/Users/2", theirs, "", http.StatusNotFound, nil)
+
+	scimCall(t, env, http.MethodGet, "/scim/v2/Groups/1", theirs, "", http.StatusNotFound, nil)
+	scimCall(t, env, http.MethodDelete, "/scim/v2/Groups/1", theirs, "", http.StatusNotFound, nil)
+	scimCall(t, env, http.MethodPost, "/scim/v2/Groups", theirs, `{"displayName":"Sales","members":[{"value":"2"}]}`, http.StatusBadRequest, nil)
+
+	// group names only need to be unique within an organization
+	scimCall(t, env, http.MethodPost, "/scim/v2/Groups", theirs, `{"displayName":"Engineering"}`, http.StatusCreated, nil)
+
+	scimCall(t, env, http.MethodGet, "/scim/v2/Users/2", ours, "", http.StatusOK, nil)
+	scimCall(t, env, http.MethodGet, "/scim/v2/Groups?filter="+url.QueryEscape(`displayName eq "Engineering"`), ours, "", http.StatusOK, &list)
+	if list.TotalResults != 1 {
+		t.Fatalf("expected one group, got %+v", list)
+	}
+}
+
+func TestSCIMDeactivationSignsOut(t *testing.T) {
+	env := newTestEnv(t)
+	key := provisioningKey(t, env, "scim:provision")
+
+	scimCall(t, env, http.MethodPost, "/scim/v2/Users", key, `{"userName":"bjensen@example.com","password":"provisioned"}`, http.StatusCreated, nil)
+
+	rr := env.authenticate(credentials("bjensen@example.com", "provisioned"))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("login: expected %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body)
+	}
+
+	var resp struct {
+		Data tokenResponse `json:"data"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	scimCall(t, env, http.MethodPatch, "/scim/v2/Users/2", key,
+		`{"Operations":[{"op":"replace","path":"active","value":false}]}`, http.StatusOK, nil)
+
+	if rr := call(env, http.MethodGet, "/sessions", resp.Data.AccessToken, ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("access token: expected %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+	if len(env.events) != 1 || env.events[0].UserID != 2 {
+		t.Fatalf("expected the revocation to be published, got %+v", env.events)
+	}
+
+	rr = call(env, http.MethodPost, "/authenticate/refresh", "", `{"refresh_token":"`+resp.Data.RefreshToken+`"}`)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("refresh: expected %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+
+	if rr := env.authenticate(credentials("bjensen@example.com", "provisioned")); rr.Code != http.StatusBadRequest {
+		t.Fatalf("login while deactivated: expected %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"authentication/data"
+	"authz"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// login signs the admin in from a browser and returns their tokens
+func login(t *testing.T, env *testEnv, userAgent string) tokenResponse {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/authenticate", strings.NewReader(credentials("admin@example.com", "verysecret")))
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("User-Agent", userAgent)
+
+	rr := httptest.NewRecorder()
+	env.app.routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("login: expected %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body)
+	}
+
+	var resp struct {
+		Data tokenResponse `json:"data"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.Data
+}
+
+func call(env *testEnv, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.RemoteAddr = "10.0.0.1:1234"
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rr := httptest.NewRecorder()
+	env.app.routes().ServeHTTP(rr, req)
+
+	return rr
+}
+
+func TestSessionsListAndRevoke(t *testing.T) {
+	env := newTestEnv(t)
+
+	laptop := login(t, env, "laptop")
+	phone := login(t, env, "phone")
+
+	rr := call(env, http.MethodGet, "/sessions", laptop.AccessToken, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+
+	var list struct {
+		Data []struct {
+			ID        string `json:"id"`
+			UserAgent string `json:"user_agent"`
+			IP        string `json:"ip"`
+			Current   bool   `json:"current"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list.Data) != 2 {
+		t.Fatalf("expected two sessions, got %+v", list.Data)
+	}
+
+	var phoneID string
+	for _, s := range list.Data {
+		if s.IP != "10.0.0.1" {
+			t.Fatalf("unexpected ip %q", s.IP)
+		}
+		if s.Current != (s.UserAgent == "laptop") {
+			t.Fatalf("wrong session marked current: %+v", list.Data)
+		}
+		if s.UserAgent == "phone" {
+			phoneID = s.ID
+		}
+	}
+
+	// sign the phone out from the laptop
+	rr = call(env, http.MethodDelete, "/sessions/"+phoneID, laptop.AccessToken, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+
+	if rr := call(env, http.MethodGet, "/sessions", phone.AccessToken, ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("revoked session's token: expected %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+
+	if rr := call(env, http.MethodGet, "/sessions", laptop.AccessToken, ""); rr.Code != http.StatusOK {
+		t.Fatalf("other session's token: expected %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	body := `{"refresh_token":"` + phone.RefreshToken + `"}`
+	if rr := call(env, http.MethodPost, "/authenticate/refresh", "", body); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("revoked session's refresh token: expected %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+
+	env.mu.Lock()
+	events := env.events
+	env.mu.Unlock()
+
+	if len(events) != 1 || len(events[0].SessionIDs) != 1 || events[0].SessionIDs[0] != phoneID {
+		t.Fatalf("expected one revocation event for %s, got %+v", phoneID, events)
+	}
+}
+
+func TestRefreshTokenRotates(t *testing.T) {
+	env := newTestEnv(t)
+
+	first := login(t, env, "laptop")
+
+	rr := call(env, http.MethodPost, "/authenticate/refresh", "", `{"refresh_token":"`+first.RefreshToken+`"}`)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body)
+	}
+
+	var resp struct {
+		Data tokenResponse `json:"data"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Data.RefreshToken == "" || resp.Data.RefreshToken == first.RefreshToken {
+		t.Fatal("expected a new refresh token")
+	}
+
+	before, _ := authz.VerifyRS256(first.AccessToken, env.app.Keys.PublicKey)
+	after, err := authz.VerifyRS256(resp.Data.AccessToken, env.app.Keys.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if after.SessionID == "" || after.SessionID != before.SessionID {
+		t.Fatalf("expected the refreshed token to keep session %q, got %q", before.SessionID, after.SessionID)
+	}
+
+	// the old refresh token is spent
+	rr = call(env, http.MethodPost, "/authenticate/refresh", "", `{"refresh_token":"`+first.RefreshToken+`"}`)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("reused refresh token: expected %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+}
+
+// racingSessions lets another request rotate the refresh token between the lookup
+// and the Touch, as happens when a stolen token is used alongside the real one
+type racingSessions struct {
+	data.SessionRepository
+}
+
+func (r racingSessions) Touch(ctx context.Context, id, oldHash, newHash string, seen, expires time.Time) (bool, error) {
+	if _, err := r.SessionRepository.Touch(ctx, id, oldHash, "rival", seen, expires); err != nil {
+		return false, err
+	}
+	return r.SessionRepository.Touch(ctx, id, oldHash, newHash, seen, expires)
+}
+
+func TestRefreshTokenRaceRevokesSession(t *testing.T) {
+	env := newTestEnv(t)
+
+	first := login(t, env, "laptop")
+	env.app.Sessions = racingSessions{env.app.Sessions}
+
+	rr := call(env, http.MethodPost, "/authenticate/refresh", "", `{"refresh_token":"`+first.RefreshToken+`"}`)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected %d, got %d: %s", http.StatusUnauthorized, rr.Code, rr.Body)
+	}
+
+	// the rival's rotation is no good either, and the access token goes with it
+	rr = call(env, http.MethodGet, "/sessions", first.AccessToken, "")
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the session to be revoked, got %d: %s", rr.Code, rr.Body)
+	}
+}
+
+func TestRevokeUserSessions(t *testing.T) {
+	env := newTestEnv(t)
+
+	first := login(t, env, "laptop")
+	second := login(t, env, "phone")
+
+	rr := call(env, http.MethodDelete, "/users/1/sessions", first.AccessToken, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+
+	for _, token := range []string{first.AccessToken, second.AccessToken} {
+		if rr := call(env, http.MethodGet, "/sessions", token, ""); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("expected every session to be revoked, got %d", rr.Code)
+		}
+	}
+
+	env.mu.Lock()
+	defer env.mu.Unlock()
+
+	if len(env.events) != 1 || len(env.events[0].SessionIDs) != 2 || env.events[0].UserID != 1 {
+		t.Fatalf("expected one revocation event for both sessions, got %+v", env.events)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"authentication/data"
+	"authz"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+const tokenIssuer = authz.AccessTokenIssuer
+
+// tokenResponse is what we send back to a client once it has logged in
+type tokenResponse struct {
+	User         *data.User `json:"user"`
+	AccessToken  string     `json:"access_token"`
+	TokenType    string     `json:"token_type"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	RefreshToken string     `json:"refresh_token,omitempty"`
+}
+
+// issueToken looks up the roles and permissions for user and signs an access token
+// carrying them, for the session it was issued in. User tokens are signed with the current private key, so that other
+// services can verify them from our published key set without holding a secret.
+func (app *Config) issueToken(ctx context.Context, user *data.User, sessionID string) (*tokenResponse, error) {
+	return app.signUserToken(ctx, user, sessionID, nil, tokenTTL)
+}
+
+// signUserToken signs an access token for user lasting ttl. actor is set when
+// someone else will be acting as the user.
+func (app *Config) signUserToken(ctx context.Context, user *data.User, sessionID string, actor *authz.Actor, ttl time.Duration) (*tokenResponse, error) {
+	access, err := app.Users.GetAccessContext(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	tenant, err := app.tenantFor(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiry := now.Add(ttl)
+
+	key := app.Keys.Signing()
+
+	token, err := authz.SignAccessToken(key.ID, key.Private, authz.Claims{
+		Subject:     strconv.Itoa(user.ID),
+		Issuer:      tokenIssuer,
+		SessionID:   sessionID,
+		TenantID:    tenant,
+		Email:       user.Email,
+		Roles:       access.Roles,
+		Permissions: access.Permissions,
+		IssuedAt:    now.Unix(),
+		ExpiresAt:   expiry.Unix(),
+		Actor:       actor,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenResponse{
+		User:        user,
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresAt:   expiry,
+	}, nil
+}
+
+// clientToken signs an access token for user that an OAuth client can use at the
+// userinfo endpoint, and nowhere else. It carries the scopes the user granted the
+// client rather than the user's roles and permissions, and names the client as its
+// audience.
+func (app *Config) clientToken(user *data.User, sessionID, clientID, scope string) (*tokenResponse, error) {
+	now := time.Now()
+	expiry := now.Add(tokenTTL)
+
+	key := app.Keys.Signing()
+
+	token, err := authz.SignAccessToken(key.ID, key.Private, authz.Claims{
+		Subject:   strconv.Itoa(user.ID),
+		Issuer:    tokenIssuer,
+		SessionID: sessionID,
+		Audience:  clientID,
+		Scope:     scope,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expiry.Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenResponse{
+		User:        user,
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresAt:   expiry,
+	}, nil
+}
+
+// tenantFor returns the organization a user's tokens are issued for: the first one
+// they joined. Users in no organization get tokens without a tenant.
+func (app *Config) tenantFor(ctx context.Context, userID int) (string, error) {
+	orgs, err := app.Organizations.ForUser(ctx, userID)
+	if err != nil || len(orgs) == 0 {
+		return "", err
+	}
+
+	return strconv.Itoa(orgs[0].ID), nil
+}
+
+// apiKeyTenant is the tenant claim for tokens issued to key
+func apiKeyTenant(key *data.APIKey) string {
+	if key.OrganizationID == 0 {
+		return ""
+	}
+
+	return strconv.Itoa(key.OrganizationID)
+}
+
+// serviceToken signs a short lived token which this service uses to identify
+// itself when calling other services. These stay HS256 under the shared secret.
+func (app *Config) serviceToken(permissions ...string) (string, error) {
+	now := time.Now()
+
+	return app.Tokens.Sign(authz.Claims{
+		Subject:     tokenIssuer,
+		Issuer:      tokenIssuer,
+		Permissions: permissions,
+		IssuedAt:    now.Unix(),
+		ExpiresAt:   now.Add(time.Minute).Unix(),
+	})
+}
+
+// randomToken returns n random bytes, URL safe base64 encoded. It is used for
+// opaque tokens that we only ever store hashed.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the hex encoded SHA-256 of an opaque token. Our tokens are long
+// and random, so a fast hash is enough to keep them useless if the table leaks.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
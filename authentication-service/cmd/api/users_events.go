@@ -0,0 +1,129 @@
+package main
+
+import (
+	"authentication/data"
+	"authentication/event"
+	"context"
+	"log"
+)
+
+// publishingUsers is a UserRepository that publishes a lifecycle event for every
+// change it makes, so that whichever endpoint changes a user, other services hear
+// about it. Events are sent once the change has been stored; a failure to publish
+// is logged and does not undo it.
+type publishingUsers struct {
+	data.UserRepository
+	events event.Publisher
+}
+
+// withUserEvents wraps users so that changes to them are published on events
+func withUserEvents(users data.UserRepository, events event.Publisher) data.UserRepository {
+	return &publishingUsers{UserRepository: users, events: events}
+}
+
+func (p *publishingUsers) publish(eventType string, user *data.User) {
+	e := event.NewUserEvent(eventType, event.UserData{
+		ID:        user.ID,
+		Email:     user.Email,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Active:    user.Active == 1,
+	})
+
+	err := p.events.Publish(event.UsersExchange, eventType, e)
+	if err != nil {
+		log.Printf("Error publishing %s for user %d: %v", eventType, user.ID, err)
+	}
+}
+
+func (p *publishingUsers) Insert(user data.User) (int, error) {
+	return p.InsertContext(context.Background(), user)
+}
+
+func (p *publishingUsers) InsertContext(ctx context.Context, user data.User) (int, error) {
+	id, err := p.UserRepository.InsertContext(ctx, user)
+	if err != nil {
+		return 0, err
+	}
+
+	created, err := p.UserRepository.GetOneContext(ctx, id)
+	if err != nil {
+		log.Println("Error loading new user for user.created:", err)
+		return id, nil
+	}
+
+	p.publish(event.UserCreated, created)
+
+	return id, nil
+}
+
+func (p *publishingUsers) Update(user data.User) error {
+	return p.UpdateContext(context.Background(), user)
+}
+
+func (p *publishingUsers) UpdateContext(ctx context.Context, user data.User) error {
+	before, err := p.UserRepository.GetOneContext(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	err = p.UserRepository.UpdateContext(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	after, err := p.UserRepository.GetOneContext(ctx, user.ID)
+	if err != nil {
+		log.Println("Error loading updated user for user.updated:", err)
+		return nil
+	}
+
+	p.publish(event.UserUpdated, after)
+
+	if before.Active == 1 && after.Active != 1 {
+		p.publish(event.UserDeactivated, after)
+	}
+
+	return nil
+}
+
+func (p *publishingUsers) DeleteByID(id int) error {
+	return p.DeleteByIDContext(context.Background(), id)
+}
+
+func (p *publishingUsers) DeleteByIDContext(ctx context.Context, id int) error {
+	before, err := p.UserRepository.GetOneContext(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	err = p.UserRepository.DeleteByIDContext(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	p.publish(event.UserDeleted, before)
+
+	return nil
+}
+
+func (p *publishingUsers) ResetPassword(id int, password string) error {
+	return p.ResetPasswordContext(context.Background(), id, password)
+}
+
+func (p *publishingUsers) ResetPasswordContext(ctx context.Context, id int, password string) error {
+	err := p.UserRepository.ResetPasswordContext(ctx, id, password)
+	if err != nil {
+		return err
+	}
+
+	user, err := p.UserRepository.GetOneContext(ctx, id)
+	if err != nil {
+		log.Println("Error loading user for user.password_changed:", err)
+		return nil
+	}
+
+	p.publish(event.UserPasswordChanged, user)
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"authentication/data"
+	"authentication/event"
+	"reflect"
+	"testing"
+)
+
+func TestUserLifecycleEvents(t *testing.T) {
+	env := newTestEnv(t)
+
+	id, err := env.app.Users.Insert(data.User{Email: "new@example.com", Password: "password", Active: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, _ := env.app.Users.GetOne(id)
+	user.FirstName = "New"
+	if err := env.app.Users.Update(*user); err != nil {
+		t.Fatal(err)
+	}
+
+	user.Active = 0
+	if err := env.app.Users.Update(*user); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.app.Users.ResetPassword(id, "another"); err != nil {
+		t.Fatal(err)
+	}
+
+	// upgrading a hash on login is not a password change
+	if err := env.app.Users.SetPasswordHash(id, "$2a$04$unchanged"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.app.Users.DeleteByID(id); err != nil {
+		t.Fatal(err)
+	}
+
+	env.mu.Lock()
+	events := env.userEvents
+	env.mu.Unlock()
+
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+
+		if e.Version != event.UserEventVersion || e.ID == "" || e.User.ID != id || e.User.Email != "new@example.com" {
+			t.Fatalf("unexpected event %+v", e)
+		}
+	}
+
+	want := []string{
+		event.UserCreated,
+		event.UserUpdated,
+		event.UserUpdated,
+		event.UserDeactivated,
+		event.UserPasswordChanged,
+		event.UserDeleted,
+	}
+	if !reflect.DeepEqual(types, want) {
+		t.Fatalf("expected %v, got %v", want, types)
+	}
+
+	if events[1].User.FirstName != "New" || events[3].User.Active {
+		t.Fatalf("events should describe the user after the change: %+v", events)
+	}
+}
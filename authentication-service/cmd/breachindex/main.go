@@ -0,0 +1,158 @@
+// Command breachindex builds the breached password index the authentication service
+// checks new passwords against, from a Pwned Passwords SHA-1 dump.
+//
+// The dump is either one file with a HASH:COUNT line per hash, or a directory of
+// range files named after their five character prefix (00000.txt, 00001.txt, ...),
+// each holding the SUFFIX:COUNT lines of a range response. Point the service's
+// BREACHED_PASSWORDS variable at the index it writes.
+package main
+
+import (
+	"authentication/breach"
+	"bytes"
+	"crypto/sha1"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func main() {
+	out := flag.String("o", "breached-passwords.idx", "index file to write")
+	minCount := flag.Int("min-count", 1, "leave out hashes seen fewer times than this")
+	sortInput := flag.Bool("sort", false, "sort the hashes in memory first, for dumps that are not sorted by hash")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: breachindex [flags] <dump file or range directory>\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	n, err := build(flag.Arg(0), *out, *minCount, *sortInput)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Wrote %d hashes to %s\n", n, *out)
+}
+
+// build writes the index for the dump at src to dst, and returns how many hashes it holds
+func build(src, dst string, minCount int, sortInput bool) (int, error) {
+	// write to a temporary file, so that a failed build never leaves half an index
+	// where the service would pick it up
+	f, err := os.CreateTemp(filepath.Dir(dst), ".breachindex-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	b, err := breach.NewBuilder(f)
+	if err != nil {
+		return 0, err
+	}
+
+	add := b.Add
+
+	var sums [][sha1.Size]byte
+	if sortInput {
+		add = func(sum [sha1.Size]byte) error {
+			sums = append(sums, sum)
+			return nil
+		}
+	}
+
+	if err := read(src, minCount, add); err != nil {
+		return 0, err
+	}
+
+	if sortInput {
+		sort.Slice(sums, func(i, j int) bool { return bytes.Compare(sums[i][:], sums[j][:]) < 0 })
+
+		for _, sum := range sums {
+			if err := b.Add(sum); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if err := b.Close(); err != nil {
+		return 0, err
+	}
+
+	// CreateTemp makes the file readable by us alone, and the service may run as
+	// someone else
+	if err := f.Chmod(0o644); err != nil {
+		return 0, err
+	}
+
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+
+	return b.Count(), os.Rename(f.Name(), dst)
+}
+
+// read passes every hash in the dump at src to add
+func read(src string, minCount int, add func([sha1.Size]byte) error) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := breach.ReadDump(f, minCount, add); err != nil {
+			return fmt.Errorf("%s: %w", src, err)
+		}
+
+		return nil
+	}
+
+	// os.ReadDir sorts by name, which puts the ranges in hash order
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		prefix := strings.ToUpper(strings.TrimSuffix(e.Name(), filepath.Ext(e.Name())))
+		if e.IsDir() || len(prefix) != 5 {
+			continue
+		}
+
+		err := readRange(filepath.Join(src, e.Name()), prefix, minCount, add)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readRange(path, prefix string, minCount int, add func([sha1.Size]byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := breach.ReadRange(prefix, f, minCount, add); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// APIKey lets a job or another system call our services without a user to log in
+// as. The key handed out is the prefix and a secret joined by a dot; the prefix
+// identifies the key, and only a hash of the secret is stored. A key acts for the
+// organization of the user who created it.
+type APIKey struct {
+	ID             int        `json:"id"`
+	Prefix         string     `json:"prefix"`
+	SecretHash     string     `json:"-"`
+	Name           string     `json:"name"`
+	Scopes         []string   `json:"scopes"`
+	OrganizationID int        `json:"organization_id,omitempty"`
+	CreatedBy      int        `json:"created_by,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Active reports whether the key can be used at now
+func (k *APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// APIKeyRepository stores API keys. Lookups for a key that does not exist return
+// sql.ErrNoRows.
+type APIKeyRepository interface {
+	All(ctx context.Context) ([]*APIKey, error)
+	GetByPrefix(ctx context.Context, prefix string) (*APIKey, error)
+	Insert(ctx context.Context, key APIKey) (int, error)
+
+	// Revoke revokes a key, reporting whether it existed and was not already revoked.
+	Revoke(ctx context.Context, id int, at time.Time) (bool, error)
+
+	// Touch records that a key was used.
+	Touch(ctx context.Context, id int, at time.Time) error
+}
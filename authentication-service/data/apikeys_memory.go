@@ -0,0 +1,88 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryAPIKeyRepository is an APIKeyRepository that keeps keys in memory. It is
+// meant for tests.
+type MemoryAPIKeyRepository struct {
+	mu     sync.Mutex
+	keys   map[int]APIKey
+	nextID int
+}
+
+// NewMemoryAPIKeyRepository returns an empty MemoryAPIKeyRepository.
+func NewMemoryAPIKeyRepository() *MemoryAPIKeyRepository {
+	return &MemoryAPIKeyRepository{keys: make(map[int]APIKey), nextID: 1}
+}
+
+func (r *MemoryAPIKeyRepository) All(ctx context.Context) ([]*APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var keys []*APIKey
+	for _, k := range r.keys {
+		k := k
+		keys = append(keys, &k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID > keys[j].ID })
+
+	return keys, nil
+}
+
+func (r *MemoryAPIKeyRepository) GetByPrefix(ctx context.Context, prefix string) (*APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, k := range r.keys {
+		if k.Prefix == prefix {
+			return &k, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (r *MemoryAPIKeyRepository) Insert(ctx context.Context, key APIKey) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key.ID = r.nextID
+	r.keys[key.ID] = key
+	r.nextID++
+
+	return key.ID, nil
+}
+
+func (r *MemoryAPIKeyRepository) Revoke(ctx context.Context, id int, at time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k, ok := r.keys[id]
+	if !ok || k.RevokedAt != nil {
+		return false, nil
+	}
+
+	k.RevokedAt = &at
+	r.keys[id] = k
+
+	return true, nil
+}
+
+func (r *MemoryAPIKeyRepository) Touch(ctx context.Context, id int, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if k, ok := r.keys[id]; ok {
+		k.LastUsedAt = &at
+		r.keys[id] = k
+	}
+
+	return nil
+}
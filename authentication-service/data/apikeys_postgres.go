@@ -0,0 +1,154 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// PostgresAPIKeyRepository is the APIKeyRepository used by the service.
+type PostgresAPIKeyRepository struct {
+	db *sql.DB
+}
+
+// NewPostgresAPIKeyRepository returns a PostgresAPIKeyRepository using db.
+func NewPostgresAPIKeyRepository(db *sql.DB) *PostgresAPIKeyRepository {
+	return &PostgresAPIKeyRepository{db: db}
+}
+
+const apiKeyColumns = `id, prefix, secret_hash, name, scopes, coalesce(organization_id, 0), coalesce(created_by, 0), created_at, expires_at, last_used_at, revoked_at`
+
+func scanAPIKey(row interface{ Scan(...any) error }) (*APIKey, error) {
+	var key APIKey
+	var scopes string
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+
+	err := row.Scan(
+		&key.ID,
+		&key.Prefix,
+		&key.SecretHash,
+		&key.Name,
+		&scopes,
+		&key.OrganizationID,
+		&key.CreatedBy,
+		&key.CreatedAt,
+		&expiresAt,
+		&lastUsedAt,
+		&revokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Scopes = strings.Fields(scopes)
+	key.ExpiresAt = nullTime(expiresAt)
+	key.LastUsedAt = nullTime(lastUsedAt)
+	key.RevokedAt = nullTime(revokedAt)
+
+	return &key, nil
+}
+
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+
+	return &t.Time
+}
+
+// All returns every key, newest first
+func (r *PostgresAPIKeyRepository) All(ctx context.Context) ([]*APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `select `+apiKeyColumns+` from api_keys order by created_at desc, id desc`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*APIKey
+
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// GetByPrefix returns the key with the given prefix
+func (r *PostgresAPIKeyRepository) GetByPrefix(ctx context.Context, prefix string) (*APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select ` + apiKeyColumns + ` from api_keys where prefix = $1`
+
+	return scanAPIKey(r.db.QueryRowContext(ctx, query, prefix))
+}
+
+// Insert stores a new key and returns its id
+func (r *PostgresAPIKeyRepository) Insert(ctx context.Context, key APIKey) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var createdBy sql.NullInt64
+	if key.CreatedBy != 0 {
+		createdBy = sql.NullInt64{Int64: int64(key.CreatedBy), Valid: true}
+	}
+
+	var organizationID sql.NullInt64
+	if key.OrganizationID != 0 {
+		organizationID = sql.NullInt64{Int64: int64(key.OrganizationID), Valid: true}
+	}
+
+	var expiresAt sql.NullTime
+	if key.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: *key.ExpiresAt, Valid: true}
+	}
+
+	stmt := `insert into api_keys (prefix, secret_hash, name, scopes, organization_id, created_by, created_at, expires_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	var id int
+	err := r.db.QueryRowContext(ctx, stmt,
+		key.Prefix,
+		key.SecretHash,
+		key.Name,
+		strings.Join(key.Scopes, " "),
+		organizationID,
+		createdBy,
+		key.CreatedAt,
+		expiresAt,
+	).Scan(&id)
+
+	return id, err
+}
+
+// Revoke revokes a key
+func (r *PostgresAPIKeyRepository) Revoke(ctx context.Context, id int, at time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	res, err := r.db.ExecContext(ctx, `update api_keys set revoked_at = $1 where id = $2 and revoked_at is null`, at, id)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Touch records that a key was used
+func (r *PostgresAPIKeyRepository) Touch(ctx context.Context, id int, at time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx, `update api_keys set last_used_at = $1 where id = $2`, at, id)
+	return err
+}
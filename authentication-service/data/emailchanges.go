@@ -0,0 +1,31 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// EmailChange is a change of email address waiting to be confirmed from the new
+// address. Only a hash of the token in the confirmation link is stored.
+type EmailChange struct {
+	TokenHash string
+	UserID    int
+	NewEmail  string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// EmailChangeRepository stores pending email changes. Lookups for a change that does
+// not exist return sql.ErrNoRows.
+type EmailChangeRepository interface {
+	// Insert stores a change, replacing any the user already had pending, so only
+	// the latest link they asked for works.
+	Insert(ctx context.Context, change EmailChange) error
+
+	// Consume removes a change and returns it, so that it can only ever be confirmed
+	// once. Expired changes are returned too; checking expiry is up to the caller.
+	Consume(ctx context.Context, tokenHash string) (*EmailChange, error)
+
+	// DeleteForUser drops a user's pending change, if they have one.
+	DeleteForUser(ctx context.Context, userID int) error
+}
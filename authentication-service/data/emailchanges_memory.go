@@ -0,0 +1,61 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// MemoryEmailChangeRepository is an EmailChangeRepository that keeps changes in
+// memory. It is meant for tests.
+type MemoryEmailChangeRepository struct {
+	mu      sync.Mutex
+	changes map[string]EmailChange
+}
+
+// NewMemoryEmailChangeRepository returns an empty MemoryEmailChangeRepository.
+func NewMemoryEmailChangeRepository() *MemoryEmailChangeRepository {
+	return &MemoryEmailChangeRepository{changes: make(map[string]EmailChange)}
+}
+
+func (r *MemoryEmailChangeRepository) Insert(ctx context.Context, change EmailChange) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for hash, c := range r.changes {
+		if c.UserID == change.UserID {
+			delete(r.changes, hash)
+		}
+	}
+
+	r.changes[change.TokenHash] = change
+
+	return nil
+}
+
+func (r *MemoryEmailChangeRepository) Consume(ctx context.Context, tokenHash string) (*EmailChange, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	change, ok := r.changes[tokenHash]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	delete(r.changes, tokenHash)
+
+	return &change, nil
+}
+
+func (r *MemoryEmailChangeRepository) DeleteForUser(ctx context.Context, userID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for hash, c := range r.changes {
+		if c.UserID == userID {
+			delete(r.changes, hash)
+		}
+	}
+
+	return nil
+}
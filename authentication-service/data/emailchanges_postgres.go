@@ -0,0 +1,77 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// PostgresEmailChangeRepository is the EmailChangeRepository used by the service.
+type PostgresEmailChangeRepository struct {
+	db *sql.DB
+}
+
+// NewPostgresEmailChangeRepository returns a PostgresEmailChangeRepository using db.
+func NewPostgresEmailChangeRepository(db *sql.DB) *PostgresEmailChangeRepository {
+	return &PostgresEmailChangeRepository{db: db}
+}
+
+// Insert stores an email change in place of the user's pending one, and clears out
+// any that have expired
+func (r *PostgresEmailChangeRepository) Insert(ctx context.Context, change EmailChange) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `delete from email_changes where user_id = $1 or expires_at < $2`, change.UserID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into email_changes (token_hash, user_id, new_email, created_at, expires_at)
+		values ($1, $2, $3, $4, $5)`
+
+	_, err = tx.ExecContext(ctx, stmt, change.TokenHash, change.UserID, change.NewEmail, change.CreatedAt, change.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Consume deletes an email change and returns it
+func (r *PostgresEmailChangeRepository) Consume(ctx context.Context, tokenHash string) (*EmailChange, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `delete from email_changes where token_hash = $1
+		returning token_hash, user_id, new_email, created_at, expires_at`
+
+	var change EmailChange
+	err := r.db.QueryRowContext(ctx, stmt, tokenHash).Scan(
+		&change.TokenHash,
+		&change.UserID,
+		&change.NewEmail,
+		&change.CreatedAt,
+		&change.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &change, nil
+}
+
+// DeleteForUser deletes a user's pending email change
+func (r *PostgresEmailChangeRepository) DeleteForUser(ctx context.Context, userID int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx, `delete from email_changes where user_id = $1`, userID)
+	return err
+}
@@ -0,0 +1,41 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrDuplicateGroup is returned when a group's name is already taken.
+var ErrDuplicateGroup = errors.New("a group with that name already exists")
+
+// Group is a named set of users, as provisioned by an HR system. Groups carry no
+// permissions of their own; they mirror the organisation for other services. Each
+// belongs to the organization that provisioned it.
+type Group struct {
+	ID             int       `json:"id"`
+	OrganizationID int       `json:"organization_id"`
+	DisplayName    string    `json:"display_name"`
+	Members        []int     `json:"members"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// GroupRepository stores groups and their members. Every lookup is made within one
+// organization, and a group that does not exist in it returns sql.ErrNoRows.
+type GroupRepository interface {
+	// Find returns up to limit of an organization's groups, ordered by id and skipping
+	// the first offset, along with how many there are in all. A non-empty displayName
+	// matches only the group with that name.
+	Find(ctx context.Context, organizationID int, displayName string, offset, limit int) ([]*Group, int, error)
+
+	Get(ctx context.Context, organizationID, id int) (*Group, error)
+
+	// Insert stores a new group in group.OrganizationID.
+	Insert(ctx context.Context, group Group) (int, error)
+
+	// Update replaces the name and members of a group in group.OrganizationID.
+	Update(ctx context.Context, group Group) error
+
+	Delete(ctx context.Context, organizationID, id int) error
+}
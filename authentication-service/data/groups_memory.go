@@ -0,0 +1,137 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryGroupRepository is a GroupRepository that keeps groups in memory. It is
+// meant for tests.
+type MemoryGroupRepository struct {
+	mu     sync.Mutex
+	groups map[int]Group
+	nextID int
+}
+
+// NewMemoryGroupRepository returns an empty MemoryGroupRepository.
+func NewMemoryGroupRepository() *MemoryGroupRepository {
+	return &MemoryGroupRepository{groups: make(map[int]Group), nextID: 1}
+}
+
+func (r *MemoryGroupRepository) Find(ctx context.Context, organizationID int, displayName string, offset, limit int) ([]*Group, int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var matched []*Group
+	for _, g := range r.groups {
+		if g.OrganizationID == organizationID && (displayName == "" || g.DisplayName == displayName) {
+			g := copyGroup(g)
+			matched = append(matched, &g)
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool { return matched[i].ID < matched[j].ID })
+
+	total := len(matched)
+	if offset > total {
+		offset = total
+	}
+	if offset+limit < total {
+		matched = matched[:offset+limit]
+	}
+
+	return matched[offset:], total, nil
+}
+
+func (r *MemoryGroupRepository) Get(ctx context.Context, organizationID, id int) (*Group, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	g, ok := r.groups[id]
+	if !ok || g.OrganizationID != organizationID {
+		return nil, sql.ErrNoRows
+	}
+
+	g = copyGroup(g)
+	return &g, nil
+}
+
+func (r *MemoryGroupRepository) Insert(ctx context.Context, group Group) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.taken(group.OrganizationID, group.DisplayName, 0) {
+		return 0, ErrDuplicateGroup
+	}
+
+	group.ID = r.nextID
+	group.CreatedAt = time.Now()
+	group.UpdatedAt = group.CreatedAt
+	r.groups[group.ID] = copyGroup(group)
+	r.nextID++
+
+	return group.ID, nil
+}
+
+func (r *MemoryGroupRepository) Update(ctx context.Context, group Group) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.groups[group.ID]
+	if !ok || existing.OrganizationID != group.OrganizationID {
+		return sql.ErrNoRows
+	}
+
+	if r.taken(group.OrganizationID, group.DisplayName, group.ID) {
+		return ErrDuplicateGroup
+	}
+
+	group.CreatedAt = existing.CreatedAt
+	group.UpdatedAt = time.Now()
+	r.groups[group.ID] = copyGroup(group)
+
+	return nil
+}
+
+func (r *MemoryGroupRepository) Delete(ctx context.Context, organizationID, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if g, ok := r.groups[id]; !ok || g.OrganizationID != organizationID {
+		return sql.ErrNoRows
+	}
+
+	delete(r.groups, id)
+
+	return nil
+}
+
+// taken reports whether a group of the organization other than id already has name
+func (r *MemoryGroupRepository) taken(organizationID int, name string, id int) bool {
+	for _, g := range r.groups {
+		if g.OrganizationID == organizationID && g.DisplayName == name && g.ID != id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// copyGroup copies g, members and all, sorting the members as Postgres would
+func copyGroup(g Group) Group {
+	seen := make(map[int]bool)
+	members := []int{}
+	for _, id := range g.Members {
+		if !seen[id] {
+			seen[id] = true
+			members = append(members, id)
+		}
+	}
+	sort.Ints(members)
+
+	g.Members = members
+	return g
+}
@@ -0,0 +1,208 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgconn"
+)
+
+// PostgresGroupRepository is the GroupRepository used by the service.
+type PostgresGroupRepository struct {
+	db *sql.DB
+}
+
+// NewPostgresGroupRepository returns a PostgresGroupRepository using db.
+func NewPostgresGroupRepository(db *sql.DB) *PostgresGroupRepository {
+	return &PostgresGroupRepository{db: db}
+}
+
+// Find returns a page of groups, with their members
+func (r *PostgresGroupRepository) Find(ctx context.Context, organizationID int, displayName string, offset, limit int) ([]*Group, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var total int
+	query := `select count(*) from groups where organization_id = $1 and ($2 = '' or display_name = $2)`
+	err := r.db.QueryRowContext(ctx, query, organizationID, displayName).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query = `select id, organization_id, display_name, created_at, updated_at from groups
+		where organization_id = $1 and ($2 = '' or display_name = $2) order by id offset $3 limit $4`
+
+	rows, err := r.db.QueryContext(ctx, query, organizationID, displayName, offset, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var groups []*Group
+
+	for rows.Next() {
+		var g Group
+		if err := rows.Scan(&g.ID, &g.OrganizationID, &g.DisplayName, &g.CreatedAt, &g.UpdatedAt); err != nil {
+			return nil, 0, err
+		}
+
+		groups = append(groups, &g)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	for _, g := range groups {
+		if g.Members, err = r.members(ctx, g.ID); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return groups, total, nil
+}
+
+// Get returns one of an organization's groups, with its members
+func (r *PostgresGroupRepository) Get(ctx context.Context, organizationID, id int) (*Group, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var g Group
+
+	query := `select id, organization_id, display_name, created_at, updated_at from groups
+		where id = $1 and organization_id = $2`
+	err := r.db.QueryRowContext(ctx, query, id, organizationID).Scan(&g.ID, &g.OrganizationID, &g.DisplayName, &g.CreatedAt, &g.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	g.Members, err = r.members(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &g, nil
+}
+
+func (r *PostgresGroupRepository) members(ctx context.Context, groupID int) ([]int, error) {
+	rows, err := r.db.QueryContext(ctx, `select user_id from group_members where group_id = $1 order by user_id`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []int{}
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		members = append(members, id)
+	}
+
+	return members, rows.Err()
+}
+
+// Insert stores a new group and its members, and returns its id
+func (r *PostgresGroupRepository) Insert(ctx context.Context, group Group) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	var id int
+	stmt := `insert into groups (organization_id, display_name, created_at, updated_at)
+		values ($1, $2, $3, $4) returning id`
+	err = tx.QueryRowContext(ctx, stmt, group.OrganizationID, group.DisplayName, now, now).Scan(&id)
+	if err != nil {
+		return 0, duplicateGroup(err)
+	}
+
+	if err := setMembers(ctx, tx, id, group.Members); err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// Update replaces a group's name and members
+func (r *PostgresGroupRepository) Update(ctx context.Context, group Group) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `update groups set display_name = $1, updated_at = $2 where id = $3 and organization_id = $4`
+	result, err := tx.ExecContext(ctx, stmt, group.DisplayName, time.Now(), group.ID, group.OrganizationID)
+	if err != nil {
+		return duplicateGroup(err)
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from group_members where group_id = $1`, group.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := setMembers(ctx, tx, group.ID, group.Members); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func setMembers(ctx context.Context, tx *sql.Tx, groupID int, members []int) error {
+	stmt := `insert into group_members (group_id, user_id) values ($1, $2) on conflict do nothing`
+	for _, userID := range members {
+		if _, err := tx.ExecContext(ctx, stmt, groupID, userID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes one of an organization's groups; its memberships go with it
+func (r *PostgresGroupRepository) Delete(ctx context.Context, organizationID, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx, `delete from groups where id = $1 and organization_id = $2`, id, organizationID)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// duplicateGroup turns a unique violation on a group's name within its organization
+// into ErrDuplicateGroup
+func duplicateGroup(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "groups_organization_id_display_name_key" {
+		return ErrDuplicateGroup
+	}
+
+	return err
+}
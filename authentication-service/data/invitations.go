@@ -0,0 +1,26 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// Invitation lets a user who was imported without a password choose one. Only a hash
+// of the token in the emailed link is stored.
+type Invitation struct {
+	TokenHash string
+	UserID    int
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// InvitationRepository stores invitations. Lookups for an invitation that does not
+// exist return sql.ErrNoRows.
+type InvitationRepository interface {
+	Insert(ctx context.Context, invitation Invitation) error
+
+	// Consume removes an invitation and returns it, so that it can only ever be
+	// accepted once. Expired invitations are returned too; checking expiry is up to
+	// the caller.
+	Consume(ctx context.Context, tokenHash string) (*Invitation, error)
+}
@@ -0,0 +1,42 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// MemoryInvitationRepository is an InvitationRepository that keeps invitations in
+// memory. It is meant for tests.
+type MemoryInvitationRepository struct {
+	mu          sync.Mutex
+	invitations map[string]Invitation
+}
+
+// NewMemoryInvitationRepository returns an empty MemoryInvitationRepository.
+func NewMemoryInvitationRepository() *MemoryInvitationRepository {
+	return &MemoryInvitationRepository{invitations: make(map[string]Invitation)}
+}
+
+func (r *MemoryInvitationRepository) Insert(ctx context.Context, invitation Invitation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.invitations[invitation.TokenHash] = invitation
+
+	return nil
+}
+
+func (r *MemoryInvitationRepository) Consume(ctx context.Context, tokenHash string) (*Invitation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	invitation, ok := r.invitations[tokenHash]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	delete(r.invitations, tokenHash)
+
+	return &invitation, nil
+}
@@ -0,0 +1,56 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// PostgresInvitationRepository is the InvitationRepository used by the service.
+type PostgresInvitationRepository struct {
+	db *sql.DB
+}
+
+// NewPostgresInvitationRepository returns a PostgresInvitationRepository using db.
+func NewPostgresInvitationRepository(db *sql.DB) *PostgresInvitationRepository {
+	return &PostgresInvitationRepository{db: db}
+}
+
+// Insert stores an invitation, and clears out any that have expired
+func (r *PostgresInvitationRepository) Insert(ctx context.Context, invitation Invitation) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx, `delete from invitations where expires_at < $1`, time.Now())
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into invitations (token_hash, user_id, created_at, expires_at)
+		values ($1, $2, $3, $4)`
+
+	_, err = r.db.ExecContext(ctx, stmt, invitation.TokenHash, invitation.UserID, invitation.CreatedAt, invitation.ExpiresAt)
+	return err
+}
+
+// Consume deletes an invitation and returns it
+func (r *PostgresInvitationRepository) Consume(ctx context.Context, tokenHash string) (*Invitation, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `delete from invitations where token_hash = $1
+		returning token_hash, user_id, created_at, expires_at`
+
+	var invitation Invitation
+	err := r.db.QueryRowContext(ctx, stmt, tokenHash).Scan(
+		&invitation.TokenHash,
+		&invitation.UserID,
+		&invitation.CreatedAt,
+		&invitation.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &invitation, nil
+}
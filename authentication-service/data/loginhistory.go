@@ -0,0 +1,44 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// The outcomes of a login attempt
+const (
+	LoginSucceeded   = "success"
+	LoginFailed      = "failure"
+	LoginBlocked     = "blocked"
+	LoginMFARequired = "mfa_required"
+)
+
+// LoginEvent is one attempt to sign in. UserID is zero when the attempt named no
+// account we know of.
+type LoginEvent struct {
+	ID        int64     `json:"id"`
+	UserID    int       `json:"user_id,omitempty"`
+	Email     string    `json:"email,omitempty"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Method    string    `json:"method"`
+	Outcome   string    `json:"outcome"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LoginHistoryRepository stores every login attempt.
+type LoginHistoryRepository interface {
+	Insert(ctx context.Context, e LoginEvent) error
+
+	// ForUser returns a page of a user's attempts, newest first, and how many they
+	// have in all.
+	ForUser(ctx context.Context, userID, offset, limit int) ([]*LoginEvent, int, error)
+
+	// Seen reports whether the user has signed in successfully from this IP and user
+	// agent before.
+	Seen(ctx context.Context, userID int, ip, userAgent string) (bool, error)
+
+	// DeleteForUser deletes a user's attempts, and any attempts naming their email
+	// address, whatever its case. It returns how many it deleted.
+	DeleteForUser(ctx context.Context, userID int, email string) (int, error)
+}
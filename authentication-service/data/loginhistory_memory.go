@@ -0,0 +1,82 @@
+package data
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// MemoryLoginHistoryRepository is a LoginHistoryRepository that keeps attempts in
+// memory. It is meant for tests.
+type MemoryLoginHistoryRepository struct {
+	mu     sync.Mutex
+	events []LoginEvent
+}
+
+// NewMemoryLoginHistoryRepository returns an empty MemoryLoginHistoryRepository.
+func NewMemoryLoginHistoryRepository() *MemoryLoginHistoryRepository {
+	return &MemoryLoginHistoryRepository{}
+}
+
+func (r *MemoryLoginHistoryRepository) Insert(ctx context.Context, e LoginEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e.ID = int64(len(r.events) + 1)
+	r.events = append(r.events, e)
+
+	return nil
+}
+
+func (r *MemoryLoginHistoryRepository) ForUser(ctx context.Context, userID, offset, limit int) ([]*LoginEvent, int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	events := []*LoginEvent{}
+	total := 0
+
+	// newest first
+	for i := len(r.events) - 1; i >= 0; i-- {
+		if r.events[i].UserID != userID {
+			continue
+		}
+
+		if total >= offset && len(events) < limit {
+			e := r.events[i]
+			events = append(events, &e)
+		}
+		total++
+	}
+
+	return events, total, nil
+}
+
+func (r *MemoryLoginHistoryRepository) Seen(ctx context.Context, userID int, ip, userAgent string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, e := range r.events {
+		if e.UserID == userID && e.IP == ip && e.UserAgent == userAgent && e.Outcome == LoginSucceeded {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (r *MemoryLoginHistoryRepository) DeleteForUser(ctx context.Context, userID int, email string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.events[:0]
+	for _, e := range r.events {
+		if e.UserID != userID && !strings.EqualFold(e.Email, email) {
+			kept = append(kept, e)
+		}
+	}
+
+	deleted := len(r.events) - len(kept)
+	r.events = kept
+
+	return deleted, nil
+}
@@ -0,0 +1,95 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+)
+
+// PostgresLoginHistoryRepository is the LoginHistoryRepository used by the service.
+type PostgresLoginHistoryRepository struct {
+	db *sql.DB
+}
+
+// NewPostgresLoginHistoryRepository returns a PostgresLoginHistoryRepository using db.
+func NewPostgresLoginHistoryRepository(db *sql.DB) *PostgresLoginHistoryRepository {
+	return &PostgresLoginHistoryRepository{db: db}
+}
+
+// Insert records a login attempt
+func (r *PostgresLoginHistoryRepository) Insert(ctx context.Context, e LoginEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var userID sql.NullInt64
+	if e.UserID != 0 {
+		userID = sql.NullInt64{Int64: int64(e.UserID), Valid: true}
+	}
+
+	stmt := `insert into login_history (user_id, email, ip, user_agent, method, outcome, created_at)
+		values ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err := r.db.ExecContext(ctx, stmt, userID, e.Email, e.IP, e.UserAgent, e.Method, e.Outcome, e.CreatedAt)
+	return err
+}
+
+// ForUser returns a page of a user's login attempts, newest first
+func (r *PostgresLoginHistoryRepository) ForUser(ctx context.Context, userID, offset, limit int) ([]*LoginEvent, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var total int
+	err := r.db.QueryRowContext(ctx, `select count(*) from login_history where user_id = $1`, userID).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := `select id, user_id, email, ip, user_agent, method, outcome, created_at
+		from login_history where user_id = $1 order by created_at desc, id desc offset $2 limit $3`
+
+	rows, err := r.db.QueryContext(ctx, query, userID, offset, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	events := []*LoginEvent{}
+
+	for rows.Next() {
+		var e LoginEvent
+		err := rows.Scan(&e.ID, &e.UserID, &e.Email, &e.IP, &e.UserAgent, &e.Method, &e.Outcome, &e.CreatedAt)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		events = append(events, &e)
+	}
+
+	return events, total, rows.Err()
+}
+
+// Seen reports whether the user has a successful login from ip and userAgent
+func (r *PostgresLoginHistoryRepository) Seen(ctx context.Context, userID int, ip, userAgent string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select exists (select 1 from login_history
+		where user_id = $1 and ip = $2 and user_agent = $3 and outcome = $4)`
+
+	var seen bool
+	err := r.db.QueryRowContext(ctx, query, userID, ip, userAgent, LoginSucceeded).Scan(&seen)
+	return seen, err
+}
+
+// DeleteForUser deletes a user's login attempts, and those naming their email
+func (r *PostgresLoginHistoryRepository) DeleteForUser(ctx context.Context, userID int, email string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx, `delete from login_history where user_id = $1 or lower(email) = lower($2)`, userID, email)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	return int(n), err
+}
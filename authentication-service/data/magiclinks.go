@@ -0,0 +1,27 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// MagicLink is a single use sign in link that was emailed to a user. Only hashes of
+// the link's token and of the nonce cookie set in the browser that asked for it are
+// stored.
+type MagicLink struct {
+	TokenHash string
+	UserID    int
+	NonceHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// MagicLinkRepository stores magic links. Lookups for a link that does not exist
+// return sql.ErrNoRows.
+type MagicLinkRepository interface {
+	Insert(ctx context.Context, link MagicLink) error
+
+	// Consume removes a link and returns it, so that it can only ever be followed
+	// once. Expired links are returned too; checking expiry is up to the caller.
+	Consume(ctx context.Context, tokenHash string) (*MagicLink, error)
+}
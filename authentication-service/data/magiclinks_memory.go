@@ -0,0 +1,42 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// MemoryMagicLinkRepository is a MagicLinkRepository that keeps links in memory. It
+// is meant for tests.
+type MemoryMagicLinkRepository struct {
+	mu    sync.Mutex
+	links map[string]MagicLink
+}
+
+// NewMemoryMagicLinkRepository returns an empty MemoryMagicLinkRepository.
+func NewMemoryMagicLinkRepository() *MemoryMagicLinkRepository {
+	return &MemoryMagicLinkRepository{links: make(map[string]MagicLink)}
+}
+
+func (r *MemoryMagicLinkRepository) Insert(ctx context.Context, link MagicLink) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.links[link.TokenHash] = link
+
+	return nil
+}
+
+func (r *MemoryMagicLinkRepository) Consume(ctx context.Context, tokenHash string) (*MagicLink, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	link, ok := r.links[tokenHash]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	delete(r.links, tokenHash)
+
+	return &link, nil
+}
@@ -0,0 +1,57 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// PostgresMagicLinkRepository is the MagicLinkRepository used by the service.
+type PostgresMagicLinkRepository struct {
+	db *sql.DB
+}
+
+// NewPostgresMagicLinkRepository returns a PostgresMagicLinkRepository using db.
+func NewPostgresMagicLinkRepository(db *sql.DB) *PostgresMagicLinkRepository {
+	return &PostgresMagicLinkRepository{db: db}
+}
+
+// Insert stores a magic link, and clears out any that have expired
+func (r *PostgresMagicLinkRepository) Insert(ctx context.Context, link MagicLink) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx, `delete from magic_links where expires_at < $1`, time.Now())
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into magic_links (token_hash, user_id, nonce_hash, created_at, expires_at)
+		values ($1, $2, $3, $4, $5)`
+
+	_, err = r.db.ExecContext(ctx, stmt, link.TokenHash, link.UserID, link.NonceHash, link.CreatedAt, link.ExpiresAt)
+	return err
+}
+
+// Consume deletes a magic link and returns it
+func (r *PostgresMagicLinkRepository) Consume(ctx context.Context, tokenHash string) (*MagicLink, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `delete from magic_links where token_hash = $1
+		returning token_hash, user_id, nonce_hash, created_at, expires_at`
+
+	var link MagicLink
+	err := r.db.QueryRowContext(ctx, stmt, tokenHash).Scan(
+		&link.TokenHash,
+		&link.UserID,
+		&link.NonceHash,
+		&link.CreatedAt,
+		&link.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &link, nil
+}
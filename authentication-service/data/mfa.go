@@ -0,0 +1,102 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// TOTP is the structure which holds a user's time-based one-time password secret.
+// A secret only counts as a second factor once it has been confirmed.
+type TOTP struct {
+	UserID       int          `json:"user_id"`
+	Secret       string       `json:"-"`
+	ConfirmedAt  sql.NullTime `json:"-"`
+	LastUsedStep int64        `json:"-"`
+	CreatedAt    time.Time    `json:"created_at"`
+}
+
+// Confirmed reports whether the user has proven they can generate codes for this secret
+func (t *TOTP) Confirmed() bool {
+	return t.ConfirmedAt.Valid
+}
+
+// RecoveryCode is a single use code which stands in for a TOTP code when the user
+// has lost their device. Only a hash of the code is stored.
+type RecoveryCode struct {
+	ID        int          `json:"id"`
+	UserID    int          `json:"user_id"`
+	CodeHash  string       `json:"-"`
+	UsedAt    sql.NullTime `json:"-"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// MFAChallenge is issued when a user with a second factor gets their password right.
+// The client trades the challenge token, plus a code, for an access token.
+type MFAChallenge struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	TokenHash string    `json:"-"`
+	Attempts  int       `json:"attempts"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Sealer encrypts secrets before they are stored, and decrypts them again when they
+// are read. keys.Sealer is the one the service uses.
+type Sealer interface {
+	Seal(plaintext, additional []byte) ([]byte, error)
+	Open(sealed, additional []byte) ([]byte, error)
+}
+
+// TOTPRepository stores users' TOTP secrets. Lookups for a user without one return
+// sql.ErrNoRows.
+type TOTPRepository interface {
+	// GetForUser returns the secret for one user.
+	GetForUser(ctx context.Context, userID int) (*TOTP, error)
+
+	// Enroll stores a new, unconfirmed secret for a user, replacing any unconfirmed
+	// one already there. It never overwrites a confirmed secret.
+	Enroll(ctx context.Context, userID int, secret string) error
+
+	// Confirm marks a user's secret as confirmed, recording the step of the code used.
+	Confirm(ctx context.Context, userID int, step int64) error
+
+	// UseStep records that a code from step has been accepted. It returns false if
+	// that step, or a later one, has already been used, which stops a code being
+	// replayed.
+	UseStep(ctx context.Context, userID int, step int64) (bool, error)
+
+	// Delete removes a user's secret along with their recovery codes.
+	Delete(ctx context.Context, userID int) error
+}
+
+// RecoveryCodeRepository stores hashes of users' recovery codes.
+type RecoveryCodeRepository interface {
+	// Replace throws away a user's recovery codes and stores a new set of hashes.
+	Replace(ctx context.Context, userID int, hashes []string) error
+
+	// Use marks an unused recovery code as used. It returns false if the user has no
+	// unused code with that hash.
+	Use(ctx context.Context, userID int, hash string) (bool, error)
+
+	// CountUnused returns the number of recovery codes a user has left.
+	CountUnused(ctx context.Context, userID int) (int, error)
+}
+
+// MFAChallengeRepository stores pending MFA challenges. Lookups for a challenge that
+// does not exist return sql.ErrNoRows.
+type MFAChallengeRepository interface {
+	// Insert stores a new challenge, and returns its ID.
+	Insert(ctx context.Context, challenge MFAChallenge) (int, error)
+
+	// GetByHash returns one challenge by the hash of its token.
+	GetByHash(ctx context.Context, hash string) (*MFAChallenge, error)
+
+	// RecordAttempt bumps the failed attempt counter on a challenge.
+	RecordAttempt(ctx context.Context, id int) error
+
+	// DeleteByID deletes one challenge. Expired challenges for any user are cleared
+	// out at the same time.
+	DeleteByID(ctx context.Context, id int) error
+}
@@ -0,0 +1,204 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// MemoryTOTPRepository is a TOTPRepository that keeps secrets in memory. It is meant
+// for tests. Deleting a secret clears the user's codes from the
+// MemoryRecoveryCodeRepository it was given.
+type MemoryTOTPRepository struct {
+	mu      sync.Mutex
+	secrets map[int]TOTP
+	codes   *MemoryRecoveryCodeRepository
+}
+
+// NewMemoryTOTPRepository returns an empty MemoryTOTPRepository whose users' recovery
+// codes are kept in codes.
+func NewMemoryTOTPRepository(codes *MemoryRecoveryCodeRepository) *MemoryTOTPRepository {
+	return &MemoryTOTPRepository{secrets: make(map[int]TOTP), codes: codes}
+}
+
+func (r *MemoryTOTPRepository) GetForUser(ctx context.Context, userID int) (*TOTP, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	totp, ok := r.secrets[userID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	return &totp, nil
+}
+
+func (r *MemoryTOTPRepository) Enroll(ctx context.Context, userID int, secret string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.secrets[userID]; ok && existing.Confirmed() {
+		return nil
+	}
+
+	r.secrets[userID] = TOTP{UserID: userID, Secret: secret, CreatedAt: time.Now()}
+
+	return nil
+}
+
+func (r *MemoryTOTPRepository) Confirm(ctx context.Context, userID int, step int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	totp, ok := r.secrets[userID]
+	if !ok {
+		return nil
+	}
+
+	totp.ConfirmedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	totp.LastUsedStep = step
+	r.secrets[userID] = totp
+
+	return nil
+}
+
+func (r *MemoryTOTPRepository) UseStep(ctx context.Context, userID int, step int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	totp, ok := r.secrets[userID]
+	if !ok || totp.LastUsedStep >= step {
+		return false, nil
+	}
+
+	totp.LastUsedStep = step
+	r.secrets[userID] = totp
+
+	return true, nil
+}
+
+func (r *MemoryTOTPRepository) Delete(ctx context.Context, userID int) error {
+	r.mu.Lock()
+	delete(r.secrets, userID)
+	r.mu.Unlock()
+
+	return r.codes.Replace(ctx, userID, nil)
+}
+
+// MemoryRecoveryCodeRepository is a RecoveryCodeRepository that keeps code hashes in
+// memory. It is meant for tests.
+type MemoryRecoveryCodeRepository struct {
+	mu    sync.Mutex
+	codes map[int][]RecoveryCode
+}
+
+// NewMemoryRecoveryCodeRepository returns an empty MemoryRecoveryCodeRepository.
+func NewMemoryRecoveryCodeRepository() *MemoryRecoveryCodeRepository {
+	return &MemoryRecoveryCodeRepository{codes: make(map[int][]RecoveryCode)}
+}
+
+func (r *MemoryRecoveryCodeRepository) Replace(ctx context.Context, userID int, hashes []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	codes := make([]RecoveryCode, 0, len(hashes))
+	for _, hash := range hashes {
+		codes = append(codes, RecoveryCode{UserID: userID, CodeHash: hash, CreatedAt: time.Now()})
+	}
+	r.codes[userID] = codes
+
+	return nil
+}
+
+func (r *MemoryRecoveryCodeRepository) Use(ctx context.Context, userID int, hash string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, code := range r.codes[userID] {
+		if code.CodeHash == hash && !code.UsedAt.Valid {
+			r.codes[userID][i].UsedAt = sql.NullTime{Time: time.Now(), Valid: true}
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (r *MemoryRecoveryCodeRepository) CountUnused(ctx context.Context, userID int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := 0
+	for _, code := range r.codes[userID] {
+		if !code.UsedAt.Valid {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// MemoryMFAChallengeRepository is an MFAChallengeRepository that keeps challenges in
+// memory. It is meant for tests.
+type MemoryMFAChallengeRepository struct {
+	mu         sync.Mutex
+	challenges map[int]MFAChallenge
+	nextID     int
+}
+
+// NewMemoryMFAChallengeRepository returns an empty MemoryMFAChallengeRepository.
+func NewMemoryMFAChallengeRepository() *MemoryMFAChallengeRepository {
+	return &MemoryMFAChallengeRepository{challenges: make(map[int]MFAChallenge), nextID: 1}
+}
+
+func (r *MemoryMFAChallengeRepository) Insert(ctx context.Context, challenge MFAChallenge) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	challenge.ID = r.nextID
+	challenge.CreatedAt = time.Now()
+	r.challenges[challenge.ID] = challenge
+	r.nextID++
+
+	return challenge.ID, nil
+}
+
+func (r *MemoryMFAChallengeRepository) GetByHash(ctx context.Context, hash string) (*MFAChallenge, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, challenge := range r.challenges {
+		if challenge.TokenHash == hash {
+			return &challenge, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (r *MemoryMFAChallengeRepository) RecordAttempt(ctx context.Context, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if challenge, ok := r.challenges[id]; ok {
+		challenge.Attempts++
+		r.challenges[id] = challenge
+	}
+
+	return nil
+}
+
+func (r *MemoryMFAChallengeRepository) DeleteByID(ctx context.Context, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for other, challenge := range r.challenges {
+		if other == id || challenge.ExpiresAt.Before(now) {
+			delete(r.challenges, other)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,294 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+)
+
+// PostgresTOTPRepository is the TOTPRepository used by the service. Secrets are
+// sealed before they are written, so a copy of the database alone is not enough to
+// generate anyone's codes.
+type PostgresTOTPRepository struct {
+	db     *sql.DB
+	sealer Sealer
+}
+
+// NewPostgresTOTPRepository returns a PostgresTOTPRepository using db, sealing
+// secrets with sealer.
+func NewPostgresTOTPRepository(db *sql.DB, sealer Sealer) *PostgresTOTPRepository {
+	return &PostgresTOTPRepository{db: db, sealer: sealer}
+}
+
+// totpAdditional binds a sealed secret to its user, so it cannot be copied into
+// another user's row
+func totpAdditional(userID int) []byte {
+	return []byte("totp:" + strconv.Itoa(userID))
+}
+
+func (r *PostgresTOTPRepository) GetForUser(ctx context.Context, userID int) (*TOTP, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select user_id, secret, sealed_secret, confirmed_at, last_used_step, created_at
+		from user_totp where user_id = $1`
+
+	var totp TOTP
+	var plain sql.NullString
+	var sealed []byte
+	err := r.db.QueryRowContext(ctx, query, userID).Scan(
+		&totp.UserID,
+		&plain,
+		&sealed,
+		&totp.ConfirmedAt,
+		&totp.LastUsedStep,
+		&totp.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if sealed == nil {
+		// written before secrets were sealed; seal it now
+		totp.Secret = plain.String
+
+		sealed, err = r.sealer.Seal([]byte(totp.Secret), totpAdditional(userID))
+		if err != nil {
+			return nil, err
+		}
+
+		stmt := `update user_totp set sealed_secret = $1, secret = null where user_id = $2 and sealed_secret is null`
+		_, err = r.db.ExecContext(ctx, stmt, sealed, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		return &totp, nil
+	}
+
+	secret, err := r.sealer.Open(sealed, totpAdditional(userID))
+	if err != nil {
+		return nil, err
+	}
+	totp.Secret = string(secret)
+
+	return &totp, nil
+}
+
+func (r *PostgresTOTPRepository) Enroll(ctx context.Context, userID int, secret string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	sealed, err := r.sealer.Seal([]byte(secret), totpAdditional(userID))
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into user_totp (user_id, sealed_secret, created_at) values ($1, $2, $3)
+		on conflict (user_id) do update set secret = null, sealed_secret = excluded.sealed_secret,
+			last_used_step = 0, created_at = excluded.created_at
+		where user_totp.confirmed_at is null`
+
+	_, err = r.db.ExecContext(ctx, stmt, userID, sealed, time.Now())
+
+	return err
+}
+
+func (r *PostgresTOTPRepository) Confirm(ctx context.Context, userID int, step int64) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update user_totp set confirmed_at = $1, last_used_step = $2 where user_id = $3`
+
+	_, err := r.db.ExecContext(ctx, stmt, time.Now(), step, userID)
+
+	return err
+}
+
+func (r *PostgresTOTPRepository) UseStep(ctx context.Context, userID int, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update user_totp set last_used_step = $1 where user_id = $2 and last_used_step < $1`
+
+	result, err := r.db.ExecContext(ctx, stmt, step, userID)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+func (r *PostgresTOTPRepository) Delete(ctx context.Context, userID int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `delete from recovery_codes where user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from user_totp where user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// PostgresRecoveryCodeRepository is the RecoveryCodeRepository used by the service.
+type PostgresRecoveryCodeRepository struct {
+	db *sql.DB
+}
+
+// NewPostgresRecoveryCodeRepository returns a PostgresRecoveryCodeRepository using db.
+func NewPostgresRecoveryCodeRepository(db *sql.DB) *PostgresRecoveryCodeRepository {
+	return &PostgresRecoveryCodeRepository{db: db}
+}
+
+func (r *PostgresRecoveryCodeRepository) Replace(ctx context.Context, userID int, hashes []string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `delete from recovery_codes where user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into recovery_codes (user_id, code_hash, created_at) values ($1, $2, $3)`
+	for _, hash := range hashes {
+		_, err = tx.ExecContext(ctx, stmt, userID, hash, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *PostgresRecoveryCodeRepository) Use(ctx context.Context, userID int, hash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update recovery_codes set used_at = $1 where user_id = $2 and code_hash = $3 and used_at is null`
+
+	result, err := r.db.ExecContext(ctx, stmt, time.Now(), userID, hash)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows > 0, nil
+}
+
+func (r *PostgresRecoveryCodeRepository) CountUnused(ctx context.Context, userID int) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var count int
+	query := `select count(*) from recovery_codes where user_id = $1 and used_at is null`
+
+	err := r.db.QueryRowContext(ctx, query, userID).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// PostgresMFAChallengeRepository is the MFAChallengeRepository used by the service.
+type PostgresMFAChallengeRepository struct {
+	db *sql.DB
+}
+
+// NewPostgresMFAChallengeRepository returns a PostgresMFAChallengeRepository using db.
+func NewPostgresMFAChallengeRepository(db *sql.DB) *PostgresMFAChallengeRepository {
+	return &PostgresMFAChallengeRepository{db: db}
+}
+
+func (r *PostgresMFAChallengeRepository) Insert(ctx context.Context, challenge MFAChallenge) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into mfa_challenges (user_id, token_hash, expires_at, created_at)
+		values ($1, $2, $3, $4) returning id`
+
+	err := r.db.QueryRowContext(ctx, stmt,
+		challenge.UserID,
+		challenge.TokenHash,
+		challenge.ExpiresAt,
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+func (r *PostgresMFAChallengeRepository) GetByHash(ctx context.Context, hash string) (*MFAChallenge, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, token_hash, attempts, expires_at, created_at from mfa_challenges where token_hash = $1`
+
+	var challenge MFAChallenge
+	err := r.db.QueryRowContext(ctx, query, hash).Scan(
+		&challenge.ID,
+		&challenge.UserID,
+		&challenge.TokenHash,
+		&challenge.Attempts,
+		&challenge.ExpiresAt,
+		&challenge.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &challenge, nil
+}
+
+func (r *PostgresMFAChallengeRepository) RecordAttempt(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update mfa_challenges set attempts = attempts + 1 where id = $1`
+
+	_, err := r.db.ExecContext(ctx, stmt, id)
+
+	return err
+}
+
+func (r *PostgresMFAChallengeRepository) DeleteByID(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `delete from mfa_challenges where id = $1 or expires_at < $2`
+
+	_, err := r.db.ExecContext(ctx, stmt, id, time.Now())
+
+	return err
+}
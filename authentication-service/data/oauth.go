@@ -0,0 +1,64 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// OAuthClient is an application registered to sign its users in through this
+// service. Public clients, such as single page apps, have no secret and must use PKCE.
+type OAuthClient struct {
+	ID           int       `json:"id"`
+	ClientID     string    `json:"client_id"`
+	SecretHash   string    `json:"-"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Public reports whether the client has no secret
+func (c *OAuthClient) Public() bool {
+	return c.SecretHash == ""
+}
+
+// AllowsRedirect reports whether uri is one of the client's registered redirect URIs.
+// The comparison is exact, as RFC 6749 asks.
+func (c *OAuthClient) AllowsRedirect(uri string) bool {
+	for _, u := range c.RedirectURIs {
+		if u == uri {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AuthCode is an authorization code waiting to be exchanged at the token endpoint.
+// Only a hash of the code is stored.
+type AuthCode struct {
+	CodeHash      string
+	ClientID      string
+	UserID        int
+	RedirectURI   string
+	Scope         string
+	Nonce         string
+	CodeChallenge string
+	SessionID     string
+	AuthTime      time.Time
+	ExpiresAt     time.Time
+}
+
+// OAuthRepository stores OAuth clients and authorization codes. Lookups for a client
+// or code that does not exist return sql.ErrNoRows.
+type OAuthRepository interface {
+	AllClients(ctx context.Context) ([]*OAuthClient, error)
+	GetClient(ctx context.Context, clientID string) (*OAuthClient, error)
+	InsertClient(ctx context.Context, client OAuthClient) (int, error)
+	DeleteClient(ctx context.Context, clientID string) error
+
+	InsertCode(ctx context.Context, code AuthCode) error
+
+	// ConsumeCode removes a code and returns it, so that it can only ever be
+	// exchanged once. Expired codes are returned too; checking expiry is up to the caller.
+	ConsumeCode(ctx context.Context, codeHash string) (*AuthCode, error)
+}
@@ -0,0 +1,111 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryOAuthRepository is an OAuthRepository that keeps everything in memory. It is
+// meant for tests.
+type MemoryOAuthRepository struct {
+	mu      sync.Mutex
+	clients map[string]OAuthClient
+	codes   map[string]AuthCode
+	nextID  int
+}
+
+// NewMemoryOAuthRepository returns an empty MemoryOAuthRepository.
+func NewMemoryOAuthRepository() *MemoryOAuthRepository {
+	return &MemoryOAuthRepository{
+		clients: make(map[string]OAuthClient),
+		codes:   make(map[string]AuthCode),
+		nextID:  1,
+	}
+}
+
+func (r *MemoryOAuthRepository) AllClients(ctx context.Context) ([]*OAuthClient, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var clients []*OAuthClient
+	for _, c := range r.clients {
+		c := c
+		clients = append(clients, &c)
+	}
+
+	sort.Slice(clients, func(i, j int) bool { return clients[i].Name < clients[j].Name })
+
+	return clients, nil
+}
+
+func (r *MemoryOAuthRepository) GetClient(ctx context.Context, clientID string) (*OAuthClient, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.clients[clientID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	return &c, nil
+}
+
+func (r *MemoryOAuthRepository) InsertClient(ctx context.Context, client OAuthClient) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.clients[client.ClientID]; ok {
+		return 0, errors.New("client_id is already registered")
+	}
+
+	client.ID = r.nextID
+	client.CreatedAt = time.Now()
+	client.RedirectURIs = append([]string(nil), client.RedirectURIs...)
+
+	r.clients[client.ClientID] = client
+	r.nextID++
+
+	return client.ID, nil
+}
+
+func (r *MemoryOAuthRepository) DeleteClient(ctx context.Context, clientID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.clients, clientID)
+
+	for hash, code := range r.codes {
+		if code.ClientID == clientID {
+			delete(r.codes, hash)
+		}
+	}
+
+	return nil
+}
+
+func (r *MemoryOAuthRepository) InsertCode(ctx context.Context, code AuthCode) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.codes[code.CodeHash] = code
+
+	return nil
+}
+
+func (r *MemoryOAuthRepository) ConsumeCode(ctx context.Context, codeHash string) (*AuthCode, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	code, ok := r.codes[codeHash]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	delete(r.codes, codeHash)
+
+	return &code, nil
+}
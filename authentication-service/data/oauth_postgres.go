@@ -0,0 +1,173 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// PostgresOAuthRepository is the OAuthRepository used by the service.
+type PostgresOAuthRepository struct {
+	db *sql.DB
+}
+
+// NewPostgresOAuthRepository returns a PostgresOAuthRepository using db.
+func NewPostgresOAuthRepository(db *sql.DB) *PostgresOAuthRepository {
+	return &PostgresOAuthRepository{db: db}
+}
+
+func scanClient(row interface{ Scan(...any) error }) (*OAuthClient, error) {
+	var client OAuthClient
+	var secretHash sql.NullString
+	var redirectURIs string
+
+	err := row.Scan(
+		&client.ID,
+		&client.ClientID,
+		&secretHash,
+		&client.Name,
+		&redirectURIs,
+		&client.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	client.SecretHash = secretHash.String
+	client.RedirectURIs = strings.Fields(redirectURIs)
+
+	return &client, nil
+}
+
+// AllClients returns every registered client, sorted by name
+func (r *PostgresOAuthRepository) AllClients(ctx context.Context) ([]*OAuthClient, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select id, client_id, secret_hash, name, redirect_uris, created_at from oauth_clients order by name`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clients []*OAuthClient
+
+	for rows.Next() {
+		client, err := scanClient(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		clients = append(clients, client)
+	}
+
+	return clients, rows.Err()
+}
+
+// GetClient returns one client by its client_id
+func (r *PostgresOAuthRepository) GetClient(ctx context.Context, clientID string) (*OAuthClient, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select id, client_id, secret_hash, name, redirect_uris, created_at from oauth_clients where client_id = $1`
+
+	return scanClient(r.db.QueryRowContext(ctx, query, clientID))
+}
+
+// InsertClient registers a client, and returns the ID of the newly inserted row.
+// Redirect URIs are stored space separated, since a URI cannot contain a space.
+func (r *PostgresOAuthRepository) InsertClient(ctx context.Context, client OAuthClient) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var secretHash sql.NullString
+	if client.SecretHash != "" {
+		secretHash = sql.NullString{String: client.SecretHash, Valid: true}
+	}
+
+	var newID int
+	stmt := `insert into oauth_clients (client_id, secret_hash, name, redirect_uris, created_at)
+		values ($1, $2, $3, $4, $5) returning id`
+
+	err := r.db.QueryRowContext(ctx, stmt,
+		client.ClientID,
+		secretHash,
+		client.Name,
+		strings.Join(client.RedirectURIs, " "),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteClient removes a client, along with any codes issued to it
+func (r *PostgresOAuthRepository) DeleteClient(ctx context.Context, clientID string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx, `delete from oauth_clients where client_id = $1`, clientID)
+	return err
+}
+
+// InsertCode stores an authorization code, and clears out any that have expired
+func (r *PostgresOAuthRepository) InsertCode(ctx context.Context, code AuthCode) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx, `delete from oauth_codes where expires_at < $1`, time.Now())
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into oauth_codes (code_hash, client_id, user_id, redirect_uri, scope, nonce, code_challenge, session_id, auth_time, expires_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+
+	_, err = r.db.ExecContext(ctx, stmt,
+		code.CodeHash,
+		code.ClientID,
+		code.UserID,
+		code.RedirectURI,
+		code.Scope,
+		code.Nonce,
+		code.CodeChallenge,
+		code.SessionID,
+		code.AuthTime,
+		code.ExpiresAt,
+	)
+
+	return err
+}
+
+// ConsumeCode deletes a code and returns it
+func (r *PostgresOAuthRepository) ConsumeCode(ctx context.Context, codeHash string) (*AuthCode, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `delete from oauth_codes where code_hash = $1
+		returning code_hash, client_id, user_id, redirect_uri, scope, nonce, code_challenge, session_id, auth_time, expires_at`
+
+	var code AuthCode
+	err := r.db.QueryRowContext(ctx, stmt, codeHash).Scan(
+		&code.CodeHash,
+		&code.ClientID,
+		&code.UserID,
+		&code.RedirectURI,
+		&code.Scope,
+		&code.Nonce,
+		&code.CodeChallenge,
+		&code.SessionID,
+		&code.AuthTime,
+		&code.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &code, nil
+}
@@ -0,0 +1,48 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrDuplicateOrganization is returned when an organization's name is already taken.
+var ErrDuplicateOrganization = errors.New("an organization with that name already exists")
+
+// DefaultOrganization is the name of the organization the migrations create. Users
+// who are not put in any other organization when they are created join it.
+const DefaultOrganization = "Default"
+
+// Organization is a tenant. Its users' tokens carry its id, and the records they
+// write in other services are kept apart from every other organization's.
+type Organization struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// OrganizationRepository stores organizations and who belongs to them. Lookups for
+// an organization that does not exist return sql.ErrNoRows.
+type OrganizationRepository interface {
+	All(ctx context.Context) ([]*Organization, error)
+	Get(ctx context.Context, id int) (*Organization, error)
+
+	// Default returns the organization named DefaultOrganization.
+	Default(ctx context.Context) (*Organization, error)
+
+	Insert(ctx context.Context, org Organization) (int, error)
+	Delete(ctx context.Context, id int) error
+
+	// Members returns the ids of an organization's users.
+	Members(ctx context.Context, id int) ([]int, error)
+
+	AddMember(ctx context.Context, id, userID int) error
+
+	// RemoveMember reports whether the user was a member.
+	RemoveMember(ctx context.Context, id, userID int) (bool, error)
+
+	// ForUser returns the organizations a user belongs to, the one they joined first
+	// first. That one is the tenant their tokens are issued for.
+	ForUser(ctx context.Context, userID int) ([]*Organization, error)
+}
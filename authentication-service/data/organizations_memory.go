@@ -0,0 +1,166 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryOrganizationRepository is an OrganizationRepository that keeps organizations
+// in memory. It is meant for tests.
+type MemoryOrganizationRepository struct {
+	mu      sync.Mutex
+	orgs    map[int]Organization
+	members map[int][]int  // user ids by organization, in the order they joined
+	joined  map[[2]int]int // when each membership began, as a sequence number
+	seq     int
+	nextID  int
+}
+
+// NewMemoryOrganizationRepository returns an empty MemoryOrganizationRepository.
+func NewMemoryOrganizationRepository() *MemoryOrganizationRepository {
+	return &MemoryOrganizationRepository{
+		orgs:    make(map[int]Organization),
+		members: make(map[int][]int),
+		joined:  make(map[[2]int]int),
+		nextID:  1,
+	}
+}
+
+func (r *MemoryOrganizationRepository) All(ctx context.Context) ([]*Organization, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var orgs []*Organization
+	for _, o := range r.orgs {
+		o := o
+		orgs = append(orgs, &o)
+	}
+
+	sort.Slice(orgs, func(i, j int) bool { return orgs[i].Name < orgs[j].Name })
+
+	return orgs, nil
+}
+
+func (r *MemoryOrganizationRepository) Get(ctx context.Context, id int) (*Organization, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	o, ok := r.orgs[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	return &o, nil
+}
+
+func (r *MemoryOrganizationRepository) Default(ctx context.Context) (*Organization, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, o := range r.orgs {
+		if o.Name == DefaultOrganization {
+			return &o, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (r *MemoryOrganizationRepository) Insert(ctx context.Context, org Organization) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, o := range r.orgs {
+		if o.Name == org.Name {
+			return 0, ErrDuplicateOrganization
+		}
+	}
+
+	org.ID = r.nextID
+	org.CreatedAt = time.Now()
+	org.UpdatedAt = org.CreatedAt
+	r.orgs[org.ID] = org
+	r.nextID++
+
+	return org.ID, nil
+}
+
+func (r *MemoryOrganizationRepository) Delete(ctx context.Context, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.orgs[id]; !ok {
+		return sql.ErrNoRows
+	}
+
+	delete(r.orgs, id)
+	delete(r.members, id)
+
+	return nil
+}
+
+func (r *MemoryOrganizationRepository) Members(ctx context.Context, id int) ([]int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	members := append([]int{}, r.members[id]...)
+	sort.Ints(members)
+
+	return members, nil
+}
+
+func (r *MemoryOrganizationRepository) AddMember(ctx context.Context, id, userID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, m := range r.members[id] {
+		if m == userID {
+			return nil
+		}
+	}
+
+	r.members[id] = append(r.members[id], userID)
+	r.seq++
+	r.joined[[2]int{id, userID}] = r.seq
+
+	return nil
+}
+
+func (r *MemoryOrganizationRepository) RemoveMember(ctx context.Context, id, userID int) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, m := range r.members[id] {
+		if m == userID {
+			r.members[id] = append(r.members[id][:i], r.members[id][i+1:]...)
+			delete(r.joined, [2]int{id, userID})
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (r *MemoryOrganizationRepository) ForUser(ctx context.Context, userID int) ([]*Organization, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var orgs []*Organization
+	for id, members := range r.members {
+		for _, m := range members {
+			if m == userID {
+				o := r.orgs[id]
+				orgs = append(orgs, &o)
+			}
+		}
+	}
+
+	sort.Slice(orgs, func(i, j int) bool {
+		return r.joined[[2]int{orgs[i].ID, userID}] < r.joined[[2]int{orgs[j].ID, userID}]
+	})
+
+	return orgs, nil
+}
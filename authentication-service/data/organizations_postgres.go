@@ -0,0 +1,190 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgconn"
+)
+
+// PostgresOrganizationRepository is the OrganizationRepository used by the service.
+type PostgresOrganizationRepository struct {
+	db *sql.DB
+}
+
+// NewPostgresOrganizationRepository returns a PostgresOrganizationRepository using db.
+func NewPostgresOrganizationRepository(db *sql.DB) *PostgresOrganizationRepository {
+	return &PostgresOrganizationRepository{db: db}
+}
+
+func (r *PostgresOrganizationRepository) scanAll(rows *sql.Rows) ([]*Organization, error) {
+	defer rows.Close()
+
+	var orgs []*Organization
+
+	for rows.Next() {
+		var o Organization
+		if err := rows.Scan(&o.ID, &o.Name, &o.CreatedAt, &o.UpdatedAt); err != nil {
+			return nil, err
+		}
+
+		orgs = append(orgs, &o)
+	}
+
+	return orgs, rows.Err()
+}
+
+// All returns every organization, by name
+func (r *PostgresOrganizationRepository) All(ctx context.Context) ([]*Organization, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `select id, name, created_at, updated_at from organizations order by name`)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.scanAll(rows)
+}
+
+// Get returns one organization
+func (r *PostgresOrganizationRepository) Get(ctx context.Context, id int) (*Organization, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var o Organization
+
+	query := `select id, name, created_at, updated_at from organizations where id = $1`
+	err := r.db.QueryRowContext(ctx, query, id).Scan(&o.ID, &o.Name, &o.CreatedAt, &o.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &o, nil
+}
+
+// Default returns the organization new users join when nothing else is chosen for them
+func (r *PostgresOrganizationRepository) Default(ctx context.Context) (*Organization, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var o Organization
+
+	query := `select id, name, created_at, updated_at from organizations where name = $1`
+	err := r.db.QueryRowContext(ctx, query, DefaultOrganization).Scan(&o.ID, &o.Name, &o.CreatedAt, &o.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &o, nil
+}
+
+// Insert stores a new organization and returns its id
+func (r *PostgresOrganizationRepository) Insert(ctx context.Context, org Organization) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	now := time.Now()
+
+	var id int
+	stmt := `insert into organizations (name, created_at, updated_at) values ($1, $2, $3) returning id`
+	err := r.db.QueryRowContext(ctx, stmt, org.Name, now, now).Scan(&id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "organizations_name_key" {
+			return 0, ErrDuplicateOrganization
+		}
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// Delete removes an organization, along with its memberships and API keys
+func (r *PostgresOrganizationRepository) Delete(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx, `delete from organizations where id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// Members returns the ids of an organization's users
+func (r *PostgresOrganizationRepository) Members(ctx context.Context, id int) ([]int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `select user_id from organization_members where organization_id = $1 order by user_id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []int{}
+
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+
+		members = append(members, userID)
+	}
+
+	return members, rows.Err()
+}
+
+// AddMember adds a user to an organization, if they are not in it already
+func (r *PostgresOrganizationRepository) AddMember(ctx context.Context, id, userID int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `insert into organization_members (organization_id, user_id, created_at) values ($1, $2, $3)
+		on conflict do nothing`
+
+	_, err := r.db.ExecContext(ctx, stmt, id, userID, time.Now())
+	return err
+}
+
+// RemoveMember takes a user out of an organization
+func (r *PostgresOrganizationRepository) RemoveMember(ctx context.Context, id, userID int) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `delete from organization_members where organization_id = $1 and user_id = $2`
+
+	result, err := r.db.ExecContext(ctx, stmt, id, userID)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// ForUser returns a user's organizations, in the order they joined them
+func (r *PostgresOrganizationRepository) ForUser(ctx context.Context, userID int) ([]*Organization, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select o.id, o.name, o.created_at, o.updated_at
+		from organizations o join organization_members m on m.organization_id = o.id
+		where m.user_id = $1 order by m.created_at, o.id`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.scanAll(rows)
+}
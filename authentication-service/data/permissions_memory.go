@@ -0,0 +1,37 @@
+package data
+
+import (
+	"context"
+	"sort"
+)
+
+// MemoryPermissionRepository is a PermissionRepository over a fixed list of names,
+// numbered from 1 in order and granted to no role. It is meant for tests.
+type MemoryPermissionRepository struct {
+	names []string
+}
+
+// NewMemoryPermissionRepository returns a MemoryPermissionRepository holding names.
+func NewMemoryPermissionRepository(names ...string) *MemoryPermissionRepository {
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+
+	return &MemoryPermissionRepository{names: sorted}
+}
+
+func (r *MemoryPermissionRepository) Names(ctx context.Context) ([]string, error) {
+	return append([]string(nil), r.names...), nil
+}
+
+func (r *MemoryPermissionRepository) GetAll(ctx context.Context) ([]*Permission, error) {
+	permissions := make([]*Permission, len(r.names))
+	for i, name := range r.names {
+		permissions[i] = &Permission{ID: i + 1, Name: name}
+	}
+
+	return permissions, nil
+}
+
+func (r *MemoryPermissionRepository) GetForRole(ctx context.Context, roleID int) ([]*Permission, error) {
+	return nil, nil
+}
@@ -0,0 +1,110 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+)
+
+// PostgresPermissionRepository is the PermissionRepository used by the service.
+type PostgresPermissionRepository struct {
+	db *sql.DB
+}
+
+// NewPostgresPermissionRepository returns a PostgresPermissionRepository using db.
+func NewPostgresPermissionRepository(db *sql.DB) *PostgresPermissionRepository {
+	return &PostgresPermissionRepository{db: db}
+}
+
+func (r *PostgresPermissionRepository) Names(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `select name from permissions order by name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+// GetAll returns a slice of all permissions, sorted by name
+func (r *PostgresPermissionRepository) GetAll(ctx context.Context) ([]*Permission, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select id, name, description, created_at from permissions order by name`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions []*Permission
+
+	for rows.Next() {
+		var permission Permission
+		err := rows.Scan(
+			&permission.ID,
+			&permission.Name,
+			&permission.Description,
+			&permission.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, &permission)
+	}
+
+	return permissions, rows.Err()
+}
+
+// GetForRole returns the permissions attached to one role, sorted by name
+func (r *PostgresPermissionRepository) GetForRole(ctx context.Context, roleID int) ([]*Permission, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select p.id, p.name, p.description, p.created_at
+		from permissions p
+		inner join role_permissions rp on rp.permission_id = p.id
+		where rp.role_id = $1
+		order by p.name`
+
+	rows, err := r.db.QueryContext(ctx, query, roleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions []*Permission
+
+	for rows.Next() {
+		var permission Permission
+		err := rows.Scan(
+			&permission.ID,
+			&permission.Name,
+			&permission.Description,
+			&permission.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, &permission)
+	}
+
+	return permissions, rows.Err()
+}
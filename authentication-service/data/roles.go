@@ -0,0 +1,52 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// Role is the structure which holds one role from the database. Roles are
+// assigned to users, and grant every permission attached to them.
+type Role struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Permission is the structure which holds one permission from the database.
+// Permission names take the form "resource:action", e.g. "logs:read".
+type Permission struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// RoleRepository stores roles, the permissions granted to them and the users they
+// are assigned to.
+type RoleRepository interface {
+	GetAll(ctx context.Context) ([]*Role, error)
+	GetOne(ctx context.Context, id int) (*Role, error)
+	Insert(ctx context.Context, role Role) (int, error)
+	DeleteByID(ctx context.Context, id int) error
+	GetForUser(ctx context.Context, userID int) ([]*Role, error)
+	AssignToUser(ctx context.Context, userID, roleID int) error
+	RemoveFromUser(ctx context.Context, userID, roleID int) error
+	GrantPermission(ctx context.Context, roleID, permissionID int) error
+	RevokePermission(ctx context.Context, roleID, permissionID int) error
+}
+
+// PermissionRepository lists the permissions that exist, for checking names that
+// come from outside, such as the scopes asked for on an API key.
+type PermissionRepository interface {
+	// Names returns the name of every permission, sorted.
+	Names(ctx context.Context) ([]string, error)
+
+	// GetAll returns every permission, sorted by name.
+	GetAll(ctx context.Context) ([]*Permission, error)
+
+	// GetForRole returns the permissions granted to one role, sorted by name.
+	GetForRole(ctx context.Context, roleID int) ([]*Permission, error)
+}
@@ -0,0 +1,212 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// PostgresRoleRepository is the RoleRepository used by the service.
+type PostgresRoleRepository struct {
+	db *sql.DB
+}
+
+// NewPostgresRoleRepository returns a PostgresRoleRepository using db.
+func NewPostgresRoleRepository(db *sql.DB) *PostgresRoleRepository {
+	return &PostgresRoleRepository{db: db}
+}
+
+// GetAll returns a slice of all roles, sorted by name
+func (r *PostgresRoleRepository) GetAll(ctx context.Context) ([]*Role, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select id, name, description, created_at, updated_at from roles order by name`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []*Role
+
+	for rows.Next() {
+		var role Role
+		err := rows.Scan(
+			&role.ID,
+			&role.Name,
+			&role.Description,
+			&role.CreatedAt,
+			&role.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, &role)
+	}
+
+	return roles, rows.Err()
+}
+
+// GetOne returns one role by id
+func (r *PostgresRoleRepository) GetOne(ctx context.Context, id int) (*Role, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select id, name, description, created_at, updated_at from roles where id = $1`
+
+	var role Role
+	err := r.db.QueryRowContext(ctx, query, id).Scan(
+		&role.ID,
+		&role.Name,
+		&role.Description,
+		&role.CreatedAt,
+		&role.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &role, nil
+}
+
+// Insert inserts a new role into the database, and returns the ID of the newly inserted row
+func (r *PostgresRoleRepository) Insert(ctx context.Context, role Role) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into roles (name, description, created_at, updated_at)
+		values ($1, $2, $3, $4) returning id`
+
+	err := r.db.QueryRowContext(ctx, stmt,
+		role.Name,
+		role.Description,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteByID deletes one role from the database, by ID. Grants and user
+// assignments for the role are removed by the foreign key cascade.
+func (r *PostgresRoleRepository) DeleteByID(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `delete from roles where id = $1`
+
+	_, err := r.db.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetForUser returns the roles assigned to one user, sorted by name
+func (r *PostgresRoleRepository) GetForUser(ctx context.Context, userID int) ([]*Role, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select r.id, r.name, r.description, r.created_at, r.updated_at
+		from roles r
+		inner join user_roles ur on ur.role_id = r.id
+		where ur.user_id = $1
+		order by r.name`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []*Role
+
+	for rows.Next() {
+		var role Role
+		err := rows.Scan(
+			&role.ID,
+			&role.Name,
+			&role.Description,
+			&role.CreatedAt,
+			&role.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, &role)
+	}
+
+	return roles, rows.Err()
+}
+
+// AssignToUser gives a role to a user. Assigning a role the user already has is a no-op.
+func (r *PostgresRoleRepository) AssignToUser(ctx context.Context, userID, roleID int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `insert into user_roles (user_id, role_id, created_at) values ($1, $2, $3)
+		on conflict (user_id, role_id) do nothing`
+
+	_, err := r.db.ExecContext(ctx, stmt, userID, roleID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RemoveFromUser takes a role away from a user
+func (r *PostgresRoleRepository) RemoveFromUser(ctx context.Context, userID, roleID int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `delete from user_roles where user_id = $1 and role_id = $2`
+
+	_, err := r.db.ExecContext(ctx, stmt, userID, roleID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GrantPermission attaches a permission to a role. Granting a permission the role
+// already has is a no-op.
+func (r *PostgresRoleRepository) GrantPermission(ctx context.Context, roleID, permissionID int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `insert into role_permissions (role_id, permission_id) values ($1, $2)
+		on conflict (role_id, permission_id) do nothing`
+
+	_, err := r.db.ExecContext(ctx, stmt, roleID, permissionID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RevokePermission detaches a permission from a role
+func (r *PostgresRoleRepository) RevokePermission(ctx context.Context, roleID, permissionID int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `delete from role_permissions where role_id = $1 and permission_id = $2`
+
+	_, err := r.db.ExecContext(ctx, stmt, roleID, permissionID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// SeedAdmin makes sure an administrator account exists with the given email, that it
+// holds the admin role, and that it belongs to an organization, joining the default
+// one if it has none. passwordHash is only stored if the account is created. It is
+// safe to run any number of times: an existing account keeps its password, and roles
+// and memberships are only added, never removed.
+func (r *PostgresUserRepository) SeedAdmin(ctx context.Context, email, passwordHash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var exists bool
+	err := r.db.QueryRowContext(ctx, `select exists (select 1 from users where email = $1)`, email).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	created := false
+
+	if !exists {
+		stmt := `insert into users (email, first_name, last_name, password, user_active, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7)
+			on conflict (email) do nothing`
+
+		result, err := r.db.ExecContext(ctx, stmt, email, "Admin", "User", passwordHash, 1, time.Now(), time.Now())
+		if err != nil {
+			return false, err
+		}
+
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return false, err
+		}
+
+		created = rows > 0
+	}
+
+	stmt := `insert into user_roles (user_id, role_id, created_at)
+		select u.id, r.id, $2 from users u, roles r
+		where u.email = $1 and r.name = 'admin'
+		on conflict (user_id, role_id) do nothing`
+
+	_, err = r.db.ExecContext(ctx, stmt, email, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	stmt = `insert into organization_members (organization_id, user_id, created_at)
+		select o.id, u.id, $2 from organizations o, users u
+		where u.email = $1 and o.name = $3
+		and not exists (select 1 from organization_members m where m.user_id = u.id)
+		on conflict (organization_id, user_id) do nothing`
+
+	_, err = r.db.ExecContext(ctx, stmt, email, time.Now(), DefaultOrganization)
+	if err != nil {
+		return false, err
+	}
+
+	return created, nil
+}
@@ -0,0 +1,57 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Session is one signed in device. Every access token carries the id of the session
+// it was issued for, so revoking the session cuts off its tokens everywhere.
+type Session struct {
+	ID          string       `json:"id"`
+	UserID      int          `json:"user_id"`
+	UserAgent   string       `json:"user_agent"`
+	IP          string       `json:"ip"`
+	RefreshHash string       `json:"-"`
+	CreatedAt   time.Time    `json:"created_at"`
+	LastSeenAt  time.Time    `json:"last_seen_at"`
+	ExpiresAt   time.Time    `json:"expires_at"`
+	RevokedAt   sql.NullTime `json:"-"`
+}
+
+// Active reports whether the session can still be used at now
+func (s *Session) Active(now time.Time) bool {
+	return !s.RevokedAt.Valid && now.Before(s.ExpiresAt)
+}
+
+// SessionRepository stores sessions. Lookups for a session that does not exist
+// return sql.ErrNoRows.
+type SessionRepository interface {
+	Insert(ctx context.Context, session Session) error
+	Get(ctx context.Context, id string) (*Session, error)
+	GetByRefreshHash(ctx context.Context, hash string) (*Session, error)
+
+	// ActiveForUser returns the user's sessions that are neither revoked nor expired,
+	// most recently seen first.
+	ActiveForUser(ctx context.Context, userID int, now time.Time) ([]*Session, error)
+
+	// Touch records that a session was used, swapping the refresh token hash oldHash
+	// for newHash and pushing back its expiry. It reports false, and changes nothing,
+	// if the session no longer has oldHash or has been revoked, as when two requests
+	// race to use the same refresh token.
+	Touch(ctx context.Context, id, oldHash, newHash string, seen, expires time.Time) (bool, error)
+
+	// Revoke revokes one session, reporting whether it was active.
+	Revoke(ctx context.Context, id string, at time.Time) (bool, error)
+
+	// RevokeAllForUser revokes every active session for a user and returns their ids.
+	RevokeAllForUser(ctx context.Context, userID int, at time.Time) ([]string, error)
+
+	// ForUser returns every session a user has had, revoked and expired ones too,
+	// newest first.
+	ForUser(ctx context.Context, userID int) ([]*Session, error)
+
+	// DeleteForUser deletes every session a user has had, and returns how many.
+	DeleteForUser(ctx context.Context, userID int) (int, error)
+}
@@ -0,0 +1,158 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemorySessionRepository is a SessionRepository that keeps sessions in memory. It
+// is meant for tests.
+type MemorySessionRepository struct {
+	mu       sync.Mutex
+	sessions map[string]Session
+}
+
+// NewMemorySessionRepository returns an empty MemorySessionRepository.
+func NewMemorySessionRepository() *MemorySessionRepository {
+	return &MemorySessionRepository{sessions: make(map[string]Session)}
+}
+
+func (r *MemorySessionRepository) Insert(ctx context.Context, s Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sessions[s.ID] = s
+
+	return nil
+}
+
+func (r *MemorySessionRepository) Get(ctx context.Context, id string) (*Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.sessions[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	return &s, nil
+}
+
+func (r *MemorySessionRepository) GetByRefreshHash(ctx context.Context, hash string) (*Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, s := range r.sessions {
+		if hash != "" && s.RefreshHash == hash {
+			return &s, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (r *MemorySessionRepository) ActiveForUser(ctx context.Context, userID int, now time.Time) ([]*Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var sessions []*Session
+	for _, s := range r.sessions {
+		s := s
+		if s.UserID == userID && s.Active(now) {
+			sessions = append(sessions, &s)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt) })
+
+	return sessions, nil
+}
+
+func (r *MemorySessionRepository) Touch(ctx context.Context, id, oldHash, newHash string, seen, expires time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.sessions[id]
+	if !ok || s.RefreshHash != oldHash || s.RevokedAt.Valid {
+		return false, nil
+	}
+
+	s.RefreshHash = newHash
+	s.LastSeenAt = seen
+	s.ExpiresAt = expires
+	r.sessions[id] = s
+
+	return true, nil
+}
+
+func (r *MemorySessionRepository) Revoke(ctx context.Context, id string, at time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.sessions[id]
+	if !ok || s.RevokedAt.Valid {
+		return false, nil
+	}
+
+	s.RevokedAt = sql.NullTime{Time: at, Valid: true}
+	s.RefreshHash = ""
+	r.sessions[id] = s
+
+	return true, nil
+}
+
+func (r *MemorySessionRepository) RevokeAllForUser(ctx context.Context, userID int, at time.Time) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var ids []string
+	for id, s := range r.sessions {
+		if s.UserID != userID || !s.Active(at) {
+			continue
+		}
+
+		s.RevokedAt = sql.NullTime{Time: at, Valid: true}
+		s.RefreshHash = ""
+		r.sessions[id] = s
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+func (r *MemorySessionRepository) ForUser(ctx context.Context, userID int) ([]*Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var sessions []*Session
+	for _, s := range r.sessions {
+		s := s
+		if s.UserID == userID {
+			sessions = append(sessions, &s)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].CreatedAt.After(sessions[j].CreatedAt) })
+
+	return sessions, nil
+}
+
+func (r *MemorySessionRepository) DeleteForUser(ctx context.Context, userID int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deleted := 0
+	for id, s := range r.sessions {
+		if s.UserID == userID {
+			delete(r.sessions, id)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
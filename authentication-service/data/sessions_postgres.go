@@ -0,0 +1,223 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// PostgresSessionRepository is the SessionRepository used by the service.
+type PostgresSessionRepository struct {
+	db *sql.DB
+}
+
+// NewPostgresSessionRepository returns a PostgresSessionRepository using db.
+func NewPostgresSessionRepository(db *sql.DB) *PostgresSessionRepository {
+	return &PostgresSessionRepository{db: db}
+}
+
+const sessionColumns = `id, user_id, user_agent, ip, coalesce(refresh_hash, ''), created_at, last_seen_at, expires_at, revoked_at`
+
+func scanSession(row interface{ Scan(...any) error }) (*Session, error) {
+	var s Session
+	err := row.Scan(
+		&s.ID,
+		&s.UserID,
+		&s.UserAgent,
+		&s.IP,
+		&s.RefreshHash,
+		&s.CreatedAt,
+		&s.LastSeenAt,
+		&s.ExpiresAt,
+		&s.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// Insert stores a new session
+func (r *PostgresSessionRepository) Insert(ctx context.Context, s Session) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var refreshHash sql.NullString
+	if s.RefreshHash != "" {
+		refreshHash = sql.NullString{String: s.RefreshHash, Valid: true}
+	}
+
+	stmt := `insert into sessions (id, user_id, user_agent, ip, refresh_hash, created_at, last_seen_at, expires_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	_, err := r.db.ExecContext(ctx, stmt,
+		s.ID,
+		s.UserID,
+		s.UserAgent,
+		s.IP,
+		refreshHash,
+		s.CreatedAt,
+		s.LastSeenAt,
+		s.ExpiresAt,
+	)
+
+	return err
+}
+
+// Get returns one session by id, whatever its state
+func (r *PostgresSessionRepository) Get(ctx context.Context, id string) (*Session, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select ` + sessionColumns + ` from sessions where id = $1`
+
+	return scanSession(r.db.QueryRowContext(ctx, query, id))
+}
+
+// GetByRefreshHash returns the session a refresh token belongs to
+func (r *PostgresSessionRepository) GetByRefreshHash(ctx context.Context, hash string) (*Session, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select ` + sessionColumns + ` from sessions where refresh_hash = $1`
+
+	return scanSession(r.db.QueryRowContext(ctx, query, hash))
+}
+
+// ActiveForUser returns a user's live sessions, most recently seen first
+func (r *PostgresSessionRepository) ActiveForUser(ctx context.Context, userID int, now time.Time) ([]*Session, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select ` + sessionColumns + ` from sessions
+		where user_id = $1 and revoked_at is null and expires_at > $2
+		order by last_seen_at desc`
+
+	rows, err := r.db.QueryContext(ctx, query, userID, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, s)
+	}
+
+	return sessions, rows.Err()
+}
+
+// Touch records that a session was used. Only one of any requests racing to use
+// the same refresh token finds it still in place.
+func (r *PostgresSessionRepository) Touch(ctx context.Context, id, oldHash, newHash string, seen, expires time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update sessions set refresh_hash = $1, last_seen_at = $2, expires_at = $3
+		where id = $4 and refresh_hash = $5 and revoked_at is null`
+
+	res, err := r.db.ExecContext(ctx, stmt, newHash, seen, expires, id, oldHash)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// Revoke revokes one session. The refresh token is dropped along with it.
+func (r *PostgresSessionRepository) Revoke(ctx context.Context, id string, at time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update sessions set revoked_at = $1, refresh_hash = null where id = $2 and revoked_at is null`
+
+	res, err := r.db.ExecContext(ctx, stmt, at, id)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RevokeAllForUser revokes every live session a user has
+func (r *PostgresSessionRepository) RevokeAllForUser(ctx context.Context, userID int, at time.Time) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update sessions set revoked_at = $1, refresh_hash = null
+		where user_id = $2 and revoked_at is null and expires_at > $1
+		returning id`
+
+	rows, err := r.db.QueryContext(ctx, stmt, at, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// ForUser returns all of a user's sessions, newest first
+func (r *PostgresSessionRepository) ForUser(ctx context.Context, userID int) ([]*Session, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select ` + sessionColumns + ` from sessions where user_id = $1 order by created_at desc`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, s)
+	}
+
+	return sessions, rows.Err()
+}
+
+// DeleteForUser deletes all of a user's sessions
+func (r *PostgresSessionRepository) DeleteForUser(ctx context.Context, userID int) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx, `delete from sessions where user_id = $1`, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,46 @@
+package data
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrDuplicateEmail is returned by Insert when the email address already belongs to a user.
+var ErrDuplicateEmail = errors.New("email address is already registered")
+
+// Access is what a user may do: the names of the roles they hold, and of every
+// permission those roles grant. This is what goes into the user's token.
+type Access struct {
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+}
+
+// UserRepository stores users. Lookups for a user that does not exist return
+// sql.ErrNoRows, whichever implementation is behind it.
+//
+// Every method has a Context variant. The plain methods are kept for callers
+// without a request to hang off, and run with a background context.
+type UserRepository interface {
+	GetAll() ([]*User, error)
+	GetByEmail(email string) (*User, error)
+	GetOne(id int) (*User, error)
+	GetAccess(id int) (*Access, error)
+	Update(user User) error
+	DeleteByID(id int) error
+	Insert(user User) (int, error)
+	ResetPassword(id int, password string) error
+
+	// SetPasswordHash replaces a user's stored hash with one that has already been
+	// computed, for when a hash is upgraded on login.
+	SetPasswordHash(id int, hash string) error
+
+	GetAllContext(ctx context.Context) ([]*User, error)
+	GetByEmailContext(ctx context.Context, email string) (*User, error)
+	GetOneContext(ctx context.Context, id int) (*User, error)
+	GetAccessContext(ctx context.Context, id int) (*Access, error)
+	UpdateContext(ctx context.Context, user User) error
+	DeleteByIDContext(ctx context.Context, id int) error
+	InsertContext(ctx context.Context, user User) (int, error)
+	ResetPasswordContext(ctx context.Context, id int, password string) error
+	SetPasswordHashContext(ctx context.Context, id int, hash string) error
+}
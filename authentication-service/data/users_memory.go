@@ -0,0 +1,242 @@
+package data
+
+import (
+	"authentication/password"
+	"context"
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MemoryUserRepository is a UserRepository that keeps users in memory. It is meant
+// for tests, and hashes passwords at bcrypt's minimum cost to keep them fast.
+type MemoryUserRepository struct {
+	mu     sync.Mutex
+	users  map[int]User
+	access map[int]Access
+	nextID int
+	hasher password.Hasher
+}
+
+// NewMemoryUserRepository returns an empty MemoryUserRepository.
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{
+		users:  make(map[int]User),
+		access: make(map[int]Access),
+		nextID: 1,
+		hasher: password.Policy{Algorithm: password.Bcrypt, BcryptCost: bcrypt.MinCost},
+	}
+}
+
+// SetAccess sets the roles and permissions GetAccess returns for a user.
+func (r *MemoryUserRepository) SetAccess(id int, roles, permissions []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.access[id] = Access{Roles: roles, Permissions: permissions}
+}
+
+// SetMFAEnabled sets whether a user is read back with a confirmed second factor,
+// which Postgres works out from the user_totp table.
+func (r *MemoryUserRepository) SetMFAEnabled(id int, enabled bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if user, ok := r.users[id]; ok {
+		user.MFAEnabled = enabled
+		r.users[id] = user
+	}
+}
+
+func (r *MemoryUserRepository) GetAll() ([]*User, error) {
+	return r.GetAllContext(context.Background())
+}
+
+func (r *MemoryUserRepository) GetAllContext(ctx context.Context) ([]*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var users []*User
+	for _, u := range r.users {
+		u := u
+		users = append(users, &u)
+	}
+
+	sort.Slice(users, func(i, j int) bool { return users[i].LastName < users[j].LastName })
+
+	return users, nil
+}
+
+func (r *MemoryUserRepository) GetByEmail(email string) (*User, error) {
+	return r.GetByEmailContext(context.Background(), email)
+}
+
+func (r *MemoryUserRepository) GetByEmailContext(ctx context.Context, email string) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, u := range r.users {
+		if u.Email == email {
+			return &u, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (r *MemoryUserRepository) GetOne(id int) (*User, error) {
+	return r.GetOneContext(context.Background(), id)
+}
+
+func (r *MemoryUserRepository) GetOneContext(ctx context.Context, id int) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	return &u, nil
+}
+
+func (r *MemoryUserRepository) GetAccess(id int) (*Access, error) {
+	return r.GetAccessContext(context.Background(), id)
+}
+
+func (r *MemoryUserRepository) GetAccessContext(ctx context.Context, id int) (*Access, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	access := r.access[id]
+	return &access, nil
+}
+
+func (r *MemoryUserRepository) Update(user User) error {
+	return r.UpdateContext(context.Background(), user)
+}
+
+func (r *MemoryUserRepository) UpdateContext(ctx context.Context, user User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[user.ID]
+	if !ok {
+		return nil
+	}
+
+	if r.emailTaken(user.Email, user.ID) {
+		return ErrDuplicateEmail
+	}
+
+	u.Email = user.Email
+	u.FirstName = user.FirstName
+	u.LastName = user.LastName
+	u.Active = user.Active
+	u.UpdatedAt = time.Now()
+	r.users[user.ID] = u
+
+	return nil
+}
+
+func (r *MemoryUserRepository) DeleteByID(id int) error {
+	return r.DeleteByIDContext(context.Background(), id)
+}
+
+func (r *MemoryUserRepository) DeleteByIDContext(ctx context.Context, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.users, id)
+	delete(r.access, id)
+
+	return nil
+}
+
+func (r *MemoryUserRepository) Insert(user User) (int, error) {
+	return r.InsertContext(context.Background(), user)
+}
+
+func (r *MemoryUserRepository) InsertContext(ctx context.Context, user User) (int, error) {
+	hashedPassword, err := r.hasher.Hash(user.Password)
+	if err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.emailTaken(user.Email, 0) {
+		return 0, ErrDuplicateEmail
+	}
+
+	now := time.Now()
+
+	user.ID = r.nextID
+	user.Password = hashedPassword
+	user.MFAEnabled = false
+	user.CreatedAt = now
+	user.UpdatedAt = now
+
+	r.users[user.ID] = user
+	r.nextID++
+
+	return user.ID, nil
+}
+
+func (r *MemoryUserRepository) ResetPassword(id int, password string) error {
+	return r.ResetPasswordContext(context.Background(), id, password)
+}
+
+func (r *MemoryUserRepository) ResetPasswordContext(ctx context.Context, id int, password string) error {
+	hashedPassword, err := r.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[id]
+	if !ok {
+		return nil
+	}
+
+	u.Password = hashedPassword
+	r.users[id] = u
+
+	return nil
+}
+
+func (r *MemoryUserRepository) SetPasswordHash(id int, hash string) error {
+	return r.SetPasswordHashContext(context.Background(), id, hash)
+}
+
+func (r *MemoryUserRepository) SetPasswordHashContext(ctx context.Context, id int, hash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[id]
+	if !ok {
+		return nil
+	}
+
+	u.Password = hash
+	r.users[id] = u
+
+	return nil
+}
+
+// emailTaken reports whether a user other than id already has email
+func (r *MemoryUserRepository) emailTaken(email string, id int) bool {
+	for _, u := range r.users {
+		if u.ID != id && u.Email == email {
+			return true
+		}
+	}
+
+	return false
+}
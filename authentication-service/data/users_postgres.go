@@ -0,0 +1,293 @@
+package data
+
+import (
+	"authentication/password"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgconn"
+)
+
+// userColumns is the select list shared by every user query. The join on user_totp
+// only tells us whether a confirmed second factor exists.
+const userColumns = `u.id, u.email, u.first_name, u.last_name, u.password, u.user_active,
+	u.created_at, u.updated_at, t.confirmed_at is not null
+	from users u
+	left join user_totp t on t.user_id = u.id`
+
+// PostgresUserRepository is the UserRepository used by the service. It keeps users
+// in the users table, and hashes new passwords with hasher.
+type PostgresUserRepository struct {
+	db     *sql.DB
+	hasher password.Hasher
+}
+
+// NewPostgresUserRepository returns a PostgresUserRepository using db.
+func NewPostgresUserRepository(db *sql.DB, hasher password.Hasher) *PostgresUserRepository {
+	return &PostgresUserRepository{db: db, hasher: hasher}
+}
+
+func scanUser(row interface{ Scan(...any) error }) (*User, error) {
+	var user User
+	err := row.Scan(
+		&user.ID,
+		&user.Email,
+		&user.FirstName,
+		&user.LastName,
+		&user.Password,
+		&user.Active,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.MFAEnabled,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// GetAll returns a slice of all users, sorted by last name
+func (r *PostgresUserRepository) GetAll() ([]*User, error) {
+	return r.GetAllContext(context.Background())
+}
+
+func (r *PostgresUserRepository) GetAllContext(ctx context.Context) ([]*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select ` + userColumns + ` order by u.last_name`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// GetByEmail returns one user by email
+func (r *PostgresUserRepository) GetByEmail(email string) (*User, error) {
+	return r.GetByEmailContext(context.Background(), email)
+}
+
+func (r *PostgresUserRepository) GetByEmailContext(ctx context.Context, email string) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select ` + userColumns + ` where u.email = $1`
+
+	return scanUser(r.db.QueryRowContext(ctx, query, email))
+}
+
+// GetOne returns one user by id
+func (r *PostgresUserRepository) GetOne(id int) (*User, error) {
+	return r.GetOneContext(context.Background(), id)
+}
+
+func (r *PostgresUserRepository) GetOneContext(ctx context.Context, id int) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select ` + userColumns + ` where u.id = $1`
+
+	return scanUser(r.db.QueryRowContext(ctx, query, id))
+}
+
+// GetAccess returns the roles a user holds and the permissions they grant
+func (r *PostgresUserRepository) GetAccess(id int) (*Access, error) {
+	return r.GetAccessContext(context.Background(), id)
+}
+
+func (r *PostgresUserRepository) GetAccessContext(ctx context.Context, id int) (*Access, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var access Access
+
+	roles := `select r.name from roles r
+		inner join user_roles ur on ur.role_id = r.id
+		where ur.user_id = $1
+		order by r.name`
+
+	permissions := `select distinct p.name
+		from permissions p
+		inner join role_permissions rp on rp.permission_id = p.id
+		inner join user_roles ur on ur.role_id = rp.role_id
+		where ur.user_id = $1
+		order by p.name`
+
+	for _, q := range []struct {
+		query string
+		dest  *[]string
+	}{
+		{roles, &access.Roles},
+		{permissions, &access.Permissions},
+	} {
+		names, err := r.names(ctx, q.query, id)
+		if err != nil {
+			return nil, err
+		}
+
+		*q.dest = names
+	}
+
+	return &access, nil
+}
+
+func (r *PostgresUserRepository) names(ctx context.Context, query string, args ...any) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+// Update updates one user in the database, using the information stored in user
+func (r *PostgresUserRepository) Update(user User) error {
+	return r.UpdateContext(context.Background(), user)
+}
+
+func (r *PostgresUserRepository) UpdateContext(ctx context.Context, user User) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update users set
+		email = $1,
+		first_name = $2,
+		last_name = $3,
+		user_active = $4,
+		updated_at = $5
+		where id = $6
+	`
+
+	_, err := r.db.ExecContext(ctx, stmt,
+		user.Email,
+		user.FirstName,
+		user.LastName,
+		user.Active,
+		time.Now(),
+		user.ID,
+	)
+
+	return duplicateEmail(err)
+}
+
+// DeleteByID deletes one user from the database, by ID
+func (r *PostgresUserRepository) DeleteByID(id int) error {
+	return r.DeleteByIDContext(context.Background(), id)
+}
+
+func (r *PostgresUserRepository) DeleteByIDContext(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `delete from users where id = $1`
+
+	_, err := r.db.ExecContext(ctx, stmt, id)
+	return err
+}
+
+// Insert inserts a new user into the database, and returns the ID of the newly inserted row
+func (r *PostgresUserRepository) Insert(user User) (int, error) {
+	return r.InsertContext(context.Background(), user)
+}
+
+func (r *PostgresUserRepository) InsertContext(ctx context.Context, user User) (int, error) {
+	hashedPassword, err := r.hasher.Hash(user.Password)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into users (email, first_name, last_name, password, user_active, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err = r.db.QueryRowContext(ctx, stmt,
+		user.Email,
+		user.FirstName,
+		user.LastName,
+		hashedPassword,
+		user.Active,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, duplicateEmail(err)
+	}
+
+	return newID, nil
+}
+
+// ResetPassword is the method we will use to change a user's password.
+func (r *PostgresUserRepository) ResetPassword(id int, password string) error {
+	return r.ResetPasswordContext(context.Background(), id, password)
+}
+
+func (r *PostgresUserRepository) ResetPasswordContext(ctx context.Context, id int, password string) error {
+	hashedPassword, err := r.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update users set password = $1 where id = $2`
+	_, err = r.db.ExecContext(ctx, stmt, hashedPassword, id)
+	return err
+}
+
+// SetPasswordHash stores a hash that has already been computed
+func (r *PostgresUserRepository) SetPasswordHash(id int, hash string) error {
+	return r.SetPasswordHashContext(context.Background(), id, hash)
+}
+
+func (r *PostgresUserRepository) SetPasswordHashContext(ctx context.Context, id int, hash string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update users set password = $1 where id = $2`
+	_, err := r.db.ExecContext(ctx, stmt, hash, id)
+	return err
+}
+
+// duplicateEmail turns a unique violation on users.email into ErrDuplicateEmail
+func duplicateEmail(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "users_email_key" {
+		return ErrDuplicateEmail
+	}
+
+	return err
+}
@@ -0,0 +1,73 @@
+// Package event publishes the authentication service's events to RabbitMQ, so that
+// other services can react to them without calling us.
+package event
+
+import (
+	"encoding/json"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Publisher sends an event to an exchange. The payload is sent as JSON.
+type Publisher interface {
+	Publish(exchange, routingKey string, payload any) error
+}
+
+// Emitter is a Publisher backed by a RabbitMQ connection. The topic exchanges it
+// publishes to are declared up front.
+type Emitter struct {
+	connection *amqp.Connection
+}
+
+// NewEmitter returns an Emitter on conn, declaring each of exchanges as a durable
+// topic exchange.
+func NewEmitter(conn *amqp.Connection, exchanges ...string) (*Emitter, error) {
+	channel, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	defer channel.Close()
+
+	for _, name := range exchanges {
+		err := channel.ExchangeDeclare(
+			name,    // name
+			"topic", // type
+			true,    // durable?
+			false,   // auto-deleted?
+			false,   // internal?
+			false,   // no-wait?
+			nil,     // arguments?
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Emitter{connection: conn}, nil
+}
+
+// Publish sends payload, encoded as JSON, to exchange with routingKey
+func (e *Emitter) Publish(exchange, routingKey string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	channel, err := e.connection.Channel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+
+	return channel.Publish(
+		exchange,
+		routingKey,
+		false,
+		false,
+		amqp.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			Body:         body,
+		},
+	)
+}
@@ -0,0 +1,40 @@
+package event
+
+import "time"
+
+// SecurityExchange is the topic exchange security events are published on, with
+// the event type as the routing key.
+const SecurityExchange = "security_topic"
+
+// NewDevice is published when a user signs in from an IP and user agent they have
+// never signed in from before.
+const NewDevice = "security.new_device"
+
+// SecurityEventVersion is the version of the SecurityEvent schema, versioned in the
+// same way as UserEvent.
+const SecurityEventVersion = 1
+
+// SecurityEvent is the body of every security event.
+type SecurityEvent struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	Version    int       `json:"version"`
+	OccurredAt time.Time `json:"occurred_at"`
+	User       UserData  `json:"user"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+}
+
+// NewSecurityEvent returns an event of the given type about user, seen from ip and
+// userAgent, with a fresh id.
+func NewSecurityEvent(eventType string, user UserData, ip, userAgent string) SecurityEvent {
+	return SecurityEvent{
+		ID:         newEventID(),
+		Type:       eventType,
+		Version:    SecurityEventVersion,
+		OccurredAt: time.Now().UTC(),
+		User:       user,
+		IP:         ip,
+		UserAgent:  userAgent,
+	}
+}
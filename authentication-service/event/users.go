@@ -0,0 +1,68 @@
+package event
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// UsersExchange is the topic exchange user lifecycle events are published on. The
+// routing key is the event type, so consumers can bind to "user.*" or to one type.
+const UsersExchange = "users_topic"
+
+// The user lifecycle event types
+const (
+	UserCreated         = "user.created"
+	UserUpdated         = "user.updated"
+	UserDeactivated     = "user.deactivated"
+	UserDeleted         = "user.deleted"
+	UserPasswordChanged = "user.password_changed"
+
+	// UserErased is published once a data subject's records have been deleted or
+	// pseudonymised. The user it carries is the pseudonymised one.
+	UserErased = "user.erased"
+)
+
+// UserEventVersion is the version of the UserEvent schema. Adding a field keeps the
+// version; removing or changing the meaning of one bumps it, and consumers skip
+// versions they don't know.
+const UserEventVersion = 1
+
+// UserEvent is the body of every user lifecycle event.
+type UserEvent struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	Version    int       `json:"version"`
+	OccurredAt time.Time `json:"occurred_at"`
+	User       UserData  `json:"user"`
+}
+
+// UserData is the user an event is about, as it was once the change was made. A
+// deleted user is described as they were just before.
+type UserData struct {
+	ID        int    `json:"id"`
+	Email     string `json:"email"`
+	FirstName string `json:"first_name,omitempty"`
+	LastName  string `json:"last_name,omitempty"`
+	Active    bool   `json:"active"`
+}
+
+// NewUserEvent returns an event of the given type about user, with a fresh id
+// consumers can use to spot redeliveries.
+func NewUserEvent(eventType string, user UserData) UserEvent {
+	return UserEvent{
+		ID:         newEventID(),
+		Type:       eventType,
+		Version:    UserEventVersion,
+		OccurredAt: time.Now().UTC(),
+		User:       user,
+	}
+}
+
+// newEventID returns a random id for an event
+func newEventID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
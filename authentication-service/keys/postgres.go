@@ -0,0 +1,110 @@
+package keys
+
+import (
+	"context"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// lockKey is the advisory lock id held while rotating, so that replicas which find
+// the key due at the same moment only rotate once between them.
+const lockKey = 7212032
+
+// PostgresStore is the default Store. It keeps keys in the signing_keys table, with
+// the private half sealed under the master key.
+type PostgresStore struct {
+	db     *sql.DB
+	sealer *Sealer
+}
+
+// NewPostgresStore returns a PostgresStore using db, sealing keys with sealer.
+func NewPostgresStore(db *sql.DB, sealer *Sealer) *PostgresStore {
+	return &PostgresStore{db: db, sealer: sealer}
+}
+
+func (s *PostgresStore) Published(ctx context.Context, now time.Time) ([]*Key, error) {
+	query := `select kid, private_key, created_at, expires_at from signing_keys
+		where expires_at is null or expires_at > $1
+		order by created_at desc`
+
+	rows, err := s.db.QueryContext(ctx, query, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*Key
+
+	for rows.Next() {
+		var k Key
+		var sealed []byte
+		var expiresAt sql.NullTime
+
+		if err := rows.Scan(&k.ID, &sealed, &k.CreatedAt, &expiresAt); err != nil {
+			return nil, err
+		}
+
+		der, err := s.sealer.Open(sealed, []byte(k.ID))
+		if err != nil {
+			return nil, err
+		}
+
+		k.Private, err = x509.ParsePKCS1PrivateKey(der)
+		if err != nil {
+			return nil, err
+		}
+
+		k.ExpiresAt = expiresAt.Time
+		keys = append(keys, &k)
+	}
+
+	return keys, rows.Err()
+}
+
+func (s *PostgresStore) Rotate(ctx context.Context, next *Key, cutoff time.Time, overlap time.Duration) (bool, error) {
+	sealed, err := s.sealer.Seal(x509.MarshalPKCS1PrivateKey(next.Private), []byte(next.ID))
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `select pg_advisory_xact_lock($1)`, lockKey)
+	if err != nil {
+		return false, err
+	}
+
+	var current time.Time
+	err = tx.QueryRowContext(ctx, `select created_at from signing_keys where expires_at is null
+		order by created_at desc limit 1`).Scan(&current)
+	if err == nil && current.After(cutoff) {
+		return false, nil
+	} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, `update signing_keys set expires_at = $1 where expires_at is null`,
+		next.CreatedAt.Add(overlap))
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from signing_keys where expires_at <= $1`, next.CreatedAt)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, `insert into signing_keys (kid, private_key, created_at) values ($1, $2, $3)`,
+		next.ID, sealed, next.CreatedAt)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
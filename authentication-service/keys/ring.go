@@ -0,0 +1,203 @@
+// Package keys manages the RSA keys the authentication service signs tokens with.
+// Keys live in a Store shared by every replica, with the private half encrypted under
+// a master key. A Ring holds the current signing key in memory, along with the keys
+// it has rotated away from: those stay published for an overlap window, so that
+// tokens signed just before a rotation still verify until they expire.
+package keys
+
+import (
+	"authz"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+const keyBits = 2048
+
+// Key is one signing key.
+type Key struct {
+	ID        string
+	Private   *rsa.PrivateKey
+	CreatedAt time.Time
+
+	// ExpiresAt is when a retired key stops being published. It is zero for the
+	// current signing key.
+	ExpiresAt time.Time
+}
+
+// Ring holds the keys currently published by the store.
+type Ring struct {
+	store Store
+
+	mu   sync.RWMutex
+	keys []*Key // newest first
+
+	// lifetime is how long a key signs for before it is rotated out
+	lifetime time.Duration
+
+	// overlap is how long a retired key stays published. It must be longer than the
+	// lifetime of any token signed with it, plus however long verifiers cache the key set.
+	overlap time.Duration
+
+	now func() time.Time
+}
+
+// NewRing returns a Ring over store, generating the first key if the store is empty
+// and rotating straight away if the current key is past its lifetime.
+func NewRing(ctx context.Context, store Store, lifetime, overlap time.Duration) (*Ring, error) {
+	r := &Ring{
+		store:    store,
+		lifetime: lifetime,
+		overlap:  overlap,
+		now:      time.Now,
+	}
+
+	if err := r.RotateIfDue(ctx); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Signing returns the key to sign new tokens with.
+func (r *Ring) Signing() *Key {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.keys[0]
+}
+
+// PublicKey returns the public half of a published key, by id.
+func (r *Ring) PublicKey(kid string) (*rsa.PublicKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, k := range r.keys {
+		if k.ID == kid {
+			return &k.Private.PublicKey, nil
+		}
+	}
+
+	return nil, authz.ErrUnknownKey
+}
+
+// Verify checks an access token signed with one of the published keys, so the
+// service can check its own tokens without fetching its own key set. Like every
+// other service, it turns away ID tokens and anything else that is not an access
+// token.
+func (r *Ring) Verify(token string) (*authz.Claims, error) {
+	return authz.VerifyRS256(token, r.PublicKey)
+}
+
+// JWKS returns every published key, newest first.
+func (r *Ring) JWKS() authz.JWKS {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	set := authz.JWKS{Keys: []authz.JWK{}}
+	for _, k := range r.keys {
+		set.Keys = append(set.Keys, authz.NewJWK(k.ID, &k.Private.PublicKey))
+	}
+
+	return set
+}
+
+// Refresh reloads the published keys from the store, picking up rotations made by
+// other replicas and dropping keys whose overlap has run out.
+func (r *Ring) Refresh(ctx context.Context) error {
+	keys, err := r.store.Published(ctx, r.now())
+	if err != nil {
+		return err
+	}
+
+	if len(keys) == 0 {
+		return errors.New("no signing keys published")
+	}
+
+	r.mu.Lock()
+	r.keys = keys
+	r.mu.Unlock()
+
+	return nil
+}
+
+// Rotate replaces the signing key now, whatever its age. It is the thing to do if a
+// key may have leaked; the old key still stays published for the overlap.
+func (r *Ring) Rotate(ctx context.Context) error {
+	return r.rotate(ctx, r.now())
+}
+
+// RotateIfDue rotates the ring if the current key has reached its lifetime.
+func (r *Ring) RotateIfDue(ctx context.Context) error {
+	keys, err := r.store.Published(ctx, r.now())
+	if err != nil {
+		return err
+	}
+
+	if len(keys) > 0 && keys[0].ExpiresAt.IsZero() && r.now().Sub(keys[0].CreatedAt) < r.lifetime {
+		r.mu.Lock()
+		r.keys = keys
+		r.mu.Unlock()
+
+		return nil
+	}
+
+	return r.rotate(ctx, r.now().Add(-r.lifetime))
+}
+
+// rotate stores a new key, unless the current one was created after cutoff, and
+// reloads the ring either way
+func (r *Ring) rotate(ctx context.Context, cutoff time.Time) error {
+	next, err := generate(r.now())
+	if err != nil {
+		return err
+	}
+
+	rotated, err := r.store.Rotate(ctx, next, cutoff, r.overlap)
+	if err != nil {
+		return err
+	}
+
+	if rotated {
+		log.Println("Rotated signing key; now signing with", next.ID)
+	}
+
+	return r.Refresh(ctx)
+}
+
+// Run rotates the ring when it is due, and picks up rotations made elsewhere,
+// checking every interval until ctx is done.
+func (r *Ring) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.RotateIfDue(ctx); err != nil {
+				log.Println("Error rotating signing keys:", err)
+			}
+		}
+	}
+}
+
+func generate(now time.Time) (*Key, error) {
+	private, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	return &Key{ID: hex.EncodeToString(id), Private: private, CreatedAt: now}, nil
+}
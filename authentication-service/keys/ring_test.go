@@ -0,0 +1,146 @@
+package keys
+
+import (
+	"authz"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestRing(t *testing.T, store Store, now *time.Time) *Ring {
+	t.Helper()
+
+	r := &Ring{store: store, lifetime: time.Hour, overlap: 30 * time.Minute, now: func() time.Time { return *now }}
+	if err := r.RotateIfDue(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+func TestSealer(t *testing.T) {
+	master := make([]byte, masterKeySize)
+	if _, err := rand.Read(master); err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseMasterKey(base64.StdEncoding.EncodeToString(master) + "\n")
+	if err != nil || !bytes.Equal(parsed, master) {
+		t.Fatalf("ParseMasterKey = %x, %v", parsed, err)
+	}
+
+	s, err := NewSealer(master)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := s.Seal([]byte("private key"), []byte("kid-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(sealed, []byte("private key")) {
+		t.Fatal("sealed key contains the plaintext")
+	}
+
+	opened, err := s.Open(sealed, []byte("kid-1"))
+	if err != nil || string(opened) != "private key" {
+		t.Fatalf("Open = %q, %v", opened, err)
+	}
+
+	// a sealed key moved to another row does not open
+	if _, err := s.Open(sealed, []byte("kid-2")); err == nil {
+		t.Fatal("opened a key under the wrong kid")
+	}
+
+	other, _ := NewSealer(make([]byte, masterKeySize))
+	if _, err := other.Open(sealed, []byte("kid-1")); err == nil {
+		t.Fatal("opened a key with the wrong master key")
+	}
+}
+
+func TestRingRotationOverlap(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	r := newTestRing(t, NewMemoryStore(), &now)
+
+	first := r.Signing()
+	token, err := authz.SignAccessToken(first.ID, first.Private, authz.Claims{Subject: "1", Issuer: authz.AccessTokenIssuer, ExpiresAt: now.Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// not due yet
+	now = now.Add(59 * time.Minute)
+	if err := r.RotateIfDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if r.Signing().ID != first.ID {
+		t.Fatal("rotated before the key reached its lifetime")
+	}
+
+	now = now.Add(time.Minute)
+	if err := r.RotateIfDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	second := r.Signing()
+	if second.ID == first.ID {
+		t.Fatal("expected a new signing key")
+	}
+
+	// inside the overlap the old key is still published and its tokens still verify
+	if len(r.JWKS().Keys) != 2 {
+		t.Fatalf("expected both keys to be published, got %d", len(r.JWKS().Keys))
+	}
+	if _, err := r.Verify(token); err != nil {
+		t.Fatalf("token signed before the rotation: %v", err)
+	}
+
+	now = now.Add(31 * time.Minute)
+	if err := r.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.JWKS().Keys) != 1 || r.JWKS().Keys[0].Kid != second.ID {
+		t.Fatalf("expected only the new key after the overlap, got %+v", r.JWKS().Keys)
+	}
+	if _, err := r.Verify(token); !errors.Is(err, authz.ErrUnknownKey) {
+		t.Fatalf("expected ErrUnknownKey for the retired key, got %v", err)
+	}
+}
+
+func TestRingReplicasRotateOnce(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := NewMemoryStore()
+
+	a := newTestRing(t, store, &now)
+	b := newTestRing(t, store, &now)
+
+	if a.Signing().ID != b.Signing().ID {
+		t.Fatal("replicas started with different keys")
+	}
+
+	now = now.Add(time.Hour)
+	if err := a.RotateIfDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// b finds the key due too, but a has already rotated, so it just picks that up
+	if err := b.rotate(ctx, now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Signing().ID != b.Signing().ID {
+		t.Fatal("replicas disagree on the signing key")
+	}
+
+	if n := len(b.JWKS().Keys); n != 2 {
+		t.Fatalf("expected two published keys after a single rotation, got %d", n)
+	}
+}
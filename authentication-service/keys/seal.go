@@ -0,0 +1,81 @@
+package keys
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// masterKeySize is the size of an AES-256 key.
+const masterKeySize = 32
+
+// Sealer encrypts private keys before they are stored, with AES-256-GCM under a
+// master key that never goes near the database.
+type Sealer struct {
+	aead cipher.AEAD
+}
+
+// NewSealer returns a Sealer using a 32 byte master key.
+func NewSealer(masterKey []byte) (*Sealer, error) {
+	if len(masterKey) != masterKeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", masterKeySize, len(masterKey))
+	}
+
+	block, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Sealer{aead: aead}, nil
+}
+
+// ParseMasterKey decodes a base64 encoded master key, as found in KEY_ENCRYPTION_KEY
+// or the file named by KEY_ENCRYPTION_KEY_FILE.
+func ParseMasterKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("master key is not valid base64: %w", err)
+	}
+
+	if len(key) != masterKeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", masterKeySize, len(key))
+	}
+
+	return key, nil
+}
+
+// Seal encrypts plaintext. additional is authenticated but not encrypted; we pass the
+// key id, so a sealed key cannot be swapped into another row.
+func (s *Sealer) Seal(plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return s.aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+// Open decrypts what Seal produced.
+func (s *Sealer) Open(sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < s.aead.NonceSize() {
+		return nil, errors.New("sealed key is too short")
+	}
+
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, additional)
+	if err != nil {
+		return nil, errors.New("cannot decrypt signing key; is the master key right?")
+	}
+
+	return plaintext, nil
+}
@@ -0,0 +1,70 @@
+package keys
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store keeps signing keys where every replica can see them.
+type Store interface {
+	// Published returns every key that has not expired at now, newest first. The
+	// newest key is the one to sign with.
+	Published(ctx context.Context, now time.Time) ([]*Key, error)
+
+	// Rotate makes next the signing key, unless the current signing key was created
+	// after cutoff, which means another replica has just rotated. Keys it replaces
+	// stay published until overlap after next was created. It reports whether next
+	// was stored.
+	Rotate(ctx context.Context, next *Key, cutoff time.Time, overlap time.Duration) (bool, error)
+}
+
+// MemoryStore is a Store for a single process. It is meant for tests.
+type MemoryStore struct {
+	mu   sync.Mutex
+	keys []*Key // newest first
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (s *MemoryStore) Published(ctx context.Context, now time.Time) ([]*Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []*Key
+	for _, k := range s.keys {
+		if k.ExpiresAt.IsZero() || k.ExpiresAt.After(now) {
+			k := *k
+			keys = append(keys, &k)
+		}
+	}
+
+	return keys, nil
+}
+
+func (s *MemoryStore) Rotate(ctx context.Context, next *Key, cutoff time.Time, overlap time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.keys) > 0 && s.keys[0].ExpiresAt.IsZero() && s.keys[0].CreatedAt.After(cutoff) {
+		return false, nil
+	}
+
+	keys := []*Key{next}
+	for _, k := range s.keys {
+		if k.ExpiresAt.IsZero() {
+			k.ExpiresAt = next.CreatedAt.Add(overlap)
+		}
+
+		if k.ExpiresAt.After(next.CreatedAt) {
+			keys = append(keys, k)
+		}
+	}
+
+	s.keys = keys
+
+	return true, nil
+}
@@ -0,0 +1,221 @@
+// Package lockout throttles password guessing. Failed logins are counted per account
+// and per client IP; after a few free attempts each further failure makes the caller
+// wait a little longer, and enough failures lock the key out altogether for a while.
+package lockout
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrBlocked is returned by Check while a key is waiting out a delay or a lockout.
+var ErrBlocked = errors.New("too many failed attempts, try again later")
+
+// Policy describes how hard to throttle one kind of key.
+type Policy struct {
+	// FreeAttempts is how many failures are allowed before delays kick in.
+	FreeAttempts int
+
+	// BaseDelay is the wait after the first failure past FreeAttempts. It doubles with
+	// every further failure, up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// Threshold is the number of failures that locks the key out for Duration.
+	Threshold int
+	Duration  time.Duration
+
+	// Window is how long a failure is remembered for.
+	Window time.Duration
+}
+
+// delay returns how long a key with the given number of failures must wait, and
+// whether that wait is a full lockout.
+func (p Policy) delay(failures int) (time.Duration, bool) {
+	if p.Threshold > 0 && failures >= p.Threshold {
+		return p.Duration, true
+	}
+
+	if failures <= p.FreeAttempts {
+		return 0, false
+	}
+
+	d := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+
+	return d, false
+}
+
+// Result describes what a failure did.
+type Result struct {
+	// Locked lists the keys that this failure pushed into a full lockout.
+	Locked []string
+
+	// RetryAfter is how long the caller must now wait before trying again.
+	RetryAfter time.Duration
+}
+
+// Limiter applies an account policy and an IP policy on top of a Store.
+type Limiter struct {
+	store   Store
+	account Policy
+	ip      Policy
+	now     func() time.Time
+}
+
+// New returns a Limiter.
+func New(store Store, account, ip Policy) *Limiter {
+	return &Limiter{
+		store:   store,
+		account: account,
+		ip:      ip,
+		now:     time.Now,
+	}
+}
+
+// AccountKey returns the store key for an email address.
+func AccountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// IPKey returns the store key for a client IP.
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// limitedKey is a store key and the policy it is throttled under
+type limitedKey struct {
+	key    string
+	policy Policy
+}
+
+// keys returns the store keys an attempt counts against. Attempts that name no
+// account, such as following a link whose owner is not known, count against the IP
+// alone.
+func (l *Limiter) keys(email, ip string) []limitedKey {
+	keys := []limitedKey{{IPKey(ip), l.ip}}
+	if strings.TrimSpace(email) != "" {
+		keys = append([]limitedKey{{AccountKey(email), l.account}}, keys...)
+	}
+
+	return keys
+}
+
+// Reserve counts an attempt against both the account and the IP before it is made,
+// returning ErrBlocked, along with how long to wait, if either is not allowed to try
+// right now. Counting first means parallel guesses each see the others, rather than
+// all passing before any of them has failed. A reserved attempt stays counted as a
+// failure unless Success gives it back. It is cheap, and must be called before doing
+// any password hashing.
+func (l *Limiter) Reserve(ctx context.Context, email, ip string) (time.Duration, error) {
+	now := l.now()
+	var reserved []limitedKey
+
+	for _, k := range l.keys(email, ip) {
+		delay := func(failures int) time.Duration {
+			d, _ := k.policy.delay(failures)
+			return d
+		}
+
+		rec, ok, err := l.store.Reserve(ctx, k.key, now, k.policy.Window, delay)
+		if err != nil {
+			return 0, err
+		}
+
+		if !ok {
+			// the attempt will not be made, so keys already charged for it get it back
+			if err := l.release(ctx, reserved); err != nil {
+				return 0, err
+			}
+
+			return rec.BlockedUntil.Sub(now), ErrBlocked
+		}
+
+		reserved = append(reserved, k)
+	}
+
+	return 0, nil
+}
+
+// Release gives back an attempt counted by Reserve that was never made, such as one
+// turned away because the server was too busy to check the password.
+func (l *Limiter) Release(ctx context.Context, email, ip string) error {
+	return l.release(ctx, l.keys(email, ip))
+}
+
+func (l *Limiter) release(ctx context.Context, keys []limitedKey) error {
+	for _, k := range keys {
+		if err := l.store.Release(ctx, k.key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Failure reports what a failed attempt, already counted by Reserve, did to the
+// account and the IP. Failures are counted whether or not the account exists, so
+// lockouts say nothing about which email addresses are registered.
+func (l *Limiter) Failure(ctx context.Context, email, ip string) (Result, error) {
+	now := l.now()
+	var res Result
+
+	for _, k := range l.keys(email, ip) {
+		rec, err := l.store.Get(ctx, k.key)
+		if err != nil {
+			return res, err
+		}
+
+		d := rec.BlockedUntil.Sub(now)
+		if d <= 0 {
+			continue
+		}
+
+		if _, locked := k.policy.delay(rec.Failures); locked {
+			res.Locked = append(res.Locked, k.key)
+		}
+
+		if d > res.RetryAfter {
+			res.RetryAfter = d
+		}
+	}
+
+	return res, nil
+}
+
+// Success clears the failure count for the account, and gives the IP back the attempt
+// Reserve counted. The rest of the IP count is left alone, so that logging in to one
+// account does not buy an attacker more guesses at others.
+func (l *Limiter) Success(ctx context.Context, email, ip string) error {
+	for _, k := range l.keys(email, ip) {
+		var err error
+		if k.key == IPKey(ip) {
+			err = l.store.Release(ctx, k.key)
+		} else {
+			err = l.store.Reset(ctx, k.key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UnlockAccount lifts any delay or lockout on an account.
+func (l *Limiter) UnlockAccount(ctx context.Context, email string) error {
+	return l.store.Reset(ctx, AccountKey(email))
+}
+
+// UnlockIP lifts any delay or lockout on a client IP.
+func (l *Limiter) UnlockIP(ctx context.Context, ip string) error {
+	return l.store.Reset(ctx, IPKey(ip))
+}
@@ -0,0 +1,208 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestLimiter(now *time.Time) *Limiter {
+	l := New(NewMemoryStore(),
+		Policy{FreeAttempts: 2, BaseDelay: time.Second, MaxDelay: 4 * time.Second, Threshold: 6, Duration: time.Hour, Window: time.Hour},
+		Policy{FreeAttempts: 100, Threshold: 1000, Duration: time.Hour, Window: time.Hour},
+	)
+	l.now = func() time.Time { return *now }
+
+	return l
+}
+
+func TestPolicyDelay(t *testing.T) {
+	p := Policy{FreeAttempts: 2, BaseDelay: time.Second, MaxDelay: 4 * time.Second, Threshold: 6, Duration: time.Hour}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+		locked   bool
+	}{
+		{1, 0, false},
+		{2, 0, false},
+		{3, time.Second, false},
+		{4, 2 * time.Second, false},
+		{5, 4 * time.Second, false},
+		{6, time.Hour, true},
+	}
+
+	for _, tt := range tests {
+		got, locked := p.delay(tt.failures)
+		if got != tt.want || locked != tt.locked {
+			t.Errorf("delay(%d) = %s, %v; want %s, %v", tt.failures, got, locked, tt.want, tt.locked)
+		}
+	}
+}
+
+func TestLimiterLocksOutAfterThreshold(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newTestLimiter(&now)
+
+	var res Result
+	var err error
+	for i := 0; i < 6; i++ {
+		// step past any delay so that every failure is counted
+		now = now.Add(5 * time.Second)
+
+		if _, err := l.Reserve(ctx, "Admin@Example.com", "10.0.0.1"); err != nil {
+			t.Fatalf("attempt %d: unexpected block: %v", i+1, err)
+		}
+
+		res, err = l.Failure(ctx, "admin@example.com", "10.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(res.Locked) != 1 || res.Locked[0] != AccountKey("admin@example.com") {
+		t.Fatalf("expected the account to be locked, got %v", res.Locked)
+	}
+
+	wait, err := l.Reserve(ctx, "admin@example.com", "10.0.0.2")
+	if !errors.Is(err, ErrBlocked) || wait != time.Hour {
+		t.Fatalf("Reserve = %s, %v; want 1h, ErrBlocked", wait, err)
+	}
+
+	// a different account from the same IP is unaffected
+	if _, err := l.Reserve(ctx, "other@example.com", "10.0.0.1"); err != nil {
+		t.Fatalf("other account blocked: %v", err)
+	}
+
+	if err := l.UnlockAccount(ctx, "admin@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := l.Reserve(ctx, "admin@example.com", "10.0.0.2"); err != nil {
+		t.Fatalf("still blocked after unlock: %v", err)
+	}
+}
+
+func TestLimiterForgetsOldFailures(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newTestLimiter(&now)
+
+	for i := 0; i < 2; i++ {
+		if _, err := l.Reserve(ctx, "user@example.com", "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now = now.Add(2 * time.Hour)
+
+	if _, err := l.Reserve(ctx, "user@example.com", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := l.Failure(ctx, "user@example.com", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.RetryAfter != 0 {
+		t.Fatalf("expected failures outside the window to be forgotten, got delay %s", res.RetryAfter)
+	}
+}
+
+func TestLimiterWithoutAccountCountsIP(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	store := NewMemoryStore()
+	l := New(WithPrefix(store, "magic:"),
+		Policy{FreeAttempts: 100, Threshold: 1000, Duration: time.Hour, Window: time.Hour},
+		Policy{FreeAttempts: 100, Threshold: 2, Duration: time.Hour, Window: time.Hour},
+	)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if _, err := l.Reserve(ctx, "", "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := l.Reserve(ctx, "", "10.0.0.1"); !errors.Is(err, ErrBlocked) {
+		t.Fatalf("expected the IP to be blocked, got %v", err)
+	}
+
+	if rec, _ := store.Get(ctx, AccountKey("")); rec.Failures != 0 {
+		t.Fatalf("expected no account to be charged, got %+v", rec)
+	}
+
+	// the prefix keeps this limiter's keys apart from a password limiter's
+	if rec, _ := store.Get(ctx, IPKey("10.0.0.1")); rec.Failures != 0 {
+		t.Fatalf("expected unprefixed keys to be untouched, got %+v", rec)
+	}
+
+	if rec, _ := store.Get(ctx, "magic:"+IPKey("10.0.0.1")); rec.Failures != 2 {
+		t.Fatalf("expected 2 failures under the prefix, got %+v", rec)
+	}
+}
+
+func TestLimiterReservesParallelAttempts(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newTestLimiter(&now)
+
+	// guesses sent all at once must not all get in before the first of them fails
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := l.Reserve(ctx, "admin@example.com", "10.0.0.1"); err == nil {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// two free attempts, then the third is let through and starts the first delay
+	if allowed != 3 {
+		t.Fatalf("expected 3 attempts to be let through, got %d", allowed)
+	}
+}
+
+func TestLimiterSuccess(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	store := NewMemoryStore()
+	l := New(store,
+		Policy{FreeAttempts: 2, BaseDelay: time.Second, MaxDelay: 4 * time.Second, Threshold: 6, Duration: time.Hour, Window: time.Hour},
+		Policy{FreeAttempts: 100, Threshold: 1000, Duration: time.Hour, Window: time.Hour},
+	)
+	l.now = func() time.Time { return now }
+
+	for _, email := range []string{"other@example.com", "admin@example.com", "admin@example.com"} {
+		if _, err := l.Reserve(ctx, email, "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := l.Success(ctx, "admin@example.com", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec, _ := store.Get(ctx, AccountKey("admin@example.com")); rec.Failures != 0 {
+		t.Fatalf("expected the account to be reset, got %+v", rec)
+	}
+
+	// only the successful attempt is given back to the IP
+	if rec, _ := store.Get(ctx, IPKey("10.0.0.1")); rec.Failures != 2 {
+		t.Fatalf("expected 2 failures left on the IP, got %+v", rec)
+	}
+}
@@ -0,0 +1,116 @@
+package lockout
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// PostgresStore is the default Store. It keeps records in the login_attempts table,
+// so limits hold across restarts and are shared by every replica.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore returns a PostgresStore using db.
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) Get(ctx context.Context, key string) (Record, error) {
+	query := `select key, failures, last_failure_at, blocked_until from login_attempts where key = $1`
+
+	var rec Record
+	var blockedUntil sql.NullTime
+
+	err := s.db.QueryRowContext(ctx, query, key).Scan(
+		&rec.Key,
+		&rec.Failures,
+		&rec.LastFailureAt,
+		&blockedUntil,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Record{}, nil
+	} else if err != nil {
+		return Record{}, err
+	}
+
+	rec.BlockedUntil = blockedUntil.Time
+
+	return rec, nil
+}
+
+func (s *PostgresStore) Reserve(ctx context.Context, key string, now time.Time, window time.Duration, delay func(failures int) time.Duration) (Record, bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Record{}, false, err
+	}
+	defer tx.Rollback()
+
+	// make sure there is a row to lock, so that the first attempts for a key queue
+	// up behind each other like the rest
+	stmt := `insert into login_attempts (key, failures, last_failure_at) values ($1, 0, $2)
+		on conflict (key) do nothing`
+
+	_, err = tx.ExecContext(ctx, stmt, key, now)
+	if err != nil {
+		return Record{}, false, err
+	}
+
+	query := `select key, failures, last_failure_at, blocked_until from login_attempts
+		where key = $1 for update`
+
+	var rec Record
+	var blockedUntil sql.NullTime
+
+	err = tx.QueryRowContext(ctx, query, key).Scan(
+		&rec.Key,
+		&rec.Failures,
+		&rec.LastFailureAt,
+		&blockedUntil,
+	)
+	if err != nil {
+		return Record{}, false, err
+	}
+
+	rec.BlockedUntil = blockedUntil.Time
+
+	if rec.BlockedUntil.After(now) {
+		return rec, false, tx.Commit()
+	}
+
+	if rec.LastFailureAt.Before(now.Add(-window)) {
+		rec.Failures = 0
+	}
+
+	rec.Failures++
+	rec.LastFailureAt = now
+	rec.BlockedUntil = time.Time{}
+	if d := delay(rec.Failures); d > 0 {
+		rec.BlockedUntil = now.Add(d)
+	}
+
+	stmt = `update login_attempts set failures = $1, last_failure_at = $2, blocked_until = $3 where key = $4`
+
+	_, err = tx.ExecContext(ctx, stmt, rec.Failures, now, sql.NullTime{Time: rec.BlockedUntil, Valid: !rec.BlockedUntil.IsZero()}, key)
+	if err != nil {
+		return Record{}, false, err
+	}
+
+	return rec, true, tx.Commit()
+}
+
+func (s *PostgresStore) Release(ctx context.Context, key string) error {
+	stmt := `update login_attempts set failures = greatest(failures - 1, 0) where key = $1`
+
+	_, err := s.db.ExecContext(ctx, stmt, key)
+	return err
+}
+
+func (s *PostgresStore) Reset(ctx context.Context, key string) error {
+	stmt := `delete from login_attempts where key = $1`
+
+	_, err := s.db.ExecContext(ctx, stmt, key)
+	return err
+}
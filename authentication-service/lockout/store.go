@@ -0,0 +1,135 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Record is what a Store keeps for one key: a run of failed attempts and, if the
+// key is being throttled, when it may try again.
+type Record struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	BlockedUntil  time.Time
+}
+
+// Store persists login attempts. Implementations must make Reserve atomic, since the
+// same key is hit from many requests at once during an attack.
+type Store interface {
+	// Get returns the record for key, or a zero Record if there is none.
+	Get(ctx context.Context, key string) (Record, error)
+
+	// Reserve counts one more attempt for key at now and returns the updated record,
+	// unless key is blocked at now, in which case it returns the record unchanged
+	// and false. Attempts older than window are forgotten, so the count starts again
+	// from one. The key is then blocked for delay(failures), if that is more than
+	// nothing, in the same step.
+	Reserve(ctx context.Context, key string, now time.Time, window time.Duration, delay func(failures int) time.Duration) (Record, bool, error)
+
+	// Release takes back one attempt counted by Reserve.
+	Release(ctx context.Context, key string) error
+
+	// Reset forgets everything about key.
+	Reset(ctx context.Context, key string) error
+}
+
+// PrefixStore keeps the keys of another Store under a prefix, so that Limiters with
+// different policies can share one table without counting each other's attempts.
+type PrefixStore struct {
+	store  Store
+	prefix string
+}
+
+// WithPrefix returns a PrefixStore putting the keys of store under prefix.
+func WithPrefix(store Store, prefix string) *PrefixStore {
+	return &PrefixStore{store: store, prefix: prefix}
+}
+
+func (s *PrefixStore) Get(ctx context.Context, key string) (Record, error) {
+	rec, err := s.store.Get(ctx, s.prefix+key)
+	rec.Key = key
+	return rec, err
+}
+
+func (s *PrefixStore) Reserve(ctx context.Context, key string, now time.Time, window time.Duration, delay func(failures int) time.Duration) (Record, bool, error) {
+	rec, ok, err := s.store.Reserve(ctx, s.prefix+key, now, window, delay)
+	rec.Key = key
+	return rec, ok, err
+}
+
+func (s *PrefixStore) Release(ctx context.Context, key string) error {
+	return s.store.Release(ctx, s.prefix+key)
+}
+
+func (s *PrefixStore) Reset(ctx context.Context, key string) error {
+	return s.store.Reset(ctx, s.prefix+key)
+}
+
+// MemoryStore is a Store that keeps everything in a map. It is meant for tests and
+// single instance development setups; state is lost on restart and not shared
+// between replicas.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]Record
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]Record)}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.records[key], nil
+}
+
+func (s *MemoryStore) Reserve(ctx context.Context, key string, now time.Time, window time.Duration, delay func(failures int) time.Duration) (Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec := s.records[key]
+	rec.Key = key
+
+	if rec.BlockedUntil.After(now) {
+		return rec, false, nil
+	}
+
+	if rec.LastFailureAt.Before(now.Add(-window)) {
+		rec.Failures = 0
+	}
+
+	rec.Failures++
+	rec.LastFailureAt = now
+	rec.BlockedUntil = time.Time{}
+	if d := delay(rec.Failures); d > 0 {
+		rec.BlockedUntil = now.Add(d)
+	}
+	s.records[key] = rec
+
+	return rec, true, nil
+}
+
+func (s *MemoryStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rec, ok := s.records[key]; ok && rec.Failures > 0 {
+		rec.Failures--
+		s.records[key] = rec
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+
+	return nil
+}
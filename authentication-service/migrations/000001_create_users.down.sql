@@ -0,0 +1,2 @@
+drop table if exists users;
+drop sequence if exists user_id_seq;
@@ -0,0 +1,17 @@
+create sequence if not exists user_id_seq
+    start with 1
+    increment by 1
+    no minvalue
+    no maxvalue
+    cache 1;
+
+create table if not exists users (
+    id integer default nextval('user_id_seq'::regclass) not null primary key,
+    email character varying(255),
+    first_name character varying(255),
+    last_name character varying(255),
+    password character varying(60),
+    user_active integer default 0,
+    created_at timestamp without time zone,
+    updated_at timestamp without time zone
+);
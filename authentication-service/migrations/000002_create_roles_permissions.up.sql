@@ -0,0 +1,49 @@
+create table roles (
+    id serial primary key,
+    name character varying(100) not null unique,
+    description character varying(255) not null default '',
+    created_at timestamp without time zone not null default now(),
+    updated_at timestamp without time zone not null default now()
+);
+
+create table permissions (
+    id serial primary key,
+    name character varying(100) not null unique,
+    description character varying(255) not null default '',
+    created_at timestamp without time zone not null default now()
+);
+
+create table role_permissions (
+    role_id integer not null references roles (id) on delete cascade,
+    permission_id integer not null references permissions (id) on delete cascade,
+    primary key (role_id, permission_id)
+);
+
+create table user_roles (
+    user_id integer not null references users (id) on delete cascade,
+    role_id integer not null references roles (id) on delete cascade,
+    created_at timestamp without time zone not null default now(),
+    primary key (user_id, role_id)
+);
+
+create index user_roles_role_id_idx on user_roles (role_id);
+
+insert into roles (name, description) values
+    ('admin', 'Full access to every service'),
+    ('user', 'Regular account');
+
+insert into permissions (name, description) values
+    ('*', 'Every permission'),
+    ('logs:read', 'Read log entries'),
+    ('logs:write', 'Write log entries'),
+    ('mail:send', 'Send mail through the mail service'),
+    ('roles:manage', 'Manage roles, permissions and assignments'),
+    ('users:manage', 'Manage user accounts');
+
+insert into role_permissions (role_id, permission_id)
+    select r.id, p.id from roles r, permissions p
+    where r.name = 'admin' and p.name = '*';
+
+insert into role_permissions (role_id, permission_id)
+    select r.id, p.id from roles r, permissions p
+    where r.name = 'user' and p.name in ('logs:write', 'mail:send');
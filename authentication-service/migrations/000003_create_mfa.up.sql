@@ -0,0 +1,26 @@
+create table user_totp (
+    user_id integer primary key references users (id) on delete cascade,
+    secret character varying(64) not null,
+    confirmed_at timestamp without time zone,
+    last_used_step bigint not null default 0,
+    created_at timestamp without time zone not null default now()
+);
+
+create table recovery_codes (
+    id serial primary key,
+    user_id integer not null references users (id) on delete cascade,
+    code_hash character varying(64) not null,
+    used_at timestamp without time zone,
+    created_at timestamp without time zone not null default now()
+);
+
+create index recovery_codes_user_id_idx on recovery_codes (user_id);
+
+create table mfa_challenges (
+    id serial primary key,
+    user_id integer not null references users (id) on delete cascade,
+    token_hash character varying(64) not null unique,
+    attempts integer not null default 0,
+    expires_at timestamp without time zone not null,
+    created_at timestamp without time zone not null default now()
+);
@@ -0,0 +1,6 @@
+create table login_attempts (
+    key character varying(320) primary key,
+    failures integer not null default 0,
+    last_failure_at timestamp without time zone not null,
+    blocked_until timestamp without time zone
+);
@@ -0,0 +1 @@
+create unique index if not exists users_email_key on users (email);
@@ -0,0 +1,4 @@
+delete from permissions where name = 'oauth:manage';
+
+drop table if exists oauth_codes;
+drop table if exists oauth_clients;
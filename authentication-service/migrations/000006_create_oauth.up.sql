@@ -0,0 +1,23 @@
+create table oauth_clients (
+    id serial primary key,
+    client_id character varying(64) not null unique,
+    secret_hash character varying(64),
+    name character varying(255) not null,
+    redirect_uris text not null,
+    created_at timestamp without time zone not null default now()
+);
+
+create table oauth_codes (
+    code_hash character varying(64) primary key,
+    client_id character varying(64) not null references oauth_clients (client_id) on delete cascade,
+    user_id integer not null references users (id) on delete cascade,
+    redirect_uri text not null,
+    scope character varying(255) not null,
+    nonce character varying(255) not null default '',
+    code_challenge character varying(128) not null,
+    auth_time timestamp without time zone not null,
+    expires_at timestamp without time zone not null
+);
+
+insert into permissions (name, description) values
+    ('oauth:manage', 'Register and remove OAuth clients');
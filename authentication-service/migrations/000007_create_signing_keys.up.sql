@@ -0,0 +1,8 @@
+create table signing_keys (
+    kid character varying(64) primary key,
+    private_key bytea not null,
+    created_at timestamp without time zone not null,
+    expires_at timestamp without time zone
+);
+
+create index signing_keys_expires_at_idx on signing_keys (expires_at);
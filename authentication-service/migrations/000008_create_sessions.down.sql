@@ -0,0 +1,3 @@
+alter table oauth_codes drop column if exists session_id;
+
+drop table if exists sessions;
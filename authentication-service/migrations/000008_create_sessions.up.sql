@@ -0,0 +1,15 @@
+create table sessions (
+    id character varying(64) primary key,
+    user_id integer not null references users (id) on delete cascade,
+    user_agent character varying(512) not null default '',
+    ip character varying(64) not null default '',
+    refresh_hash character varying(64) unique,
+    created_at timestamp without time zone not null,
+    last_seen_at timestamp without time zone not null,
+    expires_at timestamp without time zone not null,
+    revoked_at timestamp without time zone
+);
+
+create index sessions_user_id_idx on sessions (user_id);
+
+alter table oauth_codes add column session_id character varying(64) not null default '';
@@ -0,0 +1,3 @@
+-- fails while any argon2id hash is stored; switch PASSWORD_HASH to bcrypt and have
+-- those users log in, or reset their passwords, before rolling back
+alter table users alter column password type character varying(60);
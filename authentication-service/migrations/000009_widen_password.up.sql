@@ -0,0 +1,2 @@
+-- argon2id hashes in PHC format are longer than bcrypt's fixed 60 characters
+alter table users alter column password type character varying(255);
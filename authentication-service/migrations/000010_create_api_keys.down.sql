@@ -0,0 +1,3 @@
+delete from permissions where name = 'apikeys:manage';
+
+drop table if exists api_keys;
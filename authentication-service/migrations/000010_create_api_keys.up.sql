@@ -0,0 +1,15 @@
+create table api_keys (
+    id serial primary key,
+    prefix character varying(32) not null unique,
+    secret_hash character varying(64) not null,
+    name character varying(255) not null,
+    scopes text not null,
+    created_by integer references users (id) on delete set null,
+    created_at timestamp without time zone not null default now(),
+    expires_at timestamp without time zone,
+    last_used_at timestamp without time zone,
+    revoked_at timestamp without time zone
+);
+
+insert into permissions (name, description) values
+    ('apikeys:manage', 'Create, list and revoke API keys');
@@ -0,0 +1,2 @@
+insert into permissions (name, description) values
+    ('impersonate', 'Act as another user, for support');
@@ -0,0 +1,4 @@
+delete from permissions where name = 'scim:provision';
+
+drop table if exists group_members;
+drop table if exists groups;
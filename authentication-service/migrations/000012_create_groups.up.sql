@@ -0,0 +1,17 @@
+create table groups (
+    id serial primary key,
+    display_name character varying(255) not null unique,
+    created_at timestamp without time zone not null default now(),
+    updated_at timestamp without time zone not null default now()
+);
+
+create table group_members (
+    group_id integer not null references groups (id) on delete cascade,
+    user_id integer not null references users (id) on delete cascade,
+    primary key (group_id, user_id)
+);
+
+create index group_members_user_id_idx on group_members (user_id);
+
+insert into permissions (name, description) values
+    ('scim:provision', 'Provision users and groups through SCIM');
@@ -0,0 +1,6 @@
+delete from permissions where name = 'organizations:manage';
+
+alter table api_keys drop column if exists organization_id;
+
+drop table if exists organization_members;
+drop table if exists organizations;
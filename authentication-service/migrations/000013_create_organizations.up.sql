@@ -0,0 +1,28 @@
+create table organizations (
+    id serial primary key,
+    name character varying(255) not null unique,
+    created_at timestamp without time zone not null default now(),
+    updated_at timestamp without time zone not null default now()
+);
+
+create table organization_members (
+    organization_id integer not null references organizations (id) on delete cascade,
+    user_id integer not null references users (id) on delete cascade,
+    created_at timestamp without time zone not null default now(),
+    primary key (organization_id, user_id)
+);
+
+create index organization_members_user_id_idx on organization_members (user_id);
+
+-- everyone who signed up before organizations existed starts out in one together,
+-- so their tokens keep carrying a tenant
+insert into organizations (name) values ('Default');
+
+insert into organization_members (organization_id, user_id)
+    select o.id, u.id from organizations o, users u
+    where o.name = 'Default';
+
+alter table api_keys add column organization_id integer references organizations (id) on delete cascade;
+
+insert into permissions (name, description) values
+    ('organizations:manage', 'Create organizations and manage their members');
@@ -0,0 +1,9 @@
+create table magic_links (
+    token_hash character varying(64) primary key,
+    user_id integer not null references users (id) on delete cascade,
+    nonce_hash character varying(64) not null,
+    created_at timestamp without time zone not null,
+    expires_at timestamp without time zone not null
+);
+
+create index magic_links_expires_at_idx on magic_links (expires_at);
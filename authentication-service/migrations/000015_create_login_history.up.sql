@@ -0,0 +1,12 @@
+create table login_history (
+    id bigserial primary key,
+    user_id integer references users (id) on delete cascade,
+    email character varying(255) not null default '',
+    ip character varying(64) not null default '',
+    user_agent character varying(512) not null default '',
+    method character varying(32) not null,
+    outcome character varying(32) not null,
+    created_at timestamp without time zone not null
+);
+
+create index login_history_user_id_idx on login_history (user_id, created_at desc);
@@ -0,0 +1,8 @@
+create table invitations (
+    token_hash character varying(64) primary key,
+    user_id integer not null references users (id) on delete cascade,
+    created_at timestamp without time zone not null,
+    expires_at timestamp without time zone not null
+);
+
+create index invitations_expires_at_idx on invitations (expires_at);
@@ -0,0 +1,10 @@
+create table email_changes (
+    token_hash character varying(64) primary key,
+    user_id integer not null references users (id) on delete cascade,
+    new_email character varying(255) not null,
+    created_at timestamp without time zone not null,
+    expires_at timestamp without time zone not null
+);
+
+create index email_changes_user_id_idx on email_changes (user_id);
+create index email_changes_expires_at_idx on email_changes (expires_at);
@@ -0,0 +1 @@
+delete from permissions where name = 'privacy:manage';
@@ -0,0 +1,2 @@
+insert into permissions (name, description) values
+    ('privacy:manage', 'Export and erase a user''s personal data');
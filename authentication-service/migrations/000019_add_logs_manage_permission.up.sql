@@ -0,0 +1,2 @@
+insert into permissions (name, description) values
+    ('logs:manage', 'Edit log entries and delete them all');
@@ -0,0 +1,5 @@
+-- sealed secrets cannot be opened from SQL, so users who enrolled since, or whose
+-- secret has been sealed, have to enroll again after rolling back
+delete from user_totp where secret is null;
+alter table user_totp alter column secret set not null;
+alter table user_totp drop column sealed_secret;
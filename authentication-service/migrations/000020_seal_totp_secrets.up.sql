@@ -0,0 +1,4 @@
+-- secrets are sealed under the master key from now on; plaintext ones left from
+-- before are sealed the next time they are read
+alter table user_totp add column sealed_secret bytea;
+alter table user_totp alter column secret drop not null;
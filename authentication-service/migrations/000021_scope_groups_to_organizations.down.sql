@@ -0,0 +1,5 @@
+-- rolling back fails if two organizations have given groups the same name
+alter table groups drop constraint if exists groups_organization_id_display_name_key;
+alter table groups add constraint groups_display_name_key unique (display_name);
+
+alter table groups drop column if exists organization_id;
@@ -0,0 +1,10 @@
+-- groups belong to the organization that provisioned them, and their names only have
+-- to be unique within it. Groups from before this belong to the default organization.
+alter table groups add column organization_id integer references organizations (id) on delete cascade;
+
+update groups set organization_id = (select id from organizations where name = 'Default');
+
+alter table groups alter column organization_id set not null;
+
+alter table groups drop constraint groups_display_name_key;
+alter table groups add constraint groups_organization_id_display_name_key unique (organization_id, display_name);
@@ -0,0 +1,268 @@
+// Package migrations holds the authentication service's schema as numbered SQL files,
+// embedded in the binary, and applies them to Postgres. Files are named
+// NNNNNN_description.up.sql and NNNNNN_description.down.sql. Applied versions are
+// recorded in schema_migrations, and a Postgres advisory lock makes sure only one
+// replica migrates at a time.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// lockKey is the advisory lock id held while migrating. Any constant will do, as
+// long as nothing else in the database uses it.
+const lockKey = 7212026
+
+var filename = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration is one version of the schema.
+type Migration struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+
+	up   string
+	down string
+}
+
+// Load reads every embedded migration, sorted by version.
+func Load() ([]*Migration, error) {
+	entries, err := fs.ReadDir(files, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+
+	for _, entry := range entries {
+		match := filename.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := files.ReadFile(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.up = string(body)
+		} else {
+			m.down = string(body)
+		}
+	}
+
+	var all []*Migration
+	for _, m := range byVersion {
+		if m.up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+
+		all = append(all, m)
+	}
+
+	sort.Slice(all, func(i, j int) bool { return all[i].Version < all[j].Version })
+
+	return all, nil
+}
+
+// Up applies every migration that has not been applied yet, in order, and returns
+// the ones it applied. Each migration runs in its own transaction.
+func Up(ctx context.Context, db *sql.DB) ([]*Migration, error) {
+	var applied []*Migration
+
+	err := withLock(ctx, db, func(conn *sql.Conn) error {
+		all, done, err := state(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range all {
+			if _, ok := done[m.Version]; ok {
+				continue
+			}
+
+			err := apply(ctx, conn, m.up, `insert into schema_migrations (version, name, applied_at) values ($1, $2, $3)`,
+				m.Version, m.Name, time.Now())
+			if err != nil {
+				return fmt.Errorf("applying migration %d_%s: %w", m.Version, m.Name, err)
+			}
+
+			applied = append(applied, m)
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down rolls back the most recently applied steps migrations, newest first, and
+// returns the ones it rolled back.
+func Down(ctx context.Context, db *sql.DB, steps int) ([]*Migration, error) {
+	var reverted []*Migration
+
+	err := withLock(ctx, db, func(conn *sql.Conn) error {
+		all, done, err := state(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(all) - 1; i >= 0 && len(reverted) < steps; i-- {
+			m := all[i]
+			if _, ok := done[m.Version]; !ok {
+				continue
+			}
+
+			if m.down == "" {
+				return fmt.Errorf("migration %d_%s has no down file", m.Version, m.Name)
+			}
+
+			err := apply(ctx, conn, m.down, `delete from schema_migrations where version = $1`, m.Version)
+			if err != nil {
+				return fmt.Errorf("reverting migration %d_%s: %w", m.Version, m.Name, err)
+			}
+
+			reverted = append(reverted, m)
+		}
+
+		return nil
+	})
+
+	return reverted, err
+}
+
+// Status returns every known migration, with AppliedAt set on the ones that have run.
+func Status(ctx context.Context, db *sql.DB) ([]*Migration, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := ensureTable(ctx, conn); err != nil {
+		return nil, err
+	}
+
+	all, done, err := state(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range all {
+		if at, ok := done[m.Version]; ok {
+			at := at
+			m.AppliedAt = &at
+		}
+	}
+
+	return all, nil
+}
+
+// withLock runs fn on a single connection while holding the migration advisory lock.
+// Advisory locks belong to a session, so everything has to happen on that one
+// connection rather than on whatever the pool hands out.
+func withLock(ctx context.Context, db *sql.DB, fn func(conn *sql.Conn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `select pg_advisory_lock($1)`, lockKey)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		// use a fresh context so the lock is released even if ctx has been cancelled
+		_, _ = conn.ExecContext(context.Background(), `select pg_advisory_unlock($1)`, lockKey)
+	}()
+
+	if err := ensureTable(ctx, conn); err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+func ensureTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `create table if not exists schema_migrations (
+		version bigint primary key,
+		name character varying(255) not null,
+		applied_at timestamp without time zone not null
+	)`)
+
+	return err
+}
+
+// state returns every embedded migration, and the versions already applied with
+// when they were applied
+func state(ctx context.Context, conn *sql.Conn) ([]*Migration, map[int64]time.Time, error) {
+	all, err := Load()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := conn.QueryContext(ctx, `select version, applied_at from schema_migrations`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	done := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, nil, err
+		}
+
+		done[version] = at
+	}
+
+	return all, done, rows.Err()
+}
+
+// apply runs body and the bookkeeping statement in one transaction
+func apply(ctx context.Context, conn *sql.Conn, body, bookkeeping string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, body); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, bookkeeping, args...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,38 @@
+package password
+
+import "errors"
+
+// ErrBreached is returned when a new password is one that has turned up in a
+// known data breach.
+var ErrBreached = errors.New("this password has appeared in a data breach; please choose another")
+
+// BreachChecker reports whether a password is known to have been breached.
+type BreachChecker interface {
+	Breached(password string) (bool, error)
+}
+
+// RejectBreached returns a Hasher that refuses to hash passwords checker knows to
+// be breached, with ErrBreached. Existing hashes still verify, so users with a
+// breached password can sign in and change it.
+func RejectBreached(hasher Hasher, checker BreachChecker) Hasher {
+	return breachRejecter{hasher, checker}
+}
+
+type breachRejecter struct {
+	Hasher
+	checker BreachChecker
+}
+
+// Hash hashes password, unless it has been breached
+func (b breachRejecter) Hash(password string) (string, error) {
+	breached, err := b.checker.Breached(password)
+	if err != nil {
+		return "", err
+	}
+
+	if breached {
+		return "", ErrBreached
+	}
+
+	return b.Hasher.Hash(password)
+}
@@ -0,0 +1,35 @@
+package password
+
+import (
+	"errors"
+	"testing"
+)
+
+// breachList is a BreachChecker for a fixed set of passwords
+type breachList map[string]bool
+
+func (l breachList) Breached(password string) (bool, error) {
+	return l[password], nil
+}
+
+func TestRejectBreached(t *testing.T) {
+	encoded, err := testBcrypt.Hash("password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := RejectBreached(testBcrypt, breachList{"password123": true})
+
+	if _, err := h.Hash("password123"); !errors.Is(err, ErrBreached) {
+		t.Fatalf("expected %v, got %v", ErrBreached, err)
+	}
+
+	if _, err := h.Hash("correct horse"); err != nil {
+		t.Fatalf("expected an unbreached password to hash, got %v", err)
+	}
+
+	// a breached password that is already stored still verifies
+	if ok, err := h.Verify("password123", encoded); err != nil || !ok {
+		t.Fatalf("expected the stored hash to verify, got %v %v", ok, err)
+	}
+}
@@ -0,0 +1,114 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// fast parameters, so the tests don't spend their time hashing
+var (
+	testArgon2 = Policy{
+		Algorithm: Argon2id,
+		Argon2:    Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+	}
+	testBcrypt = Policy{Algorithm: Bcrypt, BcryptCost: 4}
+)
+
+func TestHashAndVerify(t *testing.T) {
+	for _, p := range []Policy{testArgon2, testBcrypt} {
+		t.Run(p.Algorithm, func(t *testing.T) {
+			encoded, err := p.Hash("correct horse")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ok, err := p.Verify("correct horse", encoded)
+			if err != nil || !ok {
+				t.Fatalf("expected the password to match, got %v %v", ok, err)
+			}
+
+			ok, err = p.Verify("battery staple", encoded)
+			if err != nil || ok {
+				t.Fatalf("expected a wrong password not to match, got %v %v", ok, err)
+			}
+
+			again, _ := p.Hash("correct horse")
+			if again == encoded {
+				t.Fatal("expected a fresh salt for every hash")
+			}
+		})
+	}
+}
+
+func TestArgon2idEncoding(t *testing.T) {
+	encoded, err := testArgon2.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("unexpected encoding %q", encoded)
+	}
+
+	// a hash made by another policy verifies under this one
+	if ok, err := testBcrypt.Verify("secret", encoded); err != nil || !ok {
+		t.Fatalf("expected the argon2id hash to verify under a bcrypt policy, got %v %v", ok, err)
+	}
+}
+
+func TestVerifyUnknownAlgorithm(t *testing.T) {
+	_, err := testArgon2.Verify("secret", "5ebe2294ecd0e0f08eab7690d2a6ee69")
+	if !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Fatalf("expected ErrUnknownAlgorithm, got %v", err)
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	weakBcrypt, _ := testBcrypt.Hash("secret")
+	argon, _ := testArgon2.Hash("secret")
+
+	stronger := testArgon2
+	stronger.Argon2.Iterations = 2
+
+	tests := []struct {
+		name    string
+		policy  Policy
+		encoded string
+		want    bool
+	}{
+		{"same argon2id parameters", testArgon2, argon, false},
+		{"argon2id below policy", stronger, argon, true},
+		{"bcrypt under an argon2id policy", testArgon2, weakBcrypt, true},
+		{"same bcrypt cost", testBcrypt, weakBcrypt, false},
+		{"bcrypt cost below policy", Policy{Algorithm: Bcrypt, BcryptCost: 5}, weakBcrypt, true},
+		{"argon2id under a bcrypt policy", testBcrypt, argon, true},
+		{"garbage", testArgon2, "not a hash", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.NeedsRehash(tt.encoded); got != tt.want {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	if err := DefaultPolicy.Validate(); err != nil {
+		t.Fatalf("default policy: %v", err)
+	}
+
+	bad := []Policy{
+		{Algorithm: "md5"},
+		{Algorithm: Bcrypt, BcryptCost: 2},
+		{Algorithm: Argon2id, Argon2: Argon2Params{Memory: 64, Iterations: 0, Parallelism: 1, SaltLength: 16, KeyLength: 32}},
+	}
+
+	for _, p := range bad {
+		if err := p.Validate(); err == nil {
+			t.Fatalf("expected %+v to be rejected", p)
+		}
+	}
+}
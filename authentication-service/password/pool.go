@@ -0,0 +1,165 @@
+package password
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBusy is returned by a Pool whose queue is full. The caller should ask the
+// client to come back shortly rather than wait.
+var ErrBusy = errors.New("too many password checks in progress, try again shortly")
+
+// Pool is a Hasher that runs hashing and verification on a fixed number of workers.
+// Slow hashes are the point of a password hash, so a burst of logins run inline
+// would take every CPU; the pool caps how many run at once, and turns callers away
+// once the queue is full.
+type Pool struct {
+	hasher Hasher
+	jobs   chan func()
+	slots  chan struct{}
+	quit   chan struct{}
+	once   sync.Once
+
+	workers   int
+	queued    atomic.Int64
+	running   atomic.Int64
+	completed atomic.Int64
+	rejected  atomic.Int64
+	waited    atomic.Int64
+	worked    atomic.Int64
+}
+
+// PoolStats is a snapshot of a Pool's counters. Times are totals, in nanoseconds,
+// across every completed job.
+type PoolStats struct {
+	Workers   int   `json:"workers"`
+	Queued    int64 `json:"queued"`
+	Running   int64 `json:"running"`
+	Completed int64 `json:"completed"`
+	Rejected  int64 `json:"rejected"`
+	WaitNanos int64 `json:"wait_ns"`
+	WorkNanos int64 `json:"work_ns"`
+}
+
+// NewPool starts workers goroutines hashing with hasher, and allows up to queue
+// callers to wait for one.
+func NewPool(hasher Hasher, workers, queue int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+
+	if queue < 0 {
+		queue = 0
+	}
+
+	p := &Pool{
+		hasher:  hasher,
+		jobs:    make(chan func()),
+		slots:   make(chan struct{}, workers+queue),
+		quit:    make(chan struct{}),
+		workers: workers,
+	}
+
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+
+	return p
+}
+
+func (p *Pool) work() {
+	for {
+		select {
+		case job := <-p.jobs:
+			job()
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// Close stops the workers. Callers still waiting for one get ErrBusy.
+func (p *Pool) Close() {
+	p.once.Do(func() { close(p.quit) })
+}
+
+// run hands fn to a worker and waits for it to finish
+func (p *Pool) run(fn func()) error {
+	done := make(chan struct{})
+	enqueued := time.Now()
+
+	job := func() {
+		p.queued.Add(-1)
+		p.running.Add(1)
+
+		start := time.Now()
+		p.waited.Add(int64(start.Sub(enqueued)))
+
+		fn()
+
+		p.worked.Add(int64(time.Since(start)))
+		p.running.Add(-1)
+		p.completed.Add(1)
+
+		close(done)
+	}
+
+	// there is a slot for every worker and every place in the queue
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		p.rejected.Add(1)
+		return ErrBusy
+	}
+	defer func() { <-p.slots }()
+
+	p.queued.Add(1)
+
+	select {
+	case p.jobs <- job:
+	case <-p.quit:
+		p.queued.Add(-1)
+		return ErrBusy
+	}
+
+	<-done
+	return nil
+}
+
+// Hash hashes password on one of the pool's workers
+func (p *Pool) Hash(password string) (hash string, err error) {
+	if busy := p.run(func() { hash, err = p.hasher.Hash(password) }); busy != nil {
+		return "", busy
+	}
+
+	return hash, err
+}
+
+// Verify checks password against encoded on one of the pool's workers
+func (p *Pool) Verify(password, encoded string) (ok bool, err error) {
+	if busy := p.run(func() { ok, err = p.hasher.Verify(password, encoded) }); busy != nil {
+		return false, busy
+	}
+
+	return ok, err
+}
+
+// NeedsRehash only looks at the encoding, so it runs on the caller's goroutine
+func (p *Pool) NeedsRehash(encoded string) bool {
+	return p.hasher.NeedsRehash(encoded)
+}
+
+// Stats returns the pool's current counters
+func (p *Pool) Stats() PoolStats {
+	return PoolStats{
+		Workers:   p.workers,
+		Queued:    p.queued.Load(),
+		Running:   p.running.Load(),
+		Completed: p.completed.Load(),
+		Rejected:  p.rejected.Load(),
+		WaitNanos: p.waited.Load(),
+		WorkNanos: p.worked.Load(),
+	}
+}
@@ -0,0 +1,96 @@
+package password
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowHasher blocks every call until it is released
+type slowHasher struct {
+	Policy
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *slowHasher) Verify(password, encoded string) (bool, error) {
+	h.started <- struct{}{}
+	<-h.release
+	return h.Policy.Verify(password, encoded)
+}
+
+func TestPoolRejectsWhenFull(t *testing.T) {
+	encoded, err := testBcrypt.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &slowHasher{Policy: testBcrypt, started: make(chan struct{}, 10), release: make(chan struct{})}
+	pool := NewPool(h, 2, 1)
+	defer pool.Close()
+
+	var wg sync.WaitGroup
+	results := make(chan error, 3)
+
+	// two run and one waits in the queue
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := pool.Verify("secret", encoded)
+			if err == nil && !ok {
+				err = errors.New("password did not match")
+			}
+			results <- err
+		}()
+	}
+
+	<-h.started
+	<-h.started
+
+	deadline := time.Now().Add(time.Second)
+	for pool.Stats().Queued != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected one queued job, got %+v", pool.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := pool.Verify("secret", encoded); !errors.Is(err, ErrBusy) {
+		t.Fatalf("expected ErrBusy with every worker and the queue full, got %v", err)
+	}
+
+	close(h.release)
+	wg.Wait()
+	close(results)
+
+	for err := range results {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := pool.Stats()
+	if stats.Completed != 3 || stats.Rejected != 1 || stats.Queued != 0 || stats.Running != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestPoolHash(t *testing.T) {
+	pool := NewPool(testArgon2, 1, 0)
+	defer pool.Close()
+
+	encoded, err := pool.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := pool.Verify("secret", encoded); err != nil || !ok {
+		t.Fatalf("expected the pool's hash to verify, got %v %v", ok, err)
+	}
+
+	if pool.NeedsRehash(encoded) {
+		t.Fatal("a hash made under the policy should not need rehashing")
+	}
+}
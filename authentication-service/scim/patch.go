@@ -0,0 +1,238 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PatchOp is the body of a PATCH request.
+type PatchOp struct {
+	Schemas    []string    `json:"schemas"`
+	Operations []Operation `json:"Operations"`
+}
+
+// Operation is one change in a PatchOp. Op is "add", "replace" or "remove", in any
+// case. Without a path, Value is an object of attributes to set.
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// PatchError is returned for an operation we cannot apply. ScimType says why.
+type PatchError struct {
+	ScimType string
+	Detail   string
+}
+
+func (e *PatchError) Error() string {
+	return e.Detail
+}
+
+func patchError(scimType, format string, args ...any) error {
+	return &PatchError{ScimType: scimType, Detail: fmt.Sprintf(format, args...)}
+}
+
+// ApplyToUser applies the operations to u in order. Attributes we don't store, such
+// as externalId or title, are ignored, as they are in a PUT.
+func (p PatchOp) ApplyToUser(u *User) error {
+	return p.apply(func(op, path string, value json.RawMessage) error {
+		return patchUser(u, op, path, value)
+	})
+}
+
+// ApplyToGroup applies the operations to g in order.
+func (p PatchOp) ApplyToGroup(g *Group) error {
+	return p.apply(func(op, path string, value json.RawMessage) error {
+		return patchGroup(g, op, path, value)
+	})
+}
+
+// apply calls patch for every operation, once for each attribute of a value that
+// has no path
+func (p PatchOp) apply(patch func(op, path string, value json.RawMessage) error) error {
+	for _, o := range p.Operations {
+		op := strings.ToLower(o.Op)
+
+		switch op {
+		case "add", "replace":
+		case "remove":
+			if o.Path == "" {
+				return patchError(NoTarget, "remove needs a path")
+			}
+		default:
+			return patchError(InvalidSyntax, "unknown op %q", o.Op)
+		}
+
+		if o.Path != "" {
+			if err := patch(op, o.Path, o.Value); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var attributes map[string]json.RawMessage
+		if err := json.Unmarshal(o.Value, &attributes); err != nil {
+			return patchError(InvalidValue, "an operation without a path needs an object value")
+		}
+
+		for path, value := range attributes {
+			if err := patch(op, path, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func patchUser(u *User, op, path string, value json.RawMessage) error {
+	attribute := strings.ToLower(path)
+
+	if op == "remove" {
+		switch attribute {
+		case "name.givenname":
+			if u.Name != nil {
+				u.Name.GivenName = ""
+			}
+		case "name.familyname":
+			if u.Name != nil {
+				u.Name.FamilyName = ""
+			}
+		case "name":
+			u.Name = nil
+		case "username", "active", "password":
+			return patchError(InvalidValue, "%s cannot be removed", path)
+		}
+		return nil
+	}
+
+	if u.Name == nil {
+		u.Name = &Name{}
+	}
+
+	switch attribute {
+	case "username":
+		return decode(value, path, &u.UserName)
+	case "password":
+		return decode(value, path, &u.Password)
+	case "name.givenname":
+		return decode(value, path, &u.Name.GivenName)
+	case "name.familyname":
+		return decode(value, path, &u.Name.FamilyName)
+	case "name":
+		var name Name
+		if err := decode(value, path, &name); err != nil {
+			return err
+		}
+		if op == "replace" {
+			*u.Name = name
+		}
+		if name.GivenName != "" {
+			u.Name.GivenName = name.GivenName
+		}
+		if name.FamilyName != "" {
+			u.Name.FamilyName = name.FamilyName
+		}
+	case "active":
+		active, err := decodeBool(value)
+		if err != nil {
+			return patchError(InvalidValue, "active must be a boolean")
+		}
+		u.Active = &active
+	}
+
+	return nil
+}
+
+func patchGroup(g *Group, op, path string, value json.RawMessage) error {
+	attribute := strings.ToLower(path)
+
+	// members[value eq "2"] picks out one member
+	if strings.HasPrefix(attribute, "members[") && strings.HasSuffix(attribute, "]") {
+		if op != "remove" {
+			return patchError(InvalidPath, "only remove is supported on %s", path)
+		}
+
+		field, id, err := ParseFilter(path[len("members[") : len(path)-1])
+		if err != nil || field != "value" {
+			return patchError(InvalidPath, "invalid member filter %q", path)
+		}
+
+		g.Members = withoutMember(g.Members, id)
+		return nil
+	}
+
+	switch attribute {
+	case "displayname":
+		if op == "remove" {
+			return patchError(InvalidValue, "displayName cannot be removed")
+		}
+		return decode(value, path, &g.DisplayName)
+	case "members":
+		if op == "remove" {
+			// a value names the members to remove; without one, they all go
+			var members []Member
+			if len(value) == 0 {
+				g.Members = nil
+				return nil
+			}
+			if err := decode(value, path, &members); err != nil {
+				return err
+			}
+			for _, m := range members {
+				g.Members = withoutMember(g.Members, m.Value)
+			}
+			return nil
+		}
+
+		var members []Member
+		if err := decode(value, path, &members); err != nil {
+			return err
+		}
+
+		if op == "replace" {
+			g.Members = nil
+		}
+		g.Members = append(g.Members, members...)
+	}
+
+	return nil
+}
+
+func withoutMember(members []Member, id string) []Member {
+	var kept []Member
+	for _, m := range members {
+		if m.Value != id {
+			kept = append(kept, m)
+		}
+	}
+
+	return kept
+}
+
+func decode(value json.RawMessage, path string, v any) error {
+	if err := json.Unmarshal(value, v); err != nil {
+		return patchError(InvalidValue, "invalid value for %s", path)
+	}
+
+	return nil
+}
+
+// decodeBool accepts true and false, and the strings "True" and "False" some
+// clients send instead
+func decodeBool(value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return false, err
+	}
+
+	return strconv.ParseBool(s)
+}
@@ -0,0 +1,181 @@
+// Package scim holds the parts of SCIM 2.0 (RFC 7643 and 7644) the authentication
+// service speaks to HR systems: the User and Group resources, list and error
+// responses, the "eq" filters provisioning clients use to look resources up, and
+// PATCH operations.
+package scim
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	UserSchema  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	GroupSchema = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	ListSchema  = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	ErrorSchema = "urn:ietf:params:scim:api:messages:2.0:Error"
+	PatchSchema = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+
+	// ContentType is the media type of every SCIM request and response.
+	ContentType = "application/scim+json"
+
+	// MaxCount is the most resources returned on one page.
+	MaxCount = 100
+)
+
+// Error types, sent as scimType in error responses
+const (
+	InvalidFilter = "invalidFilter"
+	InvalidSyntax = "invalidSyntax"
+	InvalidPath   = "invalidPath"
+	InvalidValue  = "invalidValue"
+	NoTarget      = "noTarget"
+	Uniqueness    = "uniqueness"
+)
+
+// Meta describes a resource.
+type Meta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+}
+
+// Name is a user's name.
+type Name struct {
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// Email is one of a user's addresses. We hold one, which is always primary.
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// User is the SCIM view of a user. userName is the user's email address.
+type User struct {
+	Schemas  []string `json:"schemas"`
+	ID       string   `json:"id,omitempty"`
+	UserName string   `json:"userName"`
+	Name     *Name    `json:"name,omitempty"`
+	Emails   []Email  `json:"emails,omitempty"`
+	Active   *bool    `json:"active,omitempty"`
+	Password string   `json:"password,omitempty"`
+	Meta     *Meta    `json:"meta,omitempty"`
+}
+
+// Member is a user in a group.
+type Member struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// Group is the SCIM view of a group.
+type Group struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	DisplayName string   `json:"displayName"`
+	Members     []Member `json:"members"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+// ListResponse is one page of resources.
+type ListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    any      `json:"Resources"`
+}
+
+// NewListResponse returns a page of resources starting at the 1-based startIndex.
+func NewListResponse(resources any, count, total, startIndex int) ListResponse {
+	return ListResponse{
+		Schemas:      []string{ListSchema},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: count,
+		Resources:    resources,
+	}
+}
+
+// Error is a SCIM error response. Status is a string, as the RFC has it.
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+}
+
+// NewError returns an error response for an HTTP status.
+func NewError(status int, scimType, detail string) Error {
+	return Error{
+		Schemas:  []string{ErrorSchema},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	}
+}
+
+// ErrInvalidFilter is returned for a filter other than a single "eq" comparison.
+var ErrInvalidFilter = errors.New(`only filters of the form 'attribute eq "value"' are supported`)
+
+// ParseFilter parses an equality filter such as `userName eq "bjensen"`. Attribute
+// names are case insensitive, so the attribute is returned in lower case.
+func ParseFilter(filter string) (attribute, value string, err error) {
+	attribute, rest, ok := strings.Cut(strings.TrimSpace(filter), " ")
+	if !ok {
+		return "", "", ErrInvalidFilter
+	}
+
+	op, value, ok := strings.Cut(strings.TrimSpace(rest), " ")
+	if !ok || !strings.EqualFold(op, "eq") {
+		return "", "", ErrInvalidFilter
+	}
+
+	value, err = strconv.Unquote(strings.TrimSpace(value))
+	if err != nil {
+		return "", "", ErrInvalidFilter
+	}
+
+	return strings.ToLower(attribute), value, nil
+}
+
+// Page reads the startIndex and count query parameters. startIndex is 1-based and
+// defaults to 1; count defaults to, and is capped at, MaxCount.
+func Page(startIndex, count string) (offset, limit int, err error) {
+	start := 1
+	if startIndex != "" {
+		start, err = strconv.Atoi(startIndex)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid startIndex %q", startIndex)
+		}
+
+		// the RFC says values below 1 are read as 1
+		if start < 1 {
+			start = 1
+		}
+	}
+
+	limit = MaxCount
+	if count != "" {
+		limit, err = strconv.Atoi(count)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid count %q", count)
+		}
+
+		if limit < 0 {
+			limit = 0
+		} else if limit > MaxCount {
+			limit = MaxCount
+		}
+	}
+
+	return start - 1, limit, nil
+}
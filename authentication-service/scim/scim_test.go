@@ -0,0 +1,93 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	attribute, value, err := ParseFilter(`userName Eq "bjensen@example.com"`)
+	if err != nil || attribute != "username" || value != "bjensen@example.com" {
+		t.Fatalf("got %q %q %v", attribute, value, err)
+	}
+
+	for _, filter := range []string{``, `userName`, `userName sw "b"`, `userName eq bjensen`, `userName eq "b" and active eq true`} {
+		if _, _, err := ParseFilter(filter); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("%q: expected ErrInvalidFilter, got %v", filter, err)
+		}
+	}
+}
+
+func TestPage(t *testing.T) {
+	for _, tc := range []struct {
+		start, count  string
+		offset, limit int
+	}{
+		{"", "", 0, MaxCount},
+		{"11", "10", 10, 10},
+		{"0", "-1", 0, 0},
+		{"1", "1000", 0, MaxCount},
+	} {
+		offset, limit, err := Page(tc.start, tc.count)
+		if err != nil || offset != tc.offset || limit != tc.limit {
+			t.Errorf("Page(%q, %q) = %d, %d, %v", tc.start, tc.count, offset, limit, err)
+		}
+	}
+
+	if _, _, err := Page("one", ""); err == nil {
+		t.Error("expected an error for a startIndex that is not a number")
+	}
+}
+
+func patchOp(t *testing.T, body string) PatchOp {
+	t.Helper()
+
+	var p PatchOp
+	if err := json.Unmarshal([]byte(body), &p); err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func TestApplyToUser(t *testing.T) {
+	active := true
+	u := User{UserName: "b@example.com", Name: &Name{GivenName: "Barbara", FamilyName: "Jensen"}, Active: &active}
+
+	err := patchOp(t, `{"Operations":[
+		{"op":"Replace","value":{"active":"False","name.familyName":"Smith","externalId":"ignored"}},
+		{"op":"remove","path":"name.givenName"}
+	]}`).ApplyToUser(&u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *u.Active || u.Name.GivenName != "" || u.Name.FamilyName != "Smith" {
+		t.Fatalf("got %+v %+v", u, u.Name)
+	}
+
+	var perr *PatchError
+	if err := patchOp(t, `{"Operations":[{"op":"remove","path":"userName"}]}`).ApplyToUser(&u); !errors.As(err, &perr) {
+		t.Fatalf("expected a PatchError, got %v", err)
+	}
+}
+
+func TestApplyToGroup(t *testing.T) {
+	g := Group{DisplayName: "Engineering", Members: []Member{{Value: "1"}, {Value: "2"}}}
+
+	err := patchOp(t, `{"Operations":[
+		{"op":"add","path":"members","value":[{"value":"3"}]},
+		{"op":"remove","path":"members[value eq \"1\"]"},
+		{"op":"replace","path":"displayName","value":"Platform"}
+	]}`).ApplyToGroup(&g)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Group{DisplayName: "Platform", Members: []Member{{Value: "2"}, {Value: "3"}}}
+	if !reflect.DeepEqual(g, want) {
+		t.Fatalf("expected %+v, got %+v", want, g)
+	}
+}
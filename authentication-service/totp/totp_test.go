@@ -0,0 +1,113 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 seed from RFC 6238 appendix B, "12345678901234567890",
+// base32 encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFC6238(t *testing.T) {
+	// the RFC gives eight digit codes; we use six, which are their last six digits
+	for _, tc := range []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	} {
+		got, err := Code(rfcSecret, Step(time.Unix(tc.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != tc.want {
+			t.Errorf("T=%d: expected %s, got %s", tc.unix, tc.want, got)
+		}
+	}
+}
+
+func TestCodeLowercaseSecret(t *testing.T) {
+	got, err := Code("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", 1)
+	if err != nil || got != "287082" {
+		t.Fatalf("expected 287082, got %q, %v", got, err)
+	}
+
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Fatal("expected an error for a malformed secret")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	// 050471 is the code for step 37037037, which covers T=1111111110 to T=1111111139
+	const at, step = 1111111111, 37037037
+
+	for _, tc := range []struct {
+		name string
+		code string
+		unix int64
+		step int64
+		ok   bool
+	}{
+		{"current step", "050471", at, step, true},
+		{"with spaces", " 050 471 ", at, step, true},
+		{"one step behind", "050471", at + 30, step, true},
+		{"one step ahead", "050471", at - 30, step, true},
+		{"two steps behind", "050471", at + 60, 0, false},
+		{"two steps ahead", "050471", at - 60, 0, false},
+		{"wrong code", "050472", at, 0, false},
+		{"too short", "05047", at, 0, false},
+		{"too long", "14050471", at, 0, false},
+		{"empty", "", at, 0, false},
+	} {
+		step, ok := Validate(rfcSecret, tc.code, time.Unix(tc.unix, 0))
+		if ok != tc.ok || step != tc.step {
+			t.Errorf("%s: expected %d, %v, got %d, %v", tc.name, tc.step, tc.ok, step, ok)
+		}
+	}
+}
+
+func TestValidateReportsStep(t *testing.T) {
+	// callers refuse a step they have seen, so each code within the window must
+	// report its own step rather than the current one
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	for _, step := range []int64{current - 1, current, current + 1} {
+		code, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, ok := Validate(rfcSecret, code, now)
+		if !ok || got != step {
+			t.Errorf("code for step %d: got %d, %v", step, got, ok)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a == b || len(a) != 32 {
+		t.Fatalf("expected two different 32 character secrets, got %q and %q", a, b)
+	}
+
+	if _, err := Code(a, 1); err != nil {
+		t.Fatalf("generated secret does not decode: %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package authz
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKeyHeader is the header machine clients send their API key in.
+const APIKeyHeader = "X-API-Key"
+
+// DefaultTokenURL is the authentication service's token endpoint inside the compose
+// network.
+const DefaultTokenURL = "http://authentication-service/oauth/token"
+
+// ErrInvalidAPIKey is returned for an API key the authentication service refuses.
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+const (
+	// keyTokenMaxAge bounds how long an exchanged token is reused, and so how long a
+	// revoked key keeps working here.
+	keyTokenMaxAge = time.Minute
+
+	// keyTokenMargin stops us handing out a token that expires in flight.
+	keyTokenMargin = 30 * time.Second
+)
+
+type exchangedToken struct {
+	token  string
+	claims *Claims
+	until  time.Time
+}
+
+// KeyExchanger trades API keys for access tokens at the authentication service,
+// using the client credentials grant. Tokens are cached for a short while, so a
+// busy job doesn't cost a round trip per request.
+type KeyExchanger struct {
+	url      string
+	verifier Verifier
+	client   *http.Client
+
+	mu     sync.Mutex
+	tokens map[string]exchangedToken
+	now    func() time.Time
+}
+
+// NewKeyExchanger returns a KeyExchanger that calls the token endpoint at tokenURL
+// and checks the tokens it gets back with v.
+func NewKeyExchanger(tokenURL string, v Verifier) *KeyExchanger {
+	return &KeyExchanger{
+		url:      tokenURL,
+		verifier: v,
+		client:   &http.Client{Timeout: 5 * time.Second},
+		tokens:   make(map[string]exchangedToken),
+		now:      time.Now,
+	}
+}
+
+// Exchange returns an access token for key and its claims.
+func (x *KeyExchanger) Exchange(key string) (string, *Claims, error) {
+	prefix, secret, ok := strings.Cut(key, ".")
+	if !ok || prefix == "" || secret == "" {
+		return "", nil, ErrInvalidAPIKey
+	}
+
+	// the cache is keyed by a hash so that it holds no secrets
+	sum := sha256.Sum256([]byte(key))
+	id := hex.EncodeToString(sum[:])
+
+	x.mu.Lock()
+	cached, ok := x.tokens[id]
+	x.mu.Unlock()
+
+	now := x.now()
+	if ok && now.Before(cached.until) {
+		return cached.token, cached.claims, nil
+	}
+
+	token, err := x.fetch(prefix, secret)
+	if err != nil {
+		return "", nil, err
+	}
+
+	claims, err := x.verifier.Verify(token)
+	if err != nil {
+		return "", nil, err
+	}
+
+	until := now.Add(keyTokenMaxAge)
+	if exp := time.Unix(claims.ExpiresAt, 0).Add(-keyTokenMargin); exp.Before(until) {
+		until = exp
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	for k, t := range x.tokens {
+		if !now.Before(t.until) {
+			delete(x.tokens, k)
+		}
+	}
+	x.tokens[id] = exchangedToken{token, claims, until}
+
+	return token, claims, nil
+}
+
+// fetch calls the token endpoint
+func (x *KeyExchanger) fetch(prefix, secret string) (string, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+
+	req, err := http.NewRequest(http.MethodPost, x.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(prefix), url.QueryEscape(secret))
+
+	resp, err := x.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("exchanging API key: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return "", ErrInvalidAPIKey
+	case resp.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("exchanging API key: %s", resp.Status)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("decoding access token: %w", err)
+	}
+
+	return body.AccessToken, nil
+}
+
+// AuthenticateWithAPIKeys works like Authenticate, but also accepts an API key in
+// the X-API-Key header. The key is exchanged for an access token, which replaces
+// the request's Authorization header so that it is what gets forwarded to other
+// services.
+func AuthenticateWithAPIKeys(v Verifier, keys *KeyExchanger) func(http.Handler) http.Handler {
+	bearer := Authenticate(v)
+
+	return func(next http.Handler) http.Handler {
+		withBearer := bearer(next)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := strings.TrimSpace(r.Header.Get(APIKeyHeader))
+			if key == "" {
+				withBearer.ServeHTTP(w, r)
+				return
+			}
+
+			token, claims, err := keys.Exchange(key)
+			if errors.Is(err, ErrInvalidAPIKey) {
+				writeError(w, err, http.StatusUnauthorized)
+				return
+			} else if err != nil {
+				writeError(w, err, http.StatusBadGateway)
+				return
+			}
+
+			r = r.WithContext(NewContext(r.Context(), claims))
+			r.Header = r.Header.Clone()
+			r.Header.Del(APIKeyHeader)
+			r.Header.Set("Authorization", "Bearer "+token)
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package authz
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tokenServer plays the authentication service's token endpoint for a single key
+type tokenServer struct {
+	mu        sync.Mutex
+	signer    *HMAC
+	exchanges int
+}
+
+func (s *tokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.exchanges++
+
+	prefix, secret, _ := r.BasicAuth()
+	if r.FormValue("grant_type") != "client_credentials" || prefix != "sk_test" || secret != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	token, _ := s.signer.Sign(Claims{
+		Subject:     "apikey:sk_test",
+		Permissions: []string{"logs:write"},
+		ExpiresAt:   time.Now().Add(15 * time.Minute).Unix(),
+	})
+
+	_ = json.NewEncoder(w).Encode(map[string]string{"access_token": token})
+}
+
+func (s *tokenServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.exchanges
+}
+
+func TestAuthenticateWithAPIKeys(t *testing.T) {
+	signer := NewHMAC("test")
+	ts := &tokenServer{signer: signer}
+	srv := httptest.NewServer(ts)
+	defer srv.Close()
+
+	now := time.Now()
+	keys := NewKeyExchanger(srv.URL, signer)
+	keys.now = func() time.Time { return now }
+
+	var forwarded string
+	handler := AuthenticateWithAPIKeys(signer, keys)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := Authorize(r.Context(), "logs:write"); err != nil {
+			writeError(w, err, StatusCode(err))
+			return
+		}
+		forwarded = r.Header.Get("Authorization")
+	}))
+
+	send := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set(APIKeyHeader, key)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	for i := 0; i < 3; i++ {
+		if rr := send("sk_test.secret"); rr.Code != http.StatusOK {
+			t.Fatalf("expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+	}
+
+	if ts.count() != 1 {
+		t.Fatalf("expected the token to be cached, got %d exchanges", ts.count())
+	}
+
+	claims, err := signer.Verify(BearerToken(&http.Request{Header: http.Header{"Authorization": {forwarded}}}))
+	if err != nil || claims.Subject != "apikey:sk_test" {
+		t.Fatalf("expected the exchanged token to be forwarded, got %q (%v)", forwarded, err)
+	}
+
+	now = now.Add(keyTokenMaxAge + time.Second)
+	if rr := send("sk_test.secret"); rr.Code != http.StatusOK || ts.count() != 2 {
+		t.Fatalf("expected a fresh exchange once the cached token is old, got %d after %d exchanges", rr.Code, ts.count())
+	}
+
+	for _, key := range []string{"sk_test.wrong", "no-dot"} {
+		if rr := send(key); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: expected %d, got %d", key, http.StatusUnauthorized, rr.Code)
+		}
+	}
+}
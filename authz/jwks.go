@@ -0,0 +1,124 @@
+package authz
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultJWKSURL is where the authentication service publishes its key set inside
+// the compose network.
+const DefaultJWKSURL = "http://authentication-service/.well-known/jwks.json"
+
+// JWKSMaxAge is how long a fetched key set is used before it is fetched again. The
+// authentication service sends it as the key set's max-age, and keeps retired keys
+// published for at least this long past the last token they signed.
+const JWKSMaxAge = 5 * time.Minute
+
+const (
+	// jwksMinRefresh stops a stream of tokens with made up key ids from turning
+	// into a stream of requests to the authentication service.
+	jwksMinRefresh = 10 * time.Second
+)
+
+// JWKSVerifier verifies RS256 tokens against the key set published at a jwks_uri.
+// The key set is cached, and fetched again when it gets old or when a token names a
+// key id that is not in it, which is what happens right after a key rotation.
+type JWKSVerifier struct {
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+	now     func() time.Time
+}
+
+// NewJWKSVerifier returns a JWKSVerifier for the key set at url. Nothing is fetched
+// until the first token is verified.
+func NewJWKSVerifier(url string) *JWKSVerifier {
+	return &JWKSVerifier{
+		url:    url,
+		client: &http.Client{Timeout: 5 * time.Second},
+		now:    time.Now,
+	}
+}
+
+// Verify checks the signature and expiry of token and returns its claims.
+func (v *JWKSVerifier) Verify(token string) (*Claims, error) {
+	return VerifyRS256(token, v.key)
+}
+
+// key returns the public key for kid, fetching the key set if it is unknown or stale
+func (v *JWKSVerifier) key(kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := v.now()
+	key, ok := v.keys[kid]
+
+	stale := now.Sub(v.fetched) > JWKSMaxAge
+	if (ok && !stale) || (!ok && now.Sub(v.fetched) < jwksMinRefresh) {
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+		return key, nil
+	}
+
+	if err := v.refresh(now); err != nil {
+		// keep trusting the keys we have if the authentication service is briefly unreachable
+		if ok {
+			return key, nil
+		}
+		return nil, err
+	}
+
+	key, ok = v.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	return key, nil
+}
+
+// refresh fetches the key set. It is called with mu held.
+func (v *JWKSVerifier) refresh(now time.Time) error {
+	// count failed fetches too, so that an outage is not hammered with retries
+	v.fetched = now
+
+	resp, err := v.client.Get(v.url)
+	if err != nil {
+		return fmt.Errorf("fetching signing keys: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching signing keys: %s", resp.Status)
+	}
+
+	var set JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("decoding signing keys: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.PublicKey()
+		if err != nil {
+			continue
+		}
+
+		keys[k.Kid] = key
+	}
+
+	v.keys = keys
+
+	return nil
+}
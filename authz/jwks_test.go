@@ -0,0 +1,202 @@
+package authz
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// keyServer publishes a key set that the test can change, and counts fetches
+type keyServer struct {
+	mu      sync.Mutex
+	set     JWKS
+	fetches int
+}
+
+func (s *keyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fetches++
+	_ = json.NewEncoder(w).Encode(s.set)
+}
+
+func (s *keyServer) publish(kid string, key *rsa.PrivateKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.set.Keys = append(s.set.Keys, NewJWK(kid, &key.PublicKey))
+}
+
+func (s *keyServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.fetches
+}
+
+func newKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func signed(t *testing.T, kid string, key *rsa.PrivateKey) string {
+	t.Helper()
+
+	token, err := SignAccessToken(kid, key, Claims{Subject: "1", Issuer: AccessTokenIssuer, ExpiresAt: time.Now().Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func TestJWKSVerifierRefreshesOnUnknownKid(t *testing.T) {
+	ks := &keyServer{}
+	srv := httptest.NewServer(ks)
+	defer srv.Close()
+
+	first, second := newKey(t), newKey(t)
+	ks.publish("first", first)
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewJWKSVerifier(srv.URL)
+	v.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if _, err := v.Verify(signed(t, "first", first)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if ks.count() != 1 {
+		t.Fatalf("expected the key set to be fetched once, got %d", ks.count())
+	}
+
+	// a rotation publishes a new key; the first token signed with it triggers a refresh
+	ks.publish("second", second)
+	now = now.Add(time.Minute)
+
+	if _, err := v.Verify(signed(t, "second", second)); err != nil {
+		t.Fatalf("token signed with the new key: %v", err)
+	}
+
+	if ks.count() != 2 {
+		t.Fatalf("expected a refresh for the new kid, got %d fetches", ks.count())
+	}
+
+	// made up key ids do not cause a fetch each
+	for i := 0; i < 5; i++ {
+		_, err := v.Verify(signed(t, "bogus", second))
+		if !errors.Is(err, ErrUnknownKey) {
+			t.Fatalf("expected ErrUnknownKey, got %v", err)
+		}
+	}
+
+	if ks.count() != 2 {
+		t.Fatalf("unknown kids within the refresh interval fetched %d times", ks.count()-2)
+	}
+}
+
+func TestJWKSVerifierRejectsForgedToken(t *testing.T) {
+	ks := &keyServer{}
+	srv := httptest.NewServer(ks)
+	defer srv.Close()
+
+	ks.publish("first", newKey(t))
+
+	v := NewJWKSVerifier(srv.URL)
+
+	// signed with a key that claims to be "first" but isn't
+	_, err := v.Verify(signed(t, "first", newKey(t)))
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken, got %v", err)
+	}
+}
+
+func TestJWKSVerifierRejectsOtherTokens(t *testing.T) {
+	ks := &keyServer{}
+	srv := httptest.NewServer(ks)
+	defer srv.Close()
+
+	key := newKey(t)
+	ks.publish("first", key)
+
+	v := NewJWKSVerifier(srv.URL)
+	expiry := time.Now().Add(time.Minute).Unix()
+
+	// an ID token is signed with the same keys, but is no access token
+	idToken, err := SignRS256("first", key, map[string]any{"iss": "http://localhost:8081", "sub": "1", "aud": "client-1", "exp": expiry})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nor is an access token from some other issuer
+	foreign, err := SignAccessToken("first", key, Claims{Subject: "1", Issuer: "someone-else", ExpiresAt: expiry})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, token := range map[string]string{"ID token": idToken, "other issuer": foreign} {
+		if _, err := v.Verify(token); !errors.Is(err, ErrNotAccessToken) {
+			t.Errorf("%s: expected ErrNotAccessToken, got %v", name, err)
+		}
+	}
+
+	if _, err := v.Verify(signed(t, "first", key)); err != nil {
+		t.Fatalf("access token: %v", err)
+	}
+}
+
+func TestByAlgorithm(t *testing.T) {
+	hmac := NewHMAC("secret")
+
+	token, err := hmac.Sign(Claims{Subject: "service", ExpiresAt: time.Now().Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := (ByAlgorithm{"HS256": hmac}).Verify(token); err != nil {
+		t.Fatalf("HS256 token: %v", err)
+	}
+
+	if _, err := (ByAlgorithm{"RS256": NewJWKSVerifier("http://unused")}).Verify(token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected an HS256 token to be refused without an HS256 verifier, got %v", err)
+	}
+}
+
+func TestServicesOnly(t *testing.T) {
+	hmac := NewHMAC("secret")
+	v := ServicesOnly(hmac)
+
+	service, err := hmac.Sign(Claims{Subject: "mail-service", Issuer: "mail-service", ExpiresAt: time.Now().Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := v.Verify(service); err != nil {
+		t.Fatalf("service token: %v", err)
+	}
+
+	// users are only ever issued RS256 tokens, so an HS256 one naming a user is refused
+	user, err := hmac.Sign(Claims{Subject: "1", Issuer: "authentication-service", Permissions: []string{"*"}, ExpiresAt: time.Now().Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := v.Verify(user); !errors.Is(err, ErrNotService) {
+		t.Fatalf("expected ErrNotService for a user token, got %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireDirect(t *testing.T) {
+	handler := RequireDirect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tc := range []struct {
+		name   string
+		claims *Claims
+		want   int
+	}{
+		{"anonymous", nil, http.StatusUnauthorized},
+		{"user", &Claims{Subject: "2"}, http.StatusOK},
+		{"impersonated", &Claims{Subject: "2", Actor: &Actor{Subject: "1"}}, http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		if tc.claims != nil {
+			req = req.WithContext(NewContext(req.Context(), tc.claims))
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, rr.Code)
+		}
+	}
+}
+
+func TestResolveTenant(t *testing.T) {
+	user := NewContext(context.Background(), &Claims{Subject: "2", TenantID: "7"})
+	service := NewContext(context.Background(), &Claims{Subject: "listener-service", Issuer: "listener-service"})
+	orphan := NewContext(context.Background(), &Claims{Subject: "3", Issuer: "authentication-service"})
+
+	for _, tc := range []struct {
+		name    string
+		ctx     context.Context
+		claimed string
+		want    string
+		err     error
+	}{
+		{"user", user, "", "7", nil},
+		{"user repeating their tenant", user, "7", "7", nil},
+		{"user naming another tenant", user, "8", "", ErrForbidden},
+		{"service relaying", service, "8", "8", nil},
+		{"user without a tenant", orphan, "", "", nil},
+		{"user without a tenant naming one", orphan, "8", "", ErrForbidden},
+		{"anonymous", context.Background(), "8", "", ErrUnauthenticated},
+	} {
+		got, err := ResolveTenant(tc.ctx, tc.claimed)
+		if got != tc.want || !errors.Is(err, tc.err) {
+			t.Errorf("%s: got %q, %v", tc.name, got, err)
+		}
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	handler := RequirePermission("logs:read")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tc := range []struct {
+		name   string
+		claims *Claims
+		want   int
+	}{
+		{"anonymous", nil, http.StatusUnauthorized},
+		{"granted", &Claims{Subject: "2", Permissions: []string{"logs:read"}}, http.StatusOK},
+		{"granted by wildcard", &Claims{Subject: "2", Permissions: []string{"logs:*"}}, http.StatusOK},
+		{"admin", &Claims{Subject: "1", Permissions: []string{"*"}}, http.StatusOK},
+		{"other permission", &Claims{Subject: "2", Permissions: []string{"logs:write"}}, http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tc.claims != nil {
+			req = req.WithContext(NewContext(req.Context(), tc.claims))
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, rr.Code)
+		}
+	}
+}
+
+func TestAuthenticateRejectsClientTokens(t *testing.T) {
+	signer := NewHMAC("secret")
+	handler := Authenticate(signer)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tc := range []struct {
+		name   string
+		claims Claims
+		want   int
+	}{
+		{"user", Claims{Subject: "2"}, http.StatusOK},
+		{"OAuth client", Claims{Subject: "2", Audience: "client-1", Scope: "openid email"}, http.StatusUnauthorized},
+	} {
+		token, err := signer.Sign(tc.claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package authz
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrRevokedSession is returned for a token whose session has been revoked.
+var ErrRevokedSession = errors.New("session has been revoked")
+
+const (
+	// RevocationExchange is the RabbitMQ topic exchange session revocations are published on.
+	RevocationExchange = "sessions_topic"
+
+	// RevocationKey is the routing key of a session revocation.
+	RevocationKey = "session.revoked"
+)
+
+// Revocation is the event the authentication service publishes when sessions are
+// revoked. Access tokens are short lived, so services only need to remember a
+// revoked session until every token issued for it has expired.
+type Revocation struct {
+	UserID     int       `json:"user_id"`
+	SessionIDs []string  `json:"session_ids"`
+	RevokedAt  time.Time `json:"revoked_at"`
+	Until      time.Time `json:"until"`
+}
+
+// Revocations is an in-memory list of revoked sessions.
+type Revocations struct {
+	mu       sync.RWMutex
+	sessions map[string]time.Time
+	now      func() time.Time
+}
+
+// NewRevocations returns an empty list.
+func NewRevocations() *Revocations {
+	return &Revocations{
+		sessions: make(map[string]time.Time),
+		now:      time.Now,
+	}
+}
+
+// Add records every session in rev, and forgets any whose tokens have all expired.
+func (l *Revocations) Add(rev Revocation) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	for id, until := range l.sessions {
+		if now.After(until) {
+			delete(l.sessions, id)
+		}
+	}
+
+	for _, id := range rev.SessionIDs {
+		l.sessions[id] = rev.Until
+	}
+}
+
+// Revoked reports whether tokens for the session must be refused.
+func (l *Revocations) Revoked(sessionID string) bool {
+	if sessionID == "" {
+		return false
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	until, ok := l.sessions[sessionID]
+	return ok && !l.now().After(until)
+}
+
+// WithRevocations returns a Verifier that refuses tokens for revoked sessions.
+func WithRevocations(v Verifier, l *Revocations) Verifier {
+	return revocationVerifier{v, l}
+}
+
+type revocationVerifier struct {
+	Verifier
+	list *Revocations
+}
+
+func (v revocationVerifier) Verify(token string) (*Claims, error) {
+	claims, err := v.Verifier.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if v.list.Revoked(claims.SessionID) {
+		return nil, ErrRevokedSession
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,42 @@
+package authz
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRevocations(t *testing.T) {
+	now := time.Now()
+	list := NewRevocations()
+	list.now = func() time.Time { return now }
+
+	hmac := NewHMAC("secret")
+	v := WithRevocations(hmac, list)
+
+	token, err := hmac.Sign(Claims{Subject: "1", SessionID: "s1", ExpiresAt: now.Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := v.Verify(token); err != nil {
+		t.Fatalf("before revocation: %v", err)
+	}
+
+	list.Add(Revocation{SessionIDs: []string{"s1"}, Until: now.Add(15 * time.Minute)})
+
+	if _, err := v.Verify(token); !errors.Is(err, ErrRevokedSession) {
+		t.Fatalf("expected ErrRevokedSession, got %v", err)
+	}
+
+	// once every token for the session has expired, the entry is dropped
+	now = now.Add(16 * time.Minute)
+	list.Add(Revocation{SessionIDs: []string{"s2"}, Until: now.Add(15 * time.Minute)})
+
+	if list.Revoked("s1") {
+		t.Fatal("expected s1 to be forgotten")
+	}
+	if len(list.sessions) != 1 {
+		t.Fatalf("expected one remembered session, got %d", len(list.sessions))
+	}
+}
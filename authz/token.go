@@ -0,0 +1,236 @@
+// Package authz holds the token format shared by every service in the project,
+// along with the middleware used to enforce permissions on routes. The
+// authentication service issues tokens; everybody else only verifies them.
+package authz
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidToken is returned when a token is malformed or its signature does not verify.
+	ErrInvalidToken = errors.New("invalid token")
+
+	// ErrExpiredToken is returned when a token is well formed but past its expiry.
+	ErrExpiredToken = errors.New("token has expired")
+
+	// ErrNotService is returned by ServicesOnly for a token that is not a service's.
+	ErrNotService = errors.New("only services may use this kind of token")
+)
+
+// Claims is the payload carried in every token issued by the authentication service.
+type Claims struct {
+	Subject     string   `json:"sub"`
+	Issuer      string   `json:"iss,omitempty"`
+	SessionID   string   `json:"sid,omitempty"`
+	TenantID    string   `json:"tid,omitempty"`
+	Email       string   `json:"email,omitempty"`
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	IssuedAt    int64    `json:"iat"`
+	ExpiresAt   int64    `json:"exp"`
+
+	// Actor is set when someone else is acting as the subject, as in RFC 8693.
+	Actor *Actor `json:"act,omitempty"`
+
+	// Audience is set on tokens issued to an OAuth client, and names it. Scope lists
+	// what the user let the client see. These tokens carry no roles or permissions,
+	// and are only good at the endpoints made for them.
+	Audience string `json:"aud,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+}
+
+// Actor is the user really behind an impersonation token.
+type Actor struct {
+	Subject string `json:"sub"`
+	Email   string `json:"email,omitempty"`
+}
+
+// Impersonated reports whether the claims were issued to someone acting as the subject.
+func (c *Claims) Impersonated() bool {
+	return c.Actor != nil
+}
+
+// Can reports whether the claims grant permission. A permission of "*" grants
+// everything, and "resource:*" grants every action on that resource.
+func (c *Claims) Can(permission string) bool {
+	resource, _, _ := strings.Cut(permission, ":")
+
+	for _, p := range c.Permissions {
+		if p == "*" || p == permission || p == resource+":*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Service reports whether the claims belong to one of our services rather than a
+// user or API key. Services sign their own tokens, naming themselves as both
+// subject and issuer.
+func (c *Claims) Service() bool {
+	return c.Subject != "" && c.Subject == c.Issuer
+}
+
+// HasRole reports whether the claims include the named role.
+func (c *Claims) HasRole(role string) bool {
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Signer issues signed tokens.
+type Signer interface {
+	Sign(claims Claims) (string, error)
+}
+
+// Verifier checks a token and returns the claims it carries.
+type Verifier interface {
+	Verify(token string) (*Claims, error)
+}
+
+// ByAlgorithm is a Verifier that hands each token to the Verifier registered for the
+// alg in its header. Services use it to accept user tokens, signed by the
+// authentication service's private keys, alongside HS256 tokens from other services.
+type ByAlgorithm map[string]Verifier
+
+// Verify checks token with the Verifier for its algorithm.
+func (m ByAlgorithm) Verify(token string) (*Claims, error) {
+	segment, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	var head header
+	if err := decodeSegment(segment, &head); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	v, ok := m[head.Alg]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	return v.Verify(token)
+}
+
+// ServicesOnly wraps v so that it only accepts tokens services issued to themselves.
+// Services sign with the secret they share, and user tokens come from the
+// authentication service's keys, so an HS256 token naming anyone else is refused.
+func ServicesOnly(v Verifier) Verifier {
+	return servicesOnly{v}
+}
+
+type servicesOnly struct {
+	Verifier
+}
+
+func (s servicesOnly) Verify(token string) (*Claims, error) {
+	claims, err := s.Verifier.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if !claims.Service() {
+		return nil, ErrNotService
+	}
+
+	return claims, nil
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// HMAC signs and verifies HS256 JSON Web Tokens with a secret shared between services.
+type HMAC struct {
+	secret []byte
+}
+
+// NewHMAC returns an HMAC signer/verifier using secret.
+func NewHMAC(secret string) *HMAC {
+	return &HMAC{secret: []byte(secret)}
+}
+
+// Sign encodes claims as a compact HS256 JWT.
+func (h *HMAC) Sign(claims Claims) (string, error) {
+	head, err := encodeSegment(header{Alg: "HS256", Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	body, err := encodeSegment(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := head + "." + body
+
+	return signingInput + "." + h.signature(signingInput), nil
+}
+
+// Verify checks the signature and expiry of token and returns its claims.
+func (h *HMAC) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var head header
+	if err := decodeSegment(parts[0], &head); err != nil || head.Alg != "HS256" {
+		return nil, ErrInvalidToken
+	}
+
+	expected := h.signature(parts[0] + "." + parts[1])
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if claims.ExpiresAt != 0 && time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrExpiredToken
+	}
+
+	return &claims, nil
+}
+
+func (h *HMAC) signature(signingInput string) string {
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write([]byte(signingInput))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func encodeSegment(v any) (string, error) {
+	out, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(out), nil
+}
+
+func decodeSegment(segment string, v any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(raw, v)
+}
@@ -0,0 +1,88 @@
+package authz
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClaimsCan(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		permissions []string
+		permission  string
+		want        bool
+	}{
+		{"exact", []string{"logs:write"}, "logs:write", true},
+		{"other action", []string{"logs:write"}, "logs:read", false},
+		{"other resource", []string{"logs:write"}, "mail:send", false},
+		{"everything", []string{"*"}, "users:manage", true},
+		{"every action on the resource", []string{"logs:*"}, "logs:read", true},
+		{"every action on another resource", []string{"mail:*"}, "logs:read", false},
+		{"prefix is not a match", []string{"log:write"}, "logs:write", false},
+		{"none", nil, "logs:read", false},
+	} {
+		c := &Claims{Subject: "2", Permissions: tc.permissions}
+		if got := c.Can(tc.permission); got != tc.want {
+			t.Errorf("%s: Can(%q) = %v, want %v", tc.name, tc.permission, got, tc.want)
+		}
+	}
+}
+
+func TestHMACRoundTrip(t *testing.T) {
+	h := NewHMAC("secret")
+
+	token, err := h.Sign(Claims{Subject: "2", TenantID: "7", Permissions: []string{"logs:read"}, ExpiresAt: time.Now().Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := h.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Subject != "2" || claims.TenantID != "7" || !claims.Can("logs:read") {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+}
+
+func TestHMACRejects(t *testing.T) {
+	h := NewHMAC("secret")
+	valid := Claims{Subject: "2", ExpiresAt: time.Now().Add(time.Minute).Unix()}
+
+	good, err := h.Sign(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherKey, _ := NewHMAC("another secret").Sign(valid)
+	expired, _ := h.Sign(Claims{Subject: "2", ExpiresAt: time.Now().Add(-time.Minute).Unix()})
+
+	// the same claims, with a more generous body swapped in under the original signature
+	parts := strings.Split(good, ".")
+	body, _ := encodeSegment(Claims{Subject: "2", Permissions: []string{"*"}, ExpiresAt: valid.ExpiresAt})
+	tampered := parts[0] + "." + body + "." + parts[2]
+
+	// an unsigned token, as alg "none" would make
+	head, _ := encodeSegment(header{Alg: "none", Typ: "JWT"})
+	unsigned := head + "." + parts[1] + "."
+
+	for _, tc := range []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"signed with another key", otherKey, ErrInvalidToken},
+		{"expired", expired, ErrExpiredToken},
+		{"tampered", tampered, ErrInvalidToken},
+		{"unsigned", unsigned, ErrInvalidToken},
+		{"malformed", "not.a-token", ErrInvalidToken},
+		{"empty", "", ErrInvalidToken},
+	} {
+		if _, err := h.Verify(tc.token); !errors.Is(err, tc.err) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.err, err)
+		}
+	}
+}
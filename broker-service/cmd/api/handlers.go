@@ -1,18 +1,30 @@
 package main
 
 import (
+	"authz"
 	"broker/event"
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
 )
 
 type RequestPayload struct {
-	Action string      `json:"action"`
-	Auth   AuthPayload `json:"auth,omitempty"`
-	Log    LogPayload  `json:"log,omitempty"`
-	Mail   MailPayload `json:"mail,omitempty"`
+	Action   string          `json:"action"`
+	Auth     AuthPayload     `json:"auth,omitempty"`
+	MFA      MFAPayload      `json:"mfa,omitempty"`
+	Refresh  RefreshPayload  `json:"refresh,omitempty"`
+	Password PasswordPayload `json:"password,omitempty"`
+	Log      LogPayload      `json:"log,omitempty"`
+	Mail     MailPayload     `json:"mail,omitempty"`
+	Read     ReadPayload     `json:"read,omitempty"`
 }
 
 type MailPayload struct {
@@ -27,9 +39,71 @@ type AuthPayload struct {
 	Password string `json:"password"`
 }
 
+// MFAPayload completes a login for a user with two-factor authentication turned on.
+// ChallengeToken comes from the response to the "auth" action.
+type MFAPayload struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code,omitempty"`
+	RecoveryCode   string `json:"recovery_code,omitempty"`
+}
+
+// RefreshPayload trades the refresh token from a login for a new access token
+type RefreshPayload struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// PasswordPayload changes the caller's password
+type PasswordPayload struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// LogPayload is a log entry. Only Name and Data are required; entries that carry
+// nothing else are logged as they always were. Severity is one of debug, info (the
+// default), warning, error or critical, and Attributes may hold any JSON object,
+// which stays queryable in the logger service.
 type LogPayload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	Name       string         `json:"name"`
+	Data       string         `json:"data"`
+	Tenant     string         `json:"tenant,omitempty"`
+	Severity   string         `json:"severity,omitempty"`
+	Source     string         `json:"source,omitempty"`
+	Host       string         `json:"host,omitempty"`
+	RequestID  string         `json:"request_id,omitempty"`
+	TraceID    string         `json:"trace_id,omitempty"`
+	UserID     string         `json:"user_id,omitempty"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+}
+
+// routingKeys are the queue routing keys for each severity an entry may have
+var routingKeys = map[string]string{
+	"":         "log.INFO",
+	"debug":    "log.INFO",
+	"info":     "log.INFO",
+	"warn":     "log.WARNING",
+	"warning":  "log.WARNING",
+	"error":    "log.ERROR",
+	"critical": "log.ERROR",
+}
+
+// ReadPayload queries the caller's organization's log entries. Every field is
+// optional; Names, Severities and Sources match any of the values given, and Fields
+// picks the fields returned. From and To are RFC 3339 times, Sort is "asc" or "desc",
+// and Cursor is the next_cursor of the previous page.
+type ReadPayload struct {
+	Names      []string `json:"names,omitempty"`
+	Severities []string `json:"severities,omitempty"`
+	From       string   `json:"from,omitempty"`
+	To         string   `json:"to,omitempty"`
+	Text       string   `json:"text,omitempty"`
+	Sources    []string `json:"sources,omitempty"`
+	RequestID  string   `json:"request_id,omitempty"`
+	TraceID    string   `json:"trace_id,omitempty"`
+	UserID     string   `json:"user_id,omitempty"`
+	Sort       string   `json:"sort,omitempty"`
+	Cursor     string   `json:"cursor,omitempty"`
+	Limit      int      `json:"limit,omitempty"`
+	Fields     []string `json:"fields,omitempty"`
 }
 
 func (app *Config) Broker(w http.ResponseWriter, r *http.Request) {
@@ -52,13 +126,73 @@ func (app *Config) HandleSubmission(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// support staff acting as a user leave a trail under both ids
+	if claims, ok := authz.FromContext(r.Context()); ok && claims.Impersonated() {
+		err := app.pushToQueue(LogPayload{
+			Name:      "impersonation",
+			Data:      fmt.Sprintf("user %s acting as user %s: %s", claims.Actor.Subject, claims.Subject, requestPayload.Action),
+			Tenant:    claims.TenantID,
+			Source:    "broker-service",
+			RequestID: middleware.GetReqID(r.Context()),
+			UserID:    claims.Subject,
+			Attributes: map[string]any{
+				"actor":  claims.Actor.Subject,
+				"action": requestPayload.Action,
+			},
+		})
+		if err != nil {
+			app.errorJSON(w, errors.New("could not record the request"), http.StatusServiceUnavailable)
+			return
+		}
+	}
+
 	switch requestPayload.Action {
 	case "auth":
-		app.authenticate(w, requestPayload.Auth)
+		app.authenticate(w, r, "/authenticate", requestPayload.Auth)
+	case "mfa":
+		app.authenticate(w, r, "/authenticate/mfa", requestPayload.MFA)
+	case "refresh":
+		app.authenticate(w, r, "/authenticate/refresh", requestPayload.Refresh)
+	case "password":
+		// a password belongs to the user, not to an organization, so this one needs
+		// no tenant
+		if !app.authorizeDirect(w, r) {
+			return
+		}
+		app.changePassword(w, r, requestPayload.Password)
 	case "log":
+		if !app.authorize(w, r, "logs:write") {
+			return
+		}
+		tenant, ok := app.tenant(w, r)
+		if !ok {
+			return
+		}
+		// entries always belong to the caller's organization, whatever they claim
+		requestPayload.Log.Tenant = tenant
+		if claims, ok := authz.FromContext(r.Context()); ok && !claims.Service() {
+			requestPayload.Log.UserID = claims.Subject
+		}
+		if requestPayload.Log.RequestID == "" {
+			requestPayload.Log.RequestID = middleware.GetReqID(r.Context())
+		}
 		app.logEventViaRabbit(w, requestPayload.Log)
 	case "mail":
-		app.sendMail(w, requestPayload.Mail)
+		if !app.authorize(w, r, "mail:send") {
+			return
+		}
+		if _, ok := app.tenant(w, r); !ok {
+			return
+		}
+		app.sendMail(w, r, requestPayload.Mail)
+	case "read":
+		if !app.authorize(w, r, "logs:read") {
+			return
+		}
+		if _, ok := app.tenant(w, r); !ok {
+			return
+		}
+		app.readLogs(w, r, requestPayload.Read)
 	default:
 		app.errorJSON(w, errors.New("unknown action"))
 	}
@@ -99,18 +233,24 @@ func (app *Config) logItem(w http.ResponseWriter, entry LogPayload) {
 
 }
 
-// authenticate calls the authentication microservice and sends back the appropriate response
-func (app *Config) authenticate(w http.ResponseWriter, a AuthPayload) {
+// authenticate calls the authentication microservice and sends back the appropriate response.
+// path is the password or second factor step of a login, or a token refresh.
+func (app *Config) authenticate(w http.ResponseWriter, r *http.Request, path string, a any) {
 	// create some json we'll send to the auth microservice
 	jsonData, _ := json.MarshalIndent(a, "", "\t")
 
 	// call the service
-	request, err := http.NewRequest("POST", "http://authentication-service/authenticate", bytes.NewBuffer(jsonData))
+	request, err := http.NewRequest("POST", "http://authentication-service"+path, bytes.NewBuffer(jsonData))
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
+	// the auth service throttles failed logins per client, so tell it who the client is
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		request.Header.Set("X-Forwarded-For", ip)
+	}
+
 	client := &http.Client{}
 	response, err := client.Do(request)
 	if err != nil {
@@ -123,6 +263,14 @@ func (app *Config) authenticate(w http.ResponseWriter, a AuthPayload) {
 	if response.StatusCode == http.StatusUnauthorized {
 		app.errorJSON(w, errors.New("invalid credentials"))
 		return
+	} else if response.StatusCode == http.StatusTooManyRequests {
+		w.Header().Set("Retry-After", response.Header.Get("Retry-After"))
+		app.errorJSON(w, errors.New("too many failed attempts, try again later"), http.StatusTooManyRequests)
+		return
+	} else if response.StatusCode == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", response.Header.Get("Retry-After"))
+		app.errorJSON(w, errors.New("the authentication service is busy, try again shortly"), http.StatusServiceUnavailable)
+		return
 	} else if response.StatusCode != http.StatusAccepted {
 		app.errorJSON(w, errors.New("error calling auth service"))
 		return
@@ -148,10 +296,55 @@ func (app *Config) authenticate(w http.ResponseWriter, a AuthPayload) {
 	payload.Message = "Authenticated!"
 	payload.Data = jsonFromService.Data
 
+	// the password was right, but the user still has to supply a second factor
+	if challenge, ok := jsonFromService.Data.(map[string]any); ok && challenge["mfa_required"] == true {
+		payload.Message = jsonFromService.Message
+	}
+
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
 
-func (app *Config) sendMail(w http.ResponseWriter, msg MailPayload) {
+// changePassword calls the authentication microservice with the caller's token to
+// change their password
+func (app *Config) changePassword(w http.ResponseWriter, r *http.Request, p PasswordPayload) {
+	jsonData, _ := json.MarshalIndent(p, "", "\t")
+
+	request, err := http.NewRequest("PUT", "http://authentication-service/password", bytes.NewBuffer(jsonData))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", r.Header.Get("Authorization"))
+
+	client := &http.Client{}
+	response, err := client.Do(request)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	defer response.Body.Close()
+
+	var jsonFromService jsonResponse
+	_ = json.NewDecoder(response.Body).Decode(&jsonFromService)
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		app.writeJSON(w, http.StatusOK, jsonResponse{Error: false, Message: "password changed"})
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden:
+		app.errorJSON(w, errors.New(jsonFromService.Message), response.StatusCode)
+	case http.StatusServiceUnavailable:
+		w.Header().Set("Retry-After", response.Header.Get("Retry-After"))
+		app.errorJSON(w, errors.New("the authentication service is busy, try again shortly"), http.StatusServiceUnavailable)
+	default:
+		app.errorJSON(w, errors.New("error calling auth service"))
+	}
+}
+
+// sendMail calls the mail microservice, passing along the caller's token so that
+// the mail service can check it too
+func (app *Config) sendMail(w http.ResponseWriter, r *http.Request, msg MailPayload) {
 	jsonData, _ := json.MarshalIndent(msg, "", "\t")
 
 	// call the mail service
@@ -165,6 +358,7 @@ func (app *Config) sendMail(w http.ResponseWriter, msg MailPayload) {
 	}
 
 	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", r.Header.Get("Authorization"))
 
 	client := &http.Client{}
 	response, err := client.Do(request)
@@ -189,9 +383,80 @@ func (app *Config) sendMail(w http.ResponseWriter, msg MailPayload) {
 
 }
 
+// readLogs queries the logger microservice with the caller's token, which decides
+// whose entries come back, and sends back the page it returns
+func (app *Config) readLogs(w http.ResponseWriter, r *http.Request, q ReadPayload) {
+	params := url.Values{}
+	for _, name := range q.Names {
+		params.Add("name", name)
+	}
+	for _, severity := range q.Severities {
+		params.Add("severity", severity)
+	}
+	for _, source := range q.Sources {
+		params.Add("source", source)
+	}
+	for key, value := range map[string]string{
+		"from":       q.From,
+		"to":         q.To,
+		"q":          q.Text,
+		"request_id": q.RequestID,
+		"trace_id":   q.TraceID,
+		"user_id":    q.UserID,
+		"sort":       q.Sort,
+		"cursor":     q.Cursor,
+		"fields":     strings.Join(q.Fields, ","),
+	} {
+		if value != "" {
+			params.Set(key, value)
+		}
+	}
+	if q.Limit > 0 {
+		params.Set("limit", strconv.Itoa(q.Limit))
+	}
+
+	request, err := http.NewRequest("GET", "http://logger-service/logs?"+params.Encode(), nil)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	request.Header.Set("Authorization", r.Header.Get("Authorization"))
+
+	client := &http.Client{}
+	response, err := client.Do(request)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	defer response.Body.Close()
+
+	var jsonFromService jsonResponse
+	_ = json.NewDecoder(response.Body).Decode(&jsonFromService)
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		app.writeJSON(w, http.StatusOK, jsonResponse{
+			Error:   false,
+			Message: jsonFromService.Message,
+			Data:    jsonFromService.Data,
+		})
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden:
+		app.errorJSON(w, errors.New(jsonFromService.Message), response.StatusCode)
+	default:
+		app.errorJSON(w, errors.New("error calling logger service"))
+	}
+}
+
 // logEventViaRabbit logs an event using the logger-service. It makes the call by pushing the data to RabbitMQ.
 func (app *Config) logEventViaRabbit(w http.ResponseWriter, l LogPayload) {
-	err := app.pushToQueue(l.Name, l.Data)
+	l.Severity = strings.ToLower(l.Severity)
+	if _, ok := routingKeys[l.Severity]; !ok {
+		app.errorJSON(w, errors.New("severity must be one of debug, info, warning, error or critical"))
+		return
+	}
+
+	err := app.pushToQueue(l)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
@@ -204,20 +469,20 @@ func (app *Config) logEventViaRabbit(w http.ResponseWriter, l LogPayload) {
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
 
-// pushToQueue pushes a message into RabbitMQ
-func (app *Config) pushToQueue(name, msg string) error {
+// pushToQueue pushes a log entry into RabbitMQ, routed by its severity
+func (app *Config) pushToQueue(entry LogPayload) error {
 	emitter, err := event.NewEventEmitter(app.Rabbit)
 	if err != nil {
 		return err
 	}
 
-	payload := LogPayload{
-		Name: name,
-		Data: msg,
+	key, ok := routingKeys[entry.Severity]
+	if !ok {
+		key = routingKeys[""]
 	}
 
-	j, _ := json.MarshalIndent(&payload, "", "\t")
-	err = emitter.Push(string(j), "log.INFO")
+	j, _ := json.MarshalIndent(&entry, "", "\t")
+	err = emitter.Push(string(j), key)
 	if err != nil {
 		return err
 	}
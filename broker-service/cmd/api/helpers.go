@@ -1,6 +1,7 @@
 package main
 
 import (
+	"authz"
 	"encoding/json"
 	"errors"
 	"io"
@@ -71,3 +72,43 @@ func (app *Config) errorJSON(w http.ResponseWriter, err error, status ...int) er
 
 	return app.writeJSON(w, statusCode, payload)
 }
+
+// authorize checks that the caller holds permission, and writes an error response
+// if they do not. It returns true when the request may go ahead.
+func (app *Config) authorize(w http.ResponseWriter, r *http.Request, permission string) bool {
+	err := authz.Authorize(r.Context(), permission)
+	if err != nil {
+		app.errorJSON(w, err, authz.StatusCode(err))
+		return false
+	}
+
+	return true
+}
+
+// authorizeDirect checks that the caller is signed in as themselves rather than
+// impersonating someone, for actions only a user may take for themselves.
+func (app *Config) authorizeDirect(w http.ResponseWriter, r *http.Request) bool {
+	err := authz.AuthorizeDirect(r.Context())
+	if err != nil {
+		app.errorJSON(w, err, authz.StatusCode(err))
+		return false
+	}
+
+	return true
+}
+
+// tenant returns the organization the caller's token belongs to. Log entries and mail
+// records belong to an organization, so actions on them refuse tokens without one.
+func (app *Config) tenant(w http.ResponseWriter, r *http.Request) (string, bool) {
+	tenant, err := authz.ResolveTenant(r.Context(), "")
+	if err == nil && tenant == "" {
+		err = authz.ErrNoTenant
+	}
+
+	if err != nil {
+		app.errorJSON(w, err, authz.StatusCode(err))
+		return "", false
+	}
+
+	return tenant, true
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"authz"
+	"broker/event"
 	"fmt"
 	"log"
 	"math"
@@ -14,7 +16,9 @@ import (
 const webPort = "80"
 
 type Config struct {
-	Rabbit *amqp.Connection
+	Rabbit   *amqp.Connection
+	Verifier authz.Verifier
+	APIKeys  *authz.KeyExchanger
 }
 
 func main() {
@@ -26,8 +30,45 @@ func main() {
 	}
 	defer rabbitConn.Close()
 
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		log.Println("JWT_SECRET is not set")
+		os.Exit(1)
+	}
+
+	jwksURL := os.Getenv("JWKS_URL")
+	if jwksURL == "" {
+		jwksURL = authz.DefaultJWKSURL
+	}
+
+	tokenURL := os.Getenv("TOKEN_URL")
+	if tokenURL == "" {
+		tokenURL = authz.DefaultTokenURL
+	}
+
+	// sessions signed out at the authentication service are refused here as soon as
+	// the revocation arrives, rather than when their tokens expire
+	revocations := authz.NewRevocations()
+	go func() {
+		err := event.ListenForRevocations(rabbitConn, revocations)
+		if err != nil {
+			log.Println("Error listening for session revocations:", err)
+		}
+	}()
+
+	// user tokens are checked against the authentication service's published keys,
+	// which are cached and fetched again when a token names a key we haven't seen
+	verifier := authz.WithRevocations(authz.ByAlgorithm{
+		"RS256": authz.NewJWKSVerifier(jwksURL),
+		"HS256": authz.ServicesOnly(authz.NewHMAC(secret)),
+	}, revocations)
+
+	// jobs without a user send an API key instead, which the authentication service
+	// trades for a token carrying the key's scopes
 	app := Config{
-		Rabbit: rabbitConn,
+		Rabbit:   rabbitConn,
+		Verifier: verifier,
+		APIKeys:  authz.NewKeyExchanger(tokenURL, verifier),
 	}
 
 	log.Printf("Starting broker service on port %s\n", webPort)
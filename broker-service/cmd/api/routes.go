@@ -1,6 +1,7 @@
 package main
 
 import (
+	"authz"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -15,7 +16,7 @@ func (app *Config) routes() http.Handler {
 	mux.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-API-Key", "X-CSRF-Token"},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: true,
 		MaxAge:           300,
@@ -23,6 +24,13 @@ func (app *Config) routes() http.Handler {
 
 	mux.Use(middleware.Heartbeat("/ping"))
 
+	// give every request an id, taken from X-Request-Id when the caller sends one, so
+	// that the log entries it leads to can be found together
+	mux.Use(middleware.RequestID)
+
+	// read the caller's token or API key, if any; each action decides whether it needs one
+	mux.Use(authz.AuthenticateWithAPIKeys(app.Verifier, app.APIKeys))
+
 	mux.Post("/", app.Broker)
 
 	mux.Post("/handle", app.HandleSubmission)
@@ -0,0 +1,61 @@
+package event
+
+import (
+	"authz"
+	"encoding/json"
+	"log"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ListenForRevocations applies the session revocations published by the
+// authentication service to list, until the connection closes. Each broker gets
+// its own queue, so every replica sees every revocation.
+func ListenForRevocations(conn *amqp.Connection, list *authz.Revocations) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	err = ch.ExchangeDeclare(
+		authz.RevocationExchange, // name
+		"topic",                  // type
+		true,                     // durable?
+		false,                    // auto-deleted?
+		false,                    // internal?
+		false,                    // no-wait?
+		nil,                      // arguments?
+	)
+	if err != nil {
+		return err
+	}
+
+	q, err := declareRandomQueue(ch)
+	if err != nil {
+		return err
+	}
+
+	err = ch.QueueBind(q.Name, authz.RevocationKey, authz.RevocationExchange, false, nil)
+	if err != nil {
+		return err
+	}
+
+	messages, err := ch.Consume(q.Name, "", true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	for d := range messages {
+		var rev authz.Revocation
+		if err := json.Unmarshal(d.Body, &rev); err != nil {
+			log.Println("Error reading session revocation:", err)
+			continue
+		}
+
+		list.Add(rev)
+		log.Printf("Revoked %d sessions for user %d\n", len(rev.SessionIDs), rev.UserID)
+	}
+
+	return nil
+}
@@ -3,7 +3,10 @@ module broker
 go 1.19
 
 require (
+	authz v0.0.0-00010101000000-000000000000
 	github.com/go-chi/chi/v5 v5.1.0 // indirect
 	github.com/go-chi/cors v1.2.1 // indirect
 	github.com/rabbitmq/amqp091-go v1.10.0 // indirect
 )
+
+replace authz => ../authz
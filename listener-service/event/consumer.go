@@ -1,11 +1,14 @@
 package event
 
 import (
+	"authz"
 	"bytes"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
@@ -16,17 +19,23 @@ Consumer 结构体定义了一个 RabbitMQ 消费者，包含连接和队列名
 ### 字段说明 (Field Description)
 - `conn *amqp.Connection`：RabbitMQ 的连接对象，用于在消费者和 RabbitMQ 服务器之间建立连接。
 - `queueName string`：队列名称，用于指定消费者监听的队列。
+- `tokens authz.Signer`：用于签发服务令牌，调用日志服务时携带。
+- `users map[string][]UserHandler`：按事件类型注册的用户事件处理函数。
 
 ### Struct Description
 The `Consumer` struct defines a RabbitMQ consumer with connection and queue name properties.
 
 - `conn *amqp.Connection`: RabbitMQ connection object used to establish communication between the consumer and the RabbitMQ server.
 - `queueName string`: The name of the queue that the consumer is listening to.
+- `tokens authz.Signer`: Signs the service token sent along with calls to the logger service.
+- `users map[string][]UserHandler`: User event handlers, by event type.
 */
 
 type Consumer struct {
 	conn      *amqp.Connection
 	queueName string
+	tokens    authz.Signer
+	users     map[string][]UserHandler
 }
 
 /*
@@ -35,6 +44,8 @@ NewConsumer 函数用于创建一个新的 `Consumer` 实例，并设置交换
 ### 函数参数 (Function Parameters)
 - `conn *amqp.Connection`：RabbitMQ 连接对象，用于在消费者和 RabbitMQ 服务器之间建立连接。
   - `conn *amqp.Connection`: RabbitMQ connection object used to establish communication between the consumer and the RabbitMQ server.
+- `tokens authz.Signer`：用于签发服务令牌。
+  - `tokens authz.Signer`: Used to sign service tokens.
 
 ### 返回值 (Return Value)
 - `Consumer`：新创建的 `Consumer` 实例。
@@ -47,9 +58,10 @@ NewConsumer 函数用于创建一个新的 `Consumer` 实例，并设置交换
 This function initializes the `Consumer` struct and calls the `setup` function to create and configure the RabbitMQ exchange. It returns the configured consumer object or an error.
 */
 
-func NewConsumer(conn *amqp.Connection) (Consumer, error) {
+func NewConsumer(conn *amqp.Connection, tokens authz.Signer) (Consumer, error) {
 	consumer := Consumer{
-		conn: conn,
+		conn:   conn,
+		tokens: tokens,
 	}
 
 	// 调用 setup 函数进行交换机设置 (Call setup function for exchange setup)
@@ -88,16 +100,28 @@ Payload 结构体定义了消息的载荷格式。
 ### 字段说明 (Field Description)
 - `Name string`：消息名称，用于标识消息的类型。
 - `Data string`：消息内容，存储具体的消息数据。
+- `Tenant string`：消息所属组织（租户）的 ID，原样转交给日志服务。
+- `Severity`、`Source`、`Host`、`RequestID`、`TraceID`、`UserID`、`Attributes`：结构化字段，都是可选的。消息没有给出级别时，使用路由键中的级别，例如 `log.WARNING` 对应 `warning`。
 
 ### Struct Description
 The `Payload` struct defines the format of the message payload.
 
 - `Name string`: The name of the message used to identify the type of message.
 - `Data string`: The content of the message storing the actual data.
+- `Tenant string`: The id of the organization (tenant) the message belongs to, passed on to the log service as is.
+- `Severity`, `Source`, `Host`, `RequestID`, `TraceID`, `UserID`, `Attributes`: structured fields, all optional. When a message gives no severity, the one in its routing key is used, e.g. `warning` for `log.WARNING`.
 */
 type Payload struct {
-	Name string `json:"name"` // 消息名称 (Message name)
-	Data string `json:"data"` // 消息内容 (Message content)
+	Name       string         `json:"name"`                 // 消息名称 (Message name)
+	Data       string         `json:"data"`                 // 消息内容 (Message content)
+	Tenant     string         `json:"tenant,omitempty"`     // 所属租户 (Owning tenant)
+	Severity   string         `json:"severity,omitempty"`   // 级别 (Severity)
+	Source     string         `json:"source,omitempty"`     // 产生消息的服务 (Service that produced it)
+	Host       string         `json:"host,omitempty"`       // 产生消息的主机 (Host that produced it)
+	RequestID  string         `json:"request_id,omitempty"` // 请求ID (Request id)
+	TraceID    string         `json:"trace_id,omitempty"`   // 追踪ID (Trace id)
+	UserID     string         `json:"user_id,omitempty"`    // 涉及的用户 (User concerned)
+	Attributes map[string]any `json:"attributes,omitempty"` // 任意属性 (Free-form attributes)
 }
 
 /*
@@ -167,8 +191,13 @@ func (consumer *Consumer) Listen(topics []string) error {
 			var payload Payload
 			_ = json.Unmarshal(d.Body, &payload)
 
+			// 没有级别的消息使用路由键中的级别 (Messages without a severity take the one in their routing key)
+			if payload.Severity == "" {
+				payload.Severity = severityFromKey(d.RoutingKey)
+			}
+
 			// 异步处理每条消息 (Asynchronously handle each message)
-			go handlePayload(payload)
+			go consumer.handlePayload(payload)
 		}
 	}()
 
@@ -179,6 +208,17 @@ func (consumer *Consumer) Listen(topics []string) error {
 	return nil
 }
 
+// severityFromKey 从 log.INFO 这样的路由键中取出级别，例如 info；其他路由键返回空字符串。
+// severityFromKey takes the severity, e.g. info, out of a routing key like log.INFO, returning an empty
+// string for other keys.
+func severityFromKey(key string) string {
+	if !strings.HasPrefix(key, "log.") {
+		return ""
+	}
+
+	return strings.ToLower(strings.TrimPrefix(key, "log."))
+}
+
 /*
 handlePayload 函数用于处理接收到的消息载荷。
 
@@ -197,11 +237,11 @@ Executes corresponding logic based on the value of `payload.Name`. The current i
 - `auth`: Performs authentication operation (not implemented in the example).
 - Others: Logs the message.
 */
-func handlePayload(payload Payload) {
+func (consumer *Consumer) handlePayload(payload Payload) {
 	switch payload.Name {
 	case "log", "event":
 		// 记录消息 (Log the message)
-		err := logEvent(payload)
+		err := consumer.logEvent(payload)
 		if err != nil {
 			log.Println(err)
 		}
@@ -213,7 +253,7 @@ func handlePayload(payload Payload) {
 
 	default:
 		// 默认处理逻辑 (Default handling logic)
-		err := logEvent(payload)
+		err := consumer.logEvent(payload)
 		if err != nil {
 			log.Println(err)
 		}
@@ -232,10 +272,10 @@ logEvent 函数用于将消息记录到日志服务中。
   - `error`: Any error that occurs during logging the message.
 
 ### 函数描述 (Function Description)
-该函数将消息载荷对象序列化为 JSON 格式，并将其发送到日志服务 `http://logger-service/log`。如果 HTTP 响应状态码不是 `202 Accepted`，则返回错误。
-This function serializes the message payload object into JSON format and sends it to the log service `http://logger-service/log`. If the HTTP response status code is not `202 Accepted`, it returns an error.
+该函数将消息载荷对象序列化为 JSON 格式，并携带服务令牌将其发送到日志服务 `http://logger-service/log`。如果 HTTP 响应状态码不是 `202 Accepted`，则返回错误。
+This function serializes the message payload object into JSON format and sends it, along with a service token, to the log service `http://logger-service/log`. If the HTTP response status code is not `202 Accepted`, it returns an error.
 */
-func logEvent(entry Payload) error {
+func (consumer *Consumer) logEvent(entry Payload) error {
 	// 将消息载荷对象序列化为 JSON 格式 (Serialize the message payload object into JSON format)
 	jsonData, _ := json.MarshalIndent(entry, "", "\t")
 
@@ -247,8 +287,15 @@ func logEvent(entry Payload) error {
 		return err
 	}
 
-	// 设置请求头为 JSON 格式 (Set the request header as JSON format)
+	// 签发一个短期有效的服务令牌 (Sign a short lived service token)
+	token, err := consumer.serviceToken("logs:write")
+	if err != nil {
+		return err
+	}
+
+	// 设置请求头为 JSON 格式，并携带令牌 (Set the request header as JSON format and attach the token)
 	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "Bearer "+token)
 
 	client := &http.Client{} // 创建 HTTP 客户端 (Create HTTP client)
 
@@ -266,3 +313,17 @@ func logEvent(entry Payload) error {
 
 	return nil
 }
+
+// serviceToken 签发一个短期有效的令牌，用于本服务调用其他服务时表明身份。
+// serviceToken signs a short lived token identifying this service to the services it calls.
+func (consumer *Consumer) serviceToken(permissions ...string) (string, error) {
+	now := time.Now()
+
+	return consumer.tokens.Sign(authz.Claims{
+		Subject:     "listener-service",
+		Issuer:      "listener-service",
+		Permissions: permissions,
+		IssuedAt:    now.Unix(),
+		ExpiresAt:   now.Add(time.Minute).Unix(),
+	})
+}
@@ -0,0 +1,218 @@
+package event
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// usersExchange 是认证服务发布用户生命周期事件的主题交换机。
+	// usersExchange is the topic exchange the authentication service publishes user lifecycle events on.
+	usersExchange = "users_topic"
+
+	// usersQueue 是持久化的具名队列，监听服务停机期间的事件不会丢失，多个副本共享同一队列，每个事件只处理一次。
+	// usersQueue is a durable, named queue: events published while the listener is down are kept,
+	// and replicas share it so that each event is handled once.
+	usersQueue = "listener.users"
+
+	// userEventVersion 是我们能理解的事件结构版本。
+	// userEventVersion is the version of the event schema we understand.
+	userEventVersion = 1
+
+	mailServiceURL = "http://mail-service/send"
+)
+
+/*
+UserEvent 是认证服务发布的用户生命周期事件，类型为 user.created、user.updated、user.deactivated、user.deleted、
+user.password_changed 或 user.erased。user.erased 在用户的个人数据被删除或化名处理后发布，其中的邮箱是化名。
+
+UserEvent is a user lifecycle event published by the authentication service. Its type is one of
+user.created, user.updated, user.deactivated, user.deleted, user.password_changed or user.erased.
+user.erased is published once a user's personal data has been deleted or pseudonymised, and carries
+the pseudonym in place of their email.
+*/
+type UserEvent struct {
+	ID         string    `json:"id"`          // 事件 ID，用于识别重复投递 (Event id, to spot redeliveries)
+	Type       string    `json:"type"`        // 事件类型，同时也是路由键 (Event type, also the routing key)
+	Version    int       `json:"version"`     // 结构版本 (Schema version)
+	OccurredAt time.Time `json:"occurred_at"` // 发生时间 (When it happened)
+	User       struct {
+		ID        int    `json:"id"`
+		Email     string `json:"email"`
+		FirstName string `json:"first_name,omitempty"`
+		LastName  string `json:"last_name,omitempty"`
+		Active    bool   `json:"active"`
+	} `json:"user"`
+}
+
+// UserHandler 对一个用户事件作出反应。返回错误时，该事件会重新入队重试一次，所以处理函数应当可以重复执行。
+// UserHandler reacts to a user event. If it returns an error the event is requeued and tried once more,
+// so handlers should be safe to run twice.
+type UserHandler func(consumer *Consumer, e UserEvent) error
+
+// OnUser 为某一类型的用户事件注册处理函数，必须在 ListenUsers 之前调用。
+// OnUser registers a handler for one type of user event. It must be called before ListenUsers.
+func (consumer *Consumer) OnUser(eventType string, handler UserHandler) {
+	if consumer.users == nil {
+		consumer.users = make(map[string][]UserHandler)
+	}
+
+	consumer.users[eventType] = append(consumer.users[eventType], handler)
+}
+
+/*
+ListenUsers 绑定 users_topic 上的所有 user.* 事件，并把每个事件交给为其类型注册的处理函数，直到连接关闭。
+
+### 函数描述 (Function Description)
+事件在所有处理函数成功后才会被确认；任何一个失败时事件会重新入队一次。无法解析或版本未知的事件会被丢弃。
+
+ListenUsers binds every user.* event on users_topic and hands each one to the handlers registered for
+its type, until the connection closes. An event is acknowledged once every handler has succeeded, and
+requeued once if any of them fails. Events we cannot parse, or whose version we don't know, are dropped.
+*/
+func (consumer *Consumer) ListenUsers() error {
+	ch, err := consumer.conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close() // 函数结束时关闭通道 (Close the channel when function ends)
+
+	err = ch.ExchangeDeclare(
+		usersExchange, // name
+		"topic",       // type
+		true,          // durable?
+		false,         // auto-deleted?
+		false,         // internal?
+		false,         // no-wait?
+		nil,           // arguments?
+	)
+	if err != nil {
+		return err
+	}
+
+	q, err := ch.QueueDeclare(
+		usersQueue, // name?
+		true,       // durable?
+		false,      // delete when unused?
+		false,      // exclusive?
+		false,      // no-wait?
+		nil,        // arguments?
+	)
+	if err != nil {
+		return err
+	}
+
+	err = ch.QueueBind(q.Name, "user.*", usersExchange, false, nil)
+	if err != nil {
+		return err
+	}
+
+	messages, err := ch.Consume(
+		q.Name, // 队列名称 (Queue name)
+		"",     // 消费者标签 (Consumer tag)
+		false,  // 处理完成后手动确认 (Acknowledge by hand once handled)
+		false,  // 是否为独占 (Exclusive?)
+		false,  // 是否在本地消费 (No-local?)
+		false,  // 是否等待服务器响应 (No-wait?)
+		nil,    // 额外参数 (Arguments)
+	)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Waiting for message [Exchange, Queue] [%s, %s]\n", usersExchange, q.Name)
+
+	for d := range messages {
+		var e UserEvent
+		if err := json.Unmarshal(d.Body, &e); err != nil || e.Version != userEventVersion {
+			log.Printf("Dropping user event %q: version %d, %v\n", d.RoutingKey, e.Version, err)
+			_ = d.Nack(false, false)
+			continue
+		}
+
+		if err := consumer.handleUserEvent(e); err != nil {
+			log.Printf("Error handling %s %s: %v\n", e.Type, e.ID, err)
+			_ = d.Nack(false, !d.Redelivered) // 只重试一次 (Only retry once)
+			continue
+		}
+
+		_ = d.Ack(false)
+	}
+
+	return nil
+}
+
+// handleUserEvent 依次运行为事件类型注册的处理函数。
+// handleUserEvent runs the handlers registered for the event's type, in order.
+func (consumer *Consumer) handleUserEvent(e UserEvent) error {
+	for _, handle := range consumer.users[e.Type] {
+		if err := handle(consumer, e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RecordUserEvent 把用户事件写入日志服务，留作审计记录。
+// RecordUserEvent writes the user event to the logger service, as an audit trail.
+func RecordUserEvent(consumer *Consumer, e UserEvent) error {
+	return consumer.logEvent(Payload{
+		Name:     "users",
+		Data:     fmt.Sprintf("%s: user %d (%s)", e.Type, e.User.ID, e.User.Email),
+		Severity: "info",
+		Source:   "authentication-service",
+		UserID:   strconv.Itoa(e.User.ID),
+		Attributes: map[string]any{
+			"event_id":    e.ID,
+			"event_type":  e.Type,
+			"occurred_at": e.OccurredAt,
+		},
+	})
+}
+
+// SendWelcomeMail 通过邮件服务向新用户发送欢迎邮件。
+// SendWelcomeMail sends a new user a welcome message through the mail service.
+func SendWelcomeMail(consumer *Consumer, e UserEvent) error {
+	name := e.User.FirstName
+	if name == "" {
+		name = "there"
+	}
+
+	// 发件人留空，由邮件服务使用默认地址 (From is left empty so the mail service uses its default address)
+	jsonData, _ := json.Marshal(map[string]string{
+		"to":      e.User.Email,
+		"subject": "Welcome!",
+		"message": fmt.Sprintf("Hi %s,\n\nYour account has been created. Welcome aboard!", name),
+	})
+
+	request, err := http.NewRequest("POST", mailServiceURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+
+	token, err := consumer.serviceToken("mail:send")
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("mail service returned %s", response.Status)
+	}
+
+	return nil
+}
@@ -2,4 +2,9 @@ module listener
 
 go 1.18
 
-require github.com/rabbitmq/amqp091-go v1.10.0 // indirect
+require (
+	authz v0.0.0-00010101000000-000000000000
+	github.com/rabbitmq/amqp091-go v1.10.0 // indirect
+)
+
+replace authz => ../authz
@@ -5,6 +5,7 @@ package main
 // This code is written in Go and interacts with RabbitMQ to listen for and consume messages from the queue. The main logic is to establish a connection with RabbitMQ and create a consumer to handle the messages.
 
 import (
+	"authz"
 	"fmt"
 	"listener/event"
 	"log"
@@ -33,13 +34,35 @@ func main() {
 	// Start listening for messages
 	log.Println("Listening for and consuming RabbitMQ messages...")
 
+	// 读取 JWT_SECRET，用于签发调用日志服务时使用的服务令牌
+	// Read JWT_SECRET, used to sign the service token sent to the logger service
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		log.Println("JWT_SECRET is not set")
+		os.Exit(1)
+	}
+
 	// 创建消费者
 	// Create a consumer
-	consumer, err := event.NewConsumer(rabbitConn)
+	consumer, err := event.NewConsumer(rabbitConn, authz.NewHMAC(secret))
 	if err != nil {
 		panic(err) // 处理创建消费者时的错误
 	}
 
+	// 对用户生命周期事件作出反应：记录每个事件，并向新用户发送欢迎邮件
+	// React to user lifecycle events: record every one, and welcome new users
+	for _, t := range []string{"user.created", "user.updated", "user.deactivated", "user.deleted", "user.password_changed", "user.erased"} {
+		consumer.OnUser(t, event.RecordUserEvent)
+	}
+	consumer.OnUser("user.created", event.SendWelcomeMail)
+
+	go func() {
+		err := consumer.ListenUsers()
+		if err != nil {
+			log.Println(err) // 处理消费用户事件时的错误 (Handle errors while consuming user events)
+		}
+	}()
+
 	// 监听队列并消费事件
 	// Watch the queue and consume events
 	err = consumer.Listen([]string{"log.INFO", "log.WARNING", "log.ERROR"})
@@ -55,8 +78,8 @@ func main() {
 // Calls the connect() function to try connecting to RabbitMQ. If the connection fails, it logs the error and exits the program.
 // 创建消费者 (Creating a Consumer)：
 
-// 调用 event.NewConsumer(rabbitConn) 创建一个消费者对象，用于从指定队列中消费消息。
-// Calls event.NewConsumer(rabbitConn) to create a consumer object to consume messages from the specified queues.
+// 调用 event.NewConsumer(rabbitConn, signer) 创建一个消费者对象，用于从指定队列中消费消息。
+// Calls event.NewConsumer(rabbitConn, signer) to create a consumer object to consume messages from the specified queues.
 // 监听消息队列 (Listening to Message Queue)：
 
 // 调用 consumer.Listen([]string{"log.INFO", "log.WARNING", "log.ERROR"}) 来监听队列中的指定类型消息（log.INFO、log.WARNING、log.ERROR）。
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// confirmationTTL 是确认令牌的有效期。
+// confirmationTTL is how long a confirmation token can be used for.
+const confirmationTTL = 5 * time.Minute
+
+// confirmation 记录一个令牌允许的操作：谁可以对哪个组织做这件事，直到何时。
+// confirmation records what a token allows: who may do it, to which organization, until when.
+type confirmation struct {
+	subject   string
+	tenant    string
+	expiresAt time.Time
+}
+
+// confirmations 保存待确认的危险操作的令牌。令牌只能使用一次，且只对请求它的人有效。
+// confirmations holds the tokens for dangerous operations waiting to be confirmed. Each token works
+// once, and only for whoever asked for it.
+type confirmations struct {
+	mu      sync.Mutex
+	pending map[string]confirmation
+}
+
+// newConfirmations 返回一个空的 confirmations。
+// newConfirmations returns an empty confirmations.
+func newConfirmations() *confirmations {
+	return &confirmations{pending: make(map[string]confirmation)}
+}
+
+// issue 为 subject 对 tenant 的操作生成一个新令牌。
+// issue makes a new token for subject to act on tenant.
+func (c *confirmations) issue(subject, tenant string, now time.Time) (string, time.Time, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	token := hex.EncodeToString(b)
+	expiresAt := now.Add(confirmationTTL)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// 顺便清理过期的令牌 (Clear out expired tokens while we are here)
+	for t, p := range c.pending {
+		if now.After(p.expiresAt) {
+			delete(c.pending, t)
+		}
+	}
+
+	c.pending[token] = confirmation{subject: subject, tenant: tenant, expiresAt: expiresAt}
+
+	return token, expiresAt, nil
+}
+
+// consume 检查令牌是否由 subject 为 tenant 申请且仍然有效；无论结果如何，令牌都会被用掉。
+// consume reports whether token was issued to subject for tenant and is still valid. The token is
+// used up either way.
+func (c *confirmations) consume(token, subject, tenant string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p, ok := c.pending[token]
+	if !ok {
+		return false
+	}
+	delete(c.pending, token)
+
+	return p.subject == subject && p.tenant == tenant && !now.After(p.expiresAt)
+}
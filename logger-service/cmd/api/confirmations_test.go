@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfirmations(t *testing.T) {
+	now := time.Now()
+
+	for _, tc := range []struct {
+		name    string
+		subject string
+		tenant  string
+		at      time.Time
+		want    bool
+	}{
+		{"same caller in time", "user 2", "1", now.Add(time.Minute), true},
+		{"at the expiry", "user 2", "1", now.Add(confirmationTTL), true},
+		{"expired", "user 2", "1", now.Add(confirmationTTL + time.Second), false},
+		{"another caller", "user 3", "1", now, false},
+		{"another tenant", "user 2", "2", now, false},
+	} {
+		c := newConfirmations()
+
+		token, expiresAt, err := c.issue("user 2", "1", now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !expiresAt.Equal(now.Add(confirmationTTL)) {
+			t.Fatalf("expected the token to expire at %v, got %v", now.Add(confirmationTTL), expiresAt)
+		}
+
+		if got := c.consume(token, tc.subject, tc.tenant, tc.at); got != tc.want {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, got)
+		}
+
+		// whatever happened, the token is gone
+		if c.consume(token, "user 2", "1", now) {
+			t.Errorf("%s: the token worked a second time", tc.name)
+		}
+	}
+}
+
+func TestConfirmationsUnknownToken(t *testing.T) {
+	c := newConfirmations()
+
+	if c.consume("", "user 2", "1", time.Now()) || c.consume("0123456789abcdef", "user 2", "1", time.Now()) {
+		t.Fatal("a token that was never issued worked")
+	}
+}
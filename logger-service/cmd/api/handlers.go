@@ -3,13 +3,33 @@ package main
 // 这段代码展示了一个用于记录日志的HTTP处理程序，以及几个用于读取和写入JSON响应的辅助函数。代码使用了Go语言的标准库，如encoding/json 和 net/http，以及一些自定义类型和函数。下面我们分段对这两个文件进行中英文详细解析。
 
 import (
+	"authz"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"log-service/data"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type JSONPayload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	Name       string         `json:"name"`
+	Data       string         `json:"data"`
+	Tenant     string         `json:"tenant,omitempty"`
+	Severity   string         `json:"severity,omitempty"`
+	Source     string         `json:"source,omitempty"`
+	Host       string         `json:"host,omitempty"`
+	RequestID  string         `json:"request_id,omitempty"`
+	TraceID    string         `json:"trace_id,omitempty"`
+	UserID     string         `json:"user_id,omitempty"`
+	Attributes map[string]any `json:"attributes,omitempty"`
 }
 
 // 这里定义了一个JSONPayload结构体，它用来表示从HTTP请求中接收的JSON数据。
@@ -18,10 +38,26 @@ type JSONPayload struct {
 // Name 和 Data 是两个字符串字段，分别用于接收请求JSON中name和data的值。
 // Name and Data are two string fields that are used to receive the values of name and data in the request JSON.
 
+// Tenant 是条目所属的组织。只有服务可以代用户指定；用户的条目总是归入其令牌中的组织。
+// Tenant is the organization the entry belongs to. Only services may name it for a user; a user's entries always go to the organization in their token.
+
+// 其余字段都是可选的：级别（默认为 info）、写入条目的服务和主机、请求和追踪ID、涉及的用户，以及任意的属性。
+// 只带 name 和 data 的旧格式载荷仍然有效。
+// The other fields are optional: the severity (info by default), the service and host that wrote the entry, the
+// request and trace ids, the user it concerns, and any attributes. Payloads with only a name and data, as
+// producers sent before entries were structured, still work.
+
 func (app *Config) WriteLog(w http.ResponseWriter, r *http.Request) {
 	// read json into var
 	var requestPayload JSONPayload
-	_ = app.readJSON(w, r, &requestPayload)
+	err := app.readJSON(w, r, &requestPayload)
+
+	// 属性必须是 JSON 对象 (Attributes must be a JSON object)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field == "attributes" {
+		app.errorJSON(w, errAttributesNotObject)
+		return
+	}
 
 	// 这是一个属于Config结构体的HTTP处理函数WriteLog，用于处理/log路径的POST请求。
 	// This is an HTTP handler function WriteLog that belongs to the Config struct, and it handles POST requests at the /log path.
@@ -29,15 +65,42 @@ func (app *Config) WriteLog(w http.ResponseWriter, r *http.Request) {
 	// 使用app.readJSON()函数将请求体中的JSON数据读取到requestPayload变量中。
 	// It uses the app.readJSON() function to read the JSON data from the request body into the requestPayload variable.
 
+	// 确定条目所属的租户，用户不能写入其他组织 (Work out the entry's tenant; users cannot write to another organization)
+	tenant, err := authz.ResolveTenant(r.Context(), requestPayload.Tenant)
+	if err != nil {
+		app.errorJSON(w, err, authz.StatusCode(err))
+		return
+	}
+
+	severity, err := severityOf(requestPayload.Severity)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	attributes, err := attributesOf(requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
 	// insert data
 	// 将JSON数据转换为LogEntry数据，并插入数据库中
 	event := data.LogEntry{
-		Name: requestPayload.Name,
-		Data: requestPayload.Data,
+		Name:       requestPayload.Name,
+		Data:       requestPayload.Data,
+		Tenant:     tenant,
+		Severity:   severity,
+		Source:     requestPayload.Source,
+		Host:       requestPayload.Host,
+		RequestID:  requestPayload.RequestID,
+		TraceID:    requestPayload.TraceID,
+		UserID:     requestPayload.UserID,
+		Attributes: attributes,
 	}
 
 	// 调用Models中的LogEntry的Insert方法将数据插入到数据库中
-	err := app.Models.LogEntry.Insert(event)
+	err = app.Logs.Insert(event)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
@@ -46,8 +109,8 @@ func (app *Config) WriteLog(w http.ResponseWriter, r *http.Request) {
 	// event是一个data.LogEntry类型的变量，它将接收到的requestPayload数据转换为日志条目（Log Entry）。
 	// event is a variable of type data.LogEntry that converts the received requestPayload data into a log entry.
 
-	// app.Models.LogEntry.Insert(event) 是一个数据库插入操作，将日志条目插入到数据库中。
-	// app.Models.LogEntry.Insert(event) is a database insertion operation that inserts the log entry into the database.
+	// app.Logs.Insert(event) 是一个数据库插入操作，将日志条目插入到数据库中。
+	// app.Logs.Insert(event) is a database insertion operation that inserts the log entry into the database.
 
 	// 如果插入数据库时发生错误，则调用app.errorJSON(w, err)，返回一个JSON格式的错误响应，并终止程序。
 	// If an error occurs during database insertion, it calls app.errorJSON(w, err) to return a JSON-formatted error response and terminates the function.
@@ -62,3 +125,506 @@ func (app *Config) WriteLog(w http.ResponseWriter, r *http.Request) {
 
 	app.writeJSON(w, http.StatusAccepted, resp)
 }
+
+// severityOf 把级别规范为小写，缺失时使用默认级别，未知级别返回错误。
+// severityOf normalises a severity to lower case, defaulting it when it is missing and refusing
+// unknown ones.
+func severityOf(severity string) (string, error) {
+	severity = strings.ToLower(strings.TrimSpace(severity))
+	if severity == "" {
+		return data.DefaultSeverity, nil
+	}
+	if severity == "warn" {
+		return "warning", nil
+	}
+
+	for _, known := range data.Severities {
+		if severity == known {
+			return severity, nil
+		}
+	}
+
+	return "", fmt.Errorf("severity must be one of %s", strings.Join(data.Severities, ", "))
+}
+
+// maxAttributesSize 是属性编码为 JSON 后的最大字节数。
+// maxAttributesSize is the most bytes an entry's attributes may take up as JSON.
+const maxAttributesSize = 16 << 10
+
+var (
+	errAttributesNotObject = errors.New("attributes must be a JSON object")
+	errAttributesTooLarge  = fmt.Errorf("attributes must be at most %d bytes", maxAttributesSize)
+)
+
+// attributesOf 返回载荷的属性，过大时返回错误。以前的生产者把 JSON 对象塞进 data 字符串里；没有给出属性时，
+// 这样的对象会被解析为属性，以便查询，而 data 保持原样。太大的对象不会被解析。
+// attributesOf returns a payload's attributes, refusing them when they are too large. Producers used to
+// put JSON objects in the data string; when no attributes are given, such an object is parsed into
+// attributes so that it can be queried, and data is kept as it was. Objects too large to be attributes
+// are left in data.
+func attributesOf(p JSONPayload) (map[string]any, error) {
+	if p.Attributes != nil {
+		encoded, err := json.Marshal(p.Attributes)
+		if err != nil {
+			return nil, err
+		}
+		if len(encoded) > maxAttributesSize {
+			return nil, errAttributesTooLarge
+		}
+		return p.Attributes, nil
+	}
+
+	trimmed := strings.TrimSpace(p.Data)
+	if !strings.HasPrefix(trimmed, "{") || len(trimmed) > maxAttributesSize {
+		return nil, nil
+	}
+
+	var attributes map[string]any
+	if err := json.Unmarshal([]byte(trimmed), &attributes); err != nil {
+		return nil, nil
+	}
+
+	return attributes, nil
+}
+
+// SubjectPayload 指定数据主体请求涉及的人：Terms 是指代此人的字符串，例如邮箱地址，按整词匹配，不区分大小写。
+// SubjectPayload names the person a data subject request is about: Terms are the strings that refer
+// to them, such as their email address, matched as whole words whatever their case.
+type SubjectPayload struct {
+	Terms     []string `json:"terms"`
+	Pseudonym string   `json:"pseudonym,omitempty"`
+}
+
+// readSubject 读取并检查数据主体请求。这些请求跨越所有组织，所以只有服务可以发出。
+// readSubject reads and checks a data subject request. These span every organization, so only
+// services may make them.
+func (app *Config) readSubject(w http.ResponseWriter, r *http.Request) (*SubjectPayload, bool) {
+	claims, _ := authz.FromContext(r.Context())
+	if claims == nil || !claims.Service() {
+		app.errorJSON(w, authz.ErrForbidden, http.StatusForbidden)
+		return nil, false
+	}
+
+	var requestPayload SubjectPayload
+	if err := app.readJSON(w, r, &requestPayload); err != nil {
+		app.errorJSON(w, err)
+		return nil, false
+	}
+
+	if len(requestPayload.Terms) == 0 {
+		app.errorJSON(w, errors.New("terms are required"))
+		return nil, false
+	}
+
+	// 太短的词会匹配到无关的条目 (Terms that are too short would match unrelated entries)
+	for _, term := range requestPayload.Terms {
+		if len(strings.TrimSpace(term)) < minSubjectTerm {
+			app.errorJSON(w, fmt.Errorf("terms must be at least %d characters", minSubjectTerm))
+			return nil, false
+		}
+	}
+
+	return &requestPayload, true
+}
+
+// minSubjectTerm 是数据主体请求中一个词的最短长度。
+// minSubjectTerm is the shortest term a data subject request may use.
+const minSubjectTerm = 3
+
+// ExportSubject 返回所有组织中提到此人的条目，包括发送邮件的记录。
+// ExportSubject returns the entries in every organization that mention the person, mail records
+// included.
+func (app *Config) ExportSubject(w http.ResponseWriter, r *http.Request) {
+	subject, ok := app.readSubject(w, r)
+	if !ok {
+		return
+	}
+
+	entries, err := app.Logs.Referencing(subject.Terms)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d entries", len(entries)),
+		Data:    entries,
+	})
+}
+
+// EraseSubject 把所有条目中提到此人的地方替换为化名，并返回修改了多少条目。
+// EraseSubject replaces every mention of the person in every entry with a pseudonym, and returns
+// how many entries it changed.
+func (app *Config) EraseSubject(w http.ResponseWriter, r *http.Request) {
+	subject, ok := app.readSubject(w, r)
+	if !ok {
+		return
+	}
+
+	if subject.Pseudonym == "" {
+		app.errorJSON(w, errors.New("pseudonym is required"))
+		return
+	}
+
+	changed, err := app.Logs.Pseudonymise(subject.Terms, subject.Pseudonym)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("pseudonymised %d entries", changed),
+		Data: struct {
+			Changed int `json:"changed"`
+		}{changed},
+	})
+}
+
+// GetLogs 返回调用方组织的一页日志条目，可按名称、级别、来源、时间范围、全文、请求ID、追踪ID和用户过滤。
+// 名称、级别、来源和字段可以重复或用逗号分隔；from 和 to 使用 RFC 3339 时间。
+// GetLogs returns a page of the caller's organization's entries, filtered by name, severity, source,
+// time range, free text, request id, trace id and user. Names, severities, sources and fields may be
+// repeated or comma separated; from and to are RFC 3339 times. Pass next_cursor back as cursor to get
+// the following page.
+func (app *Config) GetLogs(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	// 只有服务可以读取其他组织的条目 (Only services may read another organization's entries)
+	tenant, err := authz.ResolveTenant(r.Context(), params.Get("tenant"))
+	if err != nil {
+		app.errorJSON(w, err, authz.StatusCode(err))
+		return
+	}
+
+	query := data.LogQuery{
+		Tenant:     tenant,
+		Names:      listParam(params["name"]),
+		Severities: listParam(params["severity"]),
+		Text:       strings.TrimSpace(params.Get("q")),
+		Sources:    listParam(params["source"]),
+		RequestID:  params.Get("request_id"),
+		TraceID:    params.Get("trace_id"),
+		UserID:     params.Get("user_id"),
+		Cursor:     params.Get("cursor"),
+		Fields:     listParam(params["fields"]),
+	}
+
+	for i, severity := range query.Severities {
+		if query.Severities[i], err = severityOf(severity); err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+	}
+
+	if query.From, err = timeParam(params, "from"); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	if query.To, err = timeParam(params, "to"); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	switch params.Get("sort") {
+	case "", "desc":
+	case "asc":
+		query.Ascending = true
+	default:
+		app.errorJSON(w, errors.New("sort must be asc or desc"))
+		return
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil || query.Limit < 1 {
+			app.errorJSON(w, errors.New("limit must be a positive number"))
+			return
+		}
+	}
+
+	for _, field := range query.Fields {
+		if _, ok := data.QueryFields[field]; !ok {
+			app.errorJSON(w, fmt.Errorf("unknown field %q", field))
+			return
+		}
+	}
+
+	page, err := app.Logs.Query(query)
+	if errors.Is(err, data.ErrInvalidCursor) {
+		app.errorJSON(w, err)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d entries", len(page.Entries)),
+		Data:    page,
+	})
+}
+
+// listParam 把重复或逗号分隔的查询参数拆成一个列表，忽略空项。
+// listParam splits a query parameter that may be repeated or comma separated into a list, leaving
+// out empty items.
+func listParam(values []string) []string {
+	var list []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+
+	return list
+}
+
+// timeParam 读取一个 RFC 3339 时间参数；参数缺失时返回零值。
+// timeParam reads an RFC 3339 time parameter, returning the zero time when it is missing.
+func timeParam(params url.Values, name string) (time.Time, error) {
+	value := params.Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be an RFC 3339 time", name)
+	}
+
+	return t, nil
+}
+
+// GetLog 返回调用方组织中的一个条目。
+// GetLog returns one of the caller's organization's entries.
+func (app *Config) GetLog(w http.ResponseWriter, r *http.Request) {
+	tenant, err := authz.ResolveTenant(r.Context(), r.URL.Query().Get("tenant"))
+	if err != nil {
+		app.errorJSON(w, err, authz.StatusCode(err))
+		return
+	}
+
+	entry, err := app.Logs.GetOne(chi.URLParam(r, "id"), tenant)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		app.errorJSON(w, errNoSuchEntry, http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: "entry " + entry.ID,
+		Data:    entry,
+	})
+}
+
+// GetLogVersions 返回一个条目被修改之前的各个版本，最早的在前，用于审计修改。
+// GetLogVersions returns the versions an entry had before it was updated, oldest first, so that
+// edits can be audited.
+func (app *Config) GetLogVersions(w http.ResponseWriter, r *http.Request) {
+	tenant, err := authz.ResolveTenant(r.Context(), r.URL.Query().Get("tenant"))
+	if err != nil {
+		app.errorJSON(w, err, authz.StatusCode(err))
+		return
+	}
+
+	entry, err := app.Logs.GetOne(chi.URLParam(r, "id"), tenant)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		app.errorJSON(w, errNoSuchEntry, http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	versions, err := app.Logs.Versions(entry.ID, tenant)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d earlier versions of entry %s", len(versions), entry.ID),
+		Data:    versions,
+	})
+}
+
+// UpdateLog 修改一个条目的名称、数据和属性。修改前的版本会被保留，并记录是谁修改的。
+// 请求可以带上读取时条目的 updated_at；如果条目之后又被修改过，则返回 409，以免覆盖他人的修改。
+// UpdateLog changes an entry's name, data and attributes. The version it replaces is kept, along
+// with who replaced it. The request may give the updated_at the entry had when it was read; if the
+// entry has been changed since, it gets a 409 rather than overwriting someone else's edit.
+func (app *Config) UpdateLog(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		JSONPayload
+		UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	}
+	err := app.readJSON(w, r, &requestPayload)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field == "attributes" {
+		err = errAttributesNotObject
+	}
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if strings.TrimSpace(requestPayload.Name) == "" {
+		app.errorJSON(w, errors.New("name is required"))
+		return
+	}
+
+	tenant, err := authz.ResolveTenant(r.Context(), r.URL.Query().Get("tenant"))
+	if err != nil {
+		app.errorJSON(w, err, authz.StatusCode(err))
+		return
+	}
+
+	attributes, err := attributesOf(requestPayload.JSONPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	entry := data.LogEntry{
+		ID:         chi.URLParam(r, "id"),
+		Name:       requestPayload.Name,
+		Data:       requestPayload.Data,
+		Attributes: attributes,
+	}
+	if requestPayload.UpdatedAt != nil {
+		entry.UpdatedAt = *requestPayload.UpdatedAt
+	}
+
+	updated, err := app.Logs.Update(entry, tenant, editor(r))
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		app.errorJSON(w, errNoSuchEntry, http.StatusNotFound)
+		return
+	} else if errors.Is(err, data.ErrConflict) {
+		app.errorJSON(w, err, http.StatusConflict)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: "updated entry " + updated.ID,
+		Data:    updated,
+	})
+}
+
+// DeleteLogs 删除调用方组织的所有条目。这无法撤销，所以需要两步：不带 confirm 参数的请求
+// 只返回一个确认令牌；几分钟内带上 ?confirm=<令牌> 再次请求才会真正删除。
+// DeleteLogs deletes every one of the caller's organization's entries. This cannot be undone, so it
+// takes two steps: a request without a confirm parameter only returns a confirmation token, and the
+// entries are deleted when the same caller repeats the request with ?confirm=<token> within a few
+// minutes.
+func (app *Config) DeleteLogs(w http.ResponseWriter, r *http.Request) {
+	tenant, err := authz.ResolveTenant(r.Context(), r.URL.Query().Get("tenant"))
+	if err != nil {
+		app.errorJSON(w, err, authz.StatusCode(err))
+		return
+	}
+
+	subject := editor(r)
+	now := time.Now()
+
+	confirm := r.URL.Query().Get("confirm")
+	if confirm == "" {
+		token, expiresAt, err := app.Confirmations.issue(subject, tenant, now)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		app.writeJSON(w, http.StatusAccepted, jsonResponse{
+			Error:   false,
+			Message: "repeat this request with ?confirm=" + token + " to delete every entry; this cannot be undone",
+			Data: struct {
+				ConfirmationToken string    `json:"confirmation_token"`
+				ExpiresAt         time.Time `json:"expires_at"`
+			}{token, expiresAt},
+		})
+		return
+	}
+
+	if !app.Confirmations.consume(confirm, subject, tenant, now) {
+		app.errorJSON(w, errors.New("the confirmation token is invalid or has expired"), http.StatusForbidden)
+		return
+	}
+
+	deleted, err := app.Logs.DeleteAll(tenant)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// 删除本身要留下记录 (The deletion itself leaves a record)
+	err = app.Logs.Insert(data.LogEntry{
+		Name:   "logs",
+		Data:   fmt.Sprintf("%d entries deleted by %s", deleted, subject),
+		Tenant: tenant,
+	})
+	if err != nil {
+		log.Println("Error recording deletion:", err)
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("deleted %d entries", deleted),
+		Data: struct {
+			Deleted int64 `json:"deleted"`
+		}{deleted},
+	})
+}
+
+// errNoSuchEntry 在条目不存在或属于其他组织时返回。
+// errNoSuchEntry is returned for an entry that does not exist, or belongs to another organization.
+var errNoSuchEntry = errors.New("no such entry")
+
+// editor 说明是谁发出了请求；代表用户操作的员工会同时记下两个ID。
+// editor names whoever made the request; staff acting as a user are recorded under both ids.
+func editor(r *http.Request) string {
+	claims, ok := authz.FromContext(r.Context())
+	if !ok {
+		return "unknown"
+	}
+
+	if claims.Impersonated() {
+		return fmt.Sprintf("user %s acting as user %s", claims.Actor.Subject, claims.Subject)
+	}
+	if claims.Service() {
+		return "service " + claims.Subject
+	}
+
+	return "user " + claims.Subject
+}
+
+// GetRetention 返回日志的保留策略、上一次清理的结果，以及日志集合的统计信息。
+// GetRetention returns the log retention policies, how the last purge went, and statistics about the
+// logs collection.
+func (app *Config) GetRetention(w http.ResponseWriter, r *http.Request) {
+	stats, err := app.Logs.Stats()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d retention policies", len(app.Retention.policies)),
+		Data: struct {
+			retentionReport
+			Stats *data.CollectionStats `json:"stats"`
+		}{app.Retention.report(), stats},
+	})
+}
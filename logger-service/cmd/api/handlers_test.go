@@ -0,0 +1,477 @@
+package main
+
+import (
+	"authz"
+	"encoding/json"
+	"log-service/data"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testSecret signs the tokens in these tests
+const testSecret = "secret"
+
+type testEnv struct {
+	app  *Config
+	logs *data.MemoryStore
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+
+	logs := data.NewMemoryStore()
+
+	return &testEnv{
+		app: &Config{
+			Logs:          logs,
+			Verifier:      authz.NewHMAC(testSecret),
+			Confirmations: newConfirmations(),
+			Retention:     newRetention(nil, nil),
+		},
+		logs: logs,
+	}
+}
+
+// token signs claims for subject; a subject naming itself as issuer is a service
+func token(t *testing.T, subject, issuer, tenant string, permissions ...string) string {
+	t.Helper()
+
+	now := time.Now()
+
+	signed, err := authz.NewHMAC(testSecret).Sign(authz.Claims{
+		Subject:     subject,
+		Issuer:      issuer,
+		TenantID:    tenant,
+		Permissions: permissions,
+		IssuedAt:    now.Unix(),
+		ExpiresAt:   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed
+}
+
+func call(env *testEnv, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rr := httptest.NewRecorder()
+	env.app.routes().ServeHTTP(rr, req)
+
+	return rr
+}
+
+func insert(t *testing.T, env *testEnv, entries ...data.LogEntry) {
+	t.Helper()
+
+	for _, entry := range entries {
+		if err := env.logs.Insert(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSubjectRequestsAreChecked(t *testing.T) {
+	env := newTestEnv(t)
+
+	service := token(t, "mail-service", "mail-service", "", "logs:subjects")
+	user := token(t, "2", "authentication-service", "1", "logs:subjects")
+	unprivileged := token(t, "mail-service", "mail-service", "")
+
+	for _, tc := range []struct {
+		name  string
+		path  string
+		token string
+		body  string
+		want  int
+	}{
+		{"anonymous", "/subjects/export", "", `{"terms":["ann@example.com"]}`, http.StatusUnauthorized},
+		{"without logs:subjects", "/subjects/export", unprivileged, `{"terms":["ann@example.com"]}`, http.StatusForbidden},
+		{"a user rather than a service", "/subjects/export", user, `{"terms":["ann@example.com"]}`, http.StatusForbidden},
+		{"no terms", "/subjects/export", service, `{"terms":[]}`, http.StatusBadRequest},
+		{"a short term", "/subjects/export", service, `{"terms":["ann@example.com"," an "]}`, http.StatusBadRequest},
+		{"erasure without a pseudonym", "/subjects/erase", service, `{"terms":["ann@example.com"]}`, http.StatusBadRequest},
+		{"a user erasing", "/subjects/erase", user, `{"terms":["ann@example.com"],"pseudonym":"subject-1"}`, http.StatusForbidden},
+	} {
+		if rr := call(env, http.MethodPost, tc.path, tc.token, tc.body); rr.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d: %s", tc.name, tc.want, rr.Code, rr.Body)
+		}
+	}
+}
+
+func TestExportAndEraseSubject(t *testing.T) {
+	env := newTestEnv(t)
+	service := token(t, "mail-service", "mail-service", "", "logs:subjects")
+
+	insert(t, env,
+		data.LogEntry{Name: "mail", Data: "sent a receipt to ann@example.com", Tenant: "1"},
+		data.LogEntry{Name: "signup", Data: "new user", Attributes: map[string]any{"email": "Ann@Example.com"}, Tenant: "2"},
+		data.LogEntry{Name: "mail", Data: "sent a receipt to joann@example.com", Tenant: "1"},
+	)
+
+	export := func() []data.LogEntry {
+		t.Helper()
+
+		rr := call(env, http.MethodPost, "/subjects/export", service, `{"terms":["ann@example.com"]}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("export: expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		var resp struct {
+			Data []data.LogEntry `json:"data"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp.Data
+	}
+
+	// the export spans organizations, and finds the subject in attributes too
+	found := export()
+	if len(found) != 2 || found[0].Name != "mail" || found[1].Name != "signup" {
+		t.Fatalf("expected the mail and signup entries, got %+v", found)
+	}
+
+	rr := call(env, http.MethodPost, "/subjects/erase", service, `{"terms":["ann@example.com"],"pseudonym":"subject-1"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("erase: expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+
+	var erased struct {
+		Data struct {
+			Changed int `json:"changed"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&erased); err != nil {
+		t.Fatal(err)
+	}
+	if erased.Data.Changed != 2 {
+		t.Fatalf("expected 2 entries changed, got %d", erased.Data.Changed)
+	}
+
+	if found := export(); len(found) != 0 {
+		t.Fatalf("expected nothing to export after erasure, got %+v", found)
+	}
+
+	page, err := env.logs.Query(data.LogQuery{Tenant: "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := page.Entries[0]["attributes"].(map[string]any)["email"]; got != "subject-1" {
+		t.Fatalf("expected the attribute to be pseudonymised, got %v", got)
+	}
+
+	// a whole word match leaves other addresses alone
+	page, err = env.logs.Query(data.LogQuery{Tenant: "1", Text: "joann@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Entries) != 1 {
+		t.Fatalf("expected joann's entry to be untouched, got %+v", page.Entries)
+	}
+}
+
+func TestListParam(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		values []string
+		want   []string
+	}{
+		{"missing", nil, nil},
+		{"one", []string{"mail"}, []string{"mail"}},
+		{"repeated", []string{"mail", "auth"}, []string{"mail", "auth"}},
+		{"comma separated", []string{"mail,auth"}, []string{"mail", "auth"}},
+		{"both", []string{"mail, auth", "broker"}, []string{"mail", "auth", "broker"}},
+		{"empty items", []string{",mail,, ,", ""}, []string{"mail"}},
+	} {
+		if got := listParam(tc.values); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestTimeParam(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		value string
+		want  time.Time
+		ok    bool
+	}{
+		{"missing", "", time.Time{}, true},
+		{"UTC", "2024-03-01T12:30:00Z", time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC), true},
+		{"offset", "2024-03-01T14:30:00+02:00", time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC), true},
+		{"fractional seconds", "2024-03-01T12:30:00.5Z", time.Date(2024, 3, 1, 12, 30, 0, 5e8, time.UTC), true},
+		{"date only", "2024-03-01", time.Time{}, false},
+		{"no zone", "2024-03-01T12:30:00", time.Time{}, false},
+		{"unix time", "1709296200", time.Time{}, false},
+	} {
+		params := url.Values{}
+		if tc.value != "" {
+			params.Set("from", tc.value)
+		}
+
+		got, err := timeParam(params, "from")
+		if (err == nil) != tc.ok || !got.Equal(tc.want) {
+			t.Errorf("%s: got %v, %v", tc.name, got, err)
+		}
+		if err != nil && !strings.HasPrefix(err.Error(), "from ") {
+			t.Errorf("%s: expected the error to name the parameter, got %v", tc.name, err)
+		}
+	}
+}
+
+func TestGetLogsRejectsBadCursor(t *testing.T) {
+	env := newTestEnv(t)
+	reader := token(t, "2", "authentication-service", "1", "logs:read")
+
+	if rr := call(env, http.MethodGet, "/logs?cursor=garbage", reader, ""); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected %d, got %d: %s", http.StatusBadRequest, rr.Code, rr.Body)
+	}
+}
+
+// deleteToken asks to delete the tenant's entries, returning the confirmation token
+func deleteToken(t *testing.T, env *testEnv, token string) string {
+	t.Helper()
+
+	rr := call(env, http.MethodDelete, "/logs", token, "")
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body)
+	}
+
+	var resp struct {
+		Data struct {
+			ConfirmationToken string `json:"confirmation_token"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.Data.ConfirmationToken
+}
+
+func TestDeleteLogsNeedsConfirmation(t *testing.T) {
+	env := newTestEnv(t)
+	admin := token(t, "2", "authentication-service", "1", "logs:manage")
+	other := token(t, "3", "authentication-service", "1", "logs:manage")
+
+	insert(t, env,
+		data.LogEntry{Name: "event", Tenant: "1"},
+		data.LogEntry{Name: "event", Tenant: "1"},
+		data.LogEntry{Name: "event", Tenant: "2"},
+	)
+
+	count := func(tenant string) int {
+		t.Helper()
+
+		page, err := env.logs.Query(data.LogQuery{Tenant: tenant, Names: []string{"event"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(page.Entries)
+	}
+
+	// asking for a token deletes nothing
+	confirm := deleteToken(t, env, admin)
+	if count("1") != 2 {
+		t.Fatal("entries were deleted without confirmation")
+	}
+
+	for _, tc := range []struct {
+		name  string
+		token string
+		path  string
+	}{
+		{"wrong token", admin, "/logs?confirm=0123456789abcdef"},
+		{"token issued to someone else", other, "/logs?confirm=" + deleteToken(t, env, admin)},
+		{"token issued for another tenant", admin, "/logs?confirm=" + deleteToken(t, env, token(t, "2", "authentication-service", "2", "logs:manage"))},
+	} {
+		if rr := call(env, http.MethodDelete, tc.path, tc.token, ""); rr.Code != http.StatusForbidden {
+			t.Errorf("%s: expected %d, got %d: %s", tc.name, http.StatusForbidden, rr.Code, rr.Body)
+		}
+	}
+	if count("1") != 2 {
+		t.Fatal("entries were deleted without a valid confirmation")
+	}
+
+	if rr := call(env, http.MethodDelete, "/logs?confirm="+confirm, admin, ""); rr.Code != http.StatusOK {
+		t.Fatalf("confirmed: expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+	if count("1") != 0 || count("2") != 1 {
+		t.Fatalf("expected only tenant 1's entries to go, left %d and %d", count("1"), count("2"))
+	}
+
+	// the deletion is recorded
+	page, err := env.logs.Query(data.LogQuery{Tenant: "1", Names: []string{"logs"}})
+	if err != nil || len(page.Entries) != 1 {
+		t.Fatalf("expected a record of the deletion, got %v, %v", page, err)
+	}
+
+	if rr := call(env, http.MethodDelete, "/logs?confirm="+confirm, admin, ""); rr.Code != http.StatusForbidden {
+		t.Fatalf("reused token: expected %d, got %d: %s", http.StatusForbidden, rr.Code, rr.Body)
+	}
+}
+
+func TestUpdateLogRefusesStaleEdits(t *testing.T) {
+	env := newTestEnv(t)
+	editor := token(t, "2", "authentication-service", "1", "logs:manage")
+
+	insert(t, env, data.LogEntry{Name: "event", Data: "first", Tenant: "1"})
+	page, err := env.logs.Query(data.LogQuery{Tenant: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := page.Entries[0]["id"].(string)
+	read := page.Entries[0]["updated_at"].(time.Time)
+
+	edit := func(data string, updatedAt time.Time) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]any{"name": "event", "data": data, "updated_at": updatedAt})
+		return call(env, http.MethodPut, "/logs/"+id, editor, string(body))
+	}
+
+	if rr := edit("second", read); rr.Code != http.StatusOK {
+		t.Fatalf("edit: expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+
+	// a second edit made from the same read would overwrite the first
+	if rr := edit("third", read); rr.Code != http.StatusConflict {
+		t.Fatalf("stale edit: expected %d, got %d: %s", http.StatusConflict, rr.Code, rr.Body)
+	}
+
+	current, err := env.logs.GetOne(id, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Data != "second" {
+		t.Fatalf("expected the stale edit to change nothing, got %q", current.Data)
+	}
+
+	versions, err := env.logs.Versions(id, "1")
+	if err != nil || len(versions) != 1 {
+		t.Fatalf("expected one version, got %d, %v", len(versions), err)
+	}
+
+	if rr := edit("third", current.UpdatedAt); rr.Code != http.StatusOK {
+		t.Fatalf("fresh edit: expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+
+	// edits that do not say what they were made from still go through
+	if rr := call(env, http.MethodPut, "/logs/"+id, editor, `{"name":"event","data":"fourth"}`); rr.Code != http.StatusOK {
+		t.Fatalf("unconditional edit: expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+
+	if rr := edit("fifth", current.UpdatedAt); rr.Code != http.StatusConflict {
+		t.Fatalf("stale edit: expected %d, got %d: %s", http.StatusConflict, rr.Code, rr.Body)
+	}
+
+	if rr := call(env, http.MethodPut, "/logs/"+primitive.NewObjectID().Hex(), editor, `{"name":"event"}`); rr.Code != http.StatusNotFound {
+		t.Fatalf("missing entry: expected %d, got %d: %s", http.StatusNotFound, rr.Code, rr.Body)
+	}
+}
+
+func TestSeverityOf(t *testing.T) {
+	for _, tc := range []struct {
+		severity string
+		want     string
+		ok       bool
+	}{
+		{"", data.DefaultSeverity, true},
+		{"   ", data.DefaultSeverity, true},
+		{"debug", "debug", true},
+		{"ERROR", "error", true},
+		{" Critical ", "critical", true},
+		{"warn", "warning", true},
+		{"WARN", "warning", true},
+		{"fatal", "", false},
+		{"information", "", false},
+		{"error,debug", "", false},
+	} {
+		got, err := severityOf(tc.severity)
+		if got != tc.want || (err == nil) != tc.ok {
+			t.Errorf("%q: expected %q, %v, got %q, %v", tc.severity, tc.want, tc.ok, got, err)
+		}
+	}
+}
+
+func TestAttributesOf(t *testing.T) {
+	large := strings.Repeat("x", maxAttributesSize)
+
+	for _, tc := range []struct {
+		name    string
+		payload JSONPayload
+		want    map[string]any
+		err     error
+	}{
+		{"none", JSONPayload{Data: "user logged in"}, nil, nil},
+		{"given", JSONPayload{Attributes: map[string]any{"user": "7"}}, map[string]any{"user": "7"}, nil},
+		{"given wins over data", JSONPayload{Data: `{"user":"8"}`, Attributes: map[string]any{"user": "7"}}, map[string]any{"user": "7"}, nil},
+		{"empty object given", JSONPayload{Attributes: map[string]any{}}, map[string]any{}, nil},
+		{"object in data", JSONPayload{Data: ` {"user":"7","n":1} `}, map[string]any{"user": "7", "n": float64(1)}, nil},
+		{"array in data", JSONPayload{Data: `["user","7"]`}, nil, nil},
+		{"string in data", JSONPayload{Data: `"user 7"`}, nil, nil},
+		{"broken object in data", JSONPayload{Data: `{"user":`}, nil, nil},
+		{"too large", JSONPayload{Attributes: map[string]any{"blob": large}}, nil, errAttributesTooLarge},
+		{"too large in data", JSONPayload{Data: `{"blob":"` + large + `"}`}, nil, nil},
+	} {
+		got, err := attributesOf(tc.payload)
+		if !reflect.DeepEqual(got, tc.want) || err != tc.err {
+			t.Errorf("%s: expected %v, %v, got %v, %v", tc.name, tc.want, tc.err, got, err)
+		}
+	}
+}
+
+func TestWriteAndUpdateCheckAttributes(t *testing.T) {
+	env := newTestEnv(t)
+	writer := token(t, "2", "authentication-service", "1", "logs:write", "logs:manage")
+
+	insert(t, env, data.LogEntry{Name: "event", Tenant: "1"})
+	page, err := env.logs.Query(data.LogQuery{Tenant: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := page.Entries[0]["id"].(string)
+
+	large := `{"blob":"` + strings.Repeat("x", maxAttributesSize) + `"}`
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"write an object", http.MethodPost, "/log", `{"name":"event","attributes":{"user":"7"}}`, http.StatusAccepted},
+		{"write an array", http.MethodPost, "/log", `{"name":"event","attributes":["user","7"]}`, http.StatusBadRequest},
+		{"write a string", http.MethodPost, "/log", `{"name":"event","attributes":"user 7"}`, http.StatusBadRequest},
+		{"write too much", http.MethodPost, "/log", `{"name":"event","attributes":` + large + `}`, http.StatusBadRequest},
+		{"write an unknown severity", http.MethodPost, "/log", `{"name":"event","severity":"fatal"}`, http.StatusBadRequest},
+		{"update with an array", http.MethodPut, "/logs/" + id, `{"name":"event","attributes":[1]}`, http.StatusBadRequest},
+		{"update with too much", http.MethodPut, "/logs/" + id, `{"name":"event","attributes":` + large + `}`, http.StatusBadRequest},
+		{"update with an object", http.MethodPut, "/logs/" + id, `{"name":"event","attributes":{"user":"7"}}`, http.StatusOK},
+	} {
+		if rr := call(env, tc.method, tc.path, writer, tc.body); rr.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d: %s", tc.name, tc.want, rr.Code, rr.Body)
+		}
+	}
+
+	page, err = env.logs.Query(data.LogQuery{Tenant: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Entries) != 2 {
+		t.Fatalf("expected only the valid write to be kept, got %d entries", len(page.Entries))
+	}
+}
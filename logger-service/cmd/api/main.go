@@ -9,11 +9,13 @@ package main
 // 程序会监听在指定的端口（webPort），在该端口上启动HTTP服务。
 
 import (
+	"authz"            // 引入共享的 authz 包，用于校验访问令牌
 	"context"          // 引入上下文包，用于控制goroutine的生命周期
 	"fmt"              // 引入格式化输出包
 	"log"              // 引入日志包
 	"log-service/data" // 引入本地包log-service/data，用于管理数据库模型
 	"net/http"         // 引入HTTP服务器包
+	"os"               // 引入os包，用于读取环境变量
 	"time"             // 引入时间处理包
 
 	"go.mongodb.org/mongo-driver/mongo"         // 引入MongoDB驱动包
@@ -43,12 +45,25 @@ var client *mongo.Client
 // A global variable client is defined to store the MongoDB client pointer for use throughout the application.
 
 type Config struct {
-	Models data.Models
+	Models        data.Models
+	Logs          LogStore
+	Verifier      authz.Verifier
+	Confirmations *confirmations
+	Retention     *retention
 }
 
 // Config 是一个结构体类型（struct），用于存储数据库模型等配置信息。它包含一个字段 Models，类型是 data.Models，用于管理数据库操作。
 // Config is a struct type that stores configuration information such as database models. It has a field Models of type data.Models, used for managing database operations.
 
+// Logs 是处理器读写条目时使用的存储，服务中就是 Models.LogEntry。
+// Logs is the storage the handlers read and write entries through; in the service it is Models.LogEntry.
+
+// Confirmations 保存删除全部日志等危险操作的确认令牌。
+// Confirmations holds the confirmation tokens for dangerous operations such as deleting every entry.
+
+// Retention 按保留策略定期清理过期的条目。
+// Retention purges expired entries on a schedule, following the retention policies.
+
 // 关于 type 和 struct（About type and struct）:
 
 // type：在Go中，type 关键字用于定义新类型，可以是结构体、接口或别名等。
@@ -79,10 +94,60 @@ func main() {
 		}
 	}()
 
+	// 从环境变量中读取 JWT_SECRET，用于校验访问令牌
+	// Read JWT_SECRET from the environment; it is used to verify access tokens
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		log.Panic("JWT_SECRET is not set")
+	}
+
+	// 用户令牌用认证服务公布的公钥（JWKS）校验，服务之间的令牌仍用共享密钥
+	// User tokens are verified with the authentication service's published keys (JWKS);
+	// tokens from other services still use the shared secret
+	jwksURL := os.Getenv("JWKS_URL")
+	if jwksURL == "" {
+		jwksURL = authz.DefaultJWKSURL
+	}
+
+	// 从环境变量 LOG_RETENTION 读取保留策略，例如 "severity:debug=7d,severity:error=365d,*=90d"
+	// Read the retention policies from LOG_RETENTION, e.g. "severity:debug=7d,severity:error=365d,*=90d"
+	policies, err := data.ParseRetention(os.Getenv("LOG_RETENTION"))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	models := data.New(client)
+
 	app := Config{
-		Models: data.New(client),
+		Models: models,
+		Logs:   &models.LogEntry,
+		Verifier: authz.ByAlgorithm{
+			"RS256": authz.NewJWKSVerifier(jwksURL),
+			"HS256": authz.ServicesOnly(authz.NewHMAC(secret)),
+		},
+		Confirmations: newConfirmations(),
+		Retention:     newRetention(policies, models.LogEntry.Purge),
+	}
+
+	// 创建查询所需的索引；索引已存在时不做任何事
+	// Create the indexes queries rely on; this does nothing for indexes that already exist
+	err = app.Models.LogEntry.EnsureIndexes()
+	if err != nil {
+		log.Panic(err)
 	}
 
+	// 为以前写入的条目补上 attribute_text，数据主体请求靠它在属性中查找
+	// Fill in attribute_text on entries written before it was kept; data subject requests search
+	// attributes through it
+	err = app.Models.LogEntry.IndexAttributeText()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// 在后台按保留策略清理过期的条目
+	// Purge expired entries in the background, following the retention policies
+	go app.Retention.run()
+
 	// start web server
 	// go app.serve()
 	log.Println("Starting service on port", webPort)
@@ -136,6 +201,9 @@ func main() {
 func connectToMongo() (*mongo.Client, error) {
 	// create connection options
 	clientOptions := options.Client().ApplyURI(mongoURL)
+	// 嵌套文档（例如条目的属性）解码为 map，这样它们能原样编码为 JSON
+	// Nested documents, such as entries' attributes, decode as maps, so that they encode to JSON as they are
+	clientOptions.SetBSONOptions(&options.BSONOptions{DefaultDocumentM: true})
 	clientOptions.SetAuth(options.Credential{
 		Username: "admin",
 		Password: "password",
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"log"
+	"log-service/data"
+	"sync"
+	"time"
+)
+
+// retentionInterval 是两次清理之间的间隔。
+// retentionInterval is how often expired entries are purged.
+const retentionInterval = time.Hour
+
+// retention 按保留策略定期清理过期的条目，并记住上一次清理的结果。
+// retention purges expired entries on a schedule, following the retention policies, and remembers how
+// the last purge went.
+type retention struct {
+	policies []data.RetentionPolicy
+	purge    func([]data.RetentionPolicy, time.Time) (map[string]int64, error)
+
+	mu          sync.Mutex
+	lastRun     time.Time
+	lastDeleted map[string]int64
+	lastErr     error
+}
+
+// retentionReport 描述保留策略和上一次清理的结果。
+// retentionReport describes the retention policies and how the last purge went.
+type retentionReport struct {
+	Policies    []data.RetentionPolicy `json:"policies"`
+	Interval    string                 `json:"interval"`
+	LastRun     *time.Time             `json:"last_run,omitempty"`
+	LastDeleted map[string]int64       `json:"last_deleted,omitempty"`
+	LastError   string                 `json:"last_error,omitempty"`
+}
+
+// newRetention 返回按 policies 清理的 retention。
+// newRetention returns a retention that purges by policies.
+func newRetention(policies []data.RetentionPolicy, purge func([]data.RetentionPolicy, time.Time) (map[string]int64, error)) *retention {
+	return &retention{policies: policies, purge: purge}
+}
+
+// run 立即清理一次，之后每隔 retentionInterval 清理一次。没有策略时什么都不做。
+// run purges straight away, then every retentionInterval. With no policies it does nothing.
+func (rt *retention) run() {
+	if len(rt.policies) == 0 {
+		log.Println("No retention policies, log entries are kept forever")
+		return
+	}
+
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+
+	for {
+		rt.purgeNow(time.Now())
+		<-ticker.C
+	}
+}
+
+// purgeNow 执行一次清理并记录结果。
+// purgeNow purges once and records the outcome.
+func (rt *retention) purgeNow(now time.Time) {
+	deleted, err := rt.purge(rt.policies, now)
+	if err != nil {
+		log.Println("Error purging expired log entries:", err)
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.lastRun, rt.lastDeleted, rt.lastErr = now, deleted, err
+}
+
+// report 返回策略和上一次清理的结果。
+// report returns the policies and how the last purge went.
+func (rt *retention) report() retentionReport {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	r := retentionReport{
+		Policies:    rt.policies,
+		Interval:    retentionInterval.String(),
+		LastDeleted: rt.lastDeleted,
+	}
+	if r.Policies == nil {
+		r.Policies = []data.RetentionPolicy{}
+	}
+	if !rt.lastRun.IsZero() {
+		lastRun := rt.lastRun
+		r.LastRun = &lastRun
+	}
+	if rt.lastErr != nil {
+		r.LastError = rt.lastErr.Error()
+	}
+
+	return r
+}
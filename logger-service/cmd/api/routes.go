@@ -3,6 +3,7 @@ package main
 // 这段代码是用于配置HTTP路由的，用到了go-chi/chi框架，这是一个轻量级的Go语言路由库，非常适合用于创建RESTful API。在这个函数中，定义了允许的跨域（CORS）策略、加入了中间件（middleware），并指定了一个用于日志记录的POST请求路径（/log）
 
 import (
+	"authz"                               // 引入共享的 authz 包，用于校验令牌和权限
 	"github.com/go-chi/chi/v5"            // 引入go-chi路由库，用于管理路由
 	"github.com/go-chi/chi/v5/middleware" // 引入go-chi的中间件库
 	"github.com/go-chi/cors"              // 引入go-chi的CORS处理库，用于处理跨域请求
@@ -56,10 +57,34 @@ func (app *Config) routes() http.Handler {
 	// mux.Use(middleware.Heartbeat("/ping"))：添加了一个心跳检测中间件，它会在/ping路径上返回一个200状态码的响应，表示服务正常。
 	// Adds a heartbeat middleware, which returns a 200 status code response at the /ping path, indicating that the service is running normally.
 
-	mux.Post("/log", app.WriteLog) // 定义一个POST请求，路径是`/log`，请求处理函数是`app.WriteLog`
+	mux.Use(authz.Authenticate(app.Verifier)) // 读取并校验 Authorization 头中的令牌
+	// Reads and verifies the token in the Authorization header, storing its claims on the request.
+
+	mux.With(authz.RequirePermission("logs:write")).Post("/log", app.WriteLog) // 定义一个POST请求，路径是`/log`，请求处理函数是`app.WriteLog`，调用方需要 logs:write 权限
 	// mux.Post("/log", app.WriteLog)：定义了一个POST请求，路径是/log，处理函数是 app.WriteLog。这意味着当客户端发送一个POST请求到 /log 时，会调用 app.WriteLog 函数处理该请求。
 	// Defines a POST request with the path /log and the handler function app.WriteLog. This means when a client sends a POST request to /log, the app.WriteLog function will handle it.
 
+	// 查询日志条目，支持过滤、排序、字段投影和游标分页，调用方需要 logs:read 权限
+	// Queries log entries with filters, sorting, field projection and cursor pagination; callers need logs:read
+	mux.With(authz.RequirePermission("logs:read")).Get("/logs", app.GetLogs)
+	mux.With(authz.RequirePermission("logs:read")).Get("/logs/{id}", app.GetLog)
+	mux.With(authz.RequirePermission("logs:read")).Get("/logs/{id}/versions", app.GetLogVersions)
+
+	// 修改条目和删除全部条目需要 logs:manage 权限，且不能在代表他人操作时进行；删除还需要确认令牌
+	// Updating an entry and deleting every entry need logs:manage, and cannot be done while acting as
+	// someone else; deleting also needs a confirmation token
+	mux.With(authz.RequirePermission("logs:manage"), authz.RequireDirect).Put("/logs/{id}", app.UpdateLog)
+	mux.With(authz.RequirePermission("logs:manage"), authz.RequireDirect).Delete("/logs", app.DeleteLogs)
+
+	// 查看保留策略、上一次清理的结果和集合统计信息，调用方需要 logs:manage 权限
+	// Shows the retention policies, how the last purge went and collection statistics; callers need logs:manage
+	mux.With(authz.RequirePermission("logs:manage")).Get("/admin/retention", app.GetRetention)
+
+	// 数据主体请求：导出或化名处理提到某人的所有条目，仅限服务调用
+	// Data subject requests: export or pseudonymise every entry that mentions a person, for services only
+	mux.With(authz.RequirePermission("logs:subjects")).Post("/subjects/export", app.ExportSubject)
+	mux.With(authz.RequirePermission("logs:subjects")).Post("/subjects/erase", app.EraseSubject)
+
 	return mux
 	// return mux：返回配置好的mux路由器实例，作为HTTP处理程序供外部使用。
 	// Returns the configured mux router instance as an HTTP handler for external use.
@@ -0,0 +1,19 @@
+package main
+
+import "log-service/data"
+
+// LogStore 是处理器读写条目时使用的存储。服务使用 data.LogEntry，它把条目保存在 MongoDB 中；
+// 测试使用保存在内存中的实现。
+// LogStore is the storage the handlers read and write entries through. The service uses
+// data.LogEntry, which keeps them in MongoDB; tests use one kept in memory.
+type LogStore interface {
+	Insert(entry data.LogEntry) error
+	GetOne(id, tenant string) (*data.LogEntry, error)
+	Query(q data.LogQuery) (*data.LogPage, error)
+	Update(entry data.LogEntry, tenant, editor string) (*data.LogEntry, error)
+	Versions(id, tenant string) ([]*data.LogVersion, error)
+	DeleteAll(tenant string) (int64, error)
+	Referencing(terms []string) ([]*data.LogEntry, error)
+	Pseudonymise(terms []string, pseudonym string) (int, error)
+	Stats() (*data.CollectionStats, error)
+}
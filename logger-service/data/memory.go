@@ -0,0 +1,338 @@
+package data
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MemoryStore keeps entries and their versions in memory, with the same methods as
+// LogEntry. It is meant for tests.
+type MemoryStore struct {
+	mu       sync.Mutex
+	entries  []LogEntry
+	versions []LogVersion
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// storedNow is the time as MongoDB would store it, to the millisecond
+func storedNow() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+func (m *MemoryStore) Insert(entry LogEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry.ID = primitive.NewObjectID().Hex()
+	entry.CreatedAt = storedNow()
+	entry.UpdatedAt = entry.CreatedAt
+	entry.AttributeText = attributeText(entry.Attributes)
+
+	m.entries = append(m.entries, entry)
+
+	return nil
+}
+
+// find returns the index of the tenant's entry with id, or -1
+func (m *MemoryStore) find(id, tenant string) int {
+	for i, entry := range m.entries {
+		if entry.ID == id && entry.Tenant == tenant {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (m *MemoryStore) GetOne(id, tenant string) (*LogEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.find(id, tenant)
+	if i < 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	entry := m.entries[i]
+
+	return &entry, nil
+}
+
+func (m *MemoryStore) Query(q LogQuery) (*LogPage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var at time.Time
+	var after primitive.ObjectID
+	if q.Cursor != "" {
+		var err error
+		if at, after, err = decodeCursor(q.Cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, field := range q.Fields {
+		if _, ok := QueryFields[field]; !ok {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+	}
+
+	var text *regexp.Regexp
+	if words := strings.Fields(q.Text); len(words) > 0 {
+		text = regexp.MustCompile("(?i)" + termsPattern(words))
+	}
+
+	var matched []LogEntry
+	for _, entry := range m.entries {
+		severity := entry.Severity
+		if severity == "" {
+			severity = DefaultSeverity
+		}
+
+		switch {
+		case entry.Tenant != q.Tenant,
+			len(q.Names) > 0 && !contains(q.Names, entry.Name),
+			len(q.Severities) > 0 && !contains(q.Severities, severity),
+			len(q.Sources) > 0 && !contains(q.Sources, entry.Source),
+			q.RequestID != "" && entry.RequestID != q.RequestID,
+			q.TraceID != "" && entry.TraceID != q.TraceID,
+			q.UserID != "" && entry.UserID != q.UserID,
+			!q.From.IsZero() && entry.CreatedAt.Before(q.From),
+			!q.To.IsZero() && !entry.CreatedAt.Before(q.To),
+			text != nil && !text.MatchString(entry.Data):
+			continue
+		}
+
+		matched = append(matched, entry)
+	}
+
+	// newest first, or oldest first when ascending, with ids breaking ties
+	before := func(a, b LogEntry) bool {
+		switch {
+		case !a.CreatedAt.Equal(b.CreatedAt) && q.Ascending:
+			return a.CreatedAt.Before(b.CreatedAt)
+		case !a.CreatedAt.Equal(b.CreatedAt):
+			return a.CreatedAt.After(b.CreatedAt)
+		case q.Ascending:
+			return a.ID < b.ID
+		default:
+			return a.ID > b.ID
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool { return before(matched[i], matched[j]) })
+
+	if q.Cursor != "" {
+		last := LogEntry{ID: after.Hex(), CreatedAt: at}
+		for len(matched) > 0 && !before(last, matched[0]) {
+			matched = matched[1:]
+		}
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	} else if limit > MaxQueryLimit {
+		limit = MaxQueryLimit
+	}
+
+	page := &LogPage{Entries: []map[string]any{}}
+
+	if len(matched) > limit {
+		matched = matched[:limit]
+		last := matched[limit-1]
+		page.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+
+	for _, entry := range matched {
+		page.Entries = append(page.Entries, entry.project(q.Fields))
+	}
+
+	return page, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *MemoryStore) Update(entry LogEntry, tenant, editor string) (*LogEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.find(entry.ID, tenant)
+	if i < 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	current := m.entries[i]
+	if stale(entry, &current) {
+		return nil, ErrConflict
+	}
+
+	replacedAt := nextUpdate(current.UpdatedAt)
+
+	m.versions = append(m.versions, LogVersion{
+		ID:            primitive.NewObjectID().Hex(),
+		EntryID:       current.ID,
+		Name:          current.Name,
+		Data:          current.Data,
+		Attributes:    current.Attributes,
+		Tenant:        current.Tenant,
+		UpdatedAt:     current.UpdatedAt,
+		ReplacedAt:    replacedAt,
+		ReplacedBy:    editor,
+		AttributeText: current.AttributeText,
+	})
+
+	updated := current
+	updated.Name, updated.Data, updated.Attributes, updated.UpdatedAt = entry.Name, entry.Data, entry.Attributes, replacedAt
+	updated.AttributeText = attributeText(entry.Attributes)
+	m.entries[i] = updated
+
+	return &updated, nil
+}
+
+func (m *MemoryStore) Versions(id, tenant string) ([]*LogVersion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	versions := []*LogVersion{}
+	for i := range m.versions {
+		if v := m.versions[i]; v.EntryID == id && v.Tenant == tenant {
+			versions = append(versions, &v)
+		}
+	}
+
+	return versions, nil
+}
+
+func (m *MemoryStore) DeleteAll(tenant string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var kept []LogEntry
+	for _, entry := range m.entries {
+		if entry.Tenant != tenant {
+			kept = append(kept, entry)
+		}
+	}
+	deleted := int64(len(m.entries) - len(kept))
+	m.entries = kept
+
+	var versions []LogVersion
+	for _, v := range m.versions {
+		if v.Tenant != tenant {
+			versions = append(versions, v)
+		}
+	}
+	m.versions = versions
+
+	return deleted, nil
+}
+
+// Referencing matches terms against data and attribute_text, as the filter sent to
+// MongoDB does
+func (m *MemoryStore) Referencing(terms []string) ([]*LogEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	re := regexp.MustCompile("(?i)" + termsPattern(terms))
+
+	logs := []*LogEntry{}
+	for i := range m.entries {
+		if entry := m.entries[i]; matchesText(re, entry.Data, entry.AttributeText) {
+			logs = append(logs, &entry)
+		}
+	}
+
+	sort.SliceStable(logs, func(i, j int) bool { return logs[i].CreatedAt.Before(logs[j].CreatedAt) })
+
+	return logs, nil
+}
+
+func (m *MemoryStore) Pseudonymise(terms []string, pseudonym string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	re := regexp.MustCompile("(?i)" + termsPattern(terms))
+
+	changed := 0
+	for i, entry := range m.entries {
+		if !matchesText(re, entry.Data, entry.AttributeText) {
+			continue
+		}
+
+		entry.Data = re.ReplaceAllLiteralString(entry.Data, pseudonym)
+		if entry.Attributes != nil {
+			entry.Attributes = replaceIn(entry.Attributes, re, pseudonym).(map[string]any)
+			entry.AttributeText = attributeText(entry.Attributes)
+		}
+		entry.UpdatedAt = storedNow()
+		m.entries[i] = entry
+		changed++
+	}
+
+	for i, v := range m.versions {
+		if !matchesText(re, v.Data, v.AttributeText) {
+			continue
+		}
+
+		v.Data = re.ReplaceAllLiteralString(v.Data, pseudonym)
+		if v.Attributes != nil {
+			v.Attributes = replaceIn(v.Attributes, re, pseudonym).(map[string]any)
+			v.AttributeText = attributeText(v.Attributes)
+		}
+		m.versions[i] = v
+	}
+
+	return changed, nil
+}
+
+func matchesText(re *regexp.Regexp, data string, attributeText []string) bool {
+	if re.MatchString(data) {
+		return true
+	}
+
+	for _, text := range attributeText {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *MemoryStore) Stats() (*CollectionStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := &CollectionStats{
+		Count:    int64(len(m.entries)),
+		Indexes:  []string{},
+		Versions: int64(len(m.versions)),
+	}
+
+	for i := range m.entries {
+		if created := m.entries[i].CreatedAt; stats.Oldest == nil || created.Before(*stats.Oldest) {
+			stats.Oldest = &created
+		}
+	}
+
+	return stats, nil
+}
@@ -2,7 +2,11 @@ package data
 
 import (
 	"context"
+	"errors"
 	"log"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -25,22 +29,53 @@ type Models struct {
 	LogEntry LogEntry
 }
 
+// LogEntry is a log record. Only Name and Data were written before entries were
+// structured, so older entries leave the other fields empty. Attributes is stored
+// as a subdocument, so its values can be queried like any other field.
+// AttributeText keeps every string within Attributes, however deep, so that data
+// subject requests can find a person in them without reading every entry.
 type LogEntry struct {
-	ID        string    `bson:"_id,omitempty" json:"id,omitempty"`
-	Name      string    `bson:"name" json:"name"`
-	Data      string    `bson:"data" json:"data"`
-	CreatedAt time.Time `bson:"created_at" json:"created_at"`
-	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+	ID         string         `bson:"_id,omitempty" json:"id,omitempty"`
+	Name       string         `bson:"name" json:"name"`
+	Data       string         `bson:"data" json:"data"`
+	Tenant     string         `bson:"tenant,omitempty" json:"tenant,omitempty"`
+	Severity   string         `bson:"severity,omitempty" json:"severity,omitempty"`
+	Source     string         `bson:"source,omitempty" json:"source,omitempty"`
+	Host       string         `bson:"host,omitempty" json:"host,omitempty"`
+	RequestID  string         `bson:"request_id,omitempty" json:"request_id,omitempty"`
+	TraceID    string         `bson:"trace_id,omitempty" json:"trace_id,omitempty"`
+	UserID     string         `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Attributes map[string]any `bson:"attributes,omitempty" json:"attributes,omitempty"`
+	CreatedAt  time.Time      `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time      `bson:"updated_at" json:"updated_at"`
+
+	AttributeText []string `bson:"attribute_text,omitempty" json:"-"`
 }
 
+// Severities are the levels an entry may have, least severe first
+var Severities = []string{"debug", "info", "warning", "error", "critical"}
+
+// DefaultSeverity is the level of entries that do not give one
+const DefaultSeverity = "info"
+
 func (l *LogEntry) Insert(entry LogEntry) error {
 	collection := client.Database("logs").Collection("logs")
 
 	_, err := collection.InsertOne(context.TODO(), LogEntry{
-		Name: entry.Name,
-		Data: entry.Data,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		Name:       entry.Name,
+		Data:       entry.Data,
+		Tenant:     entry.Tenant,
+		Severity:   entry.Severity,
+		Source:     entry.Source,
+		Host:       entry.Host,
+		RequestID:  entry.RequestID,
+		TraceID:    entry.TraceID,
+		UserID:     entry.UserID,
+		Attributes: entry.Attributes,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+
+		AttributeText: attributeText(entry.Attributes),
 	})
 	if err != nil {
 		log.Println("Error inserting into logs:", err)
@@ -50,98 +85,462 @@ func (l *LogEntry) Insert(entry LogEntry) error {
 	return nil
 }
 
-func (l *LogEntry) All() ([]*LogEntry, error) {
+// GetOne returns an entry by id, as long as it belongs to tenant
+func (l *LogEntry) GetOne(id, tenant string) (*LogEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	collection := client.Database("logs").Collection("logs")
+
+	// an id that is not an object id cannot name an entry
+	docID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	var entry LogEntry
+	err = collection.FindOne(ctx, bson.M{"_id": docID, "tenant": tenantFilter(tenant)}).Decode(&entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// tenantFilter matches the entries of tenant. Entries written without a tenant are
+// stored without the field, so those are matched by its absence.
+func tenantFilter(tenant string) any {
+	if tenant == "" {
+		return bson.M{"$exists": false}
+	}
+
+	return tenant
+}
+
+// Referencing returns the entries of every tenant whose data or attributes mention
+// any of terms, oldest first. It answers data subject requests, which are about a
+// person wherever their records are kept.
+func (l *LogEntry) Referencing(terms []string) ([]*LogEntry, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
 	collection := client.Database("logs").Collection("logs")
 
 	opts := options.Find()
-	opts.SetSort(bson.D{{"created_at", -1}})
+	opts.SetSort(bson.D{{Key: "created_at", Value: 1}})
 
-	cursor, err := collection.Find(context.TODO(), bson.D{}, opts)
+	cursor, err := collection.Find(ctx, mentioning(terms), opts)
 	if err != nil {
-		log.Println("Finding all docs error:", err)
 		return nil, err
 	}
 	defer cursor.Close(ctx)
 
-	var logs []*LogEntry
-
+	logs := []*LogEntry{}
 	for cursor.Next(ctx) {
-		var item LogEntry
-
-		err := cursor.Decode(&item)
-		if err != nil {
-			log.Print("Error decoding log into slice:", err)
+		var entry LogEntry
+		if err := cursor.Decode(&entry); err != nil {
 			return nil, err
-		} else {
-			logs = append(logs, &item)
 		}
+
+		logs = append(logs, &entry)
 	}
 
-	return logs, nil
+	return logs, cursor.Err()
 }
 
-func (l *LogEntry) GetOne(id string) (*LogEntry, error) {
+// Pseudonymise replaces every mention of terms in the entries' data with pseudonym,
+// in every tenant, and returns how many entries it changed. Earlier versions of the
+// entries are rewritten too, but not counted.
+func (l *LogEntry) Pseudonymise(terms []string, pseudonym string) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
-	collection := client.Database("logs").Collection("logs")
+	db := client.Database("logs")
 
-	docID, err := primitive.ObjectIDFromHex(id)
+	changed, err := pseudonymiseIn(ctx, db.Collection("logs"), terms, pseudonym, true)
 	if err != nil {
-		return nil, err
+		return changed, err
 	}
 
-	var entry LogEntry
-	err = collection.FindOne(ctx, bson.M{"_id": docID}).Decode(&entry)
+	_, err = pseudonymiseIn(ctx, db.Collection("versions"), terms, pseudonym, false)
+
+	return changed, err
+}
+
+// pseudonymiseIn replaces every mention of terms in the data and attributes of the
+// documents in collection with pseudonym, and returns how many documents it changed.
+// touch sets their updated_at as well; versions keep the time of the update they
+// record.
+func pseudonymiseIn(ctx context.Context, collection *mongo.Collection, terms []string, pseudonym string, touch bool) (int, error) {
+	cursor, err := collection.Find(ctx, mentioning(terms))
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
+	defer cursor.Close(ctx)
 
-	return &entry, nil
+	re := regexp.MustCompile("(?i)" + termsPattern(terms))
+
+	changed := 0
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID         primitive.ObjectID `bson:"_id"`
+			Data       string             `bson:"data"`
+			Attributes map[string]any     `bson:"attributes,omitempty"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return changed, err
+		}
+
+		// a document already rewritten can come round again as the cursor moves on
+		if !re.MatchString(doc.Data) && !mentions(doc.Attributes, re) {
+			continue
+		}
+
+		set := bson.M{"data": re.ReplaceAllLiteralString(doc.Data, pseudonym)}
+		if doc.Attributes != nil {
+			attributes := replaceIn(doc.Attributes, re, pseudonym).(map[string]any)
+			set["attributes"] = attributes
+			set["attribute_text"] = attributeText(attributes)
+		}
+		if touch {
+			set["updated_at"] = time.Now()
+		}
+
+		_, err = collection.UpdateOne(ctx, bson.M{"_id": doc.ID}, bson.M{"$set": set})
+		if err != nil {
+			return changed, err
+		}
+
+		changed++
+	}
+
+	return changed, cursor.Err()
 }
 
-func (l *LogEntry) DropCollection() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+// mentioning selects the documents whose data, or any string within their
+// attributes, mentions terms
+func mentioning(terms []string) bson.M {
+	re := termsRegex(terms)
+
+	return bson.M{"$or": bson.A{
+		bson.M{"data": re},
+		bson.M{"attribute_text": re},
+	}}
+}
+
+// attributeText returns every string within attributes, however deep, for
+// attribute_text
+func attributeText(attributes map[string]any) []string {
+	var text []string
+
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case string:
+			text = append(text, v)
+		case map[string]any:
+			for _, item := range v {
+				walk(item)
+			}
+		case bson.M:
+			walk(map[string]any(v))
+		case bson.D:
+			for _, item := range v {
+				walk(item.Value)
+			}
+		case []any:
+			for _, item := range v {
+				walk(item)
+			}
+		case bson.A:
+			walk([]any(v))
+		}
+	}
+	walk(attributes)
+
+	sort.Strings(text)
+
+	return text
+}
+
+// IndexAttributeText fills in attribute_text on entries, and their versions, written
+// before it was kept. Entries that have it already are left alone, so it is safe to
+// call every time the service starts.
+func (l *LogEntry) IndexAttributeText() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
 	defer cancel()
 
-	collection := client.Database("logs").Collection("logs")
+	db := client.Database("logs")
 
-	if err := collection.Drop(ctx); err != nil {
-		return err
+	for _, name := range []string{"logs", "versions"} {
+		collection := db.Collection(name)
+
+		cursor, err := collection.Find(ctx, bson.M{
+			"attributes":     bson.M{"$exists": true},
+			"attribute_text": bson.M{"$exists": false},
+		})
+		if err != nil {
+			return err
+		}
+
+		for cursor.Next(ctx) {
+			var doc struct {
+				ID         primitive.ObjectID `bson:"_id"`
+				Attributes map[string]any     `bson:"attributes"`
+			}
+			if err := cursor.Decode(&doc); err != nil {
+				cursor.Close(ctx)
+				return err
+			}
+
+			_, err = collection.UpdateOne(ctx, bson.M{"_id": doc.ID}, bson.M{"$set": bson.M{"attribute_text": attributeText(doc.Attributes)}})
+			if err != nil {
+				cursor.Close(ctx)
+				return err
+			}
+		}
+
+		err = cursor.Err()
+		cursor.Close(ctx)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (l *LogEntry) Update() (*mongo.UpdateResult, error) {
+// mentions reports whether any string within v matches re
+func mentions(v any, re *regexp.Regexp) bool {
+	switch v := v.(type) {
+	case string:
+		return re.MatchString(v)
+	case map[string]any:
+		for _, item := range v {
+			if mentions(item, re) {
+				return true
+			}
+		}
+	case bson.M:
+		return mentions(map[string]any(v), re)
+	case []any:
+		for _, item := range v {
+			if mentions(item, re) {
+				return true
+			}
+		}
+	case bson.A:
+		return mentions([]any(v), re)
+	}
+
+	return false
+}
+
+// replaceIn returns v with every match of re in the strings within it replaced by
+// replacement
+func replaceIn(v any, re *regexp.Regexp, replacement string) any {
+	switch v := v.(type) {
+	case string:
+		return re.ReplaceAllLiteralString(v, replacement)
+	case map[string]any:
+		replaced := make(map[string]any, len(v))
+		for key, item := range v {
+			replaced[key] = replaceIn(item, re, replacement)
+		}
+		return replaced
+	case bson.M:
+		return replaceIn(map[string]any(v), re, replacement)
+	case []any:
+		replaced := make([]any, len(v))
+		for i, item := range v {
+			replaced[i] = replaceIn(item, re, replacement)
+		}
+		return replaced
+	case bson.A:
+		return replaceIn([]any(v), re, replacement)
+	}
+
+	return v
+}
+
+// termsPattern matches any of terms as a whole word
+func termsPattern(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = regexp.QuoteMeta(term)
+	}
+
+	return `\b(?:` + strings.Join(quoted, "|") + `)\b`
+}
+
+// termsRegex matches any of terms as a whole word, ignoring case, in a query
+func termsRegex(terms []string) primitive.Regex {
+	return primitive.Regex{Pattern: termsPattern(terms), Options: "i"}
+}
+
+// DeleteAll deletes every one of a tenant's entries, and their earlier versions, and
+// returns how many entries it deleted
+func (l *LogEntry) DeleteAll(tenant string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	db := client.Database("logs")
+
+	result, err := db.Collection("logs").DeleteMany(ctx, bson.M{"tenant": tenantFilter(tenant)})
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = db.Collection("versions").DeleteMany(ctx, bson.M{"tenant": tenantFilter(tenant)})
+	if err != nil {
+		return result.DeletedCount, err
+	}
+
+	return result.DeletedCount, nil
+}
+
+// LogVersion is an entry as it was before an update replaced it
+type LogVersion struct {
+	ID         string         `bson:"_id,omitempty" json:"id,omitempty"`
+	EntryID    string         `bson:"entry_id" json:"entry_id"`
+	Name       string         `bson:"name" json:"name"`
+	Data       string         `bson:"data" json:"data"`
+	Attributes map[string]any `bson:"attributes,omitempty" json:"attributes,omitempty"`
+	Tenant     string         `bson:"tenant,omitempty" json:"tenant,omitempty"`
+	UpdatedAt  time.Time      `bson:"updated_at" json:"updated_at"`
+	ReplacedAt time.Time      `bson:"replaced_at" json:"replaced_at"`
+	ReplacedBy string         `bson:"replaced_by" json:"replaced_by"`
+
+	AttributeText []string `bson:"attribute_text,omitempty" json:"-"`
+}
+
+// ErrConflict is returned when an entry changed while it was being updated
+var ErrConflict = errors.New("the entry was changed by someone else, try again")
+
+// Update replaces the name, data and attributes of the tenant's entry with entry.ID
+// with entry's, on behalf of editor. The entry as it was is kept as a version first,
+// so every edit can be audited. When entry.UpdatedAt is set, it must be when the
+// entry was last updated, or the edit is refused with ErrConflict as made to a
+// version that has since been replaced.
+func (l *LogEntry) Update(entry LogEntry, tenant, editor string) (*LogEntry, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
-	collection := client.Database("logs").Collection("logs")
+	db := client.Database("logs")
 
-	docID, err := primitive.ObjectIDFromHex(l.ID)
+	current, err := l.GetOne(entry.ID, tenant)
 	if err != nil {
 		return nil, err
 	}
 
-	result, err := collection.UpdateOne(
-		ctx,
-		bson.M{"_id": docID},
-		bson.D{
-			{"$set", bson.D{
-				{"name", l.Name},
-				{"data", l.Data},
-				{"updated_at", time.Now()},
+	if stale(entry, current) {
+		return nil, ErrConflict
+	}
+
+	now := nextUpdate(current.UpdatedAt)
+
+	inserted, err := db.Collection("versions").InsertOne(ctx, LogVersion{
+		EntryID:    current.ID,
+		Name:       current.Name,
+		Data:       current.Data,
+		Attributes: current.Attributes,
+		Tenant:     current.Tenant,
+		UpdatedAt:  current.UpdatedAt,
+		ReplacedAt: now,
+		ReplacedBy: editor,
+
+		AttributeText: current.AttributeText,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	docID, _ := primitive.ObjectIDFromHex(current.ID)
+
+	// only if nobody else has updated the entry since it was read, so that the
+	// version just kept really is the one being replaced
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "name", Value: entry.Name},
+		{Key: "data", Value: entry.Data},
+		{Key: "attributes", Value: entry.Attributes},
+		{Key: "attribute_text", Value: attributeText(entry.Attributes)},
+		{Key: "updated_at", Value: now},
+	}}}
+	if entry.Attributes == nil {
+		update = bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "name", Value: entry.Name},
+				{Key: "data", Value: entry.Data},
+				{Key: "updated_at", Value: now},
 			}},
-		},
+			{Key: "$unset", Value: bson.D{
+				{Key: "attributes", Value: ""},
+				{Key: "attribute_text", Value: ""},
+			}},
+		}
+	}
+
+	result, err := db.Collection("logs").UpdateOne(
+		ctx,
+		bson.M{"_id": docID, "updated_at": current.UpdatedAt},
+		update,
 	)
+	if err == nil && result.MatchedCount == 0 {
+		err = ErrConflict
+	}
+
+	if err != nil {
+		if _, delErr := db.Collection("versions").DeleteOne(ctx, bson.M{"_id": inserted.InsertedID}); delErr != nil {
+			log.Println("Error removing unused version:", delErr)
+		}
+		return nil, err
+	}
+
+	updated := *current
+	updated.Name, updated.Data, updated.Attributes, updated.UpdatedAt = entry.Name, entry.Data, entry.Attributes, now
+	updated.AttributeText = attributeText(entry.Attributes)
+
+	return &updated, nil
+}
+
+// stale reports whether entry was edited from an older version than current
+func stale(entry LogEntry, current *LogEntry) bool {
+	return !entry.UpdatedAt.IsZero() && !entry.UpdatedAt.Equal(current.UpdatedAt)
+}
+
+// nextUpdate returns the time to stamp an update to an entry last updated at
+// previous. MongoDB keeps milliseconds, so it is bumped past previous when two
+// updates land in the same one; otherwise an edit made from the first would not
+// look stale after the second.
+func nextUpdate(previous time.Time) time.Time {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	if !now.After(previous) {
+		now = previous.Add(time.Millisecond)
+	}
+
+	return now
+}
+
+// Versions returns the earlier versions of the tenant's entry, oldest first
+func (l *LogEntry) Versions(id, tenant string) ([]*LogVersion, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	collection := client.Database("logs").Collection("versions")
 
+	opts := options.Find()
+	opts.SetSort(bson.D{{Key: "replaced_at", Value: 1}, {Key: "_id", Value: 1}})
+
+	cursor, err := collection.Find(ctx, bson.M{"entry_id": id, "tenant": tenantFilter(tenant)}, opts)
 	if err != nil {
 		return nil, err
 	}
+	defer cursor.Close(ctx)
 
-	return result, nil
-}
\ No newline at end of file
+	versions := []*LogVersion{}
+	if err := cursor.All(ctx, &versions); err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
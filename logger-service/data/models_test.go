@@ -0,0 +1,172 @@
+package data
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestTermsPattern(t *testing.T) {
+	re := regexp.MustCompile("(?i)" + termsPattern([]string{"ann@example.com", "Ann Smith"}))
+
+	for _, tc := range []struct {
+		text string
+		want bool
+	}{
+		{"mail sent to ann@example.com", true},
+		{"mail sent to ANN@EXAMPLE.COM", true},
+		{"signed up as ann smith", true},
+		{"mail sent to joann@example.com", false},
+		{"mail sent to ann@example.community", false},
+		{"ann@exampleXcom is not an address", false},
+	} {
+		if got := re.MatchString(tc.text); got != tc.want {
+			t.Errorf("%q: expected %v, got %v", tc.text, tc.want, got)
+		}
+	}
+}
+
+func TestMentioning(t *testing.T) {
+	filter := mentioning([]string{"ann@example.com"})
+	re := termsRegex([]string{"ann@example.com"})
+
+	// the match is done by MongoDB, on data and the strings from attributes, rather
+	// than by reading every entry that has attributes
+	want := bson.M{"$or": bson.A{
+		bson.M{"data": re},
+		bson.M{"attribute_text": re},
+	}}
+	if !reflect.DeepEqual(filter, want) {
+		t.Fatalf("expected %v, got %v", want, filter)
+	}
+}
+
+func TestAttributeText(t *testing.T) {
+	attributes := map[string]any{
+		"user":  map[string]any{"email": "ann@example.com", "id": 7},
+		"tags":  []any{"signup", bson.M{"via": "mobile"}},
+		"count": 3,
+		"ok":    true,
+		"note":  "welcome",
+	}
+
+	want := []string{"ann@example.com", "mobile", "signup", "welcome"}
+	if got := attributeText(attributes); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	if got := attributeText(nil); got != nil {
+		t.Fatalf("expected nothing for no attributes, got %v", got)
+	}
+}
+
+func TestReplaceIn(t *testing.T) {
+	re := regexp.MustCompile("(?i)" + termsPattern([]string{"ann@example.com"}))
+
+	attributes := map[string]any{
+		"user": map[string]any{"email": "Ann@Example.com", "id": 7},
+		"cc":   []any{"bob@example.com", "ann@example.com"},
+	}
+
+	if !mentions(attributes, re) {
+		t.Fatal("expected the attributes to mention the term")
+	}
+
+	replaced := replaceIn(attributes, re, "subject-1")
+
+	want := map[string]any{
+		"user": map[string]any{"email": "subject-1", "id": 7},
+		"cc":   []any{"bob@example.com", "subject-1"},
+	}
+	if !reflect.DeepEqual(replaced, want) {
+		t.Fatalf("expected %v, got %v", want, replaced)
+	}
+
+	if mentions(replaced, re) {
+		t.Fatal("expected no mention after replacing")
+	}
+
+	// the original is left alone
+	if attributes["cc"].([]any)[1] != "ann@example.com" {
+		t.Fatalf("replaceIn changed its argument: %v", attributes)
+	}
+}
+
+func TestMemoryStoreSubjects(t *testing.T) {
+	store := NewMemoryStore()
+
+	for _, entry := range []LogEntry{
+		{Name: "mail", Data: "sent a receipt to ann@example.com", Tenant: "1"},
+		{Name: "signup", Data: "new user", Attributes: map[string]any{"email": "ANN@example.com"}, Tenant: "2"},
+		{Name: "mail", Data: "sent a receipt to joann@example.com", Tenant: "1"},
+		{Name: "login", Data: "user logged in", Tenant: "1"},
+	} {
+		if err := store.Insert(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the login entry mentioned ann before it was edited, so a version does too
+	login := store.entries[3]
+	login.Data = "user 7 logged in"
+	login.Attributes = map[string]any{"who": "ann@example.com"}
+	edited, err := store.Update(login, "1", "editor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	login = *edited
+	login.Data, login.Attributes = "user 7 logged in again", nil
+	if _, err := store.Update(login, "1", "editor"); err != nil {
+		t.Fatal(err)
+	}
+
+	terms := []string{"ann@example.com"}
+
+	found, err := store.Referencing(terms)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, entry := range found {
+		names = append(names, entry.Name)
+	}
+	if want := []string{"mail", "signup"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("expected %v, got %v", want, names)
+	}
+
+	changed, err := store.Pseudonymise(terms, "subject-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed != 2 {
+		t.Fatalf("expected 2 entries changed, got %d", changed)
+	}
+
+	if found, _ := store.Referencing(terms); len(found) != 0 {
+		t.Fatalf("expected no entries to mention the subject after erasure, got %d", len(found))
+	}
+
+	for _, entry := range store.entries {
+		if strings.Contains(strings.ToLower(entry.Data), "ann@") && !strings.Contains(entry.Data, "joann@") {
+			t.Errorf("entry still mentions the subject: %q", entry.Data)
+		}
+	}
+	if got := store.entries[1].Attributes["email"]; got != "subject-1" {
+		t.Errorf("expected the attribute to be pseudonymised, got %v", got)
+	}
+
+	versions, err := store.Versions(login.ID, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[1].Attributes["who"] != "subject-1" {
+		t.Fatalf("expected the version's attributes to be pseudonymised, got %+v", versions)
+	}
+	if !reflect.DeepEqual(versions[1].AttributeText, []string{"subject-1"}) {
+		t.Fatalf("expected attribute_text to follow the attributes, got %v", versions[1].AttributeText)
+	}
+}
@@ -0,0 +1,292 @@
+package data
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// DefaultQueryLimit is how many entries a query returns when it does not say
+	DefaultQueryLimit = 50
+	// MaxQueryLimit is the most entries a query may return at once
+	MaxQueryLimit = 500
+)
+
+// ErrInvalidCursor is returned for a cursor that was not made by Query
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// QueryFields maps the fields a query may ask for to the names they are stored under
+var QueryFields = map[string]string{
+	"id":         "_id",
+	"name":       "name",
+	"data":       "data",
+	"tenant":     "tenant",
+	"severity":   "severity",
+	"source":     "source",
+	"host":       "host",
+	"request_id": "request_id",
+	"trace_id":   "trace_id",
+	"user_id":    "user_id",
+	"attributes": "attributes",
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+}
+
+// LogQuery picks out a page of a tenant's entries. Empty fields match everything.
+type LogQuery struct {
+	Tenant     string
+	Names      []string
+	Severities []string
+	From       time.Time // inclusive
+	To         time.Time // exclusive
+	Text       string    // words to search the entries' data for
+	Sources    []string
+	RequestID  string
+	TraceID    string
+	UserID     string
+	Ascending  bool   // oldest first, rather than newest first
+	Cursor     string // from the previous page, to get the one after it
+	Limit      int
+	Fields     []string // names from QueryFields; every field when empty
+}
+
+// LogPage is a page of entries. NextCursor is empty on the last page.
+type LogPage struct {
+	Entries    []map[string]any `json:"entries"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// EnsureIndexes creates the indexes queries and retention rely on. Creating an index
+// that already exists does nothing, so it is safe to call every time the service
+// starts.
+func (l *LogEntry) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	collection := client.Database("logs").Collection("logs")
+
+	newest := bson.E{Key: "created_at", Value: -1}
+	tiebreak := bson.E{Key: "_id", Value: -1}
+
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tenant", Value: 1}, newest, tiebreak}},
+		{Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "name", Value: 1}, newest, tiebreak}},
+		{Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "severity", Value: 1}, newest, tiebreak}},
+		{Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "request_id", Value: 1}, newest, tiebreak}},
+		{Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "trace_id", Value: 1}, newest, tiebreak}},
+		{Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "user_id", Value: 1}, newest, tiebreak}},
+		{Keys: bson.D{{Key: "data", Value: "text"}}},
+		// for retention, which purges across tenants
+		{Keys: bson.D{{Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "name", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "severity", Value: 1}, {Key: "created_at", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Database("logs").Collection("versions").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "entry_id", Value: 1}, {Key: "replaced_at", Value: 1}}},
+		{Keys: bson.D{{Key: "replaced_at", Value: 1}}},
+	})
+
+	return err
+}
+
+// Query returns a page of entries ordered by when they were written. Entries written
+// in the same instant are ordered by id, so paging never skips or repeats one.
+func (l *LogEntry) Query(q LogQuery) (*LogPage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	collection := client.Database("logs").Collection("logs")
+
+	filter := bson.D{{Key: "tenant", Value: tenantFilter(q.Tenant)}}
+
+	if len(q.Names) > 0 {
+		filter = append(filter, bson.E{Key: "name", Value: bson.M{"$in": q.Names}})
+	}
+	if len(q.Severities) > 0 {
+		// entries written before they had a severity count as the default one
+		severities := bson.A{}
+		for _, severity := range q.Severities {
+			severities = append(severities, severity)
+			if severity == DefaultSeverity {
+				severities = append(severities, nil)
+			}
+		}
+		filter = append(filter, bson.E{Key: "severity", Value: bson.M{"$in": severities}})
+	}
+	if len(q.Sources) > 0 {
+		filter = append(filter, bson.E{Key: "source", Value: bson.M{"$in": q.Sources}})
+	}
+	if q.RequestID != "" {
+		filter = append(filter, bson.E{Key: "request_id", Value: q.RequestID})
+	}
+	if q.TraceID != "" {
+		filter = append(filter, bson.E{Key: "trace_id", Value: q.TraceID})
+	}
+	if q.UserID != "" {
+		filter = append(filter, bson.E{Key: "user_id", Value: q.UserID})
+	}
+
+	created := bson.M{}
+	if !q.From.IsZero() {
+		created["$gte"] = q.From
+	}
+	if !q.To.IsZero() {
+		created["$lt"] = q.To
+	}
+	if len(created) > 0 {
+		filter = append(filter, bson.E{Key: "created_at", Value: created})
+	}
+
+	if q.Text != "" {
+		filter = append(filter, bson.E{Key: "$text", Value: bson.M{"$search": q.Text}})
+	}
+
+	direction, past := -1, "$lt"
+	if q.Ascending {
+		direction, past = 1, "$gt"
+	}
+
+	if q.Cursor != "" {
+		at, id, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+			bson.M{"created_at": bson.M{past: at}},
+			bson.M{"created_at": at, "_id": bson.M{past: id}},
+		}})
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	} else if limit > MaxQueryLimit {
+		limit = MaxQueryLimit
+	}
+
+	// one more than asked for, to tell whether there is another page
+	opts := options.Find()
+	opts.SetSort(bson.D{{Key: "created_at", Value: direction}, {Key: "_id", Value: direction}})
+	opts.SetLimit(int64(limit + 1))
+
+	if len(q.Fields) > 0 {
+		projection := bson.M{"_id": 1, "created_at": 1}
+		for _, field := range q.Fields {
+			stored, ok := QueryFields[field]
+			if !ok {
+				return nil, fmt.Errorf("unknown field %q", field)
+			}
+			projection[stored] = 1
+		}
+		opts.SetProjection(projection)
+	}
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var entries []*LogEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+
+	page := &LogPage{Entries: []map[string]any{}}
+
+	if len(entries) > limit {
+		entries = entries[:limit]
+		last := entries[limit-1]
+		page.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+
+	for _, entry := range entries {
+		page.Entries = append(page.Entries, entry.project(q.Fields))
+	}
+
+	return page, nil
+}
+
+// project returns the entry's fields, or only those named when fields is not empty
+func (l *LogEntry) project(fields []string) map[string]any {
+	all := map[string]any{
+		"id":         l.ID,
+		"name":       l.Name,
+		"data":       l.Data,
+		"tenant":     l.Tenant,
+		"severity":   l.Severity,
+		"source":     l.Source,
+		"host":       l.Host,
+		"request_id": l.RequestID,
+		"trace_id":   l.TraceID,
+		"user_id":    l.UserID,
+		"attributes": l.Attributes,
+		"created_at": l.CreatedAt,
+		"updated_at": l.UpdatedAt,
+	}
+
+	if len(fields) == 0 {
+		for _, optional := range []string{"tenant", "severity", "source", "host", "request_id", "trace_id", "user_id"} {
+			if all[optional] == "" {
+				delete(all, optional)
+			}
+		}
+		if l.Attributes == nil {
+			delete(all, "attributes")
+		}
+		return all
+	}
+
+	picked := make(map[string]any, len(fields))
+	for _, field := range fields {
+		picked[field] = all[field]
+	}
+
+	return picked
+}
+
+// encodeCursor makes an opaque cursor pointing at the entry written at t with id
+func encodeCursor(t time.Time, id string) string {
+	raw := strconv.FormatInt(t.UnixMilli(), 10) + ":" + id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor undoes encodeCursor
+func decodeCursor(cursor string) (time.Time, primitive.ObjectID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, primitive.NilObjectID, ErrInvalidCursor
+	}
+
+	millis, hex, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return time.Time{}, primitive.NilObjectID, ErrInvalidCursor
+	}
+
+	ms, err := strconv.ParseInt(millis, 10, 64)
+	if err != nil {
+		return time.Time{}, primitive.NilObjectID, ErrInvalidCursor
+	}
+
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		return time.Time{}, primitive.NilObjectID, ErrInvalidCursor
+	}
+
+	return time.UnixMilli(ms).UTC(), id, nil
+}
@@ -0,0 +1,109 @@
+package data
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	id := primitive.NewObjectID()
+	at := time.Date(2024, 3, 1, 12, 30, 0, 123456789, time.UTC)
+
+	gotAt, gotID, err := decodeCursor(encodeCursor(at, id.Hex()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// MongoDB keeps milliseconds, so that is all a cursor carries
+	if !gotAt.Equal(at.Truncate(time.Millisecond)) || gotID != id {
+		t.Fatalf("expected %v %v, got %v %v", at.Truncate(time.Millisecond), id, gotAt, gotID)
+	}
+}
+
+func TestDecodeCursorRejectsMalformed(t *testing.T) {
+	id := primitive.NewObjectID().Hex()
+	encode := func(raw string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(raw))
+	}
+
+	valid := encodeCursor(time.Now(), id)
+
+	for _, tc := range []struct {
+		name   string
+		cursor string
+	}{
+		{"not base64", "not a cursor!"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte("1700000000000:" + id))},
+		{"truncated", valid[:len(valid)-3]},
+		{"no separator", encode("1700000000000" + id)},
+		{"time not a number", encode("yesterday:" + id)},
+		{"empty time", encode(":" + id)},
+		{"id not hex", encode("1700000000000:" + "zz" + id[2:])},
+		{"id too short", encode("1700000000000:" + id[:20])},
+		{"empty id", encode("1700000000000:")},
+		{"id with more after it", encode("1700000000000:" + id + ":1")},
+	} {
+		if _, _, err := decodeCursor(tc.cursor); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("%s: expected ErrInvalidCursor, got %v", tc.name, err)
+		}
+	}
+}
+
+func TestMemoryStoreQueryPages(t *testing.T) {
+	store := NewMemoryStore()
+	for _, tenant := range []string{"1", "1", "1", "2", "1", "1"} {
+		if err := store.Insert(LogEntry{Name: "event", Tenant: tenant}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seen := map[any]bool{}
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("paging did not end")
+		}
+
+		page, err := store.Query(LogQuery{Tenant: "1", Limit: 2, Cursor: cursor})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, entry := range page.Entries {
+			if seen[entry["id"]] {
+				t.Fatalf("entry %v came up twice", entry["id"])
+			}
+			seen[entry["id"]] = true
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	if len(seen) != 5 {
+		t.Fatalf("expected the tenant's 5 entries, got %d", len(seen))
+	}
+
+	// a cursor only says where to carry on from; edited, it still cannot reach
+	// another tenant's entries
+	forged := encodeCursor(time.Now().Add(time.Hour), primitive.NewObjectID().Hex())
+	page, err := store.Query(LogQuery{Tenant: "1", Cursor: forged})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range page.Entries {
+		if entry["tenant"] != "1" {
+			t.Fatalf("got another tenant's entry: %v", entry)
+		}
+	}
+
+	if _, err := store.Query(LogQuery{Tenant: "1", Cursor: "garbage"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Fatalf("expected ErrInvalidCursor, got %v", err)
+	}
+}
@@ -0,0 +1,324 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RetentionPolicy says how long entries are kept. A policy matches entries by
+// name, by severity, or, with neither set, every entry no other policy matches.
+type RetentionPolicy struct {
+	Name     string
+	Severity string
+	MaxAge   time.Duration
+}
+
+// String describes the policy in the form ParseRetention reads
+func (p RetentionPolicy) String() string {
+	return p.key() + "=" + formatAge(p.MaxAge)
+}
+
+// MarshalJSON describes the policy by what it matches and how long it keeps entries
+func (p RetentionPolicy) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Match         string `json:"match"`
+		MaxAge        string `json:"max_age"`
+		MaxAgeSeconds int64  `json:"max_age_seconds"`
+	}{p.key(), formatAge(p.MaxAge), int64(p.MaxAge / time.Second)})
+}
+
+func (p RetentionPolicy) key() string {
+	switch {
+	case p.Name != "":
+		return "name:" + p.Name
+	case p.Severity != "":
+		return "severity:" + p.Severity
+	default:
+		return "*"
+	}
+}
+
+// ParseRetention reads retention policies written as a comma separated list of
+// match=age, where match is name:<name>, severity:<severity> or * for everything
+// else, and age is a number of days followed by d, or a Go duration such as 12h.
+// For example: "severity:debug=7d,severity:error=365d,name:users=730d,*=90d".
+func ParseRetention(s string) ([]RetentionPolicy, error) {
+	var policies []RetentionPolicy
+	seen := make(map[string]bool)
+
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		match, age, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("retention policy %q: want match=age", item)
+		}
+
+		var p RetentionPolicy
+		match = strings.TrimSpace(match)
+
+		switch {
+		case match == "*":
+		case strings.HasPrefix(match, "name:"):
+			p.Name = strings.TrimPrefix(match, "name:")
+		case strings.HasPrefix(match, "severity:"):
+			p.Severity = strings.ToLower(strings.TrimPrefix(match, "severity:"))
+			if !knownSeverity(p.Severity) {
+				return nil, fmt.Errorf("retention policy %q: severity must be one of %s", item, strings.Join(Severities, ", "))
+			}
+		default:
+			return nil, fmt.Errorf("retention policy %q: match must be name:<name>, severity:<severity> or *", item)
+		}
+
+		if p.Name == "" && p.Severity == "" && match != "*" {
+			return nil, fmt.Errorf("retention policy %q: empty match", item)
+		}
+
+		maxAge, err := parseAge(strings.TrimSpace(age))
+		if err != nil || maxAge <= 0 {
+			return nil, fmt.Errorf("retention policy %q: age must be a positive number of days, such as 30d, or a duration", item)
+		}
+		p.MaxAge = maxAge
+
+		if seen[p.key()] {
+			return nil, fmt.Errorf("retention policy %q: %s is given twice", item, p.key())
+		}
+		seen[p.key()] = true
+
+		policies = append(policies, p)
+	}
+
+	// most specific first, which is also the order they are applied in
+	sort.SliceStable(policies, func(i, j int) bool {
+		return rank(policies[i]) < rank(policies[j])
+	})
+
+	return policies, nil
+}
+
+// rank orders policies by how specific they are: names, then severities, then the
+// one for everything else
+func rank(p RetentionPolicy) int {
+	switch {
+	case p.Name != "":
+		return 0
+	case p.Severity != "":
+		return 1
+	default:
+		return 2
+	}
+}
+
+func knownSeverity(severity string) bool {
+	for _, known := range Severities {
+		if severity == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseAge(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(s)
+}
+
+func formatAge(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return strconv.Itoa(int(d/(24*time.Hour))) + "d"
+	}
+
+	return d.String()
+}
+
+// retentionFilter matches the entries p governs. An entry is governed by the most
+// specific policy that matches it, so a severity policy leaves out names that have
+// their own, and the policy for everything else leaves out both. Entries written
+// before they had a severity count as the default one.
+func retentionFilter(p RetentionPolicy, policies []RetentionPolicy) bson.M {
+	var names, severities bson.A
+	for _, other := range policies {
+		if other.Name != "" {
+			names = append(names, other.Name)
+		} else if other.Severity != "" {
+			severities = append(severities, other.Severity)
+			if other.Severity == DefaultSeverity {
+				severities = append(severities, nil)
+			}
+		}
+	}
+
+	switch {
+	case p.Name != "":
+		return bson.M{"name": p.Name}
+	case p.Severity != "":
+		severity := bson.A{p.Severity}
+		if p.Severity == DefaultSeverity {
+			severity = append(severity, nil)
+		}
+		filter := bson.M{"severity": bson.M{"$in": severity}}
+		if len(names) > 0 {
+			filter["name"] = bson.M{"$nin": names}
+		}
+		return filter
+	default:
+		filter := bson.M{}
+		if len(names) > 0 {
+			filter["name"] = bson.M{"$nin": names}
+		}
+		if len(severities) > 0 {
+			filter["severity"] = bson.M{"$nin": severities}
+		}
+		return filter
+	}
+}
+
+// Purge deletes the entries, in every tenant, that are older than the policy that
+// governs them allows, and returns how many each policy deleted, keyed by what it
+// matches. Entries no policy matches are kept. When there is a policy for
+// everything, earlier versions older than the longest policy are deleted too, as
+// their entries must be gone by then.
+func (l *LogEntry) Purge(policies []RetentionPolicy, now time.Time) (map[string]int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	db := client.Database("logs")
+
+	deleted := make(map[string]int64, len(policies))
+	var longest time.Duration
+	everything := false
+
+	for _, p := range policies {
+		filter := retentionFilter(p, policies)
+		filter["created_at"] = bson.M{"$lt": now.Add(-p.MaxAge)}
+
+		result, err := db.Collection("logs").DeleteMany(ctx, filter)
+		if err != nil {
+			return deleted, fmt.Errorf("purging %s: %w", p.key(), err)
+		}
+		deleted[p.key()] = result.DeletedCount
+
+		if p.MaxAge > longest {
+			longest = p.MaxAge
+		}
+		everything = everything || (p.Name == "" && p.Severity == "")
+	}
+
+	if everything {
+		_, err := db.Collection("versions").DeleteMany(ctx, bson.M{"replaced_at": bson.M{"$lt": now.Add(-longest)}})
+		if err != nil {
+			return deleted, fmt.Errorf("purging versions: %w", err)
+		}
+	}
+
+	return deleted, nil
+}
+
+// CollectionStats describes the logs collection
+type CollectionStats struct {
+	Count       int64      `json:"count"`
+	Size        int64      `json:"size_bytes"`
+	StorageSize int64      `json:"storage_size_bytes"`
+	IndexSize   int64      `json:"index_size_bytes"`
+	Indexes     []string   `json:"indexes"`
+	Oldest      *time.Time `json:"oldest,omitempty"`
+	Versions    int64      `json:"versions"`
+}
+
+// Stats returns the size of the logs collection, its indexes and its oldest entry
+func (l *LogEntry) Stats() (*CollectionStats, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	db := client.Database("logs")
+
+	stats := &CollectionStats{Indexes: []string{}}
+
+	// one document per shard, or just the one when the collection is not sharded
+	cursor, err := db.Collection("logs").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$collStats", Value: bson.M{"storageStats": bson.M{}}}},
+	})
+	if err != nil && !namespaceNotFound(err) {
+		return nil, err
+	}
+	if err == nil {
+		defer cursor.Close(ctx)
+
+		for cursor.Next(ctx) {
+			var shard struct {
+				StorageStats struct {
+					Count          int64 `bson:"count"`
+					Size           int64 `bson:"size"`
+					StorageSize    int64 `bson:"storageSize"`
+					TotalIndexSize int64 `bson:"totalIndexSize"`
+				} `bson:"storageStats"`
+			}
+			if err := cursor.Decode(&shard); err != nil {
+				return nil, err
+			}
+
+			stats.Count += shard.StorageStats.Count
+			stats.Size += shard.StorageStats.Size
+			stats.StorageSize += shard.StorageStats.StorageSize
+			stats.IndexSize += shard.StorageStats.TotalIndexSize
+		}
+		if err := cursor.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	specs, err := db.Collection("logs").Indexes().ListSpecifications(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, spec := range specs {
+		stats.Indexes = append(stats.Indexes, spec.Name)
+	}
+
+	opts := options.FindOne()
+	opts.SetSort(bson.D{{Key: "created_at", Value: 1}})
+	opts.SetProjection(bson.M{"created_at": 1})
+
+	var oldest LogEntry
+	err = db.Collection("logs").FindOne(ctx, bson.M{}, opts).Decode(&oldest)
+	if err == nil {
+		stats.Oldest = &oldest.CreatedAt
+	} else if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	stats.Versions, err = db.Collection("versions").EstimatedDocumentCount(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// namespaceNotFound reports whether err is MongoDB saying the collection does not
+// exist yet, which $collStats does before the first entry is written
+func namespaceNotFound(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && cmdErr.Code == 26
+}
@@ -0,0 +1,136 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const day = 24 * time.Hour
+
+func TestParseRetention(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   string
+		want []RetentionPolicy
+	}{
+		{"nothing", "", nil},
+		{"only commas", " , ,", nil},
+		{"default", "*=90d", []RetentionPolicy{{MaxAge: 90 * day}}},
+		{"duration", "severity:debug=36h", []RetentionPolicy{{Severity: "debug", MaxAge: 36 * time.Hour}}},
+		{"severity in any case", "severity:ERROR=365d", []RetentionPolicy{{Severity: "error", MaxAge: 365 * day}}},
+		{
+			"names, then severities, then the default",
+			"*=90d, severity:debug=7d, name:users=730d, severity:error=365d, name:mail=30d",
+			[]RetentionPolicy{
+				{Name: "users", MaxAge: 730 * day},
+				{Name: "mail", MaxAge: 30 * day},
+				{Severity: "debug", MaxAge: 7 * day},
+				{Severity: "error", MaxAge: 365 * day},
+				{MaxAge: 90 * day},
+			},
+		},
+	} {
+		got, err := ParseRetention(tc.in)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestParseRetentionRejects(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   string
+	}{
+		{"no age", "*"},
+		{"empty age", "*="},
+		{"not a number of days", "*=ninetyd"},
+		{"not a duration", "*=90"},
+		{"zero", "*=0d"},
+		{"negative days", "*=-7d"},
+		{"negative duration", "*=-1h"},
+		{"unknown severity", "severity:fatal=7d"},
+		{"empty severity", "severity:=7d"},
+		{"empty name", "name:=7d"},
+		{"unknown match", "source:mail=7d"},
+		{"default twice", "*=90d,*=30d"},
+		{"name twice", "name:mail=30d,name:mail=60d"},
+		{"severity twice in different cases", "severity:debug=7d,severity:DEBUG=14d"},
+	} {
+		if got, err := ParseRetention(tc.in); err == nil {
+			t.Errorf("%s: expected an error, got %v", tc.name, got)
+		}
+	}
+}
+
+func TestRetentionPolicyRoundTrip(t *testing.T) {
+	in := "name:users=730d,severity:debug=12h,*=90d"
+
+	policies, err := ParseRetention(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var back []string
+	for _, p := range policies {
+		back = append(back, p.String())
+	}
+	if want := []string{"name:users=730d", "severity:debug=12h0m0s", "*=90d"}; !reflect.DeepEqual(back, want) {
+		t.Fatalf("expected %v, got %v", want, back)
+	}
+}
+
+func TestRetentionFilter(t *testing.T) {
+	policies, err := ParseRetention("name:users=730d,severity:debug=7d,severity:info=30d,*=90d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, debug, info, everything := policies[0], policies[1], policies[2], policies[3]
+
+	for _, tc := range []struct {
+		name   string
+		policy RetentionPolicy
+		want   bson.M
+	}{
+		// a name policy governs its entries whatever their severity
+		{"name", users, bson.M{"name": "users"}},
+		// a severity policy leaves out names with their own policy
+		{"severity", debug, bson.M{
+			"severity": bson.M{"$in": bson.A{"debug"}},
+			"name":     bson.M{"$nin": bson.A{"users"}},
+		}},
+		// entries without a severity count as the default one
+		{"default severity", info, bson.M{
+			"severity": bson.M{"$in": bson.A{"info", nil}},
+			"name":     bson.M{"$nin": bson.A{"users"}},
+		}},
+		// and the default policy leaves out everything the others govern
+		{"everything else", everything, bson.M{
+			"name":     bson.M{"$nin": bson.A{"users"}},
+			"severity": bson.M{"$nin": bson.A{"debug", "info", nil}},
+		}},
+	} {
+		if got := retentionFilter(tc.policy, policies); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, got)
+		}
+	}
+
+	// on its own, the default policy governs every entry
+	alone := []RetentionPolicy{{MaxAge: 90 * day}}
+	if got := retentionFilter(alone[0], alone); !reflect.DeepEqual(got, bson.M{}) {
+		t.Errorf("default alone: expected every entry, got %v", got)
+	}
+
+	// and a severity policy without name policies does not mention names
+	severities := []RetentionPolicy{{Severity: "error", MaxAge: 365 * day}}
+	if got, want := retentionFilter(severities[0], severities), (bson.M{"severity": bson.M{"$in": bson.A{"error"}}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("severity alone: expected %v, got %v", want, got)
+	}
+}
@@ -3,6 +3,7 @@ module log-service
 go 1.18
 
 require (
+	authz v0.0.0-00010101000000-000000000000
 	github.com/go-chi/chi/v5 v5.0.7
 	github.com/go-chi/cors v1.2.0
 	go.mongodb.org/mongo-driver v1.17.1
@@ -22,3 +23,5 @@ require (
 	golang.org/x/sync v0.8.0 // indirect
 	golang.org/x/text v0.17.0 // indirect
 )
+
+replace authz => ../authz
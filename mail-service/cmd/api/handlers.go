@@ -1,8 +1,13 @@
 package main
 
 import (
+	"authz"
+	"bytes"
+	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"time"
 )
 
 func (app *Config) SendMail(w http.ResponseWriter, r *http.Request) {
@@ -29,6 +34,13 @@ func (app *Config) SendMail(w http.ResponseWriter, r *http.Request) {
 		Data: requestPayload.Message,
 	}
 
+	// 邮件记录归属于发件人的组织 (The mail record belongs to the sender's organization)
+	tenant, err := authz.ResolveTenant(r.Context(), "")
+	if err != nil {
+		app.errorJSON(w, err, authz.StatusCode(err))
+		return
+	}
+
 	err = app.Mailer.SendSMTPMessage(msg)
 	if err != nil {
 		log.Println(err)
@@ -36,6 +48,11 @@ func (app *Config) SendMail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 邮件已发出，记录失败不影响响应 (The mail has gone out, so failing to record it does not fail the request)
+	if err := app.recordMail(msg, tenant); err != nil {
+		log.Println("Error recording mail:", err)
+	}
+
 	payload := jsonResponse {
 		Error: false,
 		Message: "sent to " + requestPayload.To,
@@ -43,3 +60,48 @@ func (app *Config) SendMail(w http.ResponseWriter, r *http.Request) {
 
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
+
+// recordMail keeps a record of a sent message in the logger service, under the
+// sender's tenant. That is where mail history is read from, so it is scoped to the
+// tenant like every other log entry.
+func (app *Config) recordMail(msg Message, tenant string) error {
+	now := time.Now()
+
+	// 签发一个短期有效的服务令牌 (Sign a short lived service token)
+	token, err := app.Tokens.Sign(authz.Claims{
+		Subject:     "mail-service",
+		Issuer:      "mail-service",
+		Permissions: []string{"logs:write"},
+		IssuedAt:    now.Unix(),
+		ExpiresAt:   now.Add(time.Minute).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	jsonData, _ := json.Marshal(struct {
+		Name   string `json:"name"`
+		Data   string `json:"data"`
+		Tenant string `json:"tenant,omitempty"`
+	}{"mail", "mail to " + msg.To + ": " + msg.Subject, tenant})
+
+	request, err := http.NewRequest("POST", "http://logger-service/log", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusAccepted {
+		return errors.New("logger service returned " + response.Status)
+	}
+
+	return nil
+}
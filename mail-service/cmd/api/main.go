@@ -1,6 +1,7 @@
 package main
 
 import (
+	"authz"    // 引入共享的 authz 包，用于校验访问令牌
 	"fmt"      // 引入fmt包，用于格式化字符串输出
 	"log"      // 引入log包，用于记录日志信息
 	"net/http" // 引入http包，用于启动HTTP服务器
@@ -13,7 +14,9 @@ import (
 
 // 定义了一个配置结构体 Config，其中包含一个 Mailer 字段，用于存储邮件配置。
 type Config struct {
-	Mailer Mail
+	Mailer   Mail
+	Verifier authz.Verifier // 用于校验请求中的访问令牌 (verifies access tokens on incoming requests)
+	Tokens   authz.Signer   // 用于签发服务令牌，记录邮件时携带 (signs the service token sent when recording mail)
 }
 
 // 定义了一个 Config 结构体，包含一个 Mailer 字段，该字段是 Mail 类型，用于存储邮件服务的配置。
@@ -24,9 +27,29 @@ const webPort = "80"
 
 // main 函数是程序的入口，初始化并启动HTTP服务器
 func main() {
+	// 从环境变量中读取 JWT_SECRET，用于校验访问令牌
+	// Read JWT_SECRET from the environment; it is used to verify access tokens
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		log.Panic("JWT_SECRET is not set")
+	}
+
+	// 用户令牌用认证服务公布的公钥（JWKS）校验，服务之间的令牌仍用共享密钥
+	// User tokens are verified with the authentication service's published keys (JWKS);
+	// tokens from other services still use the shared secret
+	jwksURL := os.Getenv("JWKS_URL")
+	if jwksURL == "" {
+		jwksURL = authz.DefaultJWKSURL
+	}
+
 	// 创建一个 Config 实例，Mailer 字段通过 createMail() 函数来初始化
 	app := Config{
 		Mailer: createMail(),
+		Verifier: authz.ByAlgorithm{
+			"RS256": authz.NewJWKSVerifier(jwksURL),
+			"HS256": authz.ServicesOnly(authz.NewHMAC(secret)),
+		},
+		Tokens: authz.NewHMAC(secret),
 	}
 
 	// 记录日志信息，表示服务器启动
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"authz"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -23,7 +24,9 @@ func (app *Config) routes() http.Handler {
 
 	mux.Use(middleware.Heartbeat("/ping"))
 
-	mux.Post("/send", app.SendMail)
+	mux.Use(authz.Authenticate(app.Verifier))
+
+	mux.With(authz.RequirePermission("mail:send")).Post("/send", app.SendMail)
 
 	return mux
 }
\ No newline at end of file
@@ -3,6 +3,7 @@ module mailer-service
 go 1.18
 
 require (
+	authz v0.0.0-00010101000000-000000000000
 	github.com/PuerkitoBio/goquery v1.5.1 // indirect
 	github.com/andybalholm/cascadia v1.1.0 // indirect
 	github.com/go-chi/chi/v5 v5.0.7 // indirect
@@ -14,3 +15,5 @@ require (
 	github.com/xhit/go-simple-mail/v2 v2.11.0 // indirect
 	golang.org/x/net v0.0.0-20200904194848-62affa334b73 // indirect
 )
+
+replace authz => ../authz